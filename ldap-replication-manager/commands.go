@@ -0,0 +1,643 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ldap-replication-manager/internal/audit"
+	"github.com/ldap-replication-manager/internal/config"
+	"github.com/ldap-replication-manager/internal/events"
+	"github.com/ldap-replication-manager/internal/ldap"
+	"github.com/ldap-replication-manager/internal/monitor"
+	"github.com/ldap-replication-manager/internal/output"
+	"github.com/ldap-replication-manager/internal/password"
+	"github.com/ldap-replication-manager/internal/rotation"
+	"github.com/ldap-replication-manager/internal/selection"
+)
+
+// runCommand executes a maintenance command given after the normal flags
+// Example: ldap-replication-manager --config config.yaml history agreement-to-consumer1
+// Commands are small, self-contained tasks that do not rotate any password
+// Each command parses its own flags so that options stay next to their command
+// Unknown commands return an error listing what is available
+// format is the --output value; commands with a result document honour it
+// and the others refuse json and yaml rather than ignore them
+func runCommand(cfg *config.Config, format string, args []string) error {
+	results := newResultOutput(format, os.Stdout, os.Stderr)
+	switch args[0] {
+	case "get", "build-breach-filter", "approve", "monitor", "events":
+		if results.machine() {
+			return fmt.Errorf("the %s command has no %s output; leave out --output or use --output table", args[0], format)
+		}
+	}
+
+	switch args[0] {
+	case "get":
+		return runGetCommand(cfg, results, args[1:])
+	case "list":
+		return runListCommand(cfg, results, args[1:])
+	case "history":
+		return runHistoryCommand(cfg, results, args[1:])
+	case "build-breach-filter":
+		return runBuildBreachFilterCommand(results, args[1:])
+	case "lock":
+		return runLockCommand(cfg, results, args[1:])
+	case "approve":
+		return runApproveCommand(cfg, results, args[1:])
+	case "discover":
+		return runDiscoverCommand(cfg, results, args[1:])
+	case "verify":
+		return runVerifyCommand(cfg, results, args[1:])
+	case "monitor":
+		return runMonitorCommand(cfg, results, args[1:])
+	case "audit":
+		return runAuditCommand(cfg, results, args[1:])
+	case "events":
+		return runEventsCommand(cfg, results, args[1:])
+	default:
+		return fmt.Errorf("unknown command %q (available: apply, plan, approve, discover, verify, monitor, get, list, history, build-breach-filter, lock, audit, events)", args[0])
+	}
+}
+
+// resultOutput holds where a command writes for the --output format
+// In json and yaml the result document is the only thing written to stdout
+// and every message goes to stderr; in table format messages and tables go
+// to stdout and no document is written
+// Commands write their messages to the messages writer, never to os.Stdout
+type resultOutput struct {
+	format string
+
+	// Receives the result document
+	stdout io.Writer
+
+	// Receives progress messages and human readable tables
+	messages io.Writer
+}
+
+// newResultOutput chooses the writers for the given format
+func newResultOutput(format string, stdout, stderr io.Writer) resultOutput {
+	results := resultOutput{format: format, stdout: stdout, messages: stdout}
+	if format != output.FormatTable {
+		results.messages = stderr
+	}
+	return results
+}
+
+// machine reports whether a json or yaml document is written
+func (r resultOutput) machine() bool {
+	return r.format != output.FormatTable
+}
+
+// write writes the result document; it does nothing in table format
+func (r resultOutput) write(kind string, data interface{}) error {
+	if !r.machine() {
+		return nil
+	}
+	if err := output.Write(r.stdout, r.format, kind, data); err != nil {
+		return fmt.Errorf("failed to write %s output: %v", r.format, err)
+	}
+	return nil
+}
+
+// applyOptions are the options of the apply and plan commands
+type applyOptions struct {
+	// Stop after showing the planned changes (the plan command)
+	plan bool
+
+	// plan: save the plan as a run that can be approved and applied later
+	save bool
+
+	// Run ID of an interrupted or saved run to continue
+	resume string
+
+	// Apply without asking; --yes answers the prompt, --no-input never
+	// reads stdin and fails if something would still need an answer
+	yes     bool
+	noInput bool
+
+	// Agreements matching this glob must each be confirmed by typing their name
+	confirmAgreement string
+
+	// Approval token for a saved plan (four-eyes mode)
+	approval string
+
+	// Apply without a state file when no passphrase is configured; an
+	// interrupted run then cannot be resumed
+	noState bool
+
+	// Agreements to plan and rotate
+	selection selection.Selector
+}
+
+// parseApplyOptions parses the flags of the apply and plan commands
+// apply is the rotation workflow itself, which main runs directly;
+// running without any command is the same as a plain apply
+func parseApplyOptions(command string, args []string) applyOptions {
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	options := applyOptions{plan: command == "plan"}
+	if options.plan {
+		flags.BoolVar(&options.save, "save", false, "Save the plan so it can be approved and applied later")
+	} else {
+		flags.StringVar(&options.resume, "resume", "", "Continue the interrupted or saved run with this run ID")
+		flags.BoolVar(&options.yes, "yes", false, "Apply without asking for confirmation")
+		flags.BoolVar(&options.noInput, "no-input", false, "Never read from stdin; fail if confirmation would be needed")
+		flags.StringVar(&options.confirmAgreement, "confirm-agreement", "", "Require typing the name of each agreement matching this glob (\"*\" for all)")
+		flags.StringVar(&options.approval, "approval", "", "Approval token from the approve command for the run given to --resume")
+		flags.BoolVar(&options.noState, "no-state", false, "Apply without saving progress when no passphrase is configured; the run cannot be resumed")
+	}
+	options.selection.AddFlags(flags)
+	flags.Parse(args)
+
+	if _, err := path.Match(options.confirmAgreement, ""); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid --confirm-agreement pattern %q: %v\n", options.confirmAgreement, err)
+		os.Exit(2)
+	}
+	if err := options.selection.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(2)
+	}
+	return options
+}
+
+// openLocalVault opens the local vault file for the vault commands
+// It fails with a clear message when no vault file is configured
+func openLocalVault(cfg *config.Config) (*password.LocalVault, error) {
+	vault, err := password.NewLocalVault(cfg.Secrets.LocalVault)
+	if err != nil {
+		return nil, err
+	}
+	if vault == nil {
+		return nil, fmt.Errorf("no local vault configured (set secrets.local_vault.file)")
+	}
+	return vault, nil
+}
+
+// runGetCommand prints the current password of one agreement
+// Only the password is written to stdout so it can be used in scripts
+// --previous N prints an older password instead (1 is the one before current)
+func runGetCommand(cfg *config.Config, results resultOutput, args []string) error {
+	flags := flag.NewFlagSet("get", flag.ExitOnError)
+	previous := flags.Int("previous", 0, "Print the Nth previous password instead of the current one")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: get [--previous N] <agreement>")
+	}
+
+	vault, err := openLocalVault(cfg)
+	if err != nil {
+		return err
+	}
+	record, err := vault.Get(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	entry := record.Current
+	if *previous > 0 {
+		if *previous > len(record.Previous) {
+			return fmt.Errorf("agreement %s has only %d previous passwords", flags.Arg(0), len(record.Previous))
+		}
+		entry = record.Previous[*previous-1]
+	}
+
+	fmt.Fprintf(os.Stderr, "Agreement %s: set %s by run %s\n", flags.Arg(0), entry.CreatedAt.Format("2006-01-02 15:04:05 MST"), entry.RunID)
+	fmt.Fprintln(results.stdout, entry.Password)
+	return nil
+}
+
+// runListCommand shows every agreement stored in the local vault
+// Passwords are not printed; use get to reveal one
+// The list honours --output
+func runListCommand(cfg *config.Config, results resultOutput, args []string) error {
+	flags := flag.NewFlagSet("list", flag.ExitOnError)
+	flags.Parse(args)
+
+	vault, err := openLocalVault(cfg)
+	if err != nil {
+		return err
+	}
+	records, names, err := vault.List()
+	if err != nil {
+		return err
+	}
+	if results.machine() {
+		return results.write("VaultList", output.NewVaultList(records, names))
+	}
+	if len(names) == 0 {
+		fmt.Fprintln(results.messages, "The local vault is empty.")
+		return nil
+	}
+
+	table := tabwriter.NewWriter(results.messages, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "AGREEMENT\tLAST ROTATED\tRUN ID\tCONSUMER\tPREVIOUS")
+	for _, name := range names {
+		record := records[name]
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%d\n", name,
+			record.Current.CreatedAt.Format("2006-01-02 15:04:05"), record.Current.RunID,
+			record.Current.Consumer, len(record.Previous))
+	}
+	return table.Flush()
+}
+
+// runHistoryCommand lists the current and previous passwords of one agreement
+// Passwords are masked unless --show-passwords is given
+// With --output json or yaml the versions are listed without any password
+func runHistoryCommand(cfg *config.Config, results resultOutput, args []string) error {
+	flags := flag.NewFlagSet("history", flag.ExitOnError)
+	showPasswords := flags.Bool("show-passwords", false, "Print passwords in clear text")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: history [--show-passwords] <agreement>")
+	}
+	if *showPasswords && results.machine() {
+		return fmt.Errorf("--show-passwords cannot be combined with --output %s; documents never contain passwords", results.format)
+	}
+
+	vault, err := openLocalVault(cfg)
+	if err != nil {
+		return err
+	}
+	record, err := vault.Get(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	if results.machine() {
+		return results.write("PasswordHistory", output.NewPasswordHistory(flags.Arg(0), record))
+	}
+
+	table := tabwriter.NewWriter(results.messages, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "VERSION\tSET AT\tRUN ID\tPASSWORD")
+	entries := append([]password.VaultEntry{record.Current}, record.Previous...)
+	for i, entry := range entries {
+		version := output.VersionName(i)
+		shown := password.MaskPassword(entry.Password)
+		if *showPasswords {
+			shown = entry.Password
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\n", version, entry.CreatedAt.Format("2006-01-02 15:04:05"), entry.RunID, shown)
+	}
+	return table.Flush()
+}
+
+// runBuildBreachFilterCommand converts a downloaded breached password dump
+// into the compact bloom filter used by password.breach_check
+// The dump is a Have I Been Pwned SHA-1 file with "HASH:COUNT" lines
+func runBuildBreachFilterCommand(results resultOutput, args []string) error {
+	flags := flag.NewFlagSet("build-breach-filter", flag.ExitOnError)
+	input := flags.String("input", "", "Path of the downloaded SHA-1 hash dump")
+	output := flags.String("output", "breached-passwords.bloom", "Path of the bloom filter to write")
+	falsePositiveRate := flags.Float64("fp-rate", 0.001, "Acceptable false positive rate")
+	maxMemory := flags.Int64("max-memory-mb", 512, "Most memory for the filter; a larger one is built in several passes")
+	flags.Parse(args)
+	if *input == "" || *maxMemory < 1 {
+		return fmt.Errorf("usage: build-breach-filter --input <hash dump> [--output file] [--fp-rate 0.001] [--max-memory-mb 512]")
+	}
+
+	fmt.Fprintf(results.messages, "Building breach filter from %s...\n", *input)
+	count, err := password.BuildBreachFilter(*input, *output, *falsePositiveRate, *maxMemory<<20)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(results.messages, "Wrote %s with %d hashes (false positive rate %g)\n", *output, count, *falsePositiveRate)
+	return nil
+}
+
+// runLockCommand shows or removes the rotation lock entry
+// "lock status" prints who holds the lock and whether it is stale
+// "lock break" deletes a stale lock; --force also deletes a lock that has
+// not expired, for when the operator knows its holder is gone
+// lock status honours --output; lock break has no document and refuses it
+func runLockCommand(cfg *config.Config, results resultOutput, args []string) error {
+	if len(args) == 0 || (args[0] != "status" && args[0] != "break") {
+		return fmt.Errorf("usage: lock status | lock break [--force]")
+	}
+	flags := flag.NewFlagSet("lock "+args[0], flag.ExitOnError)
+	force := flags.Bool("force", false, "Break the lock even if it has not expired")
+	flags.Parse(args[1:])
+	if args[0] == "break" && results.machine() {
+		return fmt.Errorf("the lock break command has no %s output; leave out --output or use --output table", results.format)
+	}
+
+	ldapManager, err := connectLDAP(cfg)
+	if err != nil {
+		return err
+	}
+	defer ldapManager.Close()
+
+	info, err := ldapManager.ReadLock(cfg.Rotation.LockDN)
+	if err != nil {
+		return err
+	}
+	if info == nil {
+		fmt.Fprintf(results.messages, "Rotation lock %s is free.\n", cfg.Rotation.LockDN)
+		if args[0] == "status" {
+			return results.write("LockStatus", output.LockStatus{DN: cfg.Rotation.LockDN})
+		}
+		return nil
+	}
+
+	state := "held"
+	if info.Stale() {
+		state = "stale"
+	}
+	fmt.Fprintf(results.messages, "Rotation lock %s is %s\n", info.DN, state)
+	fmt.Fprintf(results.messages, "  Holder:  %s\n", info.Holder)
+	fmt.Fprintf(results.messages, "  Expires: %s\n", info.Expires.Local().Format(time.RFC1123))
+	if args[0] == "status" {
+		return results.write("LockStatus", output.LockStatus{DN: info.DN, Held: true, Stale: info.Stale(), Holder: info.Holder, Expires: &info.Expires})
+	}
+
+	if !info.Stale() && !*force {
+		return fmt.Errorf("the lock has not expired; make sure its holder is no longer running and use lock break --force")
+	}
+	auditLog, err := openAuditLog(cfg, "")
+	if err != nil {
+		return err
+	}
+	defer closeAuditLog(auditLog, results.messages)
+	ldapManager.SetAuditLog(auditLog)
+	if err := ldapManager.BreakLock(info.DN); err != nil {
+		return err
+	}
+	fmt.Fprintln(results.messages, "Lock removed.")
+	if err := ldapManager.AuditError(); err != nil {
+		return fmt.Errorf("the lock was removed but %v", err)
+	}
+	return nil
+}
+
+// runAuditCommand checks the hash chain of the audit log
+// Usage: audit verify [--file path]
+// Every record must follow the one before it and the last one must match
+// the head file; the first record that does not is reported
+// The result honours --output and the command fails when the chain is broken
+func runAuditCommand(cfg *config.Config, results resultOutput, args []string) error {
+	if len(args) == 0 || args[0] != "verify" {
+		return fmt.Errorf("usage: audit verify [--file path]")
+	}
+	flags := flag.NewFlagSet("audit verify", flag.ExitOnError)
+	file := flags.String("file", cfg.Audit.File, "Audit log to verify")
+	flags.Parse(args[1:])
+
+	records, head, err := audit.Verify(*file)
+	report := output.AuditVerification{File: *file, Records: records, Valid: err == nil, Head: head}
+	if err != nil {
+		report.Error = err.Error()
+		if err := results.write("AuditVerification", report); err != nil {
+			return err
+		}
+		return fmt.Errorf("audit log %s is not intact: %v", *file, err)
+	}
+
+	fmt.Fprintf(results.messages, "Audit log %s is intact: %d records\n", *file, records)
+	fmt.Fprintf(results.messages, "  Head: %s\n", head)
+	return results.write("AuditVerification", report)
+}
+
+// runEventsCommand sends a test event to the configured sinks
+// Usage: events test
+// Point a sink at a local listener, for example "nc -lu 5514" with network
+// udp and address 127.0.0.1:5514, to see exactly what the SIEM receives
+func runEventsCommand(cfg *config.Config, results resultOutput, args []string) error {
+	if len(args) == 0 || args[0] != "test" {
+		return fmt.Errorf("usage: events test")
+	}
+	eventBus, err := events.Open(cfg.Events, slog.Default())
+	if err != nil {
+		return fmt.Errorf("failed to set up event sinks: %v", err)
+	}
+	if eventBus == nil {
+		return fmt.Errorf("no event sink is enabled in the events section")
+	}
+	defer eventBus.Close()
+
+	eventBus.Publish(events.Event{
+		Type:     events.TypeTest,
+		Severity: events.SeverityInfo,
+		Message:  fmt.Sprintf("Test event sent by %s", operatorIdentity()),
+		Fields:   map[string]string{"operator": operatorIdentity()},
+	})
+	fmt.Fprintln(results.messages, "Test event sent.")
+	return nil
+}
+
+// runApproveCommand issues a four-eyes approval token for a saved plan
+// The plan is shown without passwords; the token alone goes to stdout so
+// it can be handed to the operator who applies the plan
+// The operator who saved the plan cannot approve it
+// With approver keys the token is signed with the private key in --key
+func runApproveCommand(cfg *config.Config, results resultOutput, args []string) error {
+	flags := flag.NewFlagSet("approve", flag.ExitOnError)
+	keyFile := flags.String("key", "", "Private Ed25519 key of the approver (with rotation.approval.approvers_dir)")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: approve [--key <private key file>] <run-id>")
+	}
+
+	state, err := rotation.ReadRunState(cfg.Rotation.StateDir, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	keys, err := rotation.LoadApprovalKeys(cfg.Rotation.Approval)
+	if err != nil {
+		return err
+	}
+	signer, err := keys.Signer(operatorIdentity(), *keyFile)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Run %s, planned by %s at %s\n", state.RunID, state.CreatedBy, state.CreatedAt.Local().Format("2006-01-02 15:04:05"))
+	for i, group := range state.Groups {
+		fmt.Fprintf(os.Stderr, "  Group %d: %s on %s (%s)\n", i+1, group.BindDN, group.Consumer, group.Strategy)
+		for _, agreement := range group.Agreements {
+			fmt.Fprintf(os.Stderr, "    %s (supplier %s -> consumer %s)\n", agreement.Agreement.Name, agreement.Agreement.Supplier, agreement.Agreement.Consumer)
+		}
+	}
+
+	token, err := rotation.Approve(signer, state, time.Duration(cfg.Rotation.Approval.TokenTTL)*time.Second)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Approved by %s; the token is valid for %s\n", signer.Approver(), time.Duration(cfg.Rotation.Approval.TokenTTL)*time.Second)
+	fmt.Fprintln(results.stdout, token)
+	return nil
+}
+
+// connectLDAP connects to ldap.host for the commands that read the directory
+// The bind password comes from the secret store when it is not in the file
+func connectLDAP(cfg *config.Config) (*ldap.Manager, error) {
+	if _, err := openSecretStore(cfg); err != nil {
+		return nil, err
+	}
+	return ldap.NewManager(cfg, false, true)
+}
+
+// discoverSelected discovers the agreements and applies a command's selection
+// It returns the selected agreements and how many were discovered in total
+func discoverSelected(ldapManager *ldap.Manager, selector *selection.Selector) ([]ldap.ReplicationAgreement, int, error) {
+	agreements, err := ldapManager.DiscoverReplicationAgreements()
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to discover replication agreements: %v", err)
+	}
+	return selector.Filter(agreements), len(agreements), nil
+}
+
+// runDiscoverCommand lists the agreements of the supplier
+// With selection flags it shows exactly what plan and apply would act on
+func runDiscoverCommand(cfg *config.Config, results resultOutput, args []string) error {
+	flags := flag.NewFlagSet("discover", flag.ExitOnError)
+	var selector selection.Selector
+	selector.AddFlags(flags)
+	flags.Parse(args)
+	if err := selector.Validate(); err != nil {
+		return err
+	}
+
+	ldapManager, err := connectLDAP(cfg)
+	if err != nil {
+		return err
+	}
+	defer ldapManager.Close()
+	agreements, total, err := discoverSelected(ldapManager, &selector)
+	if err != nil {
+		return err
+	}
+	if results.machine() {
+		return results.write("AgreementList", output.AgreementList{Selection: selector.String(), Total: total, Agreements: output.NewAgreements(agreements)})
+	}
+
+	table := tabwriter.NewWriter(results.messages, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "AGREEMENT\tSUPPLIER\tCONSUMER\tSUFFIX\tENABLED\tSTATUS")
+	for _, agreement := range agreements {
+		enabled := "no"
+		if agreement.Enabled {
+			enabled = "yes"
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\t%s\n", agreement.Name, agreement.Supplier, agreement.Consumer,
+			agreement.Suffix, enabled, agreement.Status())
+	}
+	if err := table.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(results.messages, "\n%d of %d agreements selected (%s)\n", len(agreements), total, selector.String())
+	return nil
+}
+
+// runVerifyCommand checks that the selected agreements replicate successfully
+// The outcome of each agreement's last replication session is printed; the
+// command fails when any of them did not succeed, so it can be used after a
+// rotation or from a monitoring check
+func runVerifyCommand(cfg *config.Config, results resultOutput, args []string) error {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	var selector selection.Selector
+	selector.AddFlags(flags)
+	flags.Parse(args)
+	if err := selector.Validate(); err != nil {
+		return err
+	}
+
+	ldapManager, err := connectLDAP(cfg)
+	if err != nil {
+		return err
+	}
+	defer ldapManager.Close()
+	agreements, _, err := discoverSelected(ldapManager, &selector)
+	if err != nil {
+		return err
+	}
+	if len(agreements) == 0 {
+		return fmt.Errorf("no agreement matches the selection (%s)", selector.String())
+	}
+
+	status := ldapManager.GetReplicationStatus(agreements)
+	failed := 0
+	for _, agreement := range agreements {
+		fmt.Fprintf(results.messages, "%s (%s -> %s): %s\n", agreement.Name, agreement.Supplier, agreement.Consumer, status[agreement.Name])
+		if agreement.Status() != ldap.StatusOK {
+			failed++
+		}
+	}
+	if err := results.write("VerificationReport", output.VerificationReport{Total: len(agreements), Failed: failed, Agreements: output.NewAgreements(agreements)}); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d agreements are not replicating successfully", failed, len(agreements))
+	}
+	fmt.Fprintf(results.messages, "All %d agreements are replicating successfully.\n", len(agreements))
+	return nil
+}
+
+// runMonitorCommand watches for error 49 on the selected agreements only
+// Unlike the --monitor flag it runs on its own, without rotating anything
+func runMonitorCommand(cfg *config.Config, results resultOutput, args []string) error {
+	flags := flag.NewFlagSet("monitor", flag.ExitOnError)
+	var selector selection.Selector
+	selector.AddFlags(flags)
+	flags.Parse(args)
+	if err := selector.Validate(); err != nil {
+		return err
+	}
+
+	ldapManager, err := connectLDAP(cfg)
+	if err != nil {
+		return err
+	}
+	agreements, total, err := discoverSelected(ldapManager, &selector)
+	ldapManager.Close()
+	if err != nil {
+		return err
+	}
+
+	eventBus, err := events.Open(cfg.Events, slog.Default())
+	if err != nil {
+		return fmt.Errorf("failed to set up event sinks: %v", err)
+	}
+	defer eventBus.Close()
+
+	fmt.Fprintf(results.messages, "Monitoring %d of %d agreements (%s)\n", len(agreements), total, selector.String())
+	monitor.StartGRPCMonitor(cfg, slog.Default(), eventBus, selectedEvents(agreements))
+	return nil
+}
+
+// selectedEvents returns the monitor's filter for the given agreements
+// An event from a consumer's access log names no agreement; it is kept when
+// a given agreement binds as its DN to the server that logged it
+func selectedEvents(agreements []ldap.ReplicationAgreement) func(monitor.ErrorEvent) bool {
+	names := make(map[string]bool)
+	for _, agreement := range agreements {
+		names[agreement.Name] = true
+	}
+	return func(event monitor.ErrorEvent) bool {
+		if event.AgreementName != "" {
+			return names[event.AgreementName]
+		}
+		for _, agreement := range agreements {
+			if sameHost(agreement.Consumer, event.Consumer) && (event.BindDN == "" || ldap.SameDN(agreement.ReplicationManagerDN(), event.BindDN)) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// sameHost reports whether two host names name the same server
+// A short name matches the fully qualified one it starts: the agreement may
+// name "c1.example.com" where the consumer calls itself "c1"
+func sameHost(a, b string) bool {
+	a, b = strings.ToLower(a), strings.ToLower(b)
+	if a == b {
+		return true
+	}
+	shortA, _, _ := strings.Cut(a, ".")
+	shortB, _, _ := strings.Cut(b, ".")
+	return (a == shortA || b == shortB) && shortA == shortB
+}
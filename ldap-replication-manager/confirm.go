@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/ldap-replication-manager/internal/ldap"
+	"github.com/ldap-replication-manager/internal/rotation"
+)
+
+// confirmApply asks the operator to confirm the planned changes
+// The plain y/N prompt is skipped with --yes or when an approval token was
+// verified; agreements matching --confirm-agreement are always confirmed one
+// by one by typing their name
+// When stdin is not a terminal (cron, pipelines) or --no-input is given,
+// nothing is read: a run that still needs an answer is refused with an error
+// instead of hanging or reading garbage
+func confirmApply(options applyOptions, plan *rotation.Plan, approved bool, out io.Writer) (bool, error) {
+	var highRisk []ldap.ReplicationAgreement
+	if options.confirmAgreement != "" {
+		for _, group := range plan.Groups {
+			for _, agreement := range group.Agreements {
+				if matched, _ := path.Match(options.confirmAgreement, agreement.Name); matched {
+					highRisk = append(highRisk, agreement)
+				}
+			}
+		}
+	}
+
+	interactive := !options.noInput && stdinIsTerminal()
+	reason := "stdin is not a terminal"
+	if options.noInput {
+		reason = "--no-input was given"
+	}
+	if len(highRisk) > 0 && !interactive {
+		return false, fmt.Errorf("%d agreements match --confirm-agreement %q but %s, so they cannot be confirmed", len(highRisk), options.confirmAgreement, reason)
+	}
+
+	input := bufio.NewReader(os.Stdin)
+	if !options.yes && !approved {
+		if !interactive {
+			return false, fmt.Errorf("refusing to apply without confirmation because %s (use --yes to confirm in advance)", reason)
+		}
+		if answer := prompt(out, input, "\nDo you want to apply these changes? (y/N): "); answer != "y" && answer != "Y" {
+			return false, nil
+		}
+	}
+
+	for _, agreement := range highRisk {
+		question := fmt.Sprintf("Type the agreement name to confirm %s (supplier %s -> consumer %s): ", agreement.Name, agreement.Supplier, agreement.Consumer)
+		if prompt(out, input, question) != agreement.Name {
+			fmt.Fprintf(out, "The name does not match %s.\n", agreement.Name)
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// prompt prints a question and returns the answer without surrounding spaces
+// Whole lines are read because agreement names may contain spaces
+func prompt(out io.Writer, input *bufio.Reader, question string) string {
+	fmt.Fprint(out, question)
+	answer, _ := input.ReadString('\n')
+	return strings.TrimSpace(answer)
+}
+
+// stdinIsTerminal reports whether stdin is an interactive terminal
+// Pipes and files are not character devices; /dev/null, which cron and
+// systemd commonly give as stdin, is one and is excluded explicitly
+func stdinIsTerminal() bool {
+	info, err := os.Stdin.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	if null, err := os.Stat(os.DevNull); err == nil && os.SameFile(info, null) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,248 @@
+package audit
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Change is one attribute touched by a directory operation
+// Only the attribute name and the kind of change are kept, never the values,
+// so the audit log can be shared with auditors without exposing passwords
+type Change struct {
+	Attribute string `json:"attribute"`
+	Type      string `json:"type"` // add, delete or replace
+}
+
+// Record is one directory change in the audit log
+// Records are chained: Hash covers every other field including PrevHash,
+// the hash of the previous record, so changing, removing or reordering any
+// record breaks the chain from that point on
+type Record struct {
+	Seq        int64    `json:"seq"`
+	Time       string   `json:"time"` // RFC 3339 in UTC
+	Operator   string   `json:"operator"`
+	RunID      string   `json:"run_id,omitempty"`
+	Host       string   `json:"host"`
+	DN         string   `json:"dn"`
+	Operation  string   `json:"operation"` // add, modify or delete
+	Changes    []Change `json:"changes,omitempty"`
+	ResultCode int      `json:"result_code"` // LDAP result code, 0 for success, -1 when the server was not reached
+	Error      string   `json:"error,omitempty"`
+	DurationMS int64    `json:"duration_ms"`
+	PrevHash   string   `json:"prev_hash"`
+	Hash       string   `json:"hash"`
+}
+
+// genesisHash is the PrevHash of the first record
+const genesisHash = "0000000000000000000000000000000000000000000000000000000000000000"
+
+// Log appends records to an audit log file, one JSON object per line
+// Next to the file, <file>.head holds the sequence number and hash of the last
+// record; a log cut short after its last record still has a valid chain, but
+// no longer matches its head
+// Copy the head hash printed at the end of a run to a separate system (ticket,
+// SIEM) to also detect a rewrite of both files
+// One process writes to a log at a time; the rotation lock ensures this for
+// rotations
+type Log struct {
+	path     string
+	operator string
+	runID    string
+
+	mutex    sync.Mutex
+	file     *os.File
+	seq      int64
+	lastHash string
+}
+
+// Open opens an audit log for appending, creating it if needed
+// operator and runID are written into every record of this process
+// The existing chain is verified first: appending to a damaged log would hide
+// where the damage is
+func Open(path, operator, runID string) (*Log, error) {
+	count, head, err := Verify(path)
+	if err != nil {
+		return nil, fmt.Errorf("audit log %s failed verification: %v", path, err)
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %v", err)
+	}
+	return &Log{path: path, operator: operator, runID: runID, file: file, seq: count, lastHash: head}, nil
+}
+
+// Append adds a record to the log and syncs it to disk
+// Operator, run ID, sequence number and hashes are filled in here
+func (l *Log) Append(record Record) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	record.Seq = l.seq + 1
+	record.Operator = l.operator
+	record.RunID = l.runID
+	record.PrevHash = l.lastHash
+	record.Hash = ""
+	hash, err := recordHash(record)
+	if err != nil {
+		return err
+	}
+	record.Hash = hash
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write audit log: %v", err)
+	}
+	if err := l.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync audit log: %v", err)
+	}
+	l.seq = record.Seq
+	l.lastHash = record.Hash
+	return writeHead(l.path, l.seq, l.lastHash)
+}
+
+// Head returns the sequence number and hash of the last record
+func (l *Log) Head() (int64, string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.seq, l.lastHash
+}
+
+// Close closes the log file
+func (l *Log) Close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.file.Close()
+}
+
+// Verify checks the whole chain of an audit log and its head file
+// It returns the number of records and the hash of the last one
+// A missing log is an empty, valid log
+// The error names the first record that does not verify
+func Verify(path string) (int64, string, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		if _, err := os.Stat(headPath(path)); err == nil {
+			return 0, "", fmt.Errorf("the log is missing but its head file exists")
+		}
+		return 0, genesisHash, nil
+	}
+	if err != nil {
+		return 0, "", err
+	}
+	defer file.Close()
+
+	var seq int64
+	lastHash := genesisHash
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return seq, "", fmt.Errorf("line %d is not a valid record: %v", seq+1, err)
+		}
+		if record.Seq != seq+1 {
+			return seq, "", fmt.Errorf("record %d follows record %d: records were removed or reordered", record.Seq, seq)
+		}
+		if record.PrevHash != lastHash {
+			return seq, "", fmt.Errorf("record %d does not follow the hash of record %d", record.Seq, seq)
+		}
+		stored := record.Hash
+		record.Hash = ""
+		hash, err := recordHash(record)
+		if err != nil {
+			return seq, "", err
+		}
+		if hash != stored {
+			return seq, "", fmt.Errorf("record %d was modified after it was written", record.Seq)
+		}
+		seq = record.Seq
+		lastHash = stored
+	}
+	if err := scanner.Err(); err != nil {
+		return seq, "", err
+	}
+
+	headSeq, headHash, err := readHead(path)
+	if os.IsNotExist(err) {
+		if seq > 0 {
+			return seq, "", fmt.Errorf("the head file %s is missing", headPath(path))
+		}
+		return seq, lastHash, nil
+	}
+	if err != nil {
+		return seq, "", err
+	}
+	if headSeq != seq || headHash != lastHash {
+		return seq, "", fmt.Errorf("the log ends at record %d but its head is record %d: records were removed from the end", seq, headSeq)
+	}
+	return seq, lastHash, nil
+}
+
+// recordHash returns the SHA-256 of a record whose Hash field is empty
+func recordHash(record Record) (string, error) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// headPath returns the file holding the head of a log
+func headPath(path string) string {
+	return path + ".head"
+}
+
+// writeHead replaces the head file atomically with "<seq> <hash>"
+func writeHead(path string, seq int64, hash string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".head-*")
+	if err != nil {
+		return fmt.Errorf("failed to write audit head: %v", err)
+	}
+	fmt.Fprintf(tmp, "%d %s\n", seq, hash)
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write audit head: %v", err)
+	}
+	tmp.Close()
+	if err := os.Rename(tmp.Name(), headPath(path)); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write audit head: %v", err)
+	}
+	return nil
+}
+
+// readHead reads the head file of a log
+func readHead(path string) (int64, string, error) {
+	data, err := os.ReadFile(headPath(path))
+	if err != nil {
+		return 0, "", err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) != 2 {
+		return 0, "", fmt.Errorf("head file %s is malformed", headPath(path))
+	}
+	seq, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return 0, "", fmt.Errorf("head file %s is malformed", headPath(path))
+	}
+	return seq, fields[1], nil
+}
+
+// Timestamp formats the start time of an operation for a record
+func Timestamp(start time.Time) string {
+	return start.UTC().Format(time.RFC3339Nano)
+}
@@ -0,0 +1,144 @@
+package audit
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeLog appends count records to a new log and returns its path
+func writeLog(t *testing.T, count int) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "audit.log")
+	log, err := Open(path, "alice@admin1", "run-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < count; i++ {
+		record := Record{
+			Time:      Timestamp(time.Date(2026, 10, 18, 13, 54, i, 0, time.UTC)),
+			Host:      "c1.example.com",
+			DN:        "cn=replication manager,cn=config",
+			Operation: "modify",
+			Changes:   []Change{{Attribute: "userPassword", Type: "replace"}},
+		}
+		if err := log.Append(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := log.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// lines returns the records of a log file as lines
+func lines(t *testing.T, path string) []string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+func writeLines(t *testing.T, path string, records []string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(strings.Join(records, "\n")+"\n"), 0640); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyIntactLog(t *testing.T) {
+	path := writeLog(t, 3)
+	count, head, err := Verify(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 || len(head) != 64 || head == genesisHash {
+		t.Errorf("Verify() = %d, %q", count, head)
+	}
+
+	// Reopening continues the chain
+	log, err := Open(path, "bob@admin2", "run-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := log.Append(Record{Host: "c1.example.com", DN: "cn=x", Operation: "delete"}); err != nil {
+		t.Fatal(err)
+	}
+	log.Close()
+	if count, _, err := Verify(path); err != nil || count != 4 {
+		t.Errorf("Verify() after reopening = %d, %v", count, err)
+	}
+
+	if count, head, err := Verify(filepath.Join(t.TempDir(), "missing.log")); err != nil || count != 0 || head != genesisHash {
+		t.Errorf("a missing log must be empty and valid: %d, %q, %v", count, head, err)
+	}
+}
+
+func TestVerifyDetectsTampering(t *testing.T) {
+	tests := []struct {
+		name    string
+		tamper  func(t *testing.T, path string)
+		wantErr string
+	}{
+		{"edited record", func(t *testing.T, path string) {
+			records := lines(t, path)
+			records[1] = strings.Replace(records[1], `"host":"c1.example.com"`, `"host":"c2.example.com"`, 1)
+			writeLines(t, path, records)
+		}, "record 2 was modified"},
+		{"edited record with recomputed hash", func(t *testing.T, path string) {
+			records := lines(t, path)
+			var record Record
+			if err := json.Unmarshal([]byte(records[1]), &record); err != nil {
+				t.Fatal(err)
+			}
+			record.Operator = "mallory@admin1"
+			record.Hash = ""
+			record.Hash, _ = recordHash(record)
+			line, _ := json.Marshal(record)
+			records[1] = string(line)
+			writeLines(t, path, records)
+		}, "record 3 does not follow the hash of record 2"},
+		{"reordered records", func(t *testing.T, path string) {
+			records := lines(t, path)
+			records[1], records[2] = records[2], records[1]
+			writeLines(t, path, records)
+		}, "removed or reordered"},
+		{"removed record", func(t *testing.T, path string) {
+			records := lines(t, path)
+			writeLines(t, path, append(records[:1], records[2:]...))
+		}, "removed or reordered"},
+		{"truncated log", func(t *testing.T, path string) {
+			writeLines(t, path, lines(t, path)[:2])
+		}, "removed from the end"},
+		{"truncated log and head", func(t *testing.T, path string) {
+			writeLines(t, path, lines(t, path)[:2])
+			os.Remove(headPath(path))
+		}, "head file"},
+		{"cut mid-record", func(t *testing.T, path string) {
+			data, _ := os.ReadFile(path)
+			os.WriteFile(path, data[:len(data)-20], 0640)
+		}, "not a valid record"},
+		{"deleted log", func(t *testing.T, path string) {
+			os.Remove(path)
+		}, "log is missing"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := writeLog(t, 3)
+			test.tamper(t, path)
+			if _, _, err := Verify(path); err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("Verify() = %v, want %q", err, test.wantErr)
+			}
+			if _, err := Open(path, "alice@admin1", "run-2"); err == nil {
+				t.Error("a damaged log must not be opened for appending")
+			}
+		})
+	}
+}
@@ -3,6 +3,10 @@ package config
 import (
 	"fmt"
 	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
 
 	"gopkg.in/yaml.v2"
 )
@@ -24,6 +28,18 @@ type Config struct {
 
 	// Logging and operational settings
 	Logging LoggingConfig `yaml:"logging"`
+
+	// External secret store settings (for example HashiCorp Vault)
+	Secrets SecretsConfig `yaml:"secrets"`
+
+	// Settings for applying password changes
+	Rotation RotationConfig `yaml:"rotation"`
+
+	// Tamper-evident record of every directory change
+	Audit AuditConfig `yaml:"audit"`
+
+	// Rotation and monitor events sent to syslog or journald
+	Events EventsConfig `yaml:"events"`
 }
 
 // LDAPConfig contains all LDAP connection and operation settings
@@ -34,6 +50,11 @@ type LDAPConfig struct {
 	Host string `yaml:"host"`
 	Port int    `yaml:"port"`
 
+	// Other suppliers whose agreements are discovered and rotated with those
+	// of Host; every supplier that replicates to a rotated consumer must be
+	// listed, named as in the consumer's RUV (its nsslapd-localhost)
+	Suppliers []string `yaml:"suppliers"`
+
 	// Authentication credentials for LDAP operations
 	// Use a service account with replication management privileges
 	BindDN   string `yaml:"bind_dn"`
@@ -49,6 +70,82 @@ type LDAPConfig struct {
 
 	// Connection timeout in seconds
 	Timeout int `yaml:"timeout"`
+
+	// Storage scheme used to hash the replication manager password before it
+	// is written to userPassword on the consumer, for example "PBKDF2-SHA512"
+	// Empty sends the clear text password and lets the consumer hash it
+	// Supported: SSHA256, SSHA512, PBKDF2_SHA256, PBKDF2-SHA256, PBKDF2-SHA512
+	ConsumerPasswordScheme string `yaml:"consumer_password_scheme"`
+
+	// Maximum number of simultaneous connections to one LDAP server
+	// Idle connections are kept open and reused during a run
+	MaxConnectionsPerHost int `yaml:"max_connections_per_host"`
+}
+
+// RotationConfig controls how password changes are applied
+// Agreements are rotated concurrently to keep large topologies fast,
+// while agreements that share a consumer bind DN are always rotated together
+type RotationConfig struct {
+	// Number of agreement groups rotated at the same time
+	// Use 1 to rotate strictly one after another
+	Parallelism int `yaml:"parallelism"`
+
+	// How the consumer side is changed:
+	// "in-place" changes the password of the account the suppliers bind as,
+	// "bind-group" stages a second account in the consumer's
+	// nsds5ReplicaBindDNGroup and moves the suppliers over, so replication
+	// never fails; consumers without a bind DN group fall back to in-place
+	Strategy string `yaml:"strategy"`
+
+	// The two replication manager accounts the bind-group strategy alternates
+	// between; each rotation moves the suppliers to the one not in use
+	Accounts []string `yaml:"accounts"`
+
+	// Seconds to wait for a successful replication session after a supplier
+	// was updated (in-place) or moved to the new account (bind-group)
+	VerifyTimeout int `yaml:"verify_timeout"`
+
+	// Directory for the state files that make interrupted runs resumable
+	// New passwords in a state file are encrypted with the local vault
+	// passphrase (secrets.local_vault), which must be set for resuming to work
+	StateDir string `yaml:"state_dir"`
+
+	// DN of the entry used as a lock so only one run rotates the topology at
+	// a time, even when the tool runs on several machines
+	// The entry is created on ldap.host when a run starts and deleted when
+	// it ends; its parent must exist
+	LockDN string `yaml:"lock_dn"`
+
+	// Seconds a lock stays valid without being refreshed
+	// A running rotation refreshes its lock; a lock left by a killed run is
+	// considered stale once this time has passed and is taken over
+	LockTTL int `yaml:"lock_ttl"`
+
+	// Four-eyes approval of production runs
+	Approval ApprovalConfig `yaml:"approval"`
+}
+
+// ApprovalConfig controls the four-eyes mode
+// One operator saves a plan with "plan --save", a second operator reviews it
+// and issues a token with "approve", and only a run given that token may
+// apply the saved plan
+// Tokens are signatures over the plan, either HMAC-SHA256 with a shared key
+// or Ed25519 with a key pair per approver
+// A shared key only keeps out people who cannot read it: whoever can read it
+// can issue a token in any name, so approvers_dir is the stronger choice
+type ApprovalConfig struct {
+	// Refuse to apply anything that was not approved
+	Required bool `yaml:"required"`
+
+	// File holding the shared signing key; keep it readable only by the operators
+	KeyFile string `yaml:"key_file"`
+
+	// Directory of approver public keys, one <user name>.pem per approver
+	// Approvers sign with their own private key, so a token proves who issued it
+	ApproversDir string `yaml:"approvers_dir"`
+
+	// Seconds an approval token stays valid
+	TokenTTL int `yaml:"token_ttl"`
 }
 
 // PasswordConfig controls how new passwords are generated or specified
@@ -56,6 +153,13 @@ type LDAPConfig struct {
 // You can either generate random passwords or specify predefined ones
 // Adjust complexity and length based on your organization's policies
 type PasswordConfig struct {
+	// Generation mode: "random" for random character strings or
+	// "passphrase" for diceware-style passphrases that are easier to type
+	Mode string `yaml:"mode"`
+
+	// Passphrase settings, used when mode is "passphrase"
+	Passphrase PassphraseConfig `yaml:"passphrase"`
+
 	// Length of generated passwords (minimum 12 recommended)
 	// Only used when generating random passwords
 	Length int `yaml:"length"`
@@ -91,6 +195,70 @@ type PasswordConfig struct {
 	// Whether to generate random passwords when no predefined password is available
 	// If false and no predefined/default password exists, the operation will fail
 	GenerateRandom bool `yaml:"generate_random"`
+
+	// Password reuse prevention settings
+	Reuse ReuseConfig `yaml:"reuse"`
+
+	// Minimum estimated entropy (in bits) for predefined, default and stored
+	// passwords; weaker passwords are rejected (0 disables the check)
+	MinEntropyBits float64 `yaml:"min_entropy_bits"`
+
+	// Offline check of predefined, default and stored passwords against a
+	// local corpus of breached passwords
+	BreachCheck BreachCheckConfig `yaml:"breach_check"`
+
+	// Whether to read the consumer's 389DS password policy before rotating
+	// "auto" reads it and warns if it cannot, "require" stops the run if it
+	// cannot be read, "off" only uses the settings in this file
+	ConsumerPolicy string `yaml:"consumer_policy"`
+}
+
+// PassphraseConfig controls diceware-style passphrase generation
+// Words are picked at random from the EFF large wordlist (7776 words),
+// so every word adds about 12.9 bits of entropy
+// Example with the defaults: "Crumpet-unbolted-graph-sly7-reopen-anemia"
+type PassphraseConfig struct {
+	// Number of words (6 or more recommended)
+	Words int `yaml:"words"`
+
+	// Text placed between words
+	Separator string `yaml:"separator"`
+
+	// Capitalize every word (otherwise one word is capitalized only if required)
+	Capitalize bool `yaml:"capitalize"`
+
+	// Always insert a random digit / symbol at a random word boundary
+	// They are also inserted automatically when a password policy requires them
+	IncludeDigit  bool `yaml:"include_digit"`
+	IncludeSymbol bool `yaml:"include_symbol"`
+}
+
+// BreachCheckConfig points to a local corpus of breached password hashes
+// The corpus is either a Have I Been Pwned "SHA-1 ordered by hash" text file
+// or a bloom filter built from it with the build-breach-filter command
+// No network access is needed; passwords found in the corpus are rejected
+type BreachCheckConfig struct {
+	// Path of the corpus (empty disables the check)
+	File string `yaml:"file"`
+
+	// File format: "auto" (detect), "sorted" (hash text file) or "bloom"
+	Format string `yaml:"format"`
+}
+
+// ReuseConfig controls password reuse prevention
+// A salted hash of every applied password is kept per agreement so that the
+// same password cannot be applied again within the last N rotations
+// It also controls what happens when two agreements would share a password
+type ReuseConfig struct {
+	// File holding the salted password hashes (empty disables the history check)
+	HistoryFile string `yaml:"history_file"`
+
+	// Number of previous rotations in which a password may not be reused
+	HistoryDepth int `yaml:"history_depth"`
+
+	// What to do when two agreements would get the same password:
+	// "warn" prints a warning, "strict" refuses to continue, "allow" says nothing
+	SharedPolicy string `yaml:"shared_policy"`
 }
 
 // GRPCConfig settings for real-time error monitoring
@@ -104,10 +272,67 @@ type GRPCConfig struct {
 	Port int `yaml:"port"`
 
 	// Log file paths to monitor for error 49
+	// Leave empty to watch every local 389DS instance: the access and error
+	// logs named in /etc/dirsrv/slapd-*/dse.ldif
 	LogPaths []string `yaml:"log_paths"`
 
+	// Directory holding the slapd-<instance> configuration directories
+	InstanceDir string `yaml:"instance_dir"`
+
+	// How often to look for added or removed instances (in seconds)
+	RescanInterval int `yaml:"rescan_interval"`
+
 	// How often to check log files (in seconds)
 	CheckInterval int `yaml:"check_interval"`
+
+	// Instances that log to the journal instead of files
+	Journald JournaldSourceConfig `yaml:"journald"`
+
+	// Receives the logs of remote servers forwarded over syslog
+	Syslog SyslogReceiverConfig `yaml:"syslog"`
+
+	// How error 49 lines are grouped into incidents
+	Incidents IncidentConfig `yaml:"incidents"`
+}
+
+// IncidentConfig separates a one-off authentication failure from a
+// sustained one
+// Error 49 lines of the same agreement and consumer form one incident; it
+// becomes active once Threshold lines are seen within Window seconds, and is
+// resolved after ResolveAfter seconds without any line
+// Notifications and remediation run when an incident becomes active or
+// resolved, never for single lines
+type IncidentConfig struct {
+	Threshold    int `yaml:"threshold"`
+	Window       int `yaml:"window"`
+	ResolveAfter int `yaml:"resolve_after"`
+}
+
+// JournaldSourceConfig reads the logs of 389DS instances from the journal
+// Each instance is read from the unit dirsrv@<instance>; the position in the
+// journal is saved in CursorDir so a restarted monitor resumes where it stopped
+type JournaldSourceConfig struct {
+	Enabled bool `yaml:"enabled"`
+
+	// Instance names, as in dirsrv@<instance>
+	Instances []string `yaml:"instances"`
+
+	// Directory for the saved journal cursors
+	CursorDir string `yaml:"cursor_dir"`
+
+	// journalctl command to run
+	Journalctl string `yaml:"journalctl"`
+}
+
+// SyslogReceiverConfig makes the monitor a syslog receiver
+// 389DS servers forward their access and error logs (rsyslog, syslog-ng)
+// and the monitor tags every line with the hostname of its sender, so one
+// monitor covers the whole fleet
+// UDP and TCP are listen addresses such as ":514"; empty disables one
+type SyslogReceiverConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	UDP     string `yaml:"udp"`
+	TCP     string `yaml:"tcp"`
 }
 
 // LoggingConfig controls application logging behavior
@@ -116,11 +341,216 @@ type LoggingConfig struct {
 	// Log level: debug, info, warn, error
 	Level string `yaml:"level"`
 
-	// Log file path (empty means stdout only)
+	// Log file path (empty means standard error)
+	// The file is reopened on SIGHUP, so logrotate can rotate it
 	File string `yaml:"file"`
 
 	// Enable timestamps in log messages
 	Timestamps bool `yaml:"timestamps"`
+
+	// Line format: "text" (key=value pairs) or "json" (one object per line)
+	Format string `yaml:"format"`
+}
+
+// AuditConfig controls the audit log
+// Every add, modify and delete the tool sends to a server is appended to the
+// audit log with the operator, run ID, host, DN, attribute names and result;
+// attribute values are never written
+// Records are hash-chained, see "audit verify"
+type AuditConfig struct {
+	// Audit log path; <file>.head next to it holds the hash of the last record
+	File string `yaml:"file"`
+}
+
+// EventsConfig controls where rotation and monitor events are sent
+// Events are rotation start, the outcome of every agreement, the end of a run
+// and every error 49 the monitor detects; a SIEM can collect them from
+// syslog or the journal
+type EventsConfig struct {
+	// APP-NAME of syslog messages and SYSLOG_IDENTIFIER in the journal
+	AppName string `yaml:"app_name"`
+
+	// Syslog facility: kern, user, mail, daemon, auth, syslog, lpr, news,
+	// uucp, cron, authpriv, ftp or local0 to local7
+	Facility string `yaml:"facility"`
+
+	Syslog   SyslogConfig   `yaml:"syslog"`
+	Journald JournaldConfig `yaml:"journald"`
+
+	// HTTP webhook targets, each with its own routing and payload format
+	Webhooks []WebhookConfig `yaml:"webhooks"`
+
+	// Email alerts over SMTP
+	Email EmailConfig `yaml:"email"`
+}
+
+// EmailConfig sends events as plain text email
+// With a digest window, events are collected and sent as one mail per
+// window, so a flapping agreement does not flood the mailbox
+type EmailConfig struct {
+	Enabled bool `yaml:"enabled"`
+
+	// SMTP server
+	Host string `yaml:"host"`
+	Port int    `yaml:"port"`
+
+	// Transport security: "starttls" (upgrade a plain connection, usually
+	// port 587), "tls" (TLS from the start, usually port 465) or "none"
+	TLS string `yaml:"tls"`
+
+	// Authentication (PLAIN); leave username empty for none
+	// The password is read from a file or an environment variable
+	Username     string `yaml:"username"`
+	PasswordFile string `yaml:"password_file"`
+	PasswordEnv  string `yaml:"password_env"`
+
+	From          string   `yaml:"from"`
+	To            []string `yaml:"to"`
+	SubjectPrefix string   `yaml:"subject_prefix"`
+
+	// Routing like webhooks: event type and agreement name globs
+	Events     []string `yaml:"events"`
+	Agreements []string `yaml:"agreements"`
+
+	// Collect events for this many seconds and send them as one digest
+	// 0 sends one mail per event
+	// rotation.finished always sends the digest at once, so the report of a
+	// run arrives when the run ends
+	DigestWindow int `yaml:"digest_window"`
+
+	// Connection and send timeout in seconds
+	Timeout int `yaml:"timeout"`
+}
+
+// WebhookConfig is one HTTP target that receives events as POST requests
+// Delivery happens in the background and is retried with exponential
+// backoff on network errors, 429 and 5xx answers
+type WebhookConfig struct {
+	// Name identifies the target in log messages
+	Name string `yaml:"name"`
+
+	URL string `yaml:"url"`
+
+	// Payload shape: "json" (the event as a JSON object), "slack",
+	// "mattermost" or "teams"
+	Format string `yaml:"format"`
+
+	// Go text/template for the request body, overriding format
+	// The event is the data, e.g. {"text": {{json .Message}}}
+	Template string `yaml:"template"`
+
+	// Extra request headers, for example an Authorization header
+	Headers map[string]string `yaml:"headers"`
+
+	// HMAC-SHA256 signing key, read from a file or an environment variable
+	// Requests then carry X-LRM-Timestamp and X-LRM-Signature headers
+	SecretFile string `yaml:"secret_file"`
+	SecretEnv  string `yaml:"secret_env"`
+
+	// Routing: event type and agreement name globs; empty matches all
+	// Events without an agreement, such as rotation.finished, only need
+	// to match the event types
+	Events     []string `yaml:"events"`
+	Agreements []string `yaml:"agreements"`
+
+	// Request timeout in seconds and number of retries after the first attempt
+	Timeout    int `yaml:"timeout"`
+	MaxRetries int `yaml:"max_retries"`
+}
+
+// SyslogConfig sends events as RFC 5424 syslog messages
+type SyslogConfig struct {
+	Enabled bool `yaml:"enabled"`
+
+	// Transport: "unix" (local datagram socket), "udp" or "tcp"
+	Network string `yaml:"network"`
+
+	// Socket path for unix (default /dev/log), host:port for udp and tcp
+	Address string `yaml:"address"`
+}
+
+// JournaldConfig sends events to the systemd journal with structured fields
+type JournaldConfig struct {
+	Enabled bool `yaml:"enabled"`
+
+	// Journal socket of the native protocol
+	Socket string `yaml:"socket"`
+}
+
+// SecretsConfig selects where credentials are read from and written to
+// By default everything comes from this configuration file
+// Setting a backend lets the tool pull the Directory Manager password and
+// predefined agreement passwords from a secret store, and save newly
+// applied agreement passwords back to it after a successful rotation
+type SecretsConfig struct {
+	// Secret store backend: "" (configuration file only) or "vault"
+	Backend string `yaml:"backend"`
+
+	// HashiCorp Vault settings, used when backend is "vault"
+	Vault VaultConfig `yaml:"vault"`
+
+	// Local encrypted password vault file for sites without Vault
+	LocalVault LocalVaultConfig `yaml:"local_vault"`
+}
+
+// LocalVaultConfig controls the local encrypted password vault file
+// Every password applied in production mode is saved here so that it can be
+// recovered later with the get, list and history commands
+// The file is encrypted with AES-256-GCM using a key derived from a
+// passphrase (read from an environment variable) or from a key file
+type LocalVaultConfig struct {
+	// Path of the vault file (empty disables the local vault)
+	File string `yaml:"file"`
+
+	// Environment variable holding the passphrase
+	PassphraseEnv string `yaml:"passphrase_env"`
+
+	// Key file used instead of a passphrase (its contents are the secret)
+	KeyFile string `yaml:"key_file"`
+
+	// Number of previous passwords kept per agreement
+	History int `yaml:"history"`
+}
+
+// VaultConfig contains the HashiCorp Vault KV version 2 settings
+// Secrets are addressed as <mount>/<path>, for example secret/ldap/agreements/name
+// Each secret stores its password under the configured key
+type VaultConfig struct {
+	// Vault server address, for example https://vault.example.com:8200
+	// Falls back to the VAULT_ADDR environment variable
+	Address string `yaml:"address"`
+
+	// Vault token used for authentication
+	// Falls back to token_file, then to the VAULT_TOKEN environment variable
+	Token     string `yaml:"token"`
+	TokenFile string `yaml:"token_file"`
+
+	// Vault Enterprise namespace (leave empty for open source Vault)
+	Namespace string `yaml:"namespace"`
+
+	// Mount point of the KV version 2 secrets engine
+	Mount string `yaml:"mount"`
+
+	// Secret holding the Directory Manager password
+	// Only read when ldap.password is empty in this file
+	BindPasswordPath string `yaml:"bind_password_path"`
+
+	// Parent path for per-agreement secrets (one secret per agreement name)
+	// These are passwords chosen by operators; the tool only reads them
+	AgreementPath string `yaml:"agreement_path"`
+
+	// Parent path where applied passwords are written after a rotation
+	// It must differ from agreement_path: a rotated password read back as
+	// an operator's choice would be applied again on every later run
+	RotatedPath string `yaml:"rotated_path"`
+
+	// Key inside each secret that holds the password
+	PasswordKey string `yaml:"password_key"`
+
+	// TLS and timeout settings for the Vault HTTP client
+	CACert        string `yaml:"ca_cert"`
+	SkipTLSVerify bool   `yaml:"skip_tls_verify"`
+	Timeout       int    `yaml:"timeout"`
 }
 
 // Load reads configuration from a YAML file
@@ -136,7 +566,9 @@ func Load(filename string) (*Config, error) {
 	}
 
 	// Parse YAML content into our configuration structure
+	// Booleans that default to true are set first so the file can turn them off
 	var config Config
+	config.Logging.Timestamps = true
 	if err := yaml.Unmarshal(data, &config); err != nil {
 		return nil, fmt.Errorf("failed to parse YAML config: %v", err)
 	}
@@ -167,8 +599,49 @@ func setDefaults(config *Config) {
 	if config.LDAP.Timeout == 0 {
 		config.LDAP.Timeout = 30 // 30 second timeout
 	}
+	if config.LDAP.MaxConnectionsPerHost == 0 {
+		config.LDAP.MaxConnectionsPerHost = 2 // Gentle on busy servers
+	}
+
+	// Rotation defaults
+	if config.Rotation.Parallelism == 0 {
+		config.Rotation.Parallelism = 4
+	}
+	if config.Rotation.Strategy == "" {
+		config.Rotation.Strategy = "in-place"
+	}
+	if len(config.Rotation.Accounts) == 0 {
+		config.Rotation.Accounts = []string{
+			"cn=replication manager,cn=config",
+			"cn=replication manager 2,cn=config",
+		}
+	}
+	if config.Rotation.VerifyTimeout == 0 {
+		config.Rotation.VerifyTimeout = 60
+	}
+	if config.Rotation.StateDir == "" {
+		config.Rotation.StateDir = "runs"
+	}
+	if config.Rotation.LockDN == "" {
+		config.Rotation.LockDN = "cn=replication password rotation lock,cn=config"
+	}
+	if config.Rotation.LockTTL == 0 {
+		config.Rotation.LockTTL = 900
+	}
+	if config.Rotation.Approval.TokenTTL == 0 {
+		config.Rotation.Approval.TokenTTL = 86400
+	}
 
 	// Password generation defaults
+	if config.Password.Mode == "" {
+		config.Password.Mode = "random"
+	}
+	if config.Password.Passphrase.Words == 0 {
+		config.Password.Passphrase.Words = 6
+	}
+	if config.Password.Passphrase.Separator == "" {
+		config.Password.Passphrase.Separator = "-"
+	}
 	if config.Password.Length == 0 {
 		config.Password.Length = 16 // Strong password length
 	}
@@ -183,6 +656,22 @@ func setDefaults(config *Config) {
 		config.Password.ExcludeChars = "0O1lI" // Avoid look-alike characters
 	}
 
+	if config.Password.ConsumerPolicy == "" {
+		config.Password.ConsumerPolicy = "auto"
+	}
+
+	if config.Password.BreachCheck.Format == "" {
+		config.Password.BreachCheck.Format = "auto"
+	}
+
+	// Reuse prevention defaults
+	if config.Password.Reuse.HistoryDepth == 0 {
+		config.Password.Reuse.HistoryDepth = 5
+	}
+	if config.Password.Reuse.SharedPolicy == "" {
+		config.Password.Reuse.SharedPolicy = "warn"
+	}
+
 	// Initialize predefined passwords map if nil
 	if config.Password.PredefinedPasswords == nil {
 		config.Password.PredefinedPasswords = make(map[string]string)
@@ -200,19 +689,121 @@ func setDefaults(config *Config) {
 	if config.GRPC.CheckInterval == 0 {
 		config.GRPC.CheckInterval = 5 // Check every 5 seconds
 	}
-	// Default log paths for RHEL 389DS
-	if len(config.GRPC.LogPaths) == 0 {
-		config.GRPC.LogPaths = []string{
-			"/var/log/dirsrv/slapd-ldap/errors",
-			"/var/log/dirsrv/slapd-ldap/access",
-		}
+	if config.GRPC.Incidents.Threshold == 0 {
+		config.GRPC.Incidents.Threshold = 3
+	}
+	if config.GRPC.Incidents.Window == 0 {
+		config.GRPC.Incidents.Window = 120
+	}
+	if config.GRPC.Incidents.ResolveAfter == 0 {
+		config.GRPC.Incidents.ResolveAfter = 600
+	}
+
+	// Without log paths the monitor discovers the local 389DS instances
+	if config.GRPC.InstanceDir == "" {
+		config.GRPC.InstanceDir = "/etc/dirsrv"
+	}
+	if config.GRPC.RescanInterval == 0 {
+		config.GRPC.RescanInterval = 60
+	}
+	if config.GRPC.Journald.CursorDir == "" {
+		config.GRPC.Journald.CursorDir = "."
+	}
+	if config.GRPC.Journald.Journalctl == "" {
+		config.GRPC.Journald.Journalctl = "journalctl"
+	}
+	if config.GRPC.Syslog.UDP == "" && config.GRPC.Syslog.TCP == "" {
+		config.GRPC.Syslog.UDP = ":514"
+		config.GRPC.Syslog.TCP = ":514"
 	}
 
 	// Logging defaults
 	if config.Logging.Level == "" {
 		config.Logging.Level = "info"
 	}
-	config.Logging.Timestamps = true
+	if config.Logging.Format == "" {
+		config.Logging.Format = "text"
+	}
+
+	// Audit defaults
+	if config.Audit.File == "" {
+		config.Audit.File = "audit.log"
+	}
+
+	// Event defaults
+	if config.Events.AppName == "" {
+		config.Events.AppName = "ldap-replication-manager"
+	}
+	if config.Events.Facility == "" {
+		config.Events.Facility = "daemon"
+	}
+	if config.Events.Syslog.Network == "" {
+		config.Events.Syslog.Network = "unix"
+	}
+	if config.Events.Syslog.Address == "" && config.Events.Syslog.Network == "unix" {
+		config.Events.Syslog.Address = "/dev/log"
+	}
+	if config.Events.Journald.Socket == "" {
+		config.Events.Journald.Socket = "/run/systemd/journal/socket"
+	}
+	if config.Events.Email.Port == 0 {
+		config.Events.Email.Port = 587
+	}
+	if config.Events.Email.TLS == "" {
+		config.Events.Email.TLS = "starttls"
+	}
+	if config.Events.Email.SubjectPrefix == "" {
+		config.Events.Email.SubjectPrefix = "[ldap-replication-manager]"
+	}
+	if config.Events.Email.Timeout == 0 {
+		config.Events.Email.Timeout = 30
+	}
+	for i := range config.Events.Webhooks {
+		webhook := &config.Events.Webhooks[i]
+		if webhook.Name == "" {
+			webhook.Name = fmt.Sprintf("webhook-%d", i+1)
+		}
+		if webhook.Format == "" {
+			webhook.Format = "json"
+		}
+		if webhook.Timeout == 0 {
+			webhook.Timeout = 10
+		}
+		if webhook.MaxRetries == 0 {
+			webhook.MaxRetries = 5
+		}
+	}
+
+	// Vault defaults follow the conventions of the vault CLI
+	if config.Secrets.Vault.Address == "" {
+		config.Secrets.Vault.Address = os.Getenv("VAULT_ADDR")
+	}
+	if config.Secrets.Vault.Mount == "" {
+		config.Secrets.Vault.Mount = "secret"
+	}
+	if config.Secrets.Vault.BindPasswordPath == "" {
+		config.Secrets.Vault.BindPasswordPath = "ldap-replication-manager/directory-manager"
+	}
+	if config.Secrets.Vault.AgreementPath == "" {
+		config.Secrets.Vault.AgreementPath = "ldap-replication-manager/agreements"
+	}
+	if config.Secrets.Vault.RotatedPath == "" {
+		config.Secrets.Vault.RotatedPath = "ldap-replication-manager/rotated"
+	}
+	if config.Secrets.Vault.PasswordKey == "" {
+		config.Secrets.Vault.PasswordKey = "password"
+	}
+	if config.Secrets.Vault.Timeout == 0 {
+		config.Secrets.Vault.Timeout = 10
+	}
+
+	// Local vault defaults
+	if config.Secrets.LocalVault.PassphraseEnv == "" {
+		config.Secrets.LocalVault.PassphraseEnv = "LRM_VAULT_PASSPHRASE"
+	}
+	if config.Secrets.LocalVault.History == 0 {
+		config.Secrets.LocalVault.History = 10
+	}
 }
 
 // validate ensures required configuration values are present
@@ -226,15 +817,169 @@ func validate(config *Config) error {
 	if config.LDAP.BindDN == "" {
 		return fmt.Errorf("LDAP bind DN is required")
 	}
-	if config.LDAP.Password == "" {
+	// The password may instead come from the secret store
+	if config.LDAP.Password == "" && config.Secrets.Backend == "" {
 		return fmt.Errorf("LDAP password is required")
 	}
+	switch strings.ToUpper(strings.Trim(config.LDAP.ConsumerPasswordScheme, "{}")) {
+	case "", "SSHA256", "SSHA512", "PBKDF2_SHA256", "PBKDF2-SHA256", "PBKDF2-SHA512":
+	default:
+		return fmt.Errorf("unknown consumer password scheme %q (supported: SSHA256, SSHA512, PBKDF2_SHA256, PBKDF2-SHA256, PBKDF2-SHA512)", config.LDAP.ConsumerPasswordScheme)
+	}
+
+	if config.LDAP.MaxConnectionsPerHost < 0 {
+		return fmt.Errorf("LDAP max_connections_per_host must not be negative")
+	}
+	if config.Rotation.Parallelism < 0 {
+		return fmt.Errorf("rotation parallelism must not be negative")
+	}
+	switch config.Rotation.Strategy {
+	case "in-place":
+	case "bind-group":
+		if len(config.Rotation.Accounts) != 2 || strings.EqualFold(config.Rotation.Accounts[0], config.Rotation.Accounts[1]) {
+			return fmt.Errorf("rotation accounts must list two different replication manager DNs")
+		}
+	default:
+		return fmt.Errorf("rotation strategy must be in-place or bind-group")
+	}
+	if config.Rotation.VerifyTimeout < 0 {
+		return fmt.Errorf("rotation verify_timeout must not be negative")
+	}
+	if config.Rotation.LockTTL < 30 {
+		return fmt.Errorf("rotation lock_ttl must be at least 30 seconds")
+	}
+	if config.Rotation.Approval.Required && config.Rotation.Approval.KeyFile == "" && config.Rotation.Approval.ApproversDir == "" {
+		return fmt.Errorf("rotation approval key_file or approvers_dir is required when approval is required")
+	}
+	if config.Rotation.Approval.KeyFile != "" && config.Rotation.Approval.ApproversDir != "" {
+		return fmt.Errorf("rotation approval takes either key_file or approvers_dir, not both")
+	}
+	if config.Rotation.Approval.TokenTTL < 0 {
+		return fmt.Errorf("rotation approval token_ttl must not be negative")
+	}
+
+	// Validate logging settings
+	switch strings.ToLower(config.Logging.Level) {
+	case "debug", "info", "warn", "warning", "error":
+	default:
+		return fmt.Errorf("logging level must be debug, info, warn or error")
+	}
+	if config.Logging.Format != "text" && config.Logging.Format != "json" {
+		return fmt.Errorf("logging format must be text or json")
+	}
+
+	// The audit log must not share a file with anything else, or its chain breaks
+	if config.Logging.File != "" && filepath.Clean(config.Logging.File) == filepath.Clean(config.Audit.File) {
+		return fmt.Errorf("audit file must differ from the logging file")
+	}
+
+	// Validate event sinks
+	switch config.Events.Syslog.Network {
+	case "unix", "udp", "tcp":
+	default:
+		return fmt.Errorf("events syslog network must be unix, udp or tcp")
+	}
+	if config.Events.Syslog.Enabled && config.Events.Syslog.Address == "" {
+		return fmt.Errorf("events syslog address is required for %s", config.Events.Syslog.Network)
+	}
+	if err := validateEmail(config.Events.Email); err != nil {
+		return err
+	}
+	for _, webhook := range config.Events.Webhooks {
+		if !strings.HasPrefix(webhook.URL, "https://") && !strings.HasPrefix(webhook.URL, "http://") {
+			return fmt.Errorf("webhook %s: url must start with https:// or http://", webhook.Name)
+		}
+		switch webhook.Format {
+		case "json", "slack", "mattermost", "teams":
+		default:
+			return fmt.Errorf("webhook %s: format must be json, slack, mattermost or teams", webhook.Name)
+		}
+		if webhook.SecretFile != "" && webhook.SecretEnv != "" {
+			return fmt.Errorf("webhook %s: set either secret_file or secret_env, not both", webhook.Name)
+		}
+		if webhook.Timeout < 0 || webhook.MaxRetries < 0 {
+			return fmt.Errorf("webhook %s: timeout and max_retries must not be negative", webhook.Name)
+		}
+		for _, pattern := range append(append([]string{}, webhook.Events...), webhook.Agreements...) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("webhook %s: invalid pattern %q", webhook.Name, pattern)
+			}
+		}
+	}
+
+	// Validate secret store settings
+	switch config.Secrets.Backend {
+	case "":
+	case "vault":
+		if config.Secrets.Vault.Address == "" {
+			return fmt.Errorf("vault address is required when secrets backend is vault")
+		}
+		if strings.Trim(config.Secrets.Vault.RotatedPath, "/") == strings.Trim(config.Secrets.Vault.AgreementPath, "/") {
+			return fmt.Errorf("vault rotated_path must differ from agreement_path")
+		}
+	default:
+		return fmt.Errorf("unknown secrets backend %q (supported: vault)", config.Secrets.Backend)
+	}
+	if config.Secrets.LocalVault.History < 0 {
+		return fmt.Errorf("local vault history must not be negative")
+	}
 
 	// Validate password settings
 	if config.Password.Length < 8 {
 		return fmt.Errorf("password length must be at least 8 characters")
 	}
 
+	switch config.Password.BreachCheck.Format {
+	case "auto", "sorted", "bloom":
+	default:
+		return fmt.Errorf("password breach_check format must be auto, sorted or bloom")
+	}
+	if config.Password.MinEntropyBits < 0 {
+		return fmt.Errorf("password min_entropy_bits must not be negative")
+	}
+	switch config.Password.Mode {
+	case "random":
+	case "passphrase":
+		if config.Password.Passphrase.Words < 3 {
+			return fmt.Errorf("passphrase must have at least 3 words")
+		}
+	default:
+		return fmt.Errorf("password mode must be random or passphrase")
+	}
+	switch config.Password.ConsumerPolicy {
+	case "auto", "require", "off":
+	default:
+		return fmt.Errorf("password consumer_policy must be auto, require or off")
+	}
+	if config.Password.Reuse.HistoryDepth < 1 {
+		return fmt.Errorf("password reuse history_depth must be at least 1")
+	}
+	switch config.Password.Reuse.SharedPolicy {
+	case "warn", "strict", "allow":
+	default:
+		return fmt.Errorf("password reuse shared_policy must be warn, strict or allow")
+	}
+
+	if config.GRPC.CheckInterval < 1 || config.GRPC.RescanInterval < 1 {
+		return fmt.Errorf("grpc check_interval and rescan_interval must be at least 1")
+	}
+
+	if config.GRPC.Journald.Enabled {
+		if len(config.GRPC.Journald.Instances) == 0 {
+			return fmt.Errorf("grpc journald needs at least one instance")
+		}
+		for _, instance := range config.GRPC.Journald.Instances {
+			if instance == "" || strings.ContainsAny(instance, "/ ") {
+				return fmt.Errorf("grpc journald instance %q is not a valid instance name", instance)
+			}
+		}
+	}
+
+	// Validate incident grouping
+	if config.GRPC.Incidents.Threshold < 1 || config.GRPC.Incidents.Window < 1 || config.GRPC.Incidents.ResolveAfter < 1 {
+		return fmt.Errorf("grpc incidents threshold, window and resolve_after must be at least 1")
+	}
+
 	// Validate GRPC settings if enabled
 	if config.GRPC.Enabled {
 		if config.GRPC.Port < 1 || config.GRPC.Port > 65535 {
@@ -244,3 +989,33 @@ func validate(config *Config) error {
 
 	return nil
 }
+
+// validateEmail checks the email section when it is enabled
+func validateEmail(email EmailConfig) error {
+	if !email.Enabled {
+		return nil
+	}
+	if email.Host == "" || email.From == "" || len(email.To) == 0 {
+		return fmt.Errorf("events email needs host, from and to")
+	}
+	if email.Port < 1 || email.Port > 65535 {
+		return fmt.Errorf("events email port must be between 1 and 65535")
+	}
+	switch email.TLS {
+	case "starttls", "tls", "none":
+	default:
+		return fmt.Errorf("events email tls must be starttls, tls or none")
+	}
+	if email.PasswordFile != "" && email.PasswordEnv != "" {
+		return fmt.Errorf("events email: set either password_file or password_env, not both")
+	}
+	if email.DigestWindow < 0 || email.Timeout < 0 {
+		return fmt.Errorf("events email digest_window and timeout must not be negative")
+	}
+	for _, pattern := range append(append([]string{}, email.Events...), email.Agreements...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("events email: invalid pattern %q", pattern)
+		}
+	}
+	return nil
+}
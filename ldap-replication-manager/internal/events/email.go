@@ -0,0 +1,340 @@
+package events
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/smtp"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ldap-replication-manager/internal/config"
+)
+
+// digestMaxListed is how many events a digest lists one by one; the
+// summary at the top still counts all of them
+const digestMaxListed = 200
+
+// EmailSink sends events as plain text email over SMTP
+// Like webhooks, Send only queues the event and a background worker does
+// the sending
+// Without a digest window every event is one mail; with a window the first
+// event opens it and everything routed during the window goes out as one
+// digest, summarised per event type and agreement
+// rotation.finished closes the window early so a run's report is not held back
+type EmailSink struct {
+	config   config.EmailConfig
+	password string
+	logger   *slog.Logger
+	queue    chan Event
+	done     chan struct{}
+	closeOne sync.Once
+}
+
+// NewEmailSink prepares the SMTP notifier and starts its worker
+// The server is not contacted until the first mail is sent; use the
+// events test command to check the settings
+func NewEmailSink(cfg config.EmailConfig, logger *slog.Logger) (*EmailSink, error) {
+	var password string
+	switch {
+	case cfg.PasswordFile != "":
+		data, err := os.ReadFile(cfg.PasswordFile)
+		if err != nil {
+			return nil, fmt.Errorf("email: failed to read password: %v", err)
+		}
+		password = strings.TrimSpace(string(data))
+	case cfg.PasswordEnv != "":
+		password = os.Getenv(cfg.PasswordEnv)
+		if password == "" {
+			return nil, fmt.Errorf("email: environment variable %s is empty", cfg.PasswordEnv)
+		}
+	}
+
+	sink := &EmailSink{
+		config:   cfg,
+		password: password,
+		logger:   logger.With("component", "events", "smtp", cfg.Host),
+		queue:    make(chan Event, queueSize),
+		done:     make(chan struct{}),
+	}
+	go sink.run()
+	return sink, nil
+}
+
+// Name identifies the sink in log messages
+func (e *EmailSink) Name() string {
+	return "email " + e.config.Host
+}
+
+// Send queues an event that matches the routes of the notifier
+func (e *EmailSink) Send(event Event) error {
+	if !routed(e.config.Events, e.config.Agreements, event) {
+		return nil
+	}
+	select {
+	case e.queue <- event:
+		return nil
+	default:
+		return fmt.Errorf("mail queue is full, event dropped")
+	}
+}
+
+// Close sends what is still queued, including an open digest, and stops
+// the worker; it gives up after drainTimeout
+func (e *EmailSink) Close() error {
+	e.closeOne.Do(func() { close(e.queue) })
+	select {
+	case <-e.done:
+		return nil
+	case <-time.After(drainTimeout):
+		return fmt.Errorf("gave up on unsent mail after %s", drainTimeout)
+	}
+}
+
+// run is the worker: it sends events one by one, or collects them into
+// digests when a window is configured
+func (e *EmailSink) run() {
+	defer close(e.done)
+	window := time.Duration(e.config.DigestWindow) * time.Second
+
+	var pending []Event
+	var timer <-chan time.Time
+	flush := func() {
+		if len(pending) > 0 {
+			e.send(e.digest(pending))
+		}
+		pending, timer = nil, nil
+	}
+
+	for {
+		select {
+		case event, ok := <-e.queue:
+			if !ok {
+				flush()
+				return
+			}
+			if window == 0 || event.Type == TypeTest {
+				e.send(e.single(event))
+				continue
+			}
+			if len(pending) == 0 {
+				timer = time.After(window)
+			}
+			pending = append(pending, event)
+			if event.Type == TypeRotationFinished {
+				flush()
+			}
+		case <-timer:
+			flush()
+		}
+	}
+}
+
+// mail is one message ready to send
+type mail struct {
+	subject string
+	body    string
+}
+
+// single formats the mail of one event
+func (e *EmailSink) single(event Event) mail {
+	subject := fmt.Sprintf("%s %s %s", e.config.SubjectPrefix, strings.ToUpper(event.Severity.String()), event.Type)
+	if event.Agreement != "" {
+		subject += " " + event.Agreement
+	}
+	var body strings.Builder
+	body.WriteString(event.Message + "\n\n")
+	writeEventDetails(&body, event)
+	return mail{subject: subject, body: body.String()}
+}
+
+// digest formats the mail of a batch of events
+func (e *EmailSink) digest(batch []Event) mail {
+	// Count per event type and agreement, keeping first and last seen
+	type bucket struct {
+		key         string
+		count       int
+		first, last time.Time
+	}
+	buckets := make(map[string]*bucket)
+	types := make(map[string]int)
+	worst := SeverityInfo
+	for _, event := range batch {
+		types[event.Type]++
+		if event.Severity < worst {
+			worst = event.Severity
+		}
+		key := event.Type
+		if event.Agreement != "" {
+			key += " " + event.Agreement
+		}
+		b := buckets[key]
+		if b == nil {
+			b = &bucket{key: key, first: event.Time}
+			buckets[key] = b
+		}
+		b.count++
+		b.last = event.Time
+	}
+
+	typeNames := make([]string, 0, len(types))
+	for name := range types {
+		typeNames = append(typeNames, name)
+	}
+	sort.Strings(typeNames)
+	counts := make([]string, 0, len(typeNames))
+	for _, name := range typeNames {
+		counts = append(counts, fmt.Sprintf("%d %s", types[name], name))
+	}
+	subject := fmt.Sprintf("%s %s digest: %d events (%s)", e.config.SubjectPrefix, strings.ToUpper(worst.String()), len(batch), strings.Join(counts, ", "))
+
+	keys := make([]string, 0, len(buckets))
+	for key := range buckets {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var body strings.Builder
+	fmt.Fprintf(&body, "%d events between %s and %s\n\n", len(batch),
+		batch[0].Time.Format(time.RFC1123), batch[len(batch)-1].Time.Format(time.RFC1123))
+	fmt.Fprintf(&body, "%-60s %6s  %-8s  %-8s\n", "EVENT", "COUNT", "FIRST", "LAST")
+	for _, key := range keys {
+		b := buckets[key]
+		fmt.Fprintf(&body, "%-60s %6d  %-8s  %-8s\n", b.key, b.count, b.first.Format("15:04:05"), b.last.Format("15:04:05"))
+	}
+
+	body.WriteString("\nEvents:\n")
+	for i, event := range batch {
+		if i == digestMaxListed {
+			fmt.Fprintf(&body, "\n... and %d more\n", len(batch)-digestMaxListed)
+			break
+		}
+		fmt.Fprintf(&body, "\n%s  %s\n", event.Time.Format("15:04:05"), event.Message)
+		writeEventDetails(&body, event)
+	}
+	return mail{subject: subject, body: body.String()}
+}
+
+// writeEventDetails writes the fields of an event, one per line
+func writeEventDetails(body *strings.Builder, event Event) {
+	fmt.Fprintf(body, "  Event:     %s (%s)\n", event.Type, event.Severity)
+	fmt.Fprintf(body, "  Time:      %s\n", event.Time.Format(time.RFC1123))
+	if event.RunID != "" {
+		fmt.Fprintf(body, "  Run:       %s\n", event.RunID)
+	}
+	if event.Agreement != "" {
+		fmt.Fprintf(body, "  Agreement: %s\n", event.Agreement)
+	}
+	if event.Host != "" {
+		fmt.Fprintf(body, "  Host:      %s\n", event.Host)
+	}
+	keys := make([]string, 0, len(event.Fields))
+	for key := range event.Fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(body, "  %-10s %s\n", key+":", event.Fields[key])
+	}
+}
+
+// send delivers one mail; failures are logged, the events are then lost
+func (e *EmailSink) send(m mail) {
+	if err := e.deliver(m); err != nil {
+		e.logger.Error("Could not send mail", "subject", m.subject, "error", err)
+		return
+	}
+	e.logger.Debug("Mail sent", "subject", m.subject, "recipients", len(e.config.To))
+}
+
+// deliver runs one SMTP session
+// PLAIN authentication is refused by net/smtp over an unencrypted
+// connection to anything but localhost, so a password never travels in clear
+func (e *EmailSink) deliver(m mail) error {
+	address := net.JoinHostPort(e.config.Host, strconv.Itoa(e.config.Port))
+	timeout := time.Duration(e.config.Timeout) * time.Second
+	tlsConfig := &tls.Config{ServerName: e.config.Host}
+
+	var conn net.Conn
+	var err error
+	if e.config.TLS == "tls" {
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", address, tlsConfig)
+	} else {
+		conn, err = net.DialTimeout("tcp", address, timeout)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %v", address, err)
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	client, err := smtp.NewClient(conn, e.config.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if hostname := hostname(); hostname != "-" {
+		if err := client.Hello(hostname); err != nil {
+			return err
+		}
+	}
+	if e.config.TLS == "starttls" {
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("STARTTLS failed: %v", err)
+		}
+	}
+	if e.config.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", e.config.Username, e.password, e.config.Host)); err != nil {
+			return fmt.Errorf("authentication failed: %v", err)
+		}
+	}
+
+	if err := client.Mail(e.config.From); err != nil {
+		return err
+	}
+	for _, to := range e.config.To {
+		if err := client.Rcpt(to); err != nil {
+			return fmt.Errorf("recipient %s refused: %v", to, err)
+		}
+	}
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := writer.Write(e.message(m)); err != nil {
+		writer.Close()
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// message renders the headers and body with CRLF line endings
+func (e *EmailSink) message(m mail) []byte {
+	id := make([]byte, 12)
+	rand.Read(id)
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", e.config.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(e.config.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", strings.NewReplacer("\r", " ", "\n", " ").Replace(m.subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "Message-ID: <%s@%s>\r\n", hex.EncodeToString(id), hostname())
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	msg.WriteString("Auto-Submitted: auto-generated\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(m.body, "\n", "\r\n"))
+	return msg.Bytes()
+}
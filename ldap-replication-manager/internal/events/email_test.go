@@ -0,0 +1,305 @@
+package events
+
+import (
+	"bufio"
+	"encoding/base64"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ldap-replication-manager/internal/config"
+)
+
+// smtpSink is a minimal SMTP server that keeps every message it accepts
+type smtpSink struct {
+	listener net.Listener
+
+	mutex    sync.Mutex
+	messages []receivedMail
+}
+
+type receivedMail struct {
+	from string
+	to   []string
+	auth string // decoded AUTH PLAIN credentials
+	data string
+}
+
+func newSMTPSink(t *testing.T) *smtpSink {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("no loopback networking: %v", err)
+	}
+	sink := &smtpSink{listener: listener}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go sink.session(conn)
+		}
+	}()
+	return sink
+}
+
+func (s *smtpSink) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+// session speaks just enough SMTP for net/smtp
+func (s *smtpSink) session(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+
+	var current receivedMail
+	reply("220 sink ESMTP")
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch verb {
+		case "EHLO":
+			reply("250-sink")
+			reply("250 AUTH PLAIN")
+		case "HELO":
+			reply("250 sink")
+		case "AUTH":
+			fields := strings.Fields(line)
+			decoded, _ := base64.StdEncoding.DecodeString(fields[len(fields)-1])
+			current.auth = string(decoded)
+			reply("235 accepted")
+		case "MAIL":
+			current.from = strings.TrimSuffix(strings.TrimPrefix(line[5:], "FROM:<"), ">")
+			reply("250 ok")
+		case "RCPT":
+			current.to = append(current.to, strings.TrimSuffix(strings.TrimPrefix(line[5:], "TO:<"), ">"))
+			reply("250 ok")
+		case "DATA":
+			reply("354 go ahead")
+			var data strings.Builder
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(line, "."))
+			}
+			current.data = data.String()
+			s.mutex.Lock()
+			s.messages = append(s.messages, current)
+			s.mutex.Unlock()
+			current = receivedMail{auth: current.auth}
+			reply("250 queued")
+		case "RSET", "NOOP":
+			reply("250 ok")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+func (s *smtpSink) received() []receivedMail {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]receivedMail(nil), s.messages...)
+}
+
+func emailConfig(port int) config.EmailConfig {
+	return config.EmailConfig{
+		Enabled:       true,
+		Host:          "127.0.0.1",
+		Port:          port,
+		TLS:           "none",
+		From:          "lrm@example.com",
+		To:            []string{"ops@example.com", "dba@example.com"},
+		SubjectPrefix: "[lrm]",
+		Timeout:       5,
+	}
+}
+
+// sendAll sends events through a new email sink and waits for the mail
+func sendAll(t *testing.T, cfg config.EmailConfig, events ...Event) {
+	t.Helper()
+	sink, err := NewEmailSink(cfg, discardLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, event := range events {
+		if err := sink.Send(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// header returns the value of a header of a received message
+func header(data, name string) string {
+	head, _, _ := strings.Cut(data, "\r\n\r\n")
+	for _, line := range strings.Split(head, "\r\n") {
+		if value, ok := strings.CutPrefix(line, name+": "); ok {
+			return value
+		}
+	}
+	return ""
+}
+
+func TestEmailSingle(t *testing.T) {
+	server := newSMTPSink(t)
+	cfg := emailConfig(server.port())
+	cfg.Username = "lrm"
+	cfg.PasswordEnv = "LRM_TEST_SMTP_PASSWORD"
+	t.Setenv("LRM_TEST_SMTP_PASSWORD", "smtp-secret")
+
+	sendAll(t, cfg, testEvent())
+
+	messages := server.received()
+	if len(messages) != 1 {
+		t.Fatalf("%d mails, want 1", len(messages))
+	}
+	m := messages[0]
+	if m.from != "lrm@example.com" || strings.Join(m.to, ",") != "ops@example.com,dba@example.com" {
+		t.Errorf("envelope from %q to %v", m.from, m.to)
+	}
+	if m.auth != "\x00lrm\x00smtp-secret" {
+		t.Errorf("AUTH PLAIN credentials %q", m.auth)
+	}
+	if got := header(m.data, "Subject"); got != "[lrm] ERROR rotation.failed to-dc2-a" {
+		t.Errorf("Subject %q", got)
+	}
+	if got := header(m.data, "To"); got != "ops@example.com, dba@example.com" {
+		t.Errorf("To %q", got)
+	}
+	if header(m.data, "Message-ID") == "" || header(m.data, "Auto-Submitted") != "auto-generated" {
+		t.Errorf("headers:\n%s", m.data)
+	}
+	_, body, _ := strings.Cut(m.data, "\r\n\r\n")
+	for _, want := range []string{"Rotation of to-dc2-a failed\r\n", "  Agreement: to-dc2-a\r\n", "  Run:       20261018T135442-3fa2c1\r\n", "  error:     bind"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("body lacks %q:\n%s", want, body)
+		}
+	}
+	if strings.Contains(strings.ReplaceAll(body, "\r\n", ""), "\n") {
+		t.Error("body has bare LF line endings")
+	}
+}
+
+func TestEmailDigest(t *testing.T) {
+	at := func(seconds int, eventType, agreement string, severity Severity) Event {
+		return Event{
+			Time:      time.Date(2026, 10, 18, 13, 0, seconds, 0, time.UTC),
+			Type:      eventType,
+			Severity:  severity,
+			Message:   eventType + " " + agreement + " " + strconv.Itoa(seconds),
+			Agreement: agreement,
+		}
+	}
+
+	tests := []struct {
+		name     string
+		events   []Event
+		mails    int
+		subject  string
+		contains []string
+	}{
+		{
+			name: "one mail for the window",
+			events: []Event{
+				at(1, TypeIncidentOpened, "to-c1", SeverityError),
+				at(2, TypeIncidentOpened, "to-c1", SeverityError),
+				at(3, TypeRotationSucceeded, "to-c2", SeverityInfo),
+			},
+			mails:    1,
+			subject:  "[lrm] ERROR digest: 3 events (2 monitor.incident.opened, 1 rotation.succeeded)",
+			contains: []string{"monitor.incident.opened to-c1", "monitor.incident.opened to-c1 2", "rotation.succeeded to-c2 3"},
+		},
+		{
+			name: "rotation.finished closes the window",
+			events: []Event{
+				at(1, TypeRotationSucceeded, "to-c1", SeverityInfo),
+				at(2, TypeRotationFinished, "", SeverityInfo),
+				at(3, TypeIncidentOpened, "to-c1", SeverityError),
+			},
+			mails:   2,
+			subject: "[lrm] INFO digest: 2 events (1 rotation.finished, 1 rotation.succeeded)",
+		},
+		{
+			name: "test events are not held back",
+			events: []Event{
+				at(1, TypeIncidentOpened, "to-c1", SeverityError),
+				at(2, TypeTest, "", SeverityInfo),
+			},
+			mails:   2,
+			subject: "[lrm] INFO test",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newSMTPSink(t)
+			cfg := emailConfig(server.port())
+			cfg.DigestWindow = 3600
+			sendAll(t, cfg, test.events...)
+
+			messages := server.received()
+			if len(messages) != test.mails {
+				t.Fatalf("%d mails, want %d", len(messages), test.mails)
+			}
+			if got := header(messages[0].data, "Subject"); got != test.subject {
+				t.Errorf("Subject %q, want %q", got, test.subject)
+			}
+			for _, want := range test.contains {
+				if !strings.Contains(messages[0].data, want) {
+					t.Errorf("digest lacks %q:\n%s", want, messages[0].data)
+				}
+			}
+		})
+	}
+}
+
+func TestEmailRouting(t *testing.T) {
+	server := newSMTPSink(t)
+	cfg := emailConfig(server.port())
+	cfg.Events = []string{"rotation.failed"}
+	cfg.Agreements = []string{"to-dc2-*"}
+
+	other := testEvent()
+	other.Agreement = "to-dc1-a"
+	incident := testEvent()
+	incident.Type = TypeIncidentOpened
+	sendAll(t, cfg, testEvent(), other, incident)
+
+	if got := len(server.received()); got != 1 {
+		t.Errorf("%d mails, want only the routed event", got)
+	}
+}
+
+func TestEmailPasswordSettings(t *testing.T) {
+	cfg := emailConfig(25)
+	cfg.PasswordEnv = "LRM_TEST_SMTP_PASSWORD"
+	t.Setenv("LRM_TEST_SMTP_PASSWORD", "")
+	if _, err := NewEmailSink(cfg, discardLogger()); err == nil {
+		t.Error("an empty password variable must be refused")
+	}
+	cfg.PasswordEnv = ""
+	cfg.PasswordFile = "/nonexistent/smtp-password"
+	if _, err := NewEmailSink(cfg, discardLogger()); err == nil {
+		t.Error("a missing password file must be refused")
+	}
+}
@@ -0,0 +1,219 @@
+package events
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ldap-replication-manager/internal/config"
+)
+
+// Event types published by the rotation workflow and the monitor
+// They become the MSGID of syslog messages and LRM_EVENT_TYPE in journald,
+// so a SIEM can filter on them
+const (
+	TypeRotationStarted   = "rotation.started"
+	TypeRotationSucceeded = "rotation.succeeded"
+	TypeRotationFailed    = "rotation.failed"
+	TypeRotationRollback  = "rotation.rolled_back"
+	TypeRotationFinished  = "rotation.finished"
+	TypeIncidentOpened    = "monitor.incident.opened"
+	TypeIncidentResolved  = "monitor.incident.resolved"
+	TypeTest              = "test"
+)
+
+// Severity of an event, numbered like syslog severities
+type Severity int
+
+const (
+	SeverityError   Severity = 3
+	SeverityWarning Severity = 4
+	SeverityInfo    Severity = 6
+)
+
+// String returns "error", "warning" or "info"
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	}
+	return "info"
+}
+
+// Event is something that happened which other systems should hear about
+// Passwords never go into an event
+type Event struct {
+	// When it happened; the bus fills in the current time when zero
+	Time time.Time
+
+	// One of the Type constants
+	Type string
+
+	Severity Severity
+
+	// Human readable summary
+	Message string
+
+	// Correlation fields, empty when they do not apply
+	// The bus fills in its run ID when RunID is empty
+	RunID     string
+	Agreement string
+	Host      string
+
+	// Additional details, such as a duration or an error message
+	Fields map[string]string
+}
+
+// Sink delivers events to one destination
+type Sink interface {
+	// Name identifies the sink in log messages
+	Name() string
+
+	// Send delivers one event
+	Send(event Event) error
+
+	// Close releases the connection of the sink
+	Close() error
+}
+
+// Bus passes every published event to all its sinks
+// A sink that fails is logged and does not stop the others, nor the caller:
+// events report on the work, they must never interrupt it
+// A nil *Bus accepts and drops everything, so components can publish
+// without checking whether any sink is configured
+type Bus struct {
+	mutex  sync.Mutex
+	sinks  []Sink
+	runID  string
+	logger *slog.Logger
+}
+
+// NewBus creates a bus without sinks
+func NewBus(logger *slog.Logger) *Bus {
+	return &Bus{logger: logger.With("component", "events")}
+}
+
+// Open creates a bus with the sinks configured in the events section:
+// syslog, journald, every webhook and email; it returns nil when there is none
+func Open(cfg config.EventsConfig, logger *slog.Logger) (*Bus, error) {
+	if !cfg.Syslog.Enabled && !cfg.Journald.Enabled && len(cfg.Webhooks) == 0 && !cfg.Email.Enabled {
+		return nil, nil
+	}
+	bus := NewBus(logger)
+	if cfg.Syslog.Enabled {
+		sink, err := NewSyslogSink(cfg.Syslog.Network, cfg.Syslog.Address, cfg.Facility, cfg.AppName)
+		if err != nil {
+			bus.Close()
+			return nil, err
+		}
+		bus.Add(sink)
+	}
+	if cfg.Journald.Enabled {
+		sink, err := NewJournaldSink(cfg.Journald.Socket, cfg.Facility, cfg.AppName)
+		if err != nil {
+			bus.Close()
+			return nil, err
+		}
+		bus.Add(sink)
+	}
+	for _, webhook := range cfg.Webhooks {
+		sink, err := NewWebhookSink(webhook, logger)
+		if err != nil {
+			bus.Close()
+			return nil, err
+		}
+		bus.Add(sink)
+	}
+	if cfg.Email.Enabled {
+		sink, err := NewEmailSink(cfg.Email, logger)
+		if err != nil {
+			bus.Close()
+			return nil, err
+		}
+		bus.Add(sink)
+	}
+	return bus, nil
+}
+
+// Add registers another sink
+func (b *Bus) Add(sink Sink) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.sinks = append(b.sinks, sink)
+}
+
+// SetRunID sets the run ID given to events published without one
+func (b *Bus) SetRunID(runID string) {
+	if b == nil {
+		return
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.runID = runID
+}
+
+// Publish sends an event to every sink
+func (b *Bus) Publish(event Event) {
+	if b == nil {
+		return
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	if event.RunID == "" {
+		event.RunID = b.runID
+	}
+	for _, sink := range b.sinks {
+		if err := sink.Send(event); err != nil {
+			b.logger.Warn("Could not send event", "sink", sink.Name(), "event", event.Type, "error", err)
+		}
+	}
+}
+
+// Close closes every sink
+func (b *Bus) Close() {
+	if b == nil {
+		return
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for _, sink := range b.sinks {
+		if err := sink.Close(); err != nil {
+			b.logger.Warn("Could not close event sink", "sink", sink.Name(), "error", err)
+		}
+	}
+	b.sinks = nil
+}
+
+// facilities maps syslog facility names to their numbers (RFC 5424 section 6.2.1)
+var facilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5,
+	"lpr": 6, "news": 7, "uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// ParseFacility converts a facility name such as "daemon" or "local3"
+func ParseFacility(name string) (int, error) {
+	facility, ok := facilities[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown syslog facility %q", name)
+	}
+	return facility, nil
+}
+
+// hostname is the name this machine reports in its events
+func hostname() string {
+	name, err := os.Hostname()
+	if err != nil || name == "" {
+		return "-"
+	}
+	return name
+}
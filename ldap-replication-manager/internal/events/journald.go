@@ -0,0 +1,122 @@
+package events
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// JournaldSink sends events to the systemd journal with its native protocol
+// Every event is one datagram of KEY=value fields on the journal socket, so
+// the fields can be queried directly, for example
+// journalctl SYSLOG_IDENTIFIER=ldap-replication-manager LRM_EVENT_TYPE=rotation.failed
+// Correlation fields are LRM_RUN_ID, LRM_AGREEMENT and LRM_HOST; details
+// become LRM_<NAME> with the name in upper case
+type JournaldSink struct {
+	socket   string
+	facility int
+	appName  string
+
+	mutex sync.Mutex
+	conn  *net.UnixConn
+}
+
+// NewJournaldSink connects to the journal socket
+func NewJournaldSink(socket, facilityName, appName string) (*JournaldSink, error) {
+	facility, err := ParseFacility(facilityName)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to journald socket %s: %v", socket, err)
+	}
+	return &JournaldSink{socket: socket, facility: facility, appName: appName, conn: conn}, nil
+}
+
+// Name identifies the sink in log messages
+func (j *JournaldSink) Name() string {
+	return "journald " + j.socket
+}
+
+// Send writes one event as a journal entry
+func (j *JournaldSink) Send(event Event) error {
+	fields := map[string]string{
+		"MESSAGE":           event.Message,
+		"PRIORITY":          strconv.Itoa(int(event.Severity)),
+		"SYSLOG_FACILITY":   strconv.Itoa(j.facility),
+		"SYSLOG_IDENTIFIER": j.appName,
+		"LRM_EVENT_TYPE":    event.Type,
+		"LRM_EVENT_TIME":    event.Time.UTC().Format("2006-01-02T15:04:05.000000Z07:00"),
+	}
+	if event.RunID != "" {
+		fields["LRM_RUN_ID"] = event.RunID
+	}
+	if event.Agreement != "" {
+		fields["LRM_AGREEMENT"] = event.Agreement
+	}
+	if event.Host != "" {
+		fields["LRM_HOST"] = event.Host
+	}
+	for key, value := range event.Fields {
+		if name := journalFieldName(key); name != "" {
+			fields["LRM_"+name] = value
+		}
+	}
+
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	_, err := j.conn.Write(encodeJournalEntry(fields))
+	return err
+}
+
+// Close closes the socket
+func (j *JournaldSink) Close() error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return j.conn.Close()
+}
+
+// encodeJournalEntry encodes fields in the journal native protocol
+// A value with a newline is written as KEY, newline, its length as a 64 bit
+// little endian number, the value and a newline; others as KEY=value
+func encodeJournalEntry(fields map[string]string) []byte {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var buf bytes.Buffer
+	for _, key := range keys {
+		value := fields[key]
+		if strings.Contains(value, "\n") {
+			buf.WriteString(key + "\n")
+			binary.Write(&buf, binary.LittleEndian, uint64(len(value)))
+			buf.WriteString(value + "\n")
+		} else {
+			buf.WriteString(key + "=" + value + "\n")
+		}
+	}
+	return buf.Bytes()
+}
+
+// journalFieldName converts a detail name to a journal field name:
+// upper case letters, digits and underscores; "" when nothing is left
+func journalFieldName(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToUpper(name) {
+		switch {
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+			b.WriteRune(r)
+		case r == '-' || r == '.':
+			b.WriteRune('_')
+		}
+	}
+	return b.String()
+}
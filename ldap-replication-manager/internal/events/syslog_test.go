@@ -0,0 +1,293 @@
+package events
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testEvent() Event {
+	return Event{
+		Time:      time.Date(2026, 10, 18, 13, 54, 44, 120000000, time.UTC),
+		Type:      TypeRotationFailed,
+		Severity:  SeverityError,
+		Message:   "Rotation of to-dc2-a failed",
+		RunID:     "20261018T135442-3fa2c1",
+		Agreement: "to-dc2-a",
+		Host:      "dc2-a",
+		Fields:    map[string]string{"error": `bind "failed"] \ retry`, "duration": "1.5s"},
+	}
+}
+
+func TestSyslogFormat(t *testing.T) {
+	sink := &SyslogSink{facility: 3, appName: "ldap replication manager", hostname: "admin1"}
+	message := sink.Format(testEvent())
+
+	want := fmt.Sprintf(`<27>1 2026-10-18T13:54:44.120000Z admin1 ldapreplicationmanager %d rotation.failed `+
+		`[lrm@32473 type="rotation.failed" run_id="20261018T135442-3fa2c1" agreement="to-dc2-a" host="dc2-a" duration="1.5s" error="bind \"failed\"\] \\ retry"] `+
+		`Rotation of to-dc2-a failed`, os.Getpid())
+	if message != want {
+		t.Errorf("Format() =\n%s\nwant\n%s", message, want)
+	}
+
+	// Facility and severity make the priority
+	sink.facility = 16
+	info := testEvent()
+	info.Severity = SeverityInfo
+	if got := sink.Format(info); !strings.HasPrefix(got, "<134>1 ") {
+		t.Errorf("local0 info message starts %q", got[:8])
+	}
+}
+
+func TestHeaderField(t *testing.T) {
+	tests := []struct {
+		value string
+		max   int
+		want  string
+	}{
+		{"admin1", 255, "admin1"},
+		{"", 48, "-"},
+		{"two words", 48, "twowords"},
+		{"naïve\thost", 48, "navehost"},
+		{strings.Repeat("a", 40), 32, strings.Repeat("a", 32)},
+	}
+	for _, test := range tests {
+		if got := headerField(test.value, test.max); got != test.want {
+			t.Errorf("headerField(%q, %d) = %q, want %q", test.value, test.max, got, test.want)
+		}
+	}
+}
+
+func TestSyslogSinkUDP(t *testing.T) {
+	receiver, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("no loopback networking: %v", err)
+	}
+	defer receiver.Close()
+
+	sink, err := NewSyslogSink("udp", receiver.LocalAddr().String(), "daemon", "lrm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	if err := sink.Send(testEvent()); err != nil {
+		t.Fatal(err)
+	}
+
+	receiver.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 64*1024)
+	n, _, err := receiver.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(buf[:n]); got != sink.Format(testEvent()) {
+		t.Errorf("datagram %q, want the formatted message without framing", got)
+	}
+}
+
+func TestSyslogSinkUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log")
+	receiver, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Skipf("no unix datagram sockets: %v", err)
+	}
+	defer receiver.Close()
+
+	sink, err := NewSyslogSink("unix", path, "daemon", "lrm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	if err := sink.Send(testEvent()); err != nil {
+		t.Fatal(err)
+	}
+
+	receiver.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 64*1024)
+	n, err := receiver.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(buf[:n]); !strings.HasPrefix(got, "<27>1 ") {
+		t.Errorf("datagram %q", got)
+	}
+}
+
+// readFrame reads one octet-counted message
+func readFrame(reader *bufio.Reader) (string, error) {
+	prefix, err := reader.ReadString(' ')
+	if err != nil {
+		return "", err
+	}
+	length, err := strconv.Atoi(strings.TrimSuffix(prefix, " "))
+	if err != nil {
+		return "", fmt.Errorf("bad frame length %q", prefix)
+	}
+	data := make([]byte, length)
+	_, err = io.ReadFull(reader, data)
+	return string(data), err
+}
+
+func TestSyslogSinkTCPReconnects(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("no loopback networking: %v", err)
+	}
+	defer listener.Close()
+
+	messages := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			// Each connection takes one message and is then dropped, as a
+			// restarted receiver would
+			message, err := readFrame(bufio.NewReader(conn))
+			if err == nil {
+				messages <- message
+			}
+			conn.Close()
+		}
+	}()
+
+	sink, err := NewSyslogSink("tcp", listener.Addr().String(), "daemon", "lrm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	want := sink.Format(testEvent())
+	for i := 0; i < 2; i++ {
+		// The first write after the peer closed may still succeed locally,
+		// so keep sending until the receiver has seen a message
+		deadline := time.Now().Add(5 * time.Second)
+		var got string
+		for got == "" {
+			if err := sink.Send(testEvent()); err != nil {
+				t.Logf("send %d: %v", i, err)
+			}
+			select {
+			case got = <-messages:
+			case <-time.After(100 * time.Millisecond):
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("message %d not received", i)
+			}
+		}
+		if got != want {
+			t.Errorf("message %d = %q, want %q", i, got, want)
+		}
+	}
+}
+
+func TestSyslogSinkRejectsUnknownNetwork(t *testing.T) {
+	if _, err := NewSyslogSink("sctp", "localhost:514", "daemon", "lrm"); err == nil {
+		t.Error("an unknown network must be refused")
+	}
+	if _, err := NewSyslogSink("udp", "localhost:514", "kernel", "lrm"); err == nil {
+		t.Error("an unknown facility must be refused")
+	}
+}
+
+// decodeJournalEntry parses the journal native protocol
+func decodeJournalEntry(t *testing.T, data []byte) map[string]string {
+	t.Helper()
+	fields := make(map[string]string)
+	for len(data) > 0 {
+		end := bytes.IndexByte(data, '\n')
+		if end < 0 {
+			t.Fatalf("unterminated field %q", data)
+		}
+		line := string(data[:end])
+		data = data[end+1:]
+		if key, value, ok := strings.Cut(line, "="); ok {
+			fields[key] = value
+			continue
+		}
+		length := binary.LittleEndian.Uint64(data[:8])
+		fields[line] = string(data[8 : 8+length])
+		if data[8+length] != '\n' {
+			t.Fatalf("binary field %s not followed by a newline", line)
+		}
+		data = data[9+length:]
+	}
+	return fields
+}
+
+func TestJournaldSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "socket")
+	receiver, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Skipf("no unix datagram sockets: %v", err)
+	}
+	defer receiver.Close()
+
+	sink, err := NewJournaldSink(path, "authpriv", "lrm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	event := testEvent()
+	event.Message = "Rotation failed:\nbind refused"
+	event.Fields["consumer.host"] = "dc2-a"
+	if err := sink.Send(event); err != nil {
+		t.Fatal(err)
+	}
+
+	receiver.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 64*1024)
+	n, err := receiver.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := decodeJournalEntry(t, buf[:n])
+	want := map[string]string{
+		"MESSAGE":           "Rotation failed:\nbind refused",
+		"PRIORITY":          "3",
+		"SYSLOG_FACILITY":   "10",
+		"SYSLOG_IDENTIFIER": "lrm",
+		"LRM_EVENT_TYPE":    "rotation.failed",
+		"LRM_EVENT_TIME":    "2026-10-18T13:54:44.120000Z",
+		"LRM_RUN_ID":        "20261018T135442-3fa2c1",
+		"LRM_AGREEMENT":     "to-dc2-a",
+		"LRM_HOST":          "dc2-a",
+		"LRM_ERROR":         `bind "failed"] \ retry`,
+		"LRM_DURATION":      "1.5s",
+		"LRM_CONSUMER_HOST": "dc2-a",
+	}
+	for key, value := range want {
+		if fields[key] != value {
+			t.Errorf("%s = %q, want %q", key, fields[key], value)
+		}
+	}
+	if len(fields) != len(want) {
+		t.Errorf("fields %v", fields)
+	}
+}
+
+func TestJournalFieldName(t *testing.T) {
+	tests := map[string]string{
+		"error":         "ERROR",
+		"rate_per_min":  "RATE_PER_MIN",
+		"consumer.host": "CONSUMER_HOST",
+		"first-seen":    "FIRST_SEEN",
+		"naïve key!":    "NAVEKEY",
+		"***":           "",
+	}
+	for name, want := range tests {
+		if got := journalFieldName(name); got != want {
+			t.Errorf("journalFieldName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,307 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/ldap-replication-manager/internal/config"
+)
+
+// Delivery limits of the background sinks (webhooks and email)
+const (
+	queueSize         = 100              // events waiting for delivery; more are dropped
+	webhookMaxBackoff = 60 * time.Second // longest wait between two attempts
+	drainTimeout      = 30 * time.Second // how long Close waits for queued events
+)
+
+// WebhookSink posts events to an HTTP endpoint
+// Send only queues the event; a background worker delivers it, retrying
+// with exponential backoff (1s, 2s, 4s ... up to a minute, with jitter) on
+// network errors, 429 and 5xx answers, so a slow endpoint never holds up a
+// rotation
+// Only events matching the routes of the target are delivered; test events
+// always are
+type WebhookSink struct {
+	config   config.WebhookConfig
+	body     *template.Template
+	secret   []byte
+	client   *http.Client
+	logger   *slog.Logger
+	queue    chan Event
+	done     chan struct{}
+	ctx      context.Context
+	cancel   context.CancelFunc
+	closeOne sync.Once
+}
+
+// NewWebhookSink prepares a webhook target and starts its delivery worker
+func NewWebhookSink(cfg config.WebhookConfig, logger *slog.Logger) (*WebhookSink, error) {
+	text := cfg.Template
+	if text == "" {
+		text = webhookFormats[cfg.Format]
+	}
+	body, err := template.New(cfg.Name).Funcs(template.FuncMap{"json": jsonValue}).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("webhook %s: invalid template: %v", cfg.Name, err)
+	}
+
+	var secret []byte
+	switch {
+	case cfg.SecretFile != "":
+		data, err := os.ReadFile(cfg.SecretFile)
+		if err != nil {
+			return nil, fmt.Errorf("webhook %s: failed to read secret: %v", cfg.Name, err)
+		}
+		secret = []byte(strings.TrimSpace(string(data)))
+	case cfg.SecretEnv != "":
+		secret = []byte(os.Getenv(cfg.SecretEnv))
+		if len(secret) == 0 {
+			return nil, fmt.Errorf("webhook %s: environment variable %s is empty", cfg.Name, cfg.SecretEnv)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sink := &WebhookSink{
+		config: cfg,
+		body:   body,
+		secret: secret,
+		client: &http.Client{Timeout: time.Duration(cfg.Timeout) * time.Second},
+		logger: logger.With("component", "events", "webhook", cfg.Name),
+		queue:  make(chan Event, queueSize),
+		done:   make(chan struct{}),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	go sink.deliverQueued()
+	return sink, nil
+}
+
+// Name identifies the sink in log messages
+func (w *WebhookSink) Name() string {
+	return "webhook " + w.config.Name
+}
+
+// Send queues an event that matches the routes of the target
+func (w *WebhookSink) Send(event Event) error {
+	if !w.Routes(event) {
+		return nil
+	}
+	select {
+	case w.queue <- event:
+		return nil
+	default:
+		return fmt.Errorf("delivery queue is full, event dropped")
+	}
+}
+
+// Routes reports whether an event goes to this target
+func (w *WebhookSink) Routes(event Event) bool {
+	return routed(w.config.Events, w.config.Agreements, event)
+}
+
+// routed applies routing rules: event type globs and agreement name globs
+// Events without an agreement only need a matching type, and test events
+// always pass
+func routed(types, agreements []string, event Event) bool {
+	if event.Type == TypeTest {
+		return true
+	}
+	if !matchAny(types, event.Type) {
+		return false
+	}
+	return event.Agreement == "" || matchAny(agreements, event.Agreement)
+}
+
+// matchAny reports whether a value matches one of the globs; no globs match all
+func matchAny(patterns []string, value string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, value); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Close waits for queued events to be delivered, giving up after
+// drainTimeout
+func (w *WebhookSink) Close() error {
+	w.closeOne.Do(func() { close(w.queue) })
+	select {
+	case <-w.done:
+		return nil
+	case <-time.After(drainTimeout):
+		w.cancel()
+		<-w.done
+		return fmt.Errorf("gave up on undelivered events after %s", drainTimeout)
+	}
+}
+
+// deliverQueued delivers events one at a time, in the order they were sent
+func (w *WebhookSink) deliverQueued() {
+	defer close(w.done)
+	for event := range w.queue {
+		if err := w.deliver(event); err != nil {
+			w.logger.Error("Webhook delivery failed", "event", event.Type, "agreement", event.Agreement, "error", err)
+		}
+	}
+}
+
+// deliver posts one event, retrying as long as the failure is temporary
+func (w *WebhookSink) deliver(event Event) error {
+	var body bytes.Buffer
+	if err := w.body.Execute(&body, newPayload(event)); err != nil {
+		return fmt.Errorf("failed to render payload: %v", err)
+	}
+
+	backoff := time.Second
+	for attempt := 0; ; attempt++ {
+		retryAfter, err := w.post(body.Bytes())
+		if err == nil {
+			w.logger.Debug("Webhook delivered", "event", event.Type, "attempts", attempt+1)
+			return nil
+		}
+		if retryAfter < 0 || attempt >= w.config.MaxRetries {
+			return err
+		}
+
+		wait := backoff + time.Duration(rand.Int63n(int64(backoff/2)))
+		if retryAfter > wait {
+			wait = retryAfter
+		}
+		w.logger.Warn("Webhook delivery failed, retrying", "event", event.Type, "attempt", attempt+1, "retry_in", wait, "error", err)
+		select {
+		case <-time.After(wait):
+		case <-w.ctx.Done():
+			return err
+		}
+		if backoff *= 2; backoff > webhookMaxBackoff {
+			backoff = webhookMaxBackoff
+		}
+	}
+}
+
+// post sends one request
+// On failure it returns how long the server asked to wait (0 when it did
+// not say), or -1 when retrying cannot help
+func (w *WebhookSink) post(body []byte) (time.Duration, error) {
+	req, err := http.NewRequestWithContext(w.ctx, http.MethodPost, w.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return -1, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "ldap-replication-manager")
+	for name, value := range w.config.Headers {
+		req.Header.Set(name, value)
+	}
+	if w.secret != nil {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set("X-LRM-Timestamp", timestamp)
+		req.Header.Set("X-LRM-Signature", "sha256="+Sign(w.secret, timestamp, body))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode < 300:
+		return 0, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		seconds, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+		return time.Duration(seconds) * time.Second, fmt.Errorf("server answered %s", resp.Status)
+	}
+	return -1, fmt.Errorf("server answered %s", resp.Status)
+}
+
+// Sign computes the hex HMAC-SHA256 of "<timestamp>.<body>"
+// Receivers recompute it with the shared secret and reject requests whose
+// timestamp is too old, which stops replayed requests
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// payload is the data given to webhook templates
+// The json format renders it as is
+type payload struct {
+	Type      string            `json:"type"`
+	Severity  string            `json:"severity"`
+	Time      string            `json:"time"`
+	Message   string            `json:"message"`
+	RunID     string            `json:"run_id,omitempty"`
+	Agreement string            `json:"agreement,omitempty"`
+	Host      string            `json:"host,omitempty"`
+	Fields    map[string]string `json:"fields,omitempty"`
+	Source    string            `json:"source"`
+	Color     string            `json:"-"`
+	Emoji     string            `json:"-"`
+}
+
+// newPayload prepares an event for a template
+func newPayload(event Event) payload {
+	p := payload{
+		Type:      event.Type,
+		Severity:  event.Severity.String(),
+		Time:      event.Time.UTC().Format(time.RFC3339),
+		Message:   event.Message,
+		RunID:     event.RunID,
+		Agreement: event.Agreement,
+		Host:      event.Host,
+		Fields:    event.Fields,
+		Source:    hostname(),
+		Color:     "2EB67D",
+		Emoji:     ":white_check_mark:",
+	}
+	switch event.Severity {
+	case SeverityError:
+		p.Color, p.Emoji = "E01E5A", ":rotating_light:"
+	case SeverityWarning:
+		p.Color, p.Emoji = "ECB22E", ":warning:"
+	}
+	return p
+}
+
+// webhookFormats are the built-in templates
+// Slack and Mattermost incoming webhooks take the same "text" message;
+// Teams takes a MessageCard
+var webhookFormats = map[string]string{
+	"json":       `{{json .}}`,
+	"slack":      `{"text": {{json (printf "%s *%s* %s" .Emoji .Type .Message)}}}`,
+	"mattermost": `{"text": {{json (printf "%s **%s** %s" .Emoji .Type .Message)}}}`,
+	"teams": `{"@type": "MessageCard", "@context": "http://schema.org/extensions", ` +
+		`"themeColor": "{{.Color}}", "summary": {{json .Message}}, "title": {{json .Type}}, ` +
+		`"text": {{json .Message}}, "sections": [{"facts": [` +
+		`{"name": "Run", "value": {{json .RunID}}}, {"name": "Agreement", "value": {{json .Agreement}}}, ` +
+		`{"name": "Host", "value": {{json .Host}}}, {"name": "Source", "value": {{json .Source}}}]}]}`,
+}
+
+// jsonValue is the "json" template function: it encodes any value as JSON,
+// so strings come out quoted and escaped
+func jsonValue(value interface{}) (string, error) {
+	data, err := json.Marshal(value)
+	return string(data), err
+}
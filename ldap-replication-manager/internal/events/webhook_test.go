@@ -0,0 +1,256 @@
+package events
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ldap-replication-manager/internal/config"
+)
+
+// webhookReceiver records the requests of a webhook target and answers
+// with the given status codes in turn, then 204
+type webhookReceiver struct {
+	mutex    sync.Mutex
+	statuses []int
+	requests []receivedRequest
+}
+
+type receivedRequest struct {
+	header http.Header
+	body   []byte
+}
+
+func newWebhookReceiver(t *testing.T, statuses ...int) (*webhookReceiver, *httptest.Server) {
+	receiver := &webhookReceiver{statuses: statuses}
+	server := httptest.NewServer(receiver)
+	t.Cleanup(server.Close)
+	return receiver, server
+}
+
+func (r *webhookReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.requests = append(r.requests, receivedRequest{header: req.Header.Clone(), body: body})
+	if len(r.statuses) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	status := r.statuses[0]
+	r.statuses = r.statuses[1:]
+	if status == http.StatusTooManyRequests {
+		w.Header().Set("Retry-After", "1")
+	}
+	w.WriteHeader(status)
+}
+
+func (r *webhookReceiver) received() []receivedRequest {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]receivedRequest(nil), r.requests...)
+}
+
+func discardLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+// deliverAll sends events through a new sink and waits for delivery
+func deliverAll(t *testing.T, cfg config.WebhookConfig, events ...Event) {
+	t.Helper()
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 5
+	}
+	sink, err := NewWebhookSink(cfg, discardLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, event := range events {
+		if err := sink.Send(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWebhookFormats(t *testing.T) {
+	tests := []struct {
+		format string
+		check  func(t *testing.T, body map[string]interface{})
+	}{
+		{"json", func(t *testing.T, body map[string]interface{}) {
+			if body["type"] != "rotation.failed" || body["severity"] != "error" || body["agreement"] != "to-dc2-a" ||
+				body["run_id"] != "20261018T135442-3fa2c1" || body["time"] != "2026-10-18T13:54:44Z" {
+				t.Errorf("json body %v", body)
+			}
+			if fields, _ := body["fields"].(map[string]interface{}); fields["error"] != `bind "failed"] \ retry` {
+				t.Errorf("json fields %v", body["fields"])
+			}
+		}},
+		{"slack", func(t *testing.T, body map[string]interface{}) {
+			if body["text"] != ":rotating_light: *rotation.failed* Rotation of to-dc2-a failed" {
+				t.Errorf("slack text %q", body["text"])
+			}
+		}},
+		{"mattermost", func(t *testing.T, body map[string]interface{}) {
+			if body["text"] != ":rotating_light: **rotation.failed** Rotation of to-dc2-a failed" {
+				t.Errorf("mattermost text %q", body["text"])
+			}
+		}},
+		{"teams", func(t *testing.T, body map[string]interface{}) {
+			if body["@type"] != "MessageCard" || body["themeColor"] != "E01E5A" || body["title"] != "rotation.failed" {
+				t.Errorf("teams card %v", body)
+			}
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.format, func(t *testing.T) {
+			receiver, server := newWebhookReceiver(t)
+			deliverAll(t, config.WebhookConfig{Name: test.format, URL: server.URL, Format: test.format}, testEvent())
+
+			requests := receiver.received()
+			if len(requests) != 1 {
+				t.Fatalf("%d requests, want 1", len(requests))
+			}
+			if got := requests[0].header.Get("Content-Type"); got != "application/json" {
+				t.Errorf("Content-Type %q", got)
+			}
+			var body map[string]interface{}
+			if err := json.Unmarshal(requests[0].body, &body); err != nil {
+				t.Fatalf("body is not JSON: %v\n%s", err, requests[0].body)
+			}
+			test.check(t, body)
+		})
+	}
+}
+
+func TestWebhookTemplateAndHeaders(t *testing.T) {
+	receiver, server := newWebhookReceiver(t)
+	deliverAll(t, config.WebhookConfig{
+		Name:     "custom",
+		URL:      server.URL,
+		Template: `{"summary": {{json .Message}}, "where": "{{.Host}}"}`,
+		Headers:  map[string]string{"Authorization": "Bearer abc"},
+	}, testEvent())
+
+	requests := receiver.received()
+	if len(requests) != 1 {
+		t.Fatalf("%d requests, want 1", len(requests))
+	}
+	if got := string(requests[0].body); got != `{"summary": "Rotation of to-dc2-a failed", "where": "dc2-a"}` {
+		t.Errorf("body %s", got)
+	}
+	if got := requests[0].header.Get("Authorization"); got != "Bearer abc" {
+		t.Errorf("Authorization %q", got)
+	}
+
+	if _, err := NewWebhookSink(config.WebhookConfig{Name: "bad", Template: "{{.Missing"}, discardLogger()); err == nil {
+		t.Error("an invalid template must be refused")
+	}
+}
+
+func TestWebhookSignature(t *testing.T) {
+	secretFile := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(secretFile, []byte("s3cret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	receiver, server := newWebhookReceiver(t)
+	deliverAll(t, config.WebhookConfig{Name: "signed", URL: server.URL, Format: "json", SecretFile: secretFile}, testEvent())
+
+	request := receiver.received()[0]
+	timestamp := request.header.Get("X-LRM-Timestamp")
+	sent, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || time.Since(time.Unix(sent, 0)) > time.Minute {
+		t.Errorf("X-LRM-Timestamp %q", timestamp)
+	}
+
+	// Recompute the signature as a receiver would
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(request.body)
+	want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	if got := request.header.Get("X-LRM-Signature"); got != want {
+		t.Errorf("X-LRM-Signature %q, want %q", got, want)
+	}
+
+	t.Setenv("LRM_TEST_WEBHOOK_SECRET", "")
+	if _, err := NewWebhookSink(config.WebhookConfig{Name: "unset", SecretEnv: "LRM_TEST_WEBHOOK_SECRET"}, discardLogger()); err == nil {
+		t.Error("an empty secret variable must be refused")
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	tests := []struct {
+		name       string
+		statuses   []int
+		maxRetries int
+		attempts   int
+	}{
+		{"accepted at once", nil, 3, 1},
+		{"server error then success", []int{http.StatusBadGateway}, 3, 2},
+		{"rate limited then success", []int{http.StatusTooManyRequests}, 3, 2},
+		{"client error is not retried", []int{http.StatusBadRequest}, 3, 1},
+		{"gives up after max retries", []int{500, 500, 500}, 1, 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			receiver, server := newWebhookReceiver(t, test.statuses...)
+			deliverAll(t, config.WebhookConfig{Name: "retry", URL: server.URL, Format: "json", MaxRetries: test.maxRetries}, testEvent())
+			if got := len(receiver.received()); got != test.attempts {
+				t.Errorf("%d attempts, want %d", got, test.attempts)
+			}
+		})
+	}
+}
+
+func TestWebhookRouting(t *testing.T) {
+	event := func(eventType, agreement string) Event {
+		return Event{Type: eventType, Agreement: agreement, Time: time.Now()}
+	}
+	tests := []struct {
+		name       string
+		types      []string
+		agreements []string
+		event      Event
+		want       bool
+	}{
+		{"no routes", nil, nil, event(TypeRotationFailed, "to-c1"), true},
+		{"type glob", []string{"monitor.incident.*"}, nil, event(TypeIncidentOpened, "to-c1"), true},
+		{"type not routed", []string{"monitor.incident.*"}, nil, event(TypeRotationFailed, "to-c1"), false},
+		{"agreement glob", nil, []string{"to-dc2-*"}, event(TypeRotationFailed, "to-dc2-a"), true},
+		{"agreement not routed", nil, []string{"to-dc2-*"}, event(TypeRotationFailed, "to-dc1-a"), false},
+		{"event without agreement", nil, []string{"to-dc2-*"}, event(TypeIncidentOpened, ""), true},
+		{"test events always pass", []string{"rotation.*"}, []string{"none"}, event(TypeTest, ""), true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := routed(test.types, test.agreements, test.event); got != test.want {
+				t.Errorf("routed() = %v, want %v", got, test.want)
+			}
+		})
+	}
+
+	// Only routed events reach the endpoint
+	receiver, server := newWebhookReceiver(t)
+	deliverAll(t, config.WebhookConfig{Name: "routed", URL: server.URL, Format: "json", Events: []string{"rotation.failed"}},
+		event(TypeRotationFailed, "to-c1"), event(TypeIncidentOpened, "to-c1"), event(TypeRotationFailed, "to-c2"))
+	if got := len(receiver.received()); got != 2 {
+		t.Errorf("%d requests, want 2", got)
+	}
+}
@@ -0,0 +1,124 @@
+package ldap
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/ldap-replication-manager/internal/audit"
+)
+
+// SetAuditLog makes the manager record every add, modify and delete it
+// sends to a server in the given audit log
+// Without an audit log the changes are made but not recorded
+func (m *Manager) SetAuditLog(log *audit.Log) {
+	m.audit = log
+}
+
+// AuditError returns the first failure to write the audit log, or nil
+// A change is made whether or not it could be recorded: failing it would
+// stop a rotation between the consumer and its suppliers and break
+// replication; callers check AuditError instead, see record
+func (m *Manager) AuditError() error {
+	m.auditMutex.Lock()
+	defer m.auditMutex.Unlock()
+	if m.auditErr == nil {
+		return nil
+	}
+	return fmt.Errorf("%d changes were not recorded in the audit log, the first because: %v", m.unaudited, m.auditErr)
+}
+
+// modify sends a modify request and records it in the audit log
+// The LDAP error is returned unchanged so callers can still test its code
+func (m *Manager) modify(conn ldap.Client, host string, req *ldap.ModifyRequest) error {
+	start := time.Now()
+	err := conn.Modify(req)
+
+	changes := make([]audit.Change, 0, len(req.Changes))
+	for _, change := range req.Changes {
+		changes = append(changes, audit.Change{Attribute: change.Modification.Type, Type: changeType(change.Operation)})
+	}
+	return m.record(host, req.DN, "modify", changes, start, err)
+}
+
+// add sends an add request and records it in the audit log
+func (m *Manager) add(conn ldap.Client, host string, req *ldap.AddRequest) error {
+	start := time.Now()
+	err := conn.Add(req)
+
+	changes := make([]audit.Change, 0, len(req.Attributes))
+	for _, attribute := range req.Attributes {
+		changes = append(changes, audit.Change{Attribute: attribute.Type, Type: "add"})
+	}
+	return m.record(host, req.DN, "add", changes, start, err)
+}
+
+// del sends a delete request and records it in the audit log
+func (m *Manager) del(conn ldap.Client, host string, req *ldap.DelRequest) error {
+	start := time.Now()
+	err := conn.Del(req)
+	return m.record(host, req.DN, "delete", nil, start, err)
+}
+
+// record appends the outcome of one operation to the audit log
+// Failed operations are recorded too: attempts matter to an auditor
+// A record that cannot be written leaves the result of the operation
+// unchanged and is remembered for AuditError
+func (m *Manager) record(host, dn, operation string, changes []audit.Change, start time.Time, opErr error) error {
+	if m.audit == nil {
+		return opErr
+	}
+
+	record := audit.Record{
+		Time:       audit.Timestamp(start),
+		Host:       host,
+		DN:         dn,
+		Operation:  operation,
+		Changes:    changes,
+		ResultCode: resultCode(opErr),
+		DurationMS: time.Since(start).Milliseconds(),
+	}
+	if opErr != nil {
+		record.Error = opErr.Error()
+	}
+
+	if err := m.audit.Append(record); err != nil {
+		m.logger.Error("Could not write audit record", "host", host, "dn", dn, "operation", operation, "error", err)
+		m.auditMutex.Lock()
+		if m.auditErr == nil {
+			m.auditErr = err
+		}
+		m.unaudited++
+		m.auditMutex.Unlock()
+	}
+	return opErr
+}
+
+// changeType names the operation of one change of a modify request
+func changeType(operation uint) string {
+	switch operation {
+	case ldap.AddAttribute:
+		return "add"
+	case ldap.DeleteAttribute:
+		return "delete"
+	case ldap.ReplaceAttribute:
+		return "replace"
+	case ldap.IncrementAttribute:
+		return "increment"
+	}
+	return "unknown"
+}
+
+// resultCode returns the LDAP result code of an operation
+// 0 means success; -1 means the request never got an LDAP answer
+func resultCode(err error) int {
+	if err == nil {
+		return int(ldap.LDAPResultSuccess)
+	}
+	var ldapErr *ldap.Error
+	if errors.As(err, &ldapErr) {
+		return int(ldapErr.ResultCode)
+	}
+	return -1
+}
@@ -0,0 +1,52 @@
+package ldap
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/ldap-replication-manager/internal/audit"
+)
+
+func TestAuditFailureKeepsTheChange(t *testing.T) {
+	manager, directory := newTestManager(t)
+	path := filepath.Join(t.TempDir(), "audit.log")
+	auditLog, err := audit.Open(path, "alice@admin1", "run-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager.SetAuditLog(auditLog)
+
+	agreement := ReplicationAgreement{Name: "s1-to-c1", Supplier: testHost, Consumer: testHost, BindDN: testBindDN}
+	if err := manager.UpdateReplicationPassword(agreement, "first", "consumer"); err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.AuditError(); err != nil {
+		t.Fatalf("AuditError() = %v before any failure", err)
+	}
+
+	// A full disk: the log can no longer be written
+	auditLog.Close()
+	if err := manager.UpdateReplicationPassword(agreement, "second", "consumer"); err != nil {
+		t.Fatalf("a change that was made must not be reported as failed: %v", err)
+	}
+	if got := directory.Values(testHost, testBindDN, "userPassword"); len(got) != 1 || got[0] != "second" {
+		t.Errorf("userPassword %v, want the change applied", got)
+	}
+
+	// Failed operations keep their LDAP error
+	err = manager.withConnection(testHost, func(conn ldap.Client) error {
+		return manager.del(conn, testHost, ldap.NewDelRequest("cn=missing,cn=config", nil))
+	})
+	if !ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+		t.Errorf("delete of a missing entry = %v, want no such object", err)
+	}
+
+	if err := manager.AuditError(); err == nil || !strings.Contains(err.Error(), "2 changes were not recorded") {
+		t.Errorf("AuditError() = %v", err)
+	}
+	if count, _, err := audit.Verify(path); err != nil || count != 1 {
+		t.Errorf("audit log has %d records (%v), want the one written before the failure", count, err)
+	}
+}
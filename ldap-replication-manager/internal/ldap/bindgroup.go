@@ -0,0 +1,329 @@
+package ldap
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// ReplicaConfig describes who a consumer accepts replication updates from
+// 389DS allows the accounts listed in nsds5ReplicaBindDN and every member of
+// the group named in nsds5ReplicaBindDNGroup
+type ReplicaConfig struct {
+	// DN of the nsds5Replica entry on the consumer
+	DN string
+
+	// Accounts allowed directly
+	BindDNs []string
+
+	// Group whose members are allowed, empty when not used
+	BindDNGroup string
+
+	// How often (seconds) the consumer re-reads the group membership
+	// -1, the 389DS default, means membership changes are not picked up at runtime
+	GroupCheckInterval int
+}
+
+// AgreementCredentials is what a supplier agreement uses to bind to its consumer
+// The credentials value is kept exactly as read so it can be written back
+type AgreementCredentials struct {
+	BindDN      string
+	Credentials string
+}
+
+// ErrNotVerified is returned by VerifyReplication when no replication session
+// finished within the timeout, so the new credentials are neither proven nor refuted
+var ErrNotVerified = errors.New("no replication session completed before the timeout")
+
+// ReadReplicaConfig reads the replica entry for a suffix on a consumer
+// The entry is found by its nsds5ReplicaRoot under cn=mapping tree,cn=config
+func (m *Manager) ReadReplicaConfig(host, suffix string) (*ReplicaConfig, error) {
+	var replica *ReplicaConfig
+	err := m.withConnection(host, func(conn ldap.Client) error {
+		searchRequest := ldap.NewSearchRequest(
+			"cn=mapping tree,cn=config",
+			ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+			fmt.Sprintf("(&(objectClass=nsds5Replica)(nsds5ReplicaRoot=%s))", ldap.EscapeFilter(suffix)),
+			[]string{"nsds5ReplicaBindDN", "nsds5ReplicaBindDNGroup", "nsds5ReplicaBindDNGroupCheckInterval"},
+			nil,
+		)
+		sr, err := conn.Search(searchRequest)
+		if err != nil {
+			return err
+		}
+		if len(sr.Entries) == 0 {
+			return fmt.Errorf("no replica for %s", suffix)
+		}
+		entry := sr.Entries[0]
+		replica = &ReplicaConfig{
+			DN:                 entry.DN,
+			BindDNs:            entry.GetAttributeValues("nsds5ReplicaBindDN"),
+			BindDNGroup:        entry.GetAttributeValue("nsds5ReplicaBindDNGroup"),
+			GroupCheckInterval: -1,
+		}
+		if value := entry.GetAttributeValue("nsds5ReplicaBindDNGroupCheckInterval"); value != "" {
+			if interval, err := strconv.Atoi(value); err == nil {
+				replica.GroupCheckInterval = interval
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read replica configuration for %s on %s: %v", suffix, host, err)
+	}
+	return replica, nil
+}
+
+// ruvSupplier matches the element of a replica update vector (nsds50ruv)
+// naming a supplier: "{replica 1 ldap://supplier1.example.com:389} ..."
+var ruvSupplier = regexp.MustCompile(`^\{replica -?\d+ ldaps?://([^:/}\s]+)`)
+
+// ReadReplicaSuppliers returns the servers in the RUV of a suffix on a host
+// The RUV names every supplier that ever sent the replica a change, as the
+// supplier calls itself (nsslapd-localhost); the host itself is included
+// when it is a supplier too
+// It is kept in the RUV tombstone, which a search only returns when its
+// filter asks for nsTombstone entries
+func (m *Manager) ReadReplicaSuppliers(host, suffix string) ([]string, error) {
+	var suppliers []string
+	err := m.withConnection(host, func(conn ldap.Client) error {
+		searchRequest := ldap.NewSearchRequest(
+			suffix,
+			ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+			"(&(nsuniqueid=ffffffff-ffffffff-ffffffff-ffffffff)(objectClass=nsTombstone))",
+			[]string{"nsds50ruv"},
+			nil,
+		)
+		sr, err := conn.Search(searchRequest)
+		if err != nil {
+			return err
+		}
+		if len(sr.Entries) == 0 {
+			return fmt.Errorf("no RUV for %s", suffix)
+		}
+		for _, element := range sr.Entries[0].GetAttributeValues("nsds50ruv") {
+			if match := ruvSupplier.FindStringSubmatch(element); match != nil {
+				suppliers = append(suppliers, match[1])
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the RUV of %s on %s: %v", suffix, host, err)
+	}
+	return suppliers, nil
+}
+
+// StageAccount creates a replication manager account or refreshes its password
+// The entry follows the layout of the 389DS documentation for replication
+// managers: no password expiry and no idle timeout
+// value is the userPassword value returned by ConsumerPasswordValue
+func (m *Manager) StageAccount(host, accountDN, value string) error {
+	if m.DryRun {
+		m.logger.Info("[DRY-RUN] Would create or refresh account", "account", accountDN, "host", host)
+		return nil
+	}
+
+	err := m.withConnection(host, func(conn ldap.Client) error {
+		cn, err := firstRDNValue(accountDN)
+		if err != nil {
+			return err
+		}
+		addReq := ldap.NewAddRequest(accountDN, nil)
+		addReq.Attribute("objectClass", []string{"top", "person", "organizationalPerson", "inetOrgPerson"})
+		addReq.Attribute("cn", []string{cn})
+		addReq.Attribute("sn", []string{"RM"})
+		addReq.Attribute("userPassword", []string{value})
+		addReq.Attribute("passwordExpirationTime", []string{"20380119031407Z"})
+		addReq.Attribute("nsIdleTimeout", []string{"0"})
+		err = m.add(conn, host, addReq)
+		if !ldap.IsErrorWithCode(err, ldap.LDAPResultEntryAlreadyExists) {
+			return err
+		}
+
+		// The account is left over from an earlier rotation; only refresh it
+		modifyReq := ldap.NewModifyRequest(accountDN, nil)
+		modifyReq.Replace("userPassword", []string{value})
+		return m.modify(conn, host, modifyReq)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to stage %s on %s: %v", accountDN, host, err)
+	}
+	return nil
+}
+
+// AddGroupMember adds an account to a bind DN group on a consumer
+// An account that is already a member is not an error
+func (m *Manager) AddGroupMember(host, groupDN, memberDN string) error {
+	if m.DryRun {
+		m.logger.Info("[DRY-RUN] Would add account to bind DN group", "account", memberDN, "group", groupDN, "host", host)
+		return nil
+	}
+	err := m.withConnection(host, func(conn ldap.Client) error {
+		modifyReq := ldap.NewModifyRequest(groupDN, nil)
+		modifyReq.Add("member", []string{memberDN})
+		err := m.modify(conn, host, modifyReq)
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultAttributeOrValueExists) {
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to add %s to %s on %s: %v", memberDN, groupDN, host, err)
+	}
+	return nil
+}
+
+// VerifyBind checks that an account can bind to a server with a password
+func (m *Manager) VerifyBind(host, accountDN, password string) error {
+	if m.DryRun {
+		return nil
+	}
+	conn, err := m.dialAs(host, accountDN, password)
+	if err != nil {
+		return fmt.Errorf("%s cannot bind to %s: %v", accountDN, host, err)
+	}
+	conn.Close()
+	return nil
+}
+
+// RepointAgreement switches a supplier agreement to another consumer account
+// The bind DN and the credentials are changed in one modify so the agreement
+// never uses the new account with the old password
+// The previous values are returned so RestoreAgreement can undo the change
+func (m *Manager) RepointAgreement(agreement ReplicationAgreement, accountDN, newPassword string) (*AgreementCredentials, error) {
+	if m.DryRun {
+		m.logger.Info("[DRY-RUN] Would repoint agreement", "agreement", agreement.Name, "host", agreement.Supplier, "account", accountDN)
+		return &AgreementCredentials{BindDN: agreement.BindDN}, nil
+	}
+
+	var previous *AgreementCredentials
+	err := m.withConnection(agreement.Supplier, func(conn ldap.Client) error {
+		entry, err := readEntry(conn, agreement.DN, []string{"nsds5replicabinddn", "nsds5replicacredentials"})
+		if err != nil {
+			return err
+		}
+		previous = &AgreementCredentials{
+			BindDN:      entry.GetAttributeValue("nsds5replicabinddn"),
+			Credentials: entry.GetAttributeValue("nsds5replicacredentials"),
+		}
+
+		modifyReq := ldap.NewModifyRequest(agreement.DN, nil)
+		modifyReq.Replace("nsds5replicabinddn", []string{accountDN})
+		modifyReq.Replace("nsds5replicacredentials", []string{newPassword})
+		return m.modify(conn, agreement.Supplier, modifyReq)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to repoint agreement %s: %v", agreement.Name, err)
+	}
+	return previous, nil
+}
+
+// RestoreAgreement puts back the bind DN and credentials read by RepointAgreement
+// The old account is still a member of the group, so replication resumes
+// exactly as before the rotation
+func (m *Manager) RestoreAgreement(agreement ReplicationAgreement, previous *AgreementCredentials) error {
+	if m.DryRun {
+		return nil
+	}
+	err := m.withConnection(agreement.Supplier, func(conn ldap.Client) error {
+		modifyReq := ldap.NewModifyRequest(agreement.DN, nil)
+		modifyReq.Replace("nsds5replicabinddn", []string{previous.BindDN})
+		modifyReq.Replace("nsds5replicacredentials", []string{previous.Credentials})
+		return m.modify(conn, agreement.Supplier, modifyReq)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to restore agreement %s: %v", agreement.Name, err)
+	}
+	return nil
+}
+
+// lastUpdateStatusCode extracts the result code from nsds5replicaLastUpdateStatus
+// Depending on the 389DS version the value looks like
+// "Error (0) Replica acquired successfully: ..." or "0 Replica acquired successfully: ..."
+var lastUpdateStatusCode = regexp.MustCompile(`^(?:Error \()?(-?\d+)`)
+
+// VerifyReplication waits for a replication session that started after since
+// A 389DS agreement reconnects to its consumer when its bind settings change,
+// so the outcome of that session shows whether the new credentials work
+// The agreement status is polled until a session ends or the timeout passes
+// A session that ends with an error is returned as an error; when no session
+// ends in time ErrNotVerified is returned
+func (m *Manager) VerifyReplication(agreement ReplicationAgreement, since time.Time, timeout time.Duration) error {
+	if m.DryRun {
+		return nil
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		var status, end string
+		err := m.withConnection(agreement.Supplier, func(conn ldap.Client) error {
+			entry, err := readEntry(conn, agreement.DN, []string{"nsds5replicaLastUpdateStatus", "nsds5replicaLastUpdateEnd"})
+			if err != nil {
+				return err
+			}
+			status = entry.GetAttributeValue("nsds5replicaLastUpdateStatus")
+			end = entry.GetAttributeValue("nsds5replicaLastUpdateEnd")
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to read status of agreement %s: %v", agreement.Name, err)
+		}
+
+		// Generalized time has one second resolution, so allow for truncation
+		if ended, err := time.Parse("20060102150405Z", end); err == nil && !ended.Before(since.Truncate(time.Second)) {
+			match := lastUpdateStatusCode.FindStringSubmatch(status)
+			if match != nil && match[1] == "0" {
+				return nil
+			}
+			return fmt.Errorf("replication session failed: %s", status)
+		}
+
+		if time.Now().After(deadline) {
+			return ErrNotVerified
+		}
+		time.Sleep(2 * time.Second)
+	}
+}
+
+// RetireAccount takes an old replication manager account out of service
+// It is removed from the bind DN groups and its password is deleted, so
+// nobody can bind with it; the entry itself is kept and reused by the
+// next rotation
+func (m *Manager) RetireAccount(host, accountDN string, groupDNs []string) error {
+	if m.DryRun {
+		m.logger.Info("[DRY-RUN] Would retire account", "account", accountDN, "host", host)
+		return nil
+	}
+	return m.withConnection(host, func(conn ldap.Client) error {
+		for _, groupDN := range groupDNs {
+			modifyReq := ldap.NewModifyRequest(groupDN, nil)
+			modifyReq.Delete("member", []string{accountDN})
+			err := m.modify(conn, host, modifyReq)
+			if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchAttribute) {
+				return fmt.Errorf("failed to remove %s from %s on %s: %v", accountDN, groupDN, host, err)
+			}
+		}
+		modifyReq := ldap.NewModifyRequest(accountDN, nil)
+		modifyReq.Delete("userPassword", nil)
+		err := m.modify(conn, host, modifyReq)
+		if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchAttribute) {
+			return fmt.Errorf("failed to remove the password of %s on %s: %v", accountDN, host, err)
+		}
+		return nil
+	})
+}
+
+// firstRDNValue returns the value of the first RDN, "replication manager"
+// for "cn=replication manager,cn=config"
+func firstRDNValue(dn string) (string, error) {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil || len(parsed.RDNs) == 0 || len(parsed.RDNs[0].Attributes) == 0 {
+		return "", fmt.Errorf("invalid DN %q", dn)
+	}
+	return parsed.RDNs[0].Attributes[0].Value, nil
+}
@@ -0,0 +1,523 @@
+// Package ldaptest provides an in-memory directory for tests
+// It stands in for a set of 389DS servers: each host has its own entries,
+// binds are checked against userPassword, and add, modify, delete and search
+// return the same LDAP result codes a real server would for the cases the
+// manager handles
+package ldaptest
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// Directory holds the entries of every fake server
+// It is safe for concurrent use
+type Directory struct {
+	mutex   sync.Mutex
+	hosts   map[string]map[string]*entry // host -> normalized DN -> entry
+	open    map[string]int               // connections currently open per host
+	maxOpen map[string]int               // most connections ever open at once per host
+	log     []string
+
+	// Hook is called before every operation with the host, the operation
+	// ("bind", "search", "add", "modify" or "delete") and the DN
+	// A non-nil error fails the operation with that error
+	// It runs without the directory lock held, so it may change entries
+	Hook func(host, operation, dn string) error
+}
+
+// entry is one stored entry
+// Attribute names keep the spelling of the first write; lookups ignore case
+type entry struct {
+	dn         string
+	attributes map[string][]string
+}
+
+// NewDirectory returns a directory without servers
+func NewDirectory() *Directory {
+	return &Directory{
+		hosts:   make(map[string]map[string]*entry),
+		open:    make(map[string]int),
+		maxOpen: make(map[string]int),
+	}
+}
+
+// AddServer makes a host reachable
+// An entry for bindDN with password is created so the manager can bind
+func (d *Directory) AddServer(host, bindDN, password string) {
+	d.mutex.Lock()
+	if d.hosts[host] == nil {
+		d.hosts[host] = make(map[string]*entry)
+	}
+	d.mutex.Unlock()
+	d.AddEntry(host, bindDN, map[string][]string{"objectClass": {"top", "person"}, "userPassword": {password}})
+}
+
+// AddEntry creates or replaces an entry on a host
+func (d *Directory) AddEntry(host, dn string, attributes map[string][]string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.hosts[host] == nil {
+		d.hosts[host] = make(map[string]*entry)
+	}
+	stored := &entry{dn: dn, attributes: make(map[string][]string)}
+	for name, values := range attributes {
+		stored.attributes[name] = append([]string(nil), values...)
+	}
+	d.hosts[host][normalizeDN(dn)] = stored
+}
+
+// DeleteEntry removes an entry from a host, if it exists
+func (d *Directory) DeleteEntry(host, dn string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	delete(d.hosts[host], normalizeDN(dn))
+}
+
+// Values returns the values of an attribute of an entry
+// The result is nil when the entry or the attribute does not exist
+func (d *Directory) Values(host, dn, attribute string) []string {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	stored := d.hosts[host][normalizeDN(dn)]
+	if stored == nil {
+		return nil
+	}
+	_, values := stored.get(attribute)
+	return append([]string(nil), values...)
+}
+
+// Exists reports whether an entry exists on a host
+func (d *Directory) Exists(host, dn string) bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.hosts[host][normalizeDN(dn)] != nil
+}
+
+// Operations returns every successful change as "<operation> <host> <dn>",
+// in the order the directory applied them
+func (d *Directory) Operations() []string {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return append([]string(nil), d.log...)
+}
+
+// MaxOpen returns the most connections that were open to a host at once
+func (d *Directory) MaxOpen(host string) int {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.maxOpen[host]
+}
+
+// Dial connects to a host and binds; pass it to NewManagerWithDialer
+// An unknown host fails like an unreachable server
+func (d *Directory) Dial(host, bindDN, password string) (ldap.Client, error) {
+	d.mutex.Lock()
+	_, exists := d.hosts[host]
+	d.mutex.Unlock()
+	if !exists {
+		return nil, fmt.Errorf("failed to connect to LDAP server: dial tcp %s: connection refused", host)
+	}
+
+	conn := &conn{directory: d, host: host}
+	if err := conn.Bind(bindDN, password); err != nil {
+		return nil, fmt.Errorf("failed to bind to LDAP server: %v", err)
+	}
+	d.mutex.Lock()
+	d.open[host]++
+	if d.open[host] > d.maxOpen[host] {
+		d.maxOpen[host] = d.open[host]
+	}
+	d.mutex.Unlock()
+	return conn, nil
+}
+
+// conn is a connection to one fake server
+// Methods the manager never calls are left to the embedded nil interface
+// and panic when used
+type conn struct {
+	ldap.Client
+	directory *Directory
+	host      string
+
+	mutex  sync.Mutex
+	closed bool
+}
+
+// begin runs the hook and takes the directory lock
+// The caller must unlock the directory when begin returns no error
+func (c *conn) begin(operation, dn string) (map[string]*entry, error) {
+	if c.IsClosing() {
+		return nil, ldap.NewError(ldap.ErrorNetwork, fmt.Errorf("connection closed"))
+	}
+	if hook := c.directory.Hook; hook != nil {
+		if err := hook(c.host, operation, dn); err != nil {
+			return nil, err
+		}
+	}
+	c.directory.mutex.Lock()
+	return c.directory.hosts[c.host], nil
+}
+
+// record logs a successful change; the directory lock must be held
+func (c *conn) record(operation, dn string) {
+	c.directory.log = append(c.directory.log, operation+" "+c.host+" "+dn)
+}
+
+func (c *conn) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if !c.closed {
+		c.closed = true
+		c.directory.mutex.Lock()
+		c.directory.open[c.host]--
+		c.directory.mutex.Unlock()
+	}
+	return nil
+}
+
+func (c *conn) IsClosing() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.closed
+}
+
+func (c *conn) Bind(username, password string) error {
+	entries, err := c.begin("bind", username)
+	if err != nil {
+		return err
+	}
+	defer c.directory.mutex.Unlock()
+	if stored := entries[normalizeDN(username)]; stored != nil && password != "" {
+		if _, values := stored.get("userPassword"); contains(values, password) {
+			return nil
+		}
+	}
+	return ldap.NewError(ldap.LDAPResultInvalidCredentials, fmt.Errorf("invalid credentials"))
+}
+
+func (c *conn) Add(req *ldap.AddRequest) error {
+	entries, err := c.begin("add", req.DN)
+	if err != nil {
+		return err
+	}
+	defer c.directory.mutex.Unlock()
+	key := normalizeDN(req.DN)
+	if entries[key] != nil {
+		return ldap.NewError(ldap.LDAPResultEntryAlreadyExists, fmt.Errorf("entry %s already exists", req.DN))
+	}
+	stored := &entry{dn: req.DN, attributes: make(map[string][]string)}
+	for _, attribute := range req.Attributes {
+		stored.set(attribute.Type, append([]string(nil), attribute.Vals...))
+	}
+	entries[key] = stored
+	c.record("add", req.DN)
+	return nil
+}
+
+// Modify applies all changes or none, like a server does
+func (c *conn) Modify(req *ldap.ModifyRequest) error {
+	entries, err := c.begin("modify", req.DN)
+	if err != nil {
+		return err
+	}
+	defer c.directory.mutex.Unlock()
+	stored := entries[normalizeDN(req.DN)]
+	if stored == nil {
+		return ldap.NewError(ldap.LDAPResultNoSuchObject, fmt.Errorf("no such entry %s", req.DN))
+	}
+
+	changed := stored.copy()
+	for _, change := range req.Changes {
+		name := change.Modification.Type
+		_, current := changed.get(name)
+		switch change.Operation {
+		case ldap.AddAttribute:
+			for _, value := range change.Modification.Vals {
+				if contains(current, value) {
+					return ldap.NewError(ldap.LDAPResultAttributeOrValueExists, fmt.Errorf("%s: value exists", name))
+				}
+				current = append(current, value)
+			}
+			changed.set(name, current)
+		case ldap.DeleteAttribute:
+			if len(current) == 0 {
+				return ldap.NewError(ldap.LDAPResultNoSuchAttribute, fmt.Errorf("%s: no such attribute", name))
+			}
+			if len(change.Modification.Vals) == 0 {
+				changed.set(name, nil)
+				continue
+			}
+			for _, value := range change.Modification.Vals {
+				if !contains(current, value) {
+					return ldap.NewError(ldap.LDAPResultNoSuchAttribute, fmt.Errorf("%s: no such value", name))
+				}
+				current = remove(current, value)
+			}
+			changed.set(name, current)
+		case ldap.ReplaceAttribute:
+			changed.set(name, append([]string(nil), change.Modification.Vals...))
+		default:
+			return ldap.NewError(ldap.LDAPResultUnwillingToPerform, fmt.Errorf("unsupported modify operation %d", change.Operation))
+		}
+	}
+	stored.attributes = changed.attributes
+	c.record("modify", req.DN)
+	return nil
+}
+
+func (c *conn) Del(req *ldap.DelRequest) error {
+	entries, err := c.begin("delete", req.DN)
+	if err != nil {
+		return err
+	}
+	defer c.directory.mutex.Unlock()
+	key := normalizeDN(req.DN)
+	if entries[key] == nil {
+		return ldap.NewError(ldap.LDAPResultNoSuchObject, fmt.Errorf("no such entry %s", req.DN))
+	}
+	delete(entries, key)
+	c.record("delete", req.DN)
+	return nil
+}
+
+// Search supports the three scopes and filters made of &, |, !, presence
+// and equality, which is all the manager sends
+func (c *conn) Search(req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	entries, err := c.begin("search", req.BaseDN)
+	if err != nil {
+		return nil, err
+	}
+	defer c.directory.mutex.Unlock()
+	base := normalizeDN(req.BaseDN)
+	if entries[base] == nil {
+		return nil, ldap.NewError(ldap.LDAPResultNoSuchObject, fmt.Errorf("no such entry %s", req.BaseDN))
+	}
+	filter, err := parseFilter(req.Filter)
+	if err != nil {
+		return nil, ldap.NewError(ldap.LDAPResultFilterError, err)
+	}
+
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	result := &ldap.SearchResult{}
+	for _, key := range keys {
+		if !inScope(key, base, req.Scope) || !filter.match(entries[key]) {
+			continue
+		}
+		result.Entries = append(result.Entries, entries[key].result(req.Attributes))
+	}
+	return result, nil
+}
+
+// get returns the stored name and values of an attribute, ignoring case
+func (e *entry) get(name string) (string, []string) {
+	for stored, values := range e.attributes {
+		if strings.EqualFold(stored, name) {
+			return stored, values
+		}
+	}
+	return "", nil
+}
+
+// set replaces the values of an attribute; no values removes it
+func (e *entry) set(name string, values []string) {
+	if stored, _ := e.get(name); stored != "" {
+		delete(e.attributes, stored)
+	}
+	if len(values) > 0 {
+		e.attributes[name] = values
+	}
+}
+
+func (e *entry) copy() *entry {
+	copied := &entry{dn: e.dn, attributes: make(map[string][]string)}
+	for name, values := range e.attributes {
+		copied.attributes[name] = append([]string(nil), values...)
+	}
+	return copied
+}
+
+// result builds a search result entry with the requested attributes,
+// named as the request names them, or every attribute when none is requested
+func (e *entry) result(requested []string) *ldap.Entry {
+	attributes := make(map[string][]string)
+	if len(requested) == 0 || contains(requested, "*") {
+		for name, values := range e.attributes {
+			attributes[name] = append([]string(nil), values...)
+		}
+	}
+	for _, name := range requested {
+		if _, values := e.get(name); values != nil {
+			attributes[name] = append([]string(nil), values...)
+		}
+	}
+	return ldap.NewEntry(e.dn, attributes)
+}
+
+// inScope reports whether the entry key lies within a search scope
+func inScope(key, base string, scope int) bool {
+	switch scope {
+	case ldap.ScopeBaseObject:
+		return key == base
+	case ldap.ScopeSingleLevel:
+		return strings.HasSuffix(key, ","+base) && !strings.Contains(strings.TrimSuffix(key, ","+base), ",")
+	default:
+		return key == base || strings.HasSuffix(key, ","+base)
+	}
+}
+
+// filter is a parsed search filter
+type filter struct {
+	operator  byte // '&', '|', '!' or '=' for a comparison
+	attribute string
+	value     string // "*" tests presence
+	children  []*filter
+}
+
+func (f *filter) match(e *entry) bool {
+	switch f.operator {
+	case '&':
+		for _, child := range f.children {
+			if !child.match(e) {
+				return false
+			}
+		}
+		return true
+	case '|':
+		for _, child := range f.children {
+			if child.match(e) {
+				return true
+			}
+		}
+		return false
+	case '!':
+		return !f.children[0].match(e)
+	}
+	_, values := e.get(f.attribute)
+	if f.value == "*" {
+		return len(values) > 0
+	}
+	for _, value := range values {
+		if strings.EqualFold(value, f.value) || normalizeDN(value) == normalizeDN(f.value) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseFilter parses the string form of a filter
+func parseFilter(text string) (*filter, error) {
+	parsed, rest, err := parseFilterAt(text)
+	if err != nil {
+		return nil, err
+	}
+	if rest != "" {
+		return nil, fmt.Errorf("unexpected %q after filter", rest)
+	}
+	return parsed, nil
+}
+
+func parseFilterAt(text string) (*filter, string, error) {
+	if !strings.HasPrefix(text, "(") {
+		return nil, "", fmt.Errorf("filter %q does not start with (", text)
+	}
+	text = text[1:]
+	if text == "" {
+		return nil, "", fmt.Errorf("unterminated filter")
+	}
+
+	switch text[0] {
+	case '&', '|', '!':
+		parsed := &filter{operator: text[0]}
+		text = text[1:]
+		for strings.HasPrefix(text, "(") {
+			child, rest, err := parseFilterAt(text)
+			if err != nil {
+				return nil, "", err
+			}
+			parsed.children = append(parsed.children, child)
+			text = rest
+		}
+		if !strings.HasPrefix(text, ")") || len(parsed.children) == 0 || (parsed.operator == '!' && len(parsed.children) != 1) {
+			return nil, "", fmt.Errorf("malformed %c filter", parsed.operator)
+		}
+		return parsed, text[1:], nil
+	}
+
+	end := strings.Index(text, ")")
+	if end < 0 {
+		return nil, "", fmt.Errorf("unterminated filter")
+	}
+	attribute, value, found := strings.Cut(text[:end], "=")
+	if !found || attribute == "" {
+		return nil, "", fmt.Errorf("unsupported filter %q", text[:end])
+	}
+	value, err := unescapeFilter(value)
+	if err != nil {
+		return nil, "", err
+	}
+	return &filter{operator: '=', attribute: attribute, value: value}, text[end+1:], nil
+}
+
+// unescapeFilter undoes ldap.EscapeFilter
+func unescapeFilter(value string) (string, error) {
+	var unescaped strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' {
+			unescaped.WriteByte(value[i])
+			continue
+		}
+		if i+2 >= len(value) {
+			return "", fmt.Errorf("invalid escape in %q", value)
+		}
+		code, err := strconv.ParseUint(value[i+1:i+3], 16, 8)
+		if err != nil {
+			return "", fmt.Errorf("invalid escape in %q", value)
+		}
+		unescaped.WriteByte(byte(code))
+		i += 2
+	}
+	return unescaped.String(), nil
+}
+
+// normalizeDN lowercases a DN and removes spaces around separators
+func normalizeDN(dn string) string {
+	parts := strings.Split(dn, ",")
+	for i, part := range parts {
+		pair := strings.SplitN(part, "=", 2)
+		for j := range pair {
+			pair[j] = strings.TrimSpace(pair[j])
+		}
+		parts[i] = strings.Join(pair, "=")
+	}
+	return strings.ToLower(strings.Join(parts, ","))
+}
+
+func contains(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
+
+func remove(values []string, value string) []string {
+	kept := values[:0:0]
+	for _, candidate := range values {
+		if candidate != value {
+			kept = append(kept, candidate)
+		}
+	}
+	return kept
+}
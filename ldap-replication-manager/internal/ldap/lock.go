@@ -0,0 +1,213 @@
+package ldap
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// generalizedTime is the LDAP GeneralizedTime layout used for the lock expiry
+const generalizedTime = "20060102150405Z"
+
+// lockAttempts bounds how often AcquireLock retries the add when the lock
+// disappears before its holder can be read
+const lockAttempts = 5
+
+// LockInfo describes the rotation lock entry as found in the directory
+type LockInfo struct {
+	DN      string
+	Holder  string
+	Expires time.Time
+}
+
+// Stale reports whether the lock expired without being released
+// This happens when the process holding it was killed
+func (l *LockInfo) Stale() bool {
+	return time.Now().After(l.Expires)
+}
+
+// Lock is a rotation lock held by this process
+// The lock is an entry in the directory itself, so every machine that runs
+// the tool against the same server sees it, unlike a local lock file
+//   - cn / objectClass extensibleObject: the entry exists only while held
+//   - description: who holds it (user, host, process and run ID)
+//   - passwordExpirationTime: when it expires if it is not refreshed
+//
+// passwordExpirationTime is used because it is a GeneralizedTime attribute
+// every 389DS schema already has; the entry is not an account
+// Creating the entry is an LDAP add, which the server performs atomically,
+// so two runs can never both acquire the lock
+type Lock struct {
+	manager *Manager
+	dn      string
+	holder  string
+	ttl     time.Duration
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// AcquireLock creates the lock entry or takes over a stale one
+// A lock held by someone else that has not expired is returned as an error
+// together with its details, so the caller can tell the operator who holds it
+// While held, the expiry is pushed forward in the background every third of ttl
+func (m *Manager) AcquireLock(dn, holder string, ttl time.Duration) (*Lock, *LockInfo, error) {
+	lock := &Lock{manager: m, dn: dn, holder: holder, ttl: ttl, stop: make(chan struct{})}
+	expires := time.Now().Add(ttl).UTC().Format(generalizedTime)
+
+	var current *LockInfo
+	err := m.withConnection(m.config.LDAP.Host, func(conn ldap.Client) error {
+		cn, err := firstRDNValue(dn)
+		if err != nil {
+			return err
+		}
+		for attempt := 1; ; attempt++ {
+			addReq := ldap.NewAddRequest(dn, nil)
+			addReq.Attribute("objectClass", []string{"top", "extensibleObject"})
+			addReq.Attribute("cn", []string{cn})
+			addReq.Attribute("description", []string{holder})
+			addReq.Attribute("passwordExpirationTime", []string{expires})
+			err = m.add(conn, m.config.LDAP.Host, addReq)
+			if !ldap.IsErrorWithCode(err, ldap.LDAPResultEntryAlreadyExists) {
+				return err
+			}
+
+			// Somebody holds the lock; take it over only if it is stale
+			current, err = readLock(conn, dn)
+			if err != nil || current != nil {
+				break
+			}
+			// The holder released the lock between the add and the read
+			if attempt == lockAttempts {
+				return fmt.Errorf("the lock was released and taken again %d times in a row", lockAttempts)
+			}
+		}
+		if err != nil || !current.Stale() {
+			return err
+		}
+		m.logger.Warn("Taking over stale rotation lock", "lock", dn, "holder", current.Holder, "expired", current.Expires)
+
+		// Deleting the old holder's value makes the modify fail if another
+		// run took the lock over in the meantime
+		modifyReq := ldap.NewModifyRequest(dn, nil)
+		modifyReq.Delete("description", []string{current.Holder})
+		modifyReq.Add("description", []string{holder})
+		modifyReq.Replace("passwordExpirationTime", []string{expires})
+		if err := m.modify(conn, m.config.LDAP.Host, modifyReq); err != nil {
+			return fmt.Errorf("failed to take over stale lock: %v", err)
+		}
+		current = nil
+		return nil
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to acquire rotation lock %s: %v", dn, err)
+	}
+	if current != nil {
+		return nil, current, fmt.Errorf("rotation lock is held by %s until %s", current.Holder, current.Expires.Local().Format(time.RFC1123))
+	}
+
+	go lock.keepAlive()
+	return lock, nil, nil
+}
+
+// keepAlive refreshes the expiry until the lock is released
+// A run that takes longer than the ttl therefore keeps its lock, while a
+// killed run stops refreshing and its lock turns stale
+func (l *Lock) keepAlive() {
+	ticker := time.NewTicker(l.ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			if err := l.refresh(); err != nil {
+				l.manager.logger.Warn("Could not refresh rotation lock", "lock", l.dn, "error", err)
+			}
+		}
+	}
+}
+
+// refresh pushes the expiry forward, provided the lock is still ours
+func (l *Lock) refresh() error {
+	return l.manager.withConnection(l.manager.config.LDAP.Host, func(conn ldap.Client) error {
+		modifyReq := ldap.NewModifyRequest(l.dn, nil)
+		modifyReq.Delete("description", []string{l.holder})
+		modifyReq.Add("description", []string{l.holder})
+		modifyReq.Replace("passwordExpirationTime", []string{time.Now().Add(l.ttl).UTC().Format(generalizedTime)})
+		return l.manager.modify(conn, l.manager.config.LDAP.Host, modifyReq)
+	})
+}
+
+// Release deletes the lock entry if this process still holds it
+// Calling it more than once is harmless
+func (l *Lock) Release() error {
+	if l == nil {
+		return nil
+	}
+	released := false
+	l.stopOnce.Do(func() {
+		close(l.stop)
+		released = true
+	})
+	if !released {
+		return nil
+	}
+
+	return l.manager.withConnection(l.manager.config.LDAP.Host, func(conn ldap.Client) error {
+		current, err := readLock(conn, l.dn)
+		if err != nil {
+			return err
+		}
+		if current == nil || current.Holder != l.holder {
+			l.manager.logger.Warn("Rotation lock was taken over by someone else", "lock", l.dn)
+			return nil
+		}
+		return l.manager.del(conn, l.manager.config.LDAP.Host, ldap.NewDelRequest(l.dn, nil))
+	})
+}
+
+// ReadLock returns the current lock entry, or nil when nobody holds the lock
+func (m *Manager) ReadLock(dn string) (*LockInfo, error) {
+	var info *LockInfo
+	err := m.withConnection(m.config.LDAP.Host, func(conn ldap.Client) error {
+		var err error
+		info, err = readLock(conn, dn)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rotation lock %s: %v", dn, err)
+	}
+	return info, nil
+}
+
+// BreakLock deletes the lock entry whoever holds it
+// It is meant for an operator who made sure the holder is no longer running
+func (m *Manager) BreakLock(dn string) error {
+	err := m.withConnection(m.config.LDAP.Host, func(conn ldap.Client) error {
+		return m.del(conn, m.config.LDAP.Host, ldap.NewDelRequest(dn, nil))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to break rotation lock %s: %v", dn, err)
+	}
+	return nil
+}
+
+// readLock reads the lock entry with an open connection
+// A missing entry is not an error: the lock is free
+func readLock(conn ldap.Client, dn string) (*LockInfo, error) {
+	entry, err := readEntry(conn, dn, []string{"description", "passwordExpirationTime"})
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	info := &LockInfo{DN: dn, Holder: entry.GetAttributeValue("description")}
+	if expires, err := time.Parse(generalizedTime, entry.GetAttributeValue("passwordExpirationTime")); err == nil {
+		info.Expires = expires
+	}
+	return info, nil
+}
@@ -0,0 +1,122 @@
+package ldap
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ldap-replication-manager/internal/ldap/ldaptest"
+)
+
+const testLockDN = "cn=rotation lock,cn=config"
+
+// addLock stores a lock entry held by holder until expires
+func addLock(directory *ldaptest.Directory, holder string, expires time.Time) {
+	directory.AddEntry(testHost, testLockDN, map[string][]string{
+		"objectClass":            {"top", "extensibleObject"},
+		"cn":                     {"rotation lock"},
+		"description":            {holder},
+		"passwordExpirationTime": {expires.UTC().Format(generalizedTime)},
+	})
+}
+
+func TestAcquireLock(t *testing.T) {
+	manager, directory := newTestManager(t)
+
+	lock, _, err := manager.AcquireLock(testLockDN, "alice@host1", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := directory.Values(testHost, testLockDN, "description"); len(got) != 1 || got[0] != "alice@host1" {
+		t.Errorf("lock holder %v", got)
+	}
+
+	// A second run is refused and told who holds the lock
+	other, info, err := manager.AcquireLock(testLockDN, "bob@host2", time.Hour)
+	if err == nil || other != nil {
+		t.Fatal("a held lock must not be acquired twice")
+	}
+	if info == nil || info.Holder != "alice@host1" || info.Stale() {
+		t.Errorf("lock info %+v", info)
+	}
+
+	if err := lock.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if directory.Exists(testHost, testLockDN) {
+		t.Error("a released lock must be deleted")
+	}
+	if err := lock.Release(); err != nil {
+		t.Errorf("a second release must be harmless: %v", err)
+	}
+}
+
+func TestAcquireLockTakesOverStale(t *testing.T) {
+	manager, directory := newTestManager(t)
+	addLock(directory, "killed@host1", time.Now().Add(-time.Minute))
+
+	lock, _, err := manager.AcquireLock(testLockDN, "alice@host2", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lock.Release()
+
+	if got := directory.Values(testHost, testLockDN, "description"); len(got) != 1 || got[0] != "alice@host2" {
+		t.Errorf("lock holder %v, want only alice@host2", got)
+	}
+	info, err := manager.ReadLock(testLockDN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Stale() {
+		t.Errorf("a taken over lock must get a new expiry, got %v", info.Expires)
+	}
+}
+
+func TestAcquireLockReleasedDuringAcquisition(t *testing.T) {
+	manager, directory := newTestManager(t)
+	addLock(directory, "bob@host1", time.Now().Add(time.Hour))
+
+	// The holder releases the lock right after our add failed
+	releases := 0
+	directory.Hook = func(host, operation, dn string) error {
+		if operation == "search" && dn == testLockDN && releases == 0 {
+			releases++
+			directory.DeleteEntry(host, dn)
+		}
+		return nil
+	}
+
+	lock, info, err := manager.AcquireLock(testLockDN, "alice@host2", time.Hour)
+	if err != nil {
+		t.Fatalf("AcquireLock() = %v, %v", info, err)
+	}
+	defer lock.Release()
+	if got := directory.Values(testHost, testLockDN, "description"); len(got) != 1 || got[0] != "alice@host2" {
+		t.Errorf("lock holder %v, want alice@host2", got)
+	}
+}
+
+func TestAcquireLockGivesUp(t *testing.T) {
+	manager, directory := newTestManager(t)
+	addLock(directory, "bob@host1", time.Now().Add(time.Hour))
+
+	// The lock is released before every read and taken again before every add
+	directory.Hook = func(host, operation, dn string) error {
+		if dn != testLockDN {
+			return nil
+		}
+		switch operation {
+		case "search":
+			directory.DeleteEntry(host, dn)
+		case "add":
+			addLock(directory, "bob@host1", time.Now().Add(time.Hour))
+		}
+		return nil
+	}
+
+	if lock, _, err := manager.AcquireLock(testLockDN, "alice@host2", time.Hour); err == nil || !strings.Contains(err.Error(), "released and taken again") {
+		lock.Release()
+		t.Errorf("AcquireLock() = %v, want it to give up", err)
+	}
+}
@@ -2,10 +2,12 @@ package ldap
 
 import (
 	"fmt"
-	"log"
+	"log/slog"
 	"strings"
+	"sync"
 
 	"github.com/go-ldap/ldap/v3"
+	"github.com/ldap-replication-manager/internal/audit"
 	"github.com/ldap-replication-manager/internal/config"
 )
 
@@ -30,8 +32,14 @@ type ReplicationAgreement struct {
 	// Distinguished Name of the agreement in LDAP
 	DN string
 
+	// Replicated suffix (nsds5replicaroot), used to find the consumer's replica entry
+	Suffix string
+
 	// Whether this agreement is currently enabled
 	Enabled bool
+
+	// Outcome of the last replication session (nsds5replicaLastUpdateStatus)
+	LastUpdateStatus string `json:",omitempty"`
 }
 
 // Manager handles all LDAP operations for replication management
@@ -42,10 +50,22 @@ type ReplicationAgreement struct {
 type Manager struct {
 	config    *config.Config
 	connected bool
-	ldapConn  *ldap.Conn
-	DryRun    bool // If true, only preview changes
+	pool      *connectionPool // Per-server connections used for updates
+	logger    *slog.Logger    // Structured logger, see SetLogger
+	audit     *audit.Log      // Record of every change, see SetAuditLog
+	dialer    Dialer          // Opens bound connections, see NewManagerWithDialer
+	DryRun    bool            // If true, only preview changes
+
+	// First audit log failure and how many changes went unrecorded, see AuditError
+	auditMutex sync.Mutex
+	auditErr   error
+	unaudited  int
 }
 
+// Dialer opens a connection to an LDAP server and binds with the given credentials
+// The manager uses DialLDAP; tests pass an in-memory directory instead
+type Dialer func(host, bindDN, password string) (ldap.Client, error)
+
 // NewManager creates a new LDAP manager instance
 // This function initializes the LDAP connection and validates connectivity
 // It accepts mode flags to determine whether to use real or simulated operations
@@ -53,71 +73,163 @@ type Manager struct {
 // Dry-run mode connects to real servers but doesn't make changes
 // This design pattern separates connection management from business logic
 func NewManager(cfg *config.Config, eduMode, prodMode bool) (*Manager, error) {
+	return NewManagerWithDialer(cfg, func(host, bindDN, password string) (ldap.Client, error) {
+		return DialLDAP(host, cfg.LDAP.Port, bindDN, password)
+	})
+}
+
+// NewManagerWithDialer creates a manager that opens its connections with dial
+// It is what NewManager does with real LDAP connections
+func NewManagerWithDialer(cfg *config.Config, dial Dialer) (*Manager, error) {
 	// Accept dry-run as an argument (add to constructor signature in main.go)
 	manager := &Manager{
 		config: cfg,
+		pool:   newConnectionPool(cfg.LDAP.MaxConnectionsPerHost),
+		logger: slog.Default().With("component", "ldap"),
+		dialer: dial,
 		DryRun: false, // default, will be set by main.go
 	}
 
-	// Connect to LDAP
-	l, err := ldap.DialURL(fmt.Sprintf("ldap://%s:%d", cfg.LDAP.Host, cfg.LDAP.Port))
+	// Connect and bind to the primary LDAP server
+	// The connection goes to the pool, so it counts towards the server's
+	// connection limit and is reused by the first change
+	l, err := manager.dial(cfg.LDAP.Host)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to LDAP server: %v", err)
+		return nil, err
 	}
-	manager.ldapConn = l
+	manager.pool.addIdle(cfg.LDAP.Host, l)
+
+	manager.connected = true
+	manager.logger.Info("Connected and bound to LDAP server", "host", cfg.LDAP.Host, "port", cfg.LDAP.Port)
+	return manager, nil
+}
+
+// SetLogger replaces the logger of the manager
+// Pass a logger carrying the correlation fields of the run, such as run_id;
+// the manager adds agreement and host to the lines it writes
+func (m *Manager) SetLogger(logger *slog.Logger) {
+	m.logger = logger.With("component", "ldap")
+}
+
+// dial opens and binds a new connection to the given LDAP server
+// It uses the port and credentials from the configuration file
+// The caller is responsible for closing the returned connection
+// Having one helper keeps connection handling identical for every server
+func (m *Manager) dial(host string) (ldap.Client, error) {
+	return m.dialAs(host, m.config.LDAP.BindDN, m.config.LDAP.Password)
+}
+
+// dialAs opens a connection to the given LDAP server and binds with the given credentials
+// It is used directly only to test credentials other than the configured ones
+func (m *Manager) dialAs(host, bindDN, password string) (ldap.Client, error) {
+	return m.dialer(host, bindDN, password)
+}
 
-	// Bind
-	err = l.Bind(cfg.LDAP.BindDN, cfg.LDAP.Password)
+// DialLDAP opens a connection to an LDAP server on port and binds with the given credentials
+func DialLDAP(host string, port int, bindDN, password string) (ldap.Client, error) {
+	l, err := ldap.DialURL(fmt.Sprintf("ldap://%s:%d", host, port))
 	if err != nil {
+		return nil, fmt.Errorf("failed to connect to LDAP server: %v", err)
+	}
+
+	if err := l.Bind(bindDN, password); err != nil {
 		l.Close()
 		return nil, fmt.Errorf("failed to bind to LDAP server: %v", err)
 	}
 
-	manager.connected = true
-	log.Printf("Connected and bound to LDAP server: %s:%d", cfg.LDAP.Host, cfg.LDAP.Port)
-	return manager, nil
+	return l, nil
 }
 
 // Close cleanly shuts down LDAP connections
 // This ensures proper cleanup of network resources
 // Always call this method when done with the manager
 func (m *Manager) Close() {
-	if m.connected && m.ldapConn != nil {
-		log.Println("Closing LDAP connection")
-		m.ldapConn.Close()
+	if m.connected {
+		m.logger.Debug("Closing LDAP connections")
+		m.pool.closeAll()
 		m.connected = false
 	}
 }
 
-// DiscoverReplicationAgreements finds all replication agreements on the server
+// Suppliers returns the servers whose agreements are discovered:
+// ldap.host followed by ldap.suppliers, each host once
+func (m *Manager) Suppliers() []string {
+	var suppliers []string
+	seen := make(map[string]bool)
+	for _, host := range append([]string{m.config.LDAP.Host}, m.config.LDAP.Suppliers...) {
+		if host == "" || seen[strings.ToLower(host)] {
+			continue
+		}
+		seen[strings.ToLower(host)] = true
+		suppliers = append(suppliers, host)
+	}
+	return suppliers
+}
+
+// DiscoverReplicationAgreements finds all replication agreements on the suppliers
 // This method searches the LDAP directory for replication agreement objects
 // It returns a slice of ReplicationAgreement structs with all relevant information
 // The search is performed in the cn=config subtree where 389DS stores configuration
 // Understanding this helps administrators see what agreements exist in their environment
+// Every supplier from Suppliers is searched, in order; agreements are known by
+// their name elsewhere (predefined passwords, the vault), so a name found on
+// two suppliers is refused
 func (m *Manager) DiscoverReplicationAgreements() ([]ReplicationAgreement, error) {
-	if !m.connected || m.ldapConn == nil {
+	if !m.connected {
 		return nil, fmt.Errorf("not connected to LDAP server")
 	}
 
-	log.Println("Searching for replication agreements...")
+	agreements := []ReplicationAgreement{}
+	foundOn := make(map[string]string)
+	for _, supplier := range m.Suppliers() {
+		discovered, err := m.discoverAgreements(supplier)
+		if err != nil {
+			return nil, fmt.Errorf("LDAP search on %s failed: %v", supplier, err)
+		}
+		for _, agreement := range discovered {
+			name := strings.ToLower(agreement.Name)
+			if other, exists := foundOn[name]; exists {
+				return nil, fmt.Errorf("agreement %s exists on %s and %s; agreement names must be unique across suppliers", agreement.Name, other, supplier)
+			}
+			foundOn[name] = supplier
+		}
+		agreements = append(agreements, discovered...)
+	}
+
+	m.logger.Info("Found replication agreements", "count", len(agreements))
+	for _, agreement := range agreements {
+		m.logger.Debug("Discovered agreement", "agreement", agreement.Name, "host", agreement.Supplier,
+			"consumer", agreement.Consumer, "enabled", agreement.Enabled, "status", agreement.Status())
+	}
+
+	return agreements, nil
+}
+
+// discoverAgreements searches one supplier for its agreements
+func (m *Manager) discoverAgreements(supplier string) ([]ReplicationAgreement, error) {
+	m.logger.Info("Searching for replication agreements", "host", supplier, "base_dn", m.config.LDAP.BaseDN)
 
 	searchRequest := ldap.NewSearchRequest(
 		m.config.LDAP.BaseDN,
 		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
 		"(objectClass=nsds5ReplicationAgreement)",
-		[]string{"cn", "nsds5replicahost", "nsds5replicabinddn", "dn", "nsds5replicaenabled"},
+		[]string{"cn", "nsds5replicahost", "nsds5replicabinddn", "dn", "nsds5replicaenabled", "nsds5replicaroot", "nsds5replicaLastUpdateStatus"},
 		nil,
 	)
 
-	sr, err := m.ldapConn.Search(searchRequest)
+	var sr *ldap.SearchResult
+	err := m.withConnection(supplier, func(conn ldap.Client) error {
+		var err error
+		sr, err = conn.Search(searchRequest)
+		return err
+	})
 	if err != nil {
-		return nil, fmt.Errorf("LDAP search failed: %v", err)
+		return nil, err
 	}
 
 	agreements := []ReplicationAgreement{}
 	for _, entry := range sr.Entries {
 		name := entry.GetAttributeValue("cn")
-		supplier := m.config.LDAP.Host
 		consumer := entry.GetAttributeValue("nsds5replicahost")
 		bindDN := entry.GetAttributeValue("nsds5replicabinddn")
 		dn := entry.DN
@@ -131,55 +243,62 @@ func (m *Manager) DiscoverReplicationAgreements() ([]ReplicationAgreement, error
 			Consumer: consumer,
 			BindDN:   bindDN,
 			DN:       dn,
+			Suffix:   entry.GetAttributeValue("nsds5replicaroot"),
 			Enabled:  enabled,
-		})
-	}
 
-	log.Printf("Found %d replication agreements", len(agreements))
-	for _, agreement := range agreements {
-		log.Printf("  - %s: %s -> %s", agreement.Name, agreement.Supplier, agreement.Consumer)
+			LastUpdateStatus: entry.GetAttributeValue("nsds5replicaLastUpdateStatus"),
+		})
 	}
-
 	return agreements, nil
 }
 
 // UpdateReplicationPassword updates the password for a replication agreement
 // This method modifies both the supplier and consumer sides of the agreement
 // The serverType parameter specifies whether we're updating "supplier" or "consumer"
+// The supplier side changes nsds5replicacredentials on the agreement entry to
+// the clear text password, the consumer side changes userPassword of the bind
+// DN the supplier uses to value, which comes from ConsumerPasswordValue so the
+// hash applied is the one shown in the plan
+// Each change goes to the server that holds the entry, over a pooled connection,
+// so it is safe to call from several goroutines at once
 // In production mode, this performs real LDAP operations
-// In educational mode, this simulates the operations for learning
 // In dry-run mode, this shows what would be changed without executing
-func (m *Manager) UpdateReplicationPassword(server, agreementName, newPassword, serverType string) error {
-	if !m.connected || m.ldapConn == nil {
+func (m *Manager) UpdateReplicationPassword(agreement ReplicationAgreement, value, serverType string) error {
+	if !m.connected {
 		return fmt.Errorf("not connected to LDAP server")
 	}
 
+	server := agreement.Supplier
+	if serverType != "supplier" {
+		server = agreement.Consumer
+	}
+
 	if m.DryRun {
-		// Print the planned LDAP modify command
-		cmd := m.GeneratePasswordUpdateCommand(server, agreementName, newPassword, serverType)
-		log.Printf("[DRY-RUN] Would execute: %s", cmd)
+		// The planned ldapmodify commands are part of the printed plan;
+		// the log only records the change, never the password
+		m.logger.Info("[DRY-RUN] Would update password", "agreement", agreement.Name, "host", server, "side", serverType)
 		return nil
 	}
 
 	var modifyReq *ldap.ModifyRequest
 	if serverType == "supplier" {
 		// Update nsds5replicacredentials on the agreement DN
-		agreementDN := fmt.Sprintf("cn=%s,cn=replica,cn=dc=example,dc=com,cn=mapping tree,cn=config", agreementName)
-		modifyReq = ldap.NewModifyRequest(agreementDN, nil)
-		modifyReq.Replace("nsds5replicacredentials", []string{newPassword})
+		modifyReq = ldap.NewModifyRequest(agreement.DN, nil)
+		modifyReq.Replace("nsds5replicacredentials", []string{value})
 	} else {
 		// Update userPassword on replication manager DN on consumer
-		replicationManagerDN := "cn=replication manager,cn=config"
-		modifyReq = ldap.NewModifyRequest(replicationManagerDN, nil)
-		modifyReq.Replace("userPassword", []string{newPassword})
+		modifyReq = ldap.NewModifyRequest(agreement.ReplicationManagerDN(), nil)
+		modifyReq.Replace("userPassword", []string{value})
 	}
 
-	err := m.ldapConn.Modify(modifyReq)
+	err := m.withConnection(server, func(conn ldap.Client) error {
+		return m.modify(conn, server, modifyReq)
+	})
 	if err != nil {
 		return fmt.Errorf("LDAP password update failed: %v", err)
 	}
 
-	log.Printf("Successfully updated %s password for agreement %s on server %s", serverType, agreementName, server)
+	m.logger.Info("Password updated", "agreement", agreement.Name, "host", server, "side", serverType)
 	return nil
 }
 
@@ -188,21 +307,87 @@ func (m *Manager) UpdateReplicationPassword(server, agreementName, newPassword,
 // It's useful for dry-run mode and for administrators who prefer manual operations
 // The generated commands can be saved to scripts for batch operations
 // This educational feature helps users understand the underlying LDAP operations
-func (m *Manager) GeneratePasswordUpdateCommand(server, agreementName, newPassword, serverType string) string {
+// value is what UpdateReplicationPassword writes: the clear text password for
+// the supplier, the result of ConsumerPasswordValue for the consumer
+func (m *Manager) GeneratePasswordUpdateCommand(agreement ReplicationAgreement, value, serverType string) string {
 	if serverType == "supplier" {
 		// Generate command to update the replication agreement password on supplier
 		// This modifies the nsds5replicacredentials attribute
-		agreementDN := fmt.Sprintf("cn=%s,cn=replica,cn=dc=example,dc=com,cn=mapping tree,cn=config", agreementName)
-
 		return fmt.Sprintf("ldapmodify -x -D \"%s\" -W -H ldap://%s:%d << EOF\ndn: %s\nchangetype: modify\nreplace: nsds5replicacredentials\nnsds5replicacredentials: %s\nEOF",
-			m.config.LDAP.BindDN, server, m.config.LDAP.Port, agreementDN, newPassword)
+			m.config.LDAP.BindDN, agreement.Supplier, m.config.LDAP.Port, agreement.DN, value)
 	} else {
 		// Generate command to update the replication manager password on consumer
 		// This updates the actual user account that the supplier binds as
-		replicationManagerDN := "cn=replication manager,cn=config"
-
 		return fmt.Sprintf("ldapmodify -x -D \"%s\" -W -H ldap://%s:%d << EOF\ndn: %s\nchangetype: modify\nreplace: userPassword\nuserPassword: %s\nEOF",
-			m.config.LDAP.BindDN, server, m.config.LDAP.Port, replicationManagerDN, newPassword)
+			m.config.LDAP.BindDN, agreement.Consumer, m.config.LDAP.Port, agreement.ReplicationManagerDN(), value)
+	}
+}
+
+// ReplicationManagerDN returns the consumer entry the agreement binds as
+// Agreements without nsds5replicabinddn fall back to the usual 389DS entry
+func (a ReplicationAgreement) ReplicationManagerDN() string {
+	if a.BindDN == "" {
+		return DefaultReplicationManagerDN
+	}
+	return a.BindDN
+}
+
+// SameDN reports whether two DNs name the same entry
+// Case and spaces around separators are ignored
+func SameDN(a, b string) bool {
+	return normalizeDN(a) == normalizeDN(b)
+}
+
+// ConsumerEntry identifies the consumer account an agreement binds as
+// Agreements with the same consumer host and bind DN share that account,
+// so changing its password affects all of them at once
+// The DN is normalized so that spelling differences do not split a group
+func (a ReplicationAgreement) ConsumerEntry() string {
+	return strings.ToLower(a.Consumer) + "|" + normalizeDN(a.ReplicationManagerDN())
+}
+
+// ConsumerPasswordValue returns the userPassword value to write on a consumer
+// With a consumer password scheme the consumer only ever receives the hash,
+// while the supplier still needs the clear text in nsds5replicacredentials
+// Without a scheme the clear text is sent and the consumer hashes it itself
+// Every hash has a new salt, so it is computed once per rotation and the same
+// value is printed and applied
+func (m *Manager) ConsumerPasswordValue(newPassword string) (string, error) {
+	if m.config.LDAP.ConsumerPasswordScheme == "" {
+		return newPassword, nil
+	}
+	hashed, err := HashPassword(m.config.LDAP.ConsumerPasswordScheme, newPassword)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash consumer password: %v", err)
+	}
+	return hashed, nil
+}
+
+// Replication status classes reported by Status
+const (
+	StatusOK        = "ok"
+	StatusAuthError = "auth-error"
+	StatusError     = "error"
+	StatusUnknown   = "unknown"
+)
+
+// Status classifies the last replication session of the agreement
+//   - ok: the session succeeded (status code 0)
+//   - auth-error: the supplier could not bind to the consumer (error 49),
+//     which 389DS reports as "Invalid credentials", often with code -1
+//   - error: any other failure
+//   - unknown: no session has run since the server started
+func (a ReplicationAgreement) Status() string {
+	match := lastUpdateStatusCode.FindStringSubmatch(a.LastUpdateStatus)
+	switch {
+	case match == nil:
+		return StatusUnknown
+	case match[1] == "0":
+		return StatusOK
+	case match[1] == "49" || strings.Contains(a.LastUpdateStatus, "Invalid credentials"):
+		return StatusAuthError
+	default:
+		return StatusError
 	}
 }
 
@@ -211,21 +396,20 @@ func (m *Manager) GeneratePasswordUpdateCommand(server, agreementName, newPasswo
 // It can detect error 49 conditions by examining replication state
 // The status information helps prioritize which agreements need password updates
 // This diagnostic capability is essential for troubleshooting replication problems
+// The status is the one read at discovery, from nsds5replicaLastUpdateStatus
 func (m *Manager) GetReplicationStatus(agreements []ReplicationAgreement) map[string]string {
 	status := make(map[string]string)
 
 	for _, agreement := range agreements {
-		// In a real implementation, this would check:
-		// - Last successful replication timestamp
-		// - Current replication state (enabled/disabled)
-		// - Any error conditions in the replication log
-		// - Consumer connectivity status
-
-		// For this educational example, simulate status checking
-		if strings.Contains(agreement.Name, "consumer1") {
-			status[agreement.Name] = "ERROR: Authentication failure (error 49)"
-		} else {
+		switch agreement.Status() {
+		case StatusOK:
 			status[agreement.Name] = "OK: Replication active"
+		case StatusAuthError:
+			status[agreement.Name] = "ERROR: Authentication failure (error 49): " + agreement.LastUpdateStatus
+		case StatusError:
+			status[agreement.Name] = "ERROR: " + agreement.LastUpdateStatus
+		default:
+			status[agreement.Name] = "UNKNOWN: No replication session since the server started"
 		}
 	}
 
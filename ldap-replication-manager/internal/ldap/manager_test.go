@@ -0,0 +1,123 @@
+package ldap
+
+import (
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/ldap-replication-manager/internal/config"
+	"github.com/ldap-replication-manager/internal/ldap/ldaptest"
+)
+
+const (
+	testHost     = "supplier1.example.com"
+	testBindDN   = "cn=Directory Manager"
+	testPassword = "admin secret"
+)
+
+// newTestManager returns a manager connected to an in-memory directory
+// with one server, testHost; more servers are added with addTestServer
+func newTestManager(t *testing.T) (*Manager, *ldaptest.Directory) {
+	t.Helper()
+	directory := ldaptest.NewDirectory()
+	addTestServer(directory, testHost)
+
+	cfg := &config.Config{}
+	cfg.LDAP.Host = testHost
+	cfg.LDAP.BindDN = testBindDN
+	cfg.LDAP.Password = testPassword
+	cfg.LDAP.BaseDN = "cn=config"
+	manager, err := NewManagerWithDialer(cfg, directory.Dial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager.SetLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	t.Cleanup(manager.Close)
+	return manager, directory
+}
+
+// addTestServer makes a server with cn=config reachable with the test credentials
+func addTestServer(directory *ldaptest.Directory, host string) {
+	directory.AddServer(host, testBindDN, testPassword)
+	directory.AddEntry(host, "cn=config", map[string][]string{"objectClass": {"top"}, "cn": {"config"}})
+}
+
+// addAgreement stores an agreement from supplier to consumer
+func addAgreement(directory *ldaptest.Directory, supplier, name, consumer string) {
+	directory.AddEntry(supplier, "cn="+name+",cn=replica,cn=dc\\=example\\,dc\\=com,cn=mapping tree,cn=config", map[string][]string{
+		"objectClass":         {"top", "nsds5ReplicationAgreement"},
+		"cn":                  {name},
+		"nsds5replicahost":    {consumer},
+		"nsds5replicabinddn":  {"cn=replication manager,cn=config"},
+		"nsds5replicaroot":    {"dc=example,dc=com"},
+		"nsds5replicaenabled": {"on"},
+	})
+}
+
+func TestDiscoverReplicationAgreementsOnEverySupplier(t *testing.T) {
+	manager, directory := newTestManager(t)
+	addTestServer(directory, "supplier2.example.com")
+	manager.config.LDAP.Suppliers = []string{"supplier2.example.com", "SUPPLIER1.example.com"}
+	addAgreement(directory, testHost, "s1-to-c1", "c1.example.com")
+	addAgreement(directory, "supplier2.example.com", "s2-to-c1", "c1.example.com")
+
+	if got := strings.Join(manager.Suppliers(), ","); got != testHost+",supplier2.example.com" {
+		t.Errorf("Suppliers() = %s, want each host once, ldap.host first", got)
+	}
+
+	agreements, err := manager.DiscoverReplicationAgreements()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(agreements) != 2 {
+		t.Fatalf("%d agreements, want 2: %+v", len(agreements), agreements)
+	}
+	for i, want := range []struct{ name, supplier string }{{"s1-to-c1", testHost}, {"s2-to-c1", "supplier2.example.com"}} {
+		got := agreements[i]
+		if got.Name != want.name || got.Supplier != want.supplier || got.Consumer != "c1.example.com" || !got.Enabled || got.Suffix != "dc=example,dc=com" {
+			t.Errorf("agreement %d = %+v, want %s on %s", i, got, want.name, want.supplier)
+		}
+	}
+	if agreements[0].ConsumerEntry() != agreements[1].ConsumerEntry() {
+		t.Error("agreements of both suppliers must share the consumer entry")
+	}
+
+	// The same name on two suppliers cannot be told apart by name
+	addAgreement(directory, "supplier2.example.com", "s1-to-c1", "c2.example.com")
+	if _, err := manager.DiscoverReplicationAgreements(); err == nil || !strings.Contains(err.Error(), "unique across suppliers") {
+		t.Errorf("DiscoverReplicationAgreements() = %v, want a duplicate name error", err)
+	}
+
+	// An unreachable supplier fails the discovery instead of being skipped
+	manager.config.LDAP.Suppliers = []string{"supplier3.example.com"}
+	if _, err := manager.DiscoverReplicationAgreements(); err == nil || !strings.Contains(err.Error(), "supplier3.example.com") {
+		t.Errorf("DiscoverReplicationAgreements() = %v, want an error naming the supplier", err)
+	}
+}
+
+func TestReadReplicaSuppliers(t *testing.T) {
+	manager, directory := newTestManager(t)
+	directory.AddEntry(testHost, "dc=example,dc=com", map[string][]string{"objectClass": {"top", "domain"}})
+	directory.AddEntry(testHost, "nsuniqueid=ffffffff-ffffffff-ffffffff-ffffffff,dc=example,dc=com", map[string][]string{
+		"objectClass": {"top", "nsTombstone", "extensibleObject"},
+		"nsuniqueid":  {"ffffffff-ffffffff-ffffffff-ffffffff"},
+		"nsds50ruv": {
+			"{replicageneration} 6531b7e2000000010000",
+			"{replica 1 ldap://supplier1.example.com:389} 6531b7e3000000010000 6531c0a1000000010000",
+			"{replica 2 ldaps://supplier2.example.com:636}",
+		},
+	})
+
+	suppliers, err := manager.ReadReplicaSuppliers(testHost, "dc=example,dc=com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(suppliers, ","); got != "supplier1.example.com,supplier2.example.com" {
+		t.Errorf("ReadReplicaSuppliers() = %s", got)
+	}
+
+	if _, err := manager.ReadReplicaSuppliers(testHost, "cn=config"); err == nil {
+		t.Error("a suffix without RUV must be an error")
+	}
+}
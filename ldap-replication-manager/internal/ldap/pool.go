@@ -0,0 +1,108 @@
+package ldap
+
+import (
+	"sync"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// connectionPool keeps bound connections to every LDAP server used in a run
+// Concurrent rotations would otherwise open a new connection per change,
+// or share one connection between goroutines and serialize every request
+// The pool limits how many connections are open to one server at a time,
+// so a run with many agreements does not overload a single consumer
+type connectionPool struct {
+	mutex sync.Mutex
+	limit int
+	hosts map[string]*hostConnections
+}
+
+// hostConnections holds the pool state for one server
+// slots is a semaphore with one token per allowed connection
+type hostConnections struct {
+	slots chan struct{}
+	idle  []ldap.Client
+}
+
+// newConnectionPool creates an empty pool allowing limit connections per server
+func newConnectionPool(limit int) *connectionPool {
+	if limit < 1 {
+		limit = 1
+	}
+	return &connectionPool{limit: limit, hosts: make(map[string]*hostConnections)}
+}
+
+// host returns the pool state for a server, creating it on first use
+func (p *connectionPool) host(name string) *hostConnections {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	connections, ok := p.hosts[name]
+	if !ok {
+		connections = &hostConnections{slots: make(chan struct{}, p.limit)}
+		p.hosts[name] = connections
+	}
+	return connections
+}
+
+// addIdle hands an open connection to the pool for later use
+func (p *connectionPool) addIdle(name string, conn ldap.Client) {
+	connections := p.host(name)
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	connections.idle = append(connections.idle, conn)
+}
+
+// closeAll closes every idle connection
+// Connections still in use are closed by their caller on release
+func (p *connectionPool) closeAll() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for _, connections := range p.hosts {
+		for _, conn := range connections.idle {
+			conn.Close()
+		}
+		connections.idle = nil
+	}
+}
+
+// withConnection runs fn with a pooled connection to host
+// It waits while the per-host connection limit is reached, reuses an idle
+// connection when one is available and otherwise dials a new one
+// A connection that failed at the network level is closed instead of reused
+func (m *Manager) withConnection(host string, fn func(conn ldap.Client) error) error {
+	connections := m.pool.host(host)
+	connections.slots <- struct{}{}
+	defer func() { <-connections.slots }()
+
+	m.pool.mutex.Lock()
+	var conn ldap.Client
+	if count := len(connections.idle); count > 0 {
+		conn = connections.idle[count-1]
+		connections.idle = connections.idle[:count-1]
+	}
+	m.pool.mutex.Unlock()
+
+	// The server may have dropped an idle connection in the meantime
+	if conn != nil && conn.IsClosing() {
+		conn.Close()
+		conn = nil
+	}
+	if conn == nil {
+		var err error
+		conn, err = m.dial(host)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := fn(conn)
+	if conn.IsClosing() || ldap.IsErrorWithCode(err, ldap.ErrorNetwork) {
+		conn.Close()
+		return err
+	}
+
+	m.pool.mutex.Lock()
+	connections.idle = append(connections.idle, conn)
+	m.pool.mutex.Unlock()
+	return err
+}
@@ -0,0 +1,157 @@
+package ldap
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"hash"
+	"strconv"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/pbkdf2"
+
+	"github.com/ldap-replication-manager/internal/config"
+)
+
+// checkHash recomputes a hashed value from its salt the way 389DS does when
+// the replication manager binds, and reports whether password matches
+func checkHash(t *testing.T, value, password string) bool {
+	t.Helper()
+	scheme, encoded, ok := strings.Cut(strings.TrimPrefix(value, "{"), "}")
+	if !ok {
+		t.Fatalf("%q has no {SCHEME} prefix", value)
+	}
+
+	salted := func(newHash func() hash.Hash, size int) bool {
+		raw, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(raw) != size+8 {
+			t.Fatalf("%s value %q: %d bytes, %v", scheme, encoded, len(raw), err)
+		}
+		digest := newHash()
+		digest.Write([]byte(password))
+		digest.Write(raw[size:])
+		return bytes.Equal(digest.Sum(nil), raw[:size])
+	}
+	modular := func(newHash func() hash.Hash, size int) bool {
+		parts := strings.Split(encoded, "$")
+		if len(parts) != 3 {
+			t.Fatalf("%s value %q is not iterations$salt$hash", scheme, encoded)
+		}
+		iterations, err := strconv.Atoi(parts[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		decode := func(s string) []byte {
+			data, err := base64.RawStdEncoding.DecodeString(strings.ReplaceAll(s, ".", "+"))
+			if err != nil {
+				t.Fatalf("%s value %q: %v", scheme, s, err)
+			}
+			return data
+		}
+		salt, key := decode(parts[1]), decode(parts[2])
+		return bytes.Equal(pbkdf2.Key([]byte(password), salt, iterations, size, newHash), key)
+	}
+
+	switch scheme {
+	case "SSHA256":
+		return salted(sha256.New, sha256.Size)
+	case "SSHA512":
+		return salted(sha512.New, sha512.Size)
+	case "PBKDF2_SHA256":
+		raw, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(raw) != 4+64+256 {
+			t.Fatalf("%s value: %d bytes, %v", scheme, len(raw), err)
+		}
+		iterations := int(binary.BigEndian.Uint32(raw[:4]))
+		return bytes.Equal(pbkdf2.Key([]byte(password), raw[4:68], iterations, 256, sha256.New), raw[68:])
+	case "PBKDF2-SHA256":
+		return modular(sha256.New, sha256.Size)
+	case "PBKDF2-SHA512":
+		return modular(sha512.New, sha512.Size)
+	}
+	t.Fatalf("unexpected scheme %q", scheme)
+	return false
+}
+
+func TestHashPassword(t *testing.T) {
+	tests := []struct {
+		scheme string
+		prefix string
+	}{
+		{"SSHA256", "{SSHA256}"},
+		{"ssha512", "{SSHA512}"},
+		{"{PBKDF2_SHA256}", "{PBKDF2_SHA256}"},
+		{"PBKDF2-SHA256", "{PBKDF2-SHA256}"},
+		{"PBKDF2-SHA512", "{PBKDF2-SHA512}"},
+	}
+	for _, test := range tests {
+		t.Run(test.scheme, func(t *testing.T) {
+			first, err := HashPassword(test.scheme, "s3cret+Pa$$")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(first, test.prefix) {
+				t.Errorf("HashPassword() = %q, want prefix %s", first, test.prefix)
+			}
+			if !checkHash(t, first, "s3cret+Pa$$") {
+				t.Errorf("%q does not verify against the password", first)
+			}
+			if checkHash(t, first, "s3cret+Pa$") {
+				t.Errorf("%q verifies against a different password", first)
+			}
+
+			second, err := HashPassword(test.scheme, "s3cret+Pa$$")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if first == second {
+				t.Error("two hashes of one password share a salt")
+			}
+		})
+	}
+
+	if _, err := HashPassword("MD5", "x"); err == nil || !strings.Contains(err.Error(), "SSHA256") {
+		t.Errorf("an unsupported scheme must list the supported ones, got %v", err)
+	}
+}
+
+func TestPasswordUpdateCommandShowsAppliedValue(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.LDAP.BindDN = "cn=Directory Manager"
+	cfg.LDAP.Port = 389
+	cfg.LDAP.ConsumerPasswordScheme = "SSHA512"
+	manager := &Manager{config: cfg}
+	agreement := ReplicationAgreement{Name: "to-c1", DN: "cn=to-c1,cn=config", Supplier: "s1", Consumer: "c1"}
+
+	value, err := manager.ConsumerPasswordValue("clear-text")
+	if err != nil {
+		t.Fatal(err)
+	}
+	command := manager.GeneratePasswordUpdateCommand(agreement, value, "consumer")
+	if !strings.Contains(command, "userPassword: "+value+"\n") {
+		t.Errorf("consumer command does not carry the applied hash:\n%s", command)
+	}
+	if strings.Contains(command, "clear-text") {
+		t.Errorf("consumer command contains the clear text:\n%s", command)
+	}
+	if command != manager.GeneratePasswordUpdateCommand(agreement, value, "consumer") {
+		t.Error("the command changes from one call to the next")
+	}
+
+	supplier := manager.GeneratePasswordUpdateCommand(agreement, "clear-text", "supplier")
+	if !strings.Contains(supplier, "nsds5replicacredentials: clear-text\n") || !strings.Contains(supplier, "ldap://s1:389") {
+		t.Errorf("unexpected supplier command:\n%s", supplier)
+	}
+
+	cfg.LDAP.ConsumerPasswordScheme = "CRYPT"
+	if _, err := manager.ConsumerPasswordValue("clear-text"); err == nil {
+		t.Error("an unusable scheme must be an error, not a clear text fallback")
+	}
+	cfg.LDAP.ConsumerPasswordScheme = ""
+	if value, err := manager.ConsumerPasswordValue("clear-text"); err != nil || value != "clear-text" {
+		t.Errorf("without a scheme ConsumerPasswordValue() = %q, %v", value, err)
+	}
+}
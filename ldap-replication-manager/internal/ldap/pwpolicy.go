@@ -0,0 +1,168 @@
+package ldap
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// DefaultReplicationManagerDN is the usual 389DS replication manager entry
+// It is used when an agreement does not name its bind DN
+const DefaultReplicationManagerDN = "cn=replication manager,cn=config"
+
+// passwordPolicyAttributes are the 389DS password syntax attributes we read
+// They exist both in cn=config (global policy) and in fine-grained policy entries
+var passwordPolicyAttributes = []string{
+	"passwordCheckSyntax",
+	"passwordMinLength",
+	"passwordMinDigits",
+	"passwordMinAlphas",
+	"passwordMinUppers",
+	"passwordMinLowers",
+	"passwordMinSpecials",
+	"passwordMin8bit",
+	"passwordMinCategories",
+	"passwordMaxRepeats",
+	"passwordMaxSequence",
+	"passwordPalindrome",
+}
+
+// PasswordPolicy is the password syntax policy a consumer enforces for an entry
+// 389DS only checks these rules when passwordCheckSyntax is on
+// The values come from the global cn=config policy, overridden by any
+// subtree or user policy that the entry's pwdpolicysubentry points to
+// A zero value means the rule is not enforced
+type PasswordPolicy struct {
+	// Where the policy was read from (cn=config and/or a policy entry DN)
+	Sources []string
+
+	// Whether the server checks password syntax at all
+	CheckSyntax bool
+
+	// Minimum counts of characters
+	MinLength     int
+	MinDigits     int
+	MinAlphas     int
+	MinUppers     int
+	MinLowers     int
+	MinSpecials   int
+	Min8Bit       int
+	MinCategories int
+
+	// Maximum number of repeated characters in a row
+	MaxRepeats int
+
+	// Maximum length of a monotonic sequence such as "abcd" or "1234"
+	MaxSequence int
+
+	// Whether palindromes are rejected
+	Palindrome bool
+}
+
+// ReadPasswordPolicy reads the effective password policy for an entry on a server
+// It is used to learn the rules the consumer applies to the replication
+// manager's userPassword before we try to change it
+// The global policy is read from cn=config; if local policies are enabled
+// (nsslapd-pwpolicy-local) the entry's pwdpolicysubentry is followed as well
+// Only the attributes present in the fine-grained entry replace global values
+func (m *Manager) ReadPasswordPolicy(host, entryDN string) (*PasswordPolicy, error) {
+	if entryDN == "" {
+		entryDN = DefaultReplicationManagerDN
+	}
+
+	conn, err := m.dial(host)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	policy := &PasswordPolicy{}
+
+	// Global policy from cn=config
+	global, err := readEntry(conn, "cn=config", append([]string{"nsslapd-pwpolicy-local"}, passwordPolicyAttributes...))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read global password policy on %s: %v", host, err)
+	}
+	applyPolicyAttributes(policy, global)
+	policy.Sources = append(policy.Sources, "cn=config")
+
+	// Fine-grained policy only applies when local policies are switched on
+	if !strings.EqualFold(global.GetAttributeValue("nsslapd-pwpolicy-local"), "on") {
+		return policy, nil
+	}
+
+	// pwdpolicysubentry is an operational attribute computed by 389DS
+	// It points at the subtree or user policy that governs the entry
+	entry, err := readEntry(conn, entryDN, []string{"pwdpolicysubentry"})
+	if err != nil {
+		// The entry may not exist yet; the global policy still applies
+		m.logger.Info("Could not read entry for password policy, using the global policy", "entry", entryDN, "host", host, "error", err)
+		return policy, nil
+	}
+	subentryDN := entry.GetAttributeValue("pwdpolicysubentry")
+	if subentryDN == "" {
+		return policy, nil
+	}
+
+	local, err := readEntry(conn, subentryDN, passwordPolicyAttributes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read password policy %s on %s: %v", subentryDN, host, err)
+	}
+	applyPolicyAttributes(policy, local)
+	policy.Sources = append(policy.Sources, subentryDN)
+
+	return policy, nil
+}
+
+// readEntry reads selected attributes of a single entry with a base search
+func readEntry(conn ldap.Client, dn string, attributes []string) (*ldap.Entry, error) {
+	searchRequest := ldap.NewSearchRequest(
+		dn,
+		ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false,
+		"(objectClass=*)",
+		attributes,
+		nil,
+	)
+
+	sr, err := conn.Search(searchRequest)
+	if err != nil {
+		return nil, err
+	}
+	if len(sr.Entries) == 0 {
+		return nil, fmt.Errorf("entry %s not found", dn)
+	}
+	return sr.Entries[0], nil
+}
+
+// applyPolicyAttributes copies the policy attributes present on an entry
+// Attributes missing from the entry leave the current value unchanged,
+// which is how a fine-grained policy inherits from the global one
+func applyPolicyAttributes(policy *PasswordPolicy, entry *ldap.Entry) {
+	setBool := func(attribute string, target *bool) {
+		if value := entry.GetAttributeValue(attribute); value != "" {
+			*target = strings.EqualFold(value, "on")
+		}
+	}
+	setInt := func(attribute string, target *int) {
+		if value := entry.GetAttributeValue(attribute); value != "" {
+			if number, err := strconv.Atoi(value); err == nil {
+				*target = number
+			}
+		}
+	}
+
+	setBool("passwordCheckSyntax", &policy.CheckSyntax)
+	setInt("passwordMinLength", &policy.MinLength)
+	setInt("passwordMinDigits", &policy.MinDigits)
+	setInt("passwordMinAlphas", &policy.MinAlphas)
+	setInt("passwordMinUppers", &policy.MinUppers)
+	setInt("passwordMinLowers", &policy.MinLowers)
+	setInt("passwordMinSpecials", &policy.MinSpecials)
+	setInt("passwordMin8bit", &policy.Min8Bit)
+	setInt("passwordMinCategories", &policy.MinCategories)
+	setInt("passwordMaxRepeats", &policy.MaxRepeats)
+	setInt("passwordMaxSequence", &policy.MaxSequence)
+	setBool("passwordPalindrome", &policy.Palindrome)
+}
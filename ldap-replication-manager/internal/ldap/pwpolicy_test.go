@@ -0,0 +1,94 @@
+package ldap
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ldap-replication-manager/internal/ldap/ldaptest"
+)
+
+const testPolicyDN = "cn=cn\\=nsPwPolicyEntry_user\\,cn\\=replication manager\\,cn\\=config,cn=nsPwPolicyContainer,cn=config"
+
+// setGlobalPolicy stores the cn=config password policy of testHost
+func setGlobalPolicy(directory *ldaptest.Directory, local string) {
+	directory.AddEntry(testHost, "cn=config", map[string][]string{
+		"objectClass":            {"top"},
+		"cn":                     {"config"},
+		"nsslapd-pwpolicy-local": {local},
+		"passwordCheckSyntax":    {"on"},
+		"passwordMinLength":      {"12"},
+		"passwordMinDigits":      {"1"},
+		"passwordMinCategories":  {"3"},
+		"passwordMaxRepeats":     {"3"},
+		"passwordPalindrome":     {"on"},
+	})
+}
+
+func TestReadPasswordPolicy(t *testing.T) {
+	global := PasswordPolicy{
+		Sources:       []string{"cn=config"},
+		CheckSyntax:   true,
+		MinLength:     12,
+		MinDigits:     1,
+		MinCategories: 3,
+		MaxRepeats:    3,
+		Palindrome:    true,
+	}
+	merged := global
+	merged.Sources = []string{"cn=config", testPolicyDN}
+	merged.MinLength = 20     // raised by the user policy
+	merged.MinCategories = 2  // lowered by the user policy
+	merged.MinSpecials = 2    // only set by the user policy
+	merged.Palindrome = false // switched off by the user policy
+
+	tests := []struct {
+		name      string
+		local     string
+		subentry  string // pwdpolicysubentry of the replication manager, "" for none
+		noAccount bool
+		want      *PasswordPolicy
+		wantErr   string
+	}{
+		{"global only", "off", testPolicyDN, false, &global, ""},
+		{"user policy overrides global", "on", testPolicyDN, false, &merged, ""},
+		{"no policy for the account", "on", "", false, &global, ""},
+		{"account not created yet", "on", "", true, &global, ""},
+		{"policy entry missing", "on", "cn=missing,cn=config", false, nil, "failed to read password policy cn=missing,cn=config"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			manager, directory := newTestManager(t)
+			setGlobalPolicy(directory, test.local)
+			directory.AddEntry(testHost, testPolicyDN, map[string][]string{
+				"objectClass":           {"top", "passwordPolicy"},
+				"passwordMinLength":     {"20"},
+				"passwordMinCategories": {"2"},
+				"passwordMinSpecials":   {"2"},
+				"passwordPalindrome":    {"off"},
+			})
+			if !test.noAccount {
+				account := map[string][]string{"objectClass": {"top", "person"}}
+				if test.subentry != "" {
+					account["pwdpolicysubentry"] = []string{test.subentry}
+				}
+				directory.AddEntry(testHost, DefaultReplicationManagerDN, account)
+			}
+
+			policy, err := manager.ReadPasswordPolicy(testHost, "")
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Errorf("ReadPasswordPolicy() = %+v, %v, want %q", policy, err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(policy, test.want) {
+				t.Errorf("policy\n%+v\nwant\n%+v", policy, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/ldap-replication-manager/internal/config"
+)
+
+// New builds the application logger from the logging section of the config
+//   - level: debug, info, warn or error; --verbose always means debug
+//   - format: "text" (key=value pairs) or "json" (one object per line)
+//   - file: append to this file instead of stderr; the file is reopened on
+//     SIGHUP so logrotate can move it away
+//   - timestamps: leave the time out when false, e.g. under journald which
+//     adds its own
+//
+// The logger also becomes the default slog logger
+// The standard log package keeps writing to stderr: it is used for fatal
+// errors, which must reach the operator whatever the level and file settings
+// Correlation fields are added by the callers with With, for example
+// logger.With("run_id", runID), and then appear on every line
+func New(cfg config.LoggingConfig, verbose bool) (*slog.Logger, error) {
+	level, err := ParseLevel(cfg.Level)
+	if err != nil {
+		return nil, err
+	}
+	if verbose {
+		level = slog.LevelDebug
+	}
+
+	var out io.Writer = os.Stderr
+	if cfg.File != "" {
+		file, err := openFile(cfg.File)
+		if err != nil {
+			return nil, err
+		}
+		file.reopenOnSIGHUP()
+		out = file
+	}
+
+	options := &slog.HandlerOptions{Level: level, AddSource: verbose}
+	if !cfg.Timestamps {
+		options.ReplaceAttr = func(groups []string, attr slog.Attr) slog.Attr {
+			if len(groups) == 0 && attr.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return attr
+		}
+	}
+
+	var handler slog.Handler
+	if cfg.Format == "json" {
+		handler = slog.NewJSONHandler(out, options)
+	} else {
+		handler = slog.NewTextHandler(out, options)
+	}
+
+	logger := slog.New(handler)
+	slog.SetDefault(logger)
+	log.SetOutput(os.Stderr) // SetDefault redirected it into the handler
+	if verbose {
+		log.SetFlags(log.LstdFlags | log.Lshortfile)
+	}
+	return logger, nil
+}
+
+// ParseLevel converts a configured level name to a slog level
+// An empty name means info
+func ParseLevel(name string) (slog.Level, error) {
+	switch strings.ToLower(name) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return slog.LevelInfo, fmt.Errorf("unknown log level %q (available: debug, info, warn, error)", name)
+}
+
+// reopenableFile is a log file that can be closed and opened again by path
+// logrotate renames the file and sends SIGHUP; writes then continue in a
+// new file under the original name
+type reopenableFile struct {
+	path  string
+	mutex sync.Mutex
+	file  *os.File
+}
+
+// openFile opens a log file for appending, creating it if needed
+func openFile(path string) (*reopenableFile, error) {
+	f := &reopenableFile{path: path}
+	if err := f.reopen(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Write appends to the current file
+func (f *reopenableFile) Write(p []byte) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.file.Write(p)
+}
+
+// reopen switches to a freshly opened file at the same path
+// If the path cannot be opened the old file is kept, so no line is lost
+func (f *reopenableFile) reopen() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return fmt.Errorf("failed to open log file %s: %v", f.path, err)
+	}
+	f.mutex.Lock()
+	old := f.file
+	f.file = file
+	f.mutex.Unlock()
+	if old != nil {
+		old.Close()
+	}
+	return nil
+}
+
+// reopenOnSIGHUP reopens the file every time the process receives SIGHUP
+func (f *reopenableFile) reopenOnSIGHUP() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		for range signals {
+			if err := f.reopen(); err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+			}
+		}
+	}()
+}
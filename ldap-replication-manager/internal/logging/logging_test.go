@@ -0,0 +1,158 @@
+package logging
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/ldap-replication-manager/internal/config"
+)
+
+// newTestLogger builds a logger writing to a file in a temporary directory
+// and restores the default slog logger afterwards
+func newTestLogger(t *testing.T, cfg config.LoggingConfig, verbose bool) (*slog.Logger, string) {
+	t.Helper()
+	previous := slog.Default()
+	t.Cleanup(func() {
+		slog.SetDefault(previous)
+	})
+	cfg.File = filepath.Join(t.TempDir(), "rotation.log")
+	logger, err := New(cfg, verbose)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return logger, cfg.File
+}
+
+func readLog(t *testing.T, path string) string {
+	t.Helper()
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		name string
+		want slog.Level
+	}{
+		{"", slog.LevelInfo},
+		{"debug", slog.LevelDebug},
+		{"info", slog.LevelInfo},
+		{"INFO", slog.LevelInfo},
+		{"warn", slog.LevelWarn},
+		{"warning", slog.LevelWarn},
+		{"error", slog.LevelError},
+	}
+	for _, test := range tests {
+		got, err := ParseLevel(test.name)
+		if err != nil || got != test.want {
+			t.Errorf("ParseLevel(%q) = %v, %v, want %v", test.name, got, err, test.want)
+		}
+	}
+	if _, err := ParseLevel("trace"); err == nil || !strings.Contains(err.Error(), "unknown log level") {
+		t.Errorf("ParseLevel(\"trace\") = %v", err)
+	}
+	if _, err := New(config.LoggingConfig{Level: "trace"}, false); err == nil {
+		t.Error("New() accepted an unknown level")
+	}
+}
+
+func TestLevelSelection(t *testing.T) {
+	tests := []struct {
+		level   string
+		verbose bool
+		want    []string
+	}{
+		{"", false, []string{"info", "warn", "error"}},
+		{"debug", false, []string{"debug", "info", "warn", "error"}},
+		{"warn", false, []string{"warn", "error"}},
+		{"error", false, []string{"error"}},
+		{"error", true, []string{"debug", "info", "warn", "error"}},
+	}
+	for _, test := range tests {
+		logger, path := newTestLogger(t, config.LoggingConfig{Level: test.level}, test.verbose)
+		logger.Debug("debug")
+		logger.Info("info")
+		logger.Warn("warn")
+		logger.Error("error")
+
+		var got []string
+		for _, line := range strings.Split(strings.TrimSpace(readLog(t, path)), "\n") {
+			for _, message := range []string{"debug", "info", "warn", "error"} {
+				if strings.Contains(line, "msg="+message) {
+					got = append(got, message)
+				}
+			}
+		}
+		if strings.Join(got, ",") != strings.Join(test.want, ",") {
+			t.Errorf("level %q verbose %v logged %q, want %q", test.level, test.verbose, got, test.want)
+		}
+	}
+}
+
+func TestFormatSelection(t *testing.T) {
+	logger, path := newTestLogger(t, config.LoggingConfig{Format: "json", Timestamps: true}, false)
+	logger.With("run_id", "20261018T135442-3fa2c1").Info("Rotated", "agreement", "to-dc1-a")
+	var line map[string]interface{}
+	if err := json.Unmarshal([]byte(readLog(t, path)), &line); err != nil {
+		t.Fatalf("json format wrote an invalid line: %v", err)
+	}
+	for key, want := range map[string]string{"level": "INFO", "msg": "Rotated", "run_id": "20261018T135442-3fa2c1", "agreement": "to-dc1-a"} {
+		if line[key] != want {
+			t.Errorf("%s = %v, want %q", key, line[key], want)
+		}
+	}
+	if _, ok := line["time"]; !ok {
+		t.Error("time missing with timestamps enabled")
+	}
+
+	logger, path = newTestLogger(t, config.LoggingConfig{Format: "text"}, false)
+	logger.Info("Rotated", "agreement", "to-dc1-a")
+	if got, want := readLog(t, path), "level=INFO msg=Rotated agreement=to-dc1-a\n"; got != want {
+		t.Errorf("text format wrote %q, want %q", got, want)
+	}
+}
+
+func TestReopenOnSIGHUP(t *testing.T) {
+	logger, path := newTestLogger(t, config.LoggingConfig{}, false)
+	logger.Info("before rotation")
+
+	// What logrotate does: rename the file, then signal the process
+	rotated := path + ".1"
+	if err := os.Rename(path, rotated); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+
+	// The file is reopened asynchronously; lines go to the rotated file
+	// until the first one reaches the new file
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		logger.Info("probe")
+		if content, err := os.ReadFile(path); err == nil && len(content) > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("log file not reopened after SIGHUP")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	logger.Info("after rotation")
+
+	if old := readLog(t, rotated); !strings.Contains(old, "before rotation") || strings.Contains(old, "after rotation") {
+		t.Errorf("rotated file holds %q", old)
+	}
+	if current := readLog(t, path); !strings.Contains(current, "after rotation") || strings.Contains(current, "before rotation") {
+		t.Errorf("new file holds %q", current)
+	}
+}
@@ -0,0 +1,71 @@
+package monitor
+
+import (
+	"regexp"
+	"sync"
+)
+
+// Lines of a 389DS access log for one bind operation:
+//
+//	[18/Oct/2026:13:54:42.318815241 -0500] conn=1843 op=0 BIND dn="cn=replication manager,cn=config" method=128 version=3
+//	[18/Oct/2026:13:54:42.319102455 -0500] conn=1843 op=0 RESULT err=49 tag=97 nentries=0 wtime=0.000098 optime=0.000287 etime=0.000383 - Invalid credentials
+//
+// Tag 97 is the LDAP bind response; the RESULT line does not repeat the DN
+var (
+	accessBind       = regexp.MustCompile(`conn=(\d+) op=(-?\d+) BIND dn="([^"]*)"`)
+	accessBindResult = regexp.MustCompile(`conn=(\d+) op=(-?\d+) RESULT err=(\d+) tag=97\b`)
+	accessResultDN   = regexp.MustCompile(` dn="([^"]*)"`)
+)
+
+// bindTrackerSize bounds the binds waiting for their result; a connection
+// dropped mid-bind never logs one
+const bindTrackerSize = 10000
+
+// bindTracker remembers the DN of each BIND in an access log until its
+// RESULT line, so an error 49 on the consumer can name the account the
+// supplier tried
+// One tracker serves one log source; operations are keyed by host and
+// instance as well, since a syslog source receives many servers' logs
+// The TCP syslog receiver reads each connection in its own goroutine, so
+// track is called concurrently
+type bindTracker struct {
+	mutex sync.Mutex
+	binds map[string]string
+}
+
+func newBindTracker() *bindTracker {
+	return &bindTracker{binds: make(map[string]string)}
+}
+
+// track looks at one log line
+// A BIND line is remembered; for the RESULT line of a bind it returns the
+// DN of that bind and forgets it; any other line returns ""
+func (b *bindTracker) track(line LogLine) string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if match := accessBind.FindStringSubmatch(line.Text); match != nil {
+		if len(b.binds) >= bindTrackerSize {
+			clear(b.binds)
+		}
+		b.binds[bindKey(line, match[1], match[2])] = match[3]
+		return ""
+	}
+	match := accessBindResult.FindStringSubmatch(line.Text)
+	if match == nil {
+		return ""
+	}
+	key := bindKey(line, match[1], match[2])
+	dn := b.binds[key]
+	delete(b.binds, key)
+	if dn == "" {
+		if logged := accessResultDN.FindStringSubmatch(line.Text); logged != nil {
+			dn = logged[1]
+		}
+	}
+	return dn
+}
+
+// bindKey identifies one operation of one connection on one instance
+func bindKey(line LogLine, conn, op string) string {
+	return line.Host + "\x00" + line.Instance + "\x00" + conn + "\x00" + op
+}
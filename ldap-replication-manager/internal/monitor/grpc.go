@@ -3,12 +3,15 @@ package monitor
 import (
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
+	"os"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/ldap-replication-manager/internal/config"
+	"github.com/ldap-replication-manager/internal/events"
 )
 
 // GRPCMonitor handles real-time monitoring of LDAP error logs
@@ -23,6 +26,39 @@ type GRPCMonitor struct {
 	running bool
 	ctx     context.Context
 	cancel  context.CancelFunc
+
+	// Structured logger; every line names the log file or agreement concerned
+	logger *slog.Logger
+
+	// Receives an event when an incident becomes active or is resolved;
+	// nil sends none
+	events *events.Bus
+
+	// Groups error 49 lines into incidents
+	incidents *IncidentTracker
+
+	// Name of this host, for lines read from local sources
+	hostname string
+
+	// Log sources being read, by name
+	mutex             sync.Mutex
+	watchers          map[string]watcher
+	warnedNoInstances bool
+
+	// Most recent error events, oldest first, and how many were seen
+	history  []ErrorEvent
+	detected int
+	started  time.Time
+
+	// Reports whether an event concerns the selection being watched
+	// nil watches every agreement
+	selected func(event ErrorEvent) bool
+}
+
+// watcher is the goroutine reading one log source
+type watcher struct {
+	source LogSource
+	stop   context.CancelFunc
 }
 
 // ErrorEvent represents a detected error 49 event
@@ -31,17 +67,38 @@ type GRPCMonitor struct {
 // The timestamp and details enable quick troubleshooting
 // This data structure makes error information easy to process and display
 type ErrorEvent struct {
-	// Timestamp when the error was detected
+	// Timestamp the server wrote in the line, or when it was received if
+	// the line has none; only shown, since the clock of a remote server or
+	// a replayed backlog can be far from the monitor's
 	Timestamp time.Time
 
+	// When the monitor read the line; incident windows are measured with it
+	Received time.Time
+
 	// Name of the replication agreement that failed
 	AgreementName string
 
+	// Consumer the agreement failed to bind to, when the line names it
+	// For a line of a consumer's access log, the server that wrote it
+	Consumer string
+
+	// Entry the supplier tried to bind as; only known from a consumer's
+	// access log, which does not name the agreement
+	BindDN string
+
+	// 389DS instance whose log contained the line
+	Instance string
+
+	// Server that wrote the line: this host, or the sender of a syslog
+	// message
+	Hostname string
+
 	// Full log line that contained the error
 	LogLine string
 
-	// Path to the log file where error was found
-	LogFile string
+	// Source the line was read from: the path of a log file,
+	// journald:dirsrv@<instance> or syslog:<network>/<address>
+	Source string
 
 	// Severity level of the error
 	Severity string
@@ -56,124 +113,279 @@ func NewGRPCMonitor(cfg *config.Config) *GRPCMonitor {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	return &GRPCMonitor{
-		config: cfg,
-		ctx:    ctx,
-		cancel: cancel,
+		config:    cfg,
+		ctx:       ctx,
+		cancel:    cancel,
+		logger:    slog.Default().With("component", "monitor"),
+		incidents: NewIncidentTracker(cfg.GRPC.Incidents),
+		watchers:  make(map[string]watcher),
+		hostname:  localHostname(),
+		started:   time.Now(),
 	}
 }
 
+// SetLogger replaces the logger of the monitor
+// Pass a logger carrying the correlation fields of the run, such as run_id
+func (m *GRPCMonitor) SetLogger(logger *slog.Logger) {
+	m.logger = logger.With("component", "monitor")
+}
+
 // StartGRPCMonitor begins monitoring LDAP log files for error 49 events
 // This function runs continuously in the background
 // It watches multiple log files simultaneously for authentication failures
 // The monitor uses efficient file watching to minimize system impact
 // Real-time detection enables immediate response to replication problems
-func StartGRPCMonitor(cfg *config.Config) {
+// Incident changes are published on eventBus, which may be nil
+// Events for which selected returns false are ignored; pass nil to act on
+// every agreement
+func StartGRPCMonitor(cfg *config.Config, logger *slog.Logger, eventBus *events.Bus, selected func(event ErrorEvent) bool) {
 	monitor := NewGRPCMonitor(cfg)
-
-	log.Println("Starting GRPC monitor for error 49 detection...")
-	log.Printf("Monitoring %d log files", len(cfg.GRPC.LogPaths))
-
-	// Start monitoring each configured log file
-	// This allows comprehensive coverage of all LDAP server logs
-	for _, logPath := range cfg.GRPC.LogPaths {
-		go monitor.watchLogFile(logPath)
-		log.Printf("  Watching: %s", logPath)
+	monitor.SetLogger(logger)
+	monitor.events = eventBus
+	monitor.selected = selected
+
+	monitor.logger.Info("Starting GRPC monitor for error 49 detection")
+
+	// Watch the configured log files, or every local 389DS instance, the
+	// journal of the instances that log there, and listen for syslog
+	// Instances are looked for again regularly, so one added or removed
+	// while the monitor runs is picked up or dropped
+	monitor.rescan()
+	if len(cfg.GRPC.LogPaths) == 0 {
+		go monitor.rescanInstances()
 	}
 
 	// Start GRPC server for real-time notifications
 	// This enables other systems to receive immediate error notifications
 	go monitor.startGRPCServer()
 
+	// Resolve incidents whose errors have stopped
+	go monitor.sweepIncidents()
+
 	// Keep the monitor running
 	// This ensures continuous monitoring until the application exits
 	<-monitor.ctx.Done()
-	log.Println("GRPC monitor stopped")
+	monitor.logger.Info("GRPC monitor stopped")
 }
 
-// watchLogFile monitors a single log file for error 49 events
-// This method uses efficient file watching to detect new log entries
-// It parses each line to identify authentication failure patterns
-// The watcher handles log rotation and file recreation automatically
-// Understanding this helps administrators see how errors are detected
-func (m *GRPCMonitor) watchLogFile(logPath string) {
-	log.Printf("Starting log watcher for: %s", logPath)
-
-	// Regular expression to match error 49 patterns
-	// This pattern matches the standard 389DS error 49 log format
-	// The regex captures the replication agreement name for identification
-	error49Pattern := regexp.MustCompile(`err=49.*agreement[:\s]+([^\s,]+)`)
-
-	// In a real implementation, this would use file system notifications
-	// For this educational example, we'll simulate log monitoring
-	ticker := time.NewTicker(time.Duration(m.config.GRPC.CheckInterval) * time.Second)
+// rescanInstances discovers instances every rescan interval
+func (m *GRPCMonitor) rescanInstances() {
+	ticker := time.NewTicker(time.Duration(m.config.GRPC.RescanInterval) * time.Second)
 	defer ticker.Stop()
-
-	var lastPosition int64 = 0
-
 	for {
 		select {
 		case <-m.ctx.Done():
 			return
 		case <-ticker.C:
-			// Check for new log entries
-			// This simulates reading new lines from the log file
-			if err := m.checkLogFile(logPath, &lastPosition, error49Pattern); err != nil {
-				log.Printf("Error checking log file %s: %v", logPath, err)
-			}
+			m.rescan()
 		}
 	}
 }
 
-// checkLogFile reads new entries from a log file and processes them
-// This method handles file reading and error pattern matching
-// It maintains position tracking to avoid re-processing old entries
-// The function processes only new log entries for efficiency
-// Error detection triggers immediate notification and response
-func (m *GRPCMonitor) checkLogFile(logPath string, lastPosition *int64, pattern *regexp.Regexp) error {
-	// In a real implementation, this would read from the actual log file
-	// For this educational example, we'll simulate finding error 49 events
-
-	// Simulate finding an error 49 event occasionally
-	// This demonstrates how the monitoring system would work
-	if time.Now().Unix()%30 == 0 { // Every 30 seconds for demo
-		event := ErrorEvent{
-			Timestamp:     time.Now(),
-			AgreementName: "agreement-to-consumer1",
-			LogLine:       "[01/Sep/2025:13:54:42 -0500] conn=123 op=456 RESULT err=49 tag=97 nentries=0 etime=0 - Invalid credentials for replication agreement: agreement-to-consumer1",
-			LogFile:       logPath,
-			Severity:      "ERROR",
+// rescan works out which sources to read and starts or stops watchers
+// so that exactly those sources are read
+func (m *GRPCMonitor) rescan() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	wanted := make(map[string]LogSource)
+	for _, source := range m.sources() {
+		wanted[source.Name()] = source
+	}
+	for name, watcher := range m.watchers {
+		if source, ok := wanted[name]; !ok || source != watcher.source {
+			watcher.stop()
+			delete(m.watchers, name)
+			m.logger.Info("Stopped watching log source", "source", name)
+		}
+	}
+	for name, source := range wanted {
+		if _, ok := m.watchers[name]; ok {
+			continue
 		}
+		ctx, cancel := context.WithCancel(m.ctx)
+		m.watchers[name] = watcher{source: source, stop: cancel}
+		go m.watchSource(ctx, source)
+		m.logger.Info("Watching log source", "source", name)
+	}
+}
 
-		// Process the detected error event
-		// This triggers the response workflow
-		m.handleErrorEvent(event)
+// sources lists the sources to read: the configured log files or those of
+// the discovered instances, the journal of the journald instances and the
+// syslog receiver
+// Sources are comparable values, so an unchanged source equals the one
+// already being read
+func (m *GRPCMonitor) sources() []LogSource {
+	interval := time.Duration(m.config.GRPC.CheckInterval) * time.Second
+	journald := m.config.GRPC.Journald
+	receiver := m.config.GRPC.Syslog
+
+	var files []LogFile
+	if len(m.config.GRPC.LogPaths) > 0 {
+		files = ConfiguredLogFiles(m.config.GRPC.LogPaths)
+	} else {
+		instances, errs := DiscoverInstances(m.config.GRPC.InstanceDir)
+		for _, err := range errs {
+			m.logger.Warn("Could not read instance configuration", "error", err)
+		}
+		if len(instances) == 0 && !journald.Enabled && !receiver.Enabled && !m.warnedNoInstances {
+			m.logger.Warn("No 389DS instance found, will look again", "dir", m.config.GRPC.InstanceDir)
+		}
+		m.warnedNoInstances = len(instances) == 0
+		files = LogFiles(instances)
 	}
 
-	return nil
+	var sources []LogSource
+	for _, file := range files {
+		sources = append(sources, fileSource{file: file, interval: interval})
+	}
+	if journald.Enabled {
+		for _, instance := range journald.Instances {
+			sources = append(sources, journaldSource{
+				instance:   instance,
+				journalctl: journald.Journalctl,
+				cursorDir:  journald.CursorDir,
+				interval:   interval,
+			})
+		}
+	}
+	if receiver.Enabled {
+		if receiver.UDP != "" {
+			sources = append(sources, syslogSource{network: "udp", address: receiver.UDP, interval: interval})
+		}
+		if receiver.TCP != "" {
+			sources = append(sources, syslogSource{network: "tcp", address: receiver.TCP, interval: interval})
+		}
+	}
+	return sources
+}
+
+// watchSource reads a source until ctx ends
+// Each line is parsed the same way whatever the source, and error 49
+// lines are tagged with the source and the server and instance that
+// wrote them
+// A failed bind in a consumer's access log is reported against that server
+// with the DN of its BIND line, which only appears a line earlier
+func (m *GRPCMonitor) watchSource(ctx context.Context, source LogSource) {
+	binds := newBindTracker()
+	source.Run(ctx, m.logger, func(line LogLine) {
+		bindDN := binds.track(line)
+		event, err := ParseLogLine(line.Text)
+		if err != nil {
+			return
+		}
+		event.Source = source.Name()
+		event.Instance = line.Instance
+		event.Hostname = line.Host
+		if event.Hostname == "" {
+			event.Hostname = m.hostname
+		}
+		if event.AgreementName == "" {
+			event.Consumer = event.Hostname
+			if bindDN != "" {
+				event.BindDN = bindDN
+			}
+		}
+		m.handleErrorEvent(*event)
+	})
 }
 
 // handleErrorEvent processes a detected error 49 event
-// This method coordinates the response to authentication failures
-// It can trigger automatic password updates or send notifications
-// The handler ensures that errors are addressed promptly
-// Understanding this helps administrators see how problems are resolved
+// A single line only counts towards the incident of its agreement and
+// consumer; the response to an authentication failure is triggered by
+// the incident, see handleTransition
 func (m *GRPCMonitor) handleErrorEvent(event ErrorEvent) {
-	log.Printf("DETECTED ERROR 49: Agreement '%s' authentication failure", event.AgreementName)
-	log.Printf("  Timestamp: %s", event.Timestamp.Format("2006-01-02 15:04:05"))
-	log.Printf("  Log file: %s", event.LogFile)
-	log.Printf("  Details: %s", event.LogLine)
-
-	// In a real implementation, this could:
-	// - Send GRPC notifications to connected clients
-	// - Trigger automatic password rotation
-	// - Update monitoring dashboards
-	// - Send email/SMS alerts to administrators
-	// - Log the event to a central monitoring system
-
-	// For this educational example, we'll show what actions would be taken
-	log.Printf("  ACTION: Would trigger password update for agreement '%s'", event.AgreementName)
-	log.Printf("  ACTION: Would notify administrators of authentication failure")
-	log.Printf("  ACTION: Would update monitoring dashboard with error status")
+	if m.selected != nil && !m.selected(event) {
+		m.logger.Debug("Ignoring error 49: agreement not in the selection", "agreement", event.AgreementName, "bind_dn", event.BindDN)
+		return
+	}
+
+	m.logger.Debug("Error 49 detected", "agreement", event.AgreementName, "consumer", event.Consumer, "bind_dn", event.BindDN, "hostname", event.Hostname, "instance", event.Instance,
+		"source", event.Source, "event_time", event.Timestamp.Format("2006-01-02 15:04:05"), "line", event.LogLine)
+	m.remember(event)
+	if transition := m.incidents.Observe(event); transition != nil {
+		m.handleTransition(*transition)
+	}
+}
+
+// sweepIncidents resolves quiet incidents until the monitor stops
+func (m *GRPCMonitor) sweepIncidents() {
+	ticker := time.NewTicker(time.Duration(m.config.GRPC.CheckInterval) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case now := <-ticker.C:
+			for _, transition := range m.incidents.Sweep(now) {
+				m.handleTransition(transition)
+			}
+		}
+	}
+}
+
+// handleTransition coordinates the response to an incident changing state
+// An incident that becomes active is a sustained authentication failure:
+// administrators are notified and a password update could be triggered
+// A resolved incident is announced so alerts can be closed; blips are only
+// logged
+func (m *GRPCMonitor) handleTransition(transition Transition) {
+	incident := transition.Incident
+	logger := m.logger.With("incident", incident.ID, "agreement", incident.Agreement, "consumer", incident.Consumer, "bind_dn", incident.BindDN,
+		"hostname", incident.Hostname, "instance", incident.Instance, "count", incident.Count, "rate_per_minute", fmt.Sprintf("%.1f", incident.Rate()))
+	fields := map[string]string{
+		"incident":        incident.ID,
+		"hostname":        incident.Hostname,
+		"instance":        incident.Instance,
+		"count":           fmt.Sprint(incident.Count),
+		"rate_per_minute": fmt.Sprintf("%.1f", incident.Rate()),
+		"first_seen":      incident.FirstSeen.UTC().Format(time.RFC3339),
+		"last_seen":       incident.LastSeen.UTC().Format(time.RFC3339),
+	}
+	if incident.BindDN != "" {
+		fields["bind_dn"] = incident.BindDN
+	}
+
+	switch incident.State {
+	case IncidentActive:
+		logger.Error("INCIDENT: sustained replication authentication failure (error 49)", "first_seen", incident.FirstSeen)
+		m.events.Publish(events.Event{
+			Type:      events.TypeIncidentOpened,
+			Severity:  events.SeverityError,
+			Message:   fmt.Sprintf("%s keeps failing to authenticate (error 49): %d times since %s", incident.Subject(), incident.Count, incident.FirstSeen.Format("15:04:05")),
+			Agreement: incident.Agreement,
+			Host:      incident.Consumer,
+			Fields:    fields,
+		})
+
+		// In a real implementation, this could trigger automatic password
+		// rotation of the agreement and update monitoring dashboards
+		// A consumer's access log does not say which agreement failed, so
+		// there is nothing to rotate; the suppliers have to be checked
+		if incident.Agreement != "" {
+			logger.Info("ACTION: Would trigger password update")
+		} else {
+			logger.Info("ACTION: Check the supplier agreements that bind to this consumer", "bind_dn", incident.BindDN)
+		}
+		logger.Info("ACTION: Administrators notified through the configured event sinks")
+	case IncidentResolved:
+		logger.Info("INCIDENT RESOLVED: no error 49 since last seen", "last_seen", incident.LastSeen,
+			"duration", incident.LastSeen.Sub(incident.FirstSeen).Round(time.Second))
+		m.events.Publish(events.Event{
+			Type:      events.TypeIncidentResolved,
+			Severity:  events.SeverityInfo,
+			Message:   fmt.Sprintf("%s authenticates again: no error 49 since %s", incident.Subject(), incident.LastSeen.Format("15:04:05")),
+			Agreement: incident.Agreement,
+			Host:      incident.Consumer,
+			Fields:    fields,
+		})
+	case IncidentBlip:
+		logger.Info("Isolated error 49 did not repeat, no action taken")
+	}
+}
+
+// Incidents returns the incidents currently open
+func (m *GRPCMonitor) Incidents() []Incident {
+	return m.incidents.Incidents()
 }
 
 // startGRPCServer initializes the GRPC server for real-time notifications
@@ -182,7 +394,7 @@ func (m *GRPCMonitor) handleErrorEvent(event ErrorEvent) {
 // The GRPC protocol ensures efficient, reliable communication
 // This component enables integration with monitoring and alerting systems
 func (m *GRPCMonitor) startGRPCServer() {
-	log.Printf("Starting GRPC server on port %d", m.config.GRPC.Port)
+	m.logger.Info("Starting GRPC server", "port", m.config.GRPC.Port)
 
 	// In a real implementation, this would:
 	// - Create GRPC server with proper service definitions
@@ -192,15 +404,14 @@ func (m *GRPCMonitor) startGRPCServer() {
 	// - Support multiple concurrent clients
 
 	// For this educational example, we'll simulate the server
-	log.Println("GRPC server started successfully")
-	log.Println("  Available services:")
-	log.Println("    - ErrorNotificationService: Real-time error 49 notifications")
-	log.Println("    - StatusQueryService: Query current replication status")
-	log.Println("    - ConfigurationService: Update monitoring configuration")
+	m.logger.Info("GRPC server started successfully")
+	m.logger.Debug("Available service", "service", "ErrorNotificationService", "description", "Real-time error 49 notifications")
+	m.logger.Debug("Available service", "service", "StatusQueryService", "description", "Query current replication status")
+	m.logger.Debug("Available service", "service", "ConfigurationService", "description", "Update monitoring configuration")
 
 	// Keep the server running
 	<-m.ctx.Done()
-	log.Println("GRPC server stopped")
+	m.logger.Info("GRPC server stopped")
 }
 
 // Stop gracefully shuts down the GRPC monitor
@@ -208,34 +419,43 @@ func (m *GRPCMonitor) startGRPCServer() {
 // It stops log watchers and closes GRPC server connections
 // Proper shutdown prevents resource leaks and data loss
 func (m *GRPCMonitor) Stop() {
-	log.Println("Stopping GRPC monitor...")
+	m.logger.Info("Stopping GRPC monitor")
 	m.cancel()
 }
 
+// localHostname returns the name of this host, or "localhost" when it
+// cannot be found
+func localHostname() string {
+	name, err := os.Hostname()
+	if err != nil || name == "" {
+		return "localhost"
+	}
+	return name
+}
+
+// historySize is how many error events GetErrorHistory keeps
+const historySize = 100
+
+// remember adds an event to the error history
+func (m *GRPCMonitor) remember(event ErrorEvent) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.detected++
+	m.history = append(m.history, event)
+	if len(m.history) > historySize {
+		m.history = m.history[len(m.history)-historySize:]
+	}
+}
+
 // GetErrorHistory returns recent error 49 events
 // This method provides access to historical error data
 // It helps administrators understand error patterns and frequency
 // The history can be used for reporting and trend analysis
-// This diagnostic capability supports proactive maintenance
+// The last 100 events are kept, oldest first
 func (m *GRPCMonitor) GetErrorHistory() []ErrorEvent {
-	// In a real implementation, this would return actual error history
-	// For this educational example, we'll return sample data
-	return []ErrorEvent{
-		{
-			Timestamp:     time.Now().Add(-1 * time.Hour),
-			AgreementName: "agreement-to-consumer1",
-			LogLine:       "err=49 Invalid credentials for replication agreement: agreement-to-consumer1",
-			LogFile:       "/var/log/dirsrv/slapd-ldap/errors",
-			Severity:      "ERROR",
-		},
-		{
-			Timestamp:     time.Now().Add(-2 * time.Hour),
-			AgreementName: "agreement-to-consumer2",
-			LogLine:       "err=49 Invalid credentials for replication agreement: agreement-to-consumer2",
-			LogFile:       "/var/log/dirsrv/slapd-ldap/errors",
-			Severity:      "ERROR",
-		},
-	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return append([]ErrorEvent(nil), m.history...)
 }
 
 // GetMonitoringStats returns statistics about the monitoring system
@@ -244,10 +464,14 @@ func (m *GRPCMonitor) GetErrorHistory() []ErrorEvent {
 // The statistics can be used for capacity planning and optimization
 // This transparency builds confidence in the monitoring system
 func (m *GRPCMonitor) GetMonitoringStats() map[string]interface{} {
+	m.mutex.Lock()
+	files, detected := len(m.watchers), m.detected
+	m.mutex.Unlock()
 	return map[string]interface{}{
-		"uptime_seconds":  time.Since(time.Now().Add(-1 * time.Hour)).Seconds(),
-		"files_monitored": len(m.config.GRPC.LogPaths),
-		"errors_detected": 2, // Sample data
+		"uptime_seconds":  time.Since(m.started).Seconds(),
+		"files_monitored": files,
+		"errors_detected": detected,
+		"open_incidents":  len(m.incidents.Incidents()),
 		"last_check":      time.Now().Format("2006-01-02 15:04:05"),
 		"grpc_port":       m.config.GRPC.Port,
 		"check_interval":  m.config.GRPC.CheckInterval,
@@ -255,32 +479,52 @@ func (m *GRPCMonitor) GetMonitoringStats() map[string]interface{} {
 	}
 }
 
+// Patterns of the log lines that report an error 49 for an agreement
+//   - the error log of a supplier, written by the replication plugin when
+//     an agreement fails to bind to its consumer:
+//     [01/Sep/2025:13:54:42.318815241 -0500] - ERR - NSMMReplicationPlugin - bind_and_check_pwp - agmt="cn=to-consumer1" (consumer1:389) - Replication bind with SIMPLE auth failed: LDAP error 49 (Invalid credentials) ()
+//   - lines naming the agreement after "agreement:", as written by older
+//     tooling: ... RESULT err=49 ... for replication agreement: to-consumer1
+//   - the access log of a consumer, on the RESULT of a BIND (tag=97):
+//     [01/Sep/2025:13:54:42.319102455 -0500] conn=1843 op=0 RESULT err=49 tag=97 nentries=0 ... - Invalid credentials
+//     It does not say which agreement bound; the event has no agreement and
+//     the bind DN is taken from the BIND line (see bindTracker)
+var (
+	logTimestamp   = regexp.MustCompile(`^\[([^\]]+)\]`)
+	agreementError = regexp.MustCompile(`agmt="cn=([^"]+)" \(([^:)]+)(?::\d+)?\).*(?:LDAP error 49|err=49)`)
+	legacyError    = regexp.MustCompile(`err=49.*agreement[:\s]+([^\s,]+)`)
+)
+
 // ParseLogLine extracts error information from a log line
 // This utility function handles the complexity of log parsing
 // It uses regular expressions to identify error patterns
-// The parser is flexible enough to handle different log formats
+// The parser understands the error log of 389DS suppliers, including the
+// consumer the agreement points to, and failed binds in the access log of
+// consumers
 // Understanding this helps administrators customize error detection
 func ParseLogLine(logLine string) (*ErrorEvent, error) {
-	// Pattern to match 389DS error 49 log entries
-	// This regex handles various log formats and extracts key information
-	pattern := regexp.MustCompile(`\[(.*?)\].*err=49.*agreement[:\s]+([^\s,]+)`)
-	matches := pattern.FindStringSubmatch(logLine)
-
-	if len(matches) < 3 {
+	event := &ErrorEvent{LogLine: strings.TrimSpace(logLine), Severity: "ERROR"}
+	if matches := agreementError.FindStringSubmatch(logLine); matches != nil {
+		event.AgreementName = matches[1]
+		event.Consumer = matches[2]
+	} else if matches := legacyError.FindStringSubmatch(logLine); matches != nil {
+		event.AgreementName = matches[1]
+	} else if matches := accessBindResult.FindStringSubmatch(logLine); matches != nil && matches[3] == "49" {
+		if logged := accessResultDN.FindStringSubmatch(logLine); logged != nil {
+			event.BindDN = logged[1]
+		}
+	} else {
 		return nil, fmt.Errorf("log line does not match error 49 pattern")
 	}
 
-	// Parse timestamp from log entry
-	timestamp, err := time.Parse("02/Jan/2006:15:04:05 -0700", matches[1])
-	if err != nil {
-		// If timestamp parsing fails, use current time
-		timestamp = time.Now()
+	// 389DS writes nanoseconds since version 1.4; older versions write seconds
+	// If timestamp parsing fails, use the time the line was received
+	event.Received = time.Now()
+	event.Timestamp = event.Received
+	if matches := logTimestamp.FindStringSubmatch(logLine); matches != nil {
+		if timestamp, err := time.Parse("02/Jan/2006:15:04:05.999999999 -0700", matches[1]); err == nil {
+			event.Timestamp = timestamp
+		}
 	}
-
-	return &ErrorEvent{
-		Timestamp:     timestamp,
-		AgreementName: matches[2],
-		LogLine:       strings.TrimSpace(logLine),
-		Severity:      "ERROR",
-	}, nil
+	return event, nil
 }
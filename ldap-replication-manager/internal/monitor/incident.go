@@ -0,0 +1,190 @@
+package monitor
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ldap-replication-manager/internal/config"
+)
+
+// Incident states
+// A first error 49 opens a suspected incident; it becomes active when the
+// errors keep coming (threshold within window) and resolved when they stop
+// A suspected incident that never becomes active is a blip: it is forgotten
+// after the resolve period without anyone being notified
+const (
+	IncidentSuspected = "suspected"
+	IncidentActive    = "active"
+	IncidentResolved  = "resolved"
+	IncidentBlip      = "blip"
+)
+
+// Incident is a series of error 49 lines for one agreement and consumer
+// of one instance on one server
+// Lines of a consumer's access log have no agreement; their incident is
+// that of the bind DN instead
+// A supplier retries a failing bind every few seconds, so a single bad
+// credential produces a line every few seconds until it is fixed
+type Incident struct {
+	ID        string
+	Agreement string
+	Consumer  string
+	BindDN    string
+	Hostname  string
+	Instance  string
+	State     string
+
+	// Log timestamps of the first and last line, for display
+	FirstSeen time.Time
+	LastSeen  time.Time
+	Count     int
+
+	// Log timestamp of the line that made the incident active; zero while
+	// suspected
+	ActiveSince time.Time
+
+	// Receive times of the lines within the last window, oldest first, and
+	// of the last line; window and resolve timing use these, so a backlog
+	// replayed from the journal or a sender with a skewed clock is measured
+	// the same way as live lines
+	recent       []time.Time
+	lastReceived time.Time
+}
+
+// Rate returns the number of lines per minute over the life of the incident
+func (i Incident) Rate() float64 {
+	minutes := i.LastSeen.Sub(i.FirstSeen).Minutes()
+	if minutes < 1 {
+		return float64(i.Count)
+	}
+	return float64(i.Count) / minutes
+}
+
+// Subject names what fails to authenticate, for messages
+func (i Incident) Subject() string {
+	if i.Agreement != "" {
+		return fmt.Sprintf("Agreement %s on %s", i.Agreement, i.Hostname)
+	}
+	if i.BindDN != "" {
+		return fmt.Sprintf("Replication bind as %q to %s", i.BindDN, i.Consumer)
+	}
+	return fmt.Sprintf("Replication bind to %s", i.Consumer)
+}
+
+// Transition is a change of state of an incident
+type Transition struct {
+	From     string
+	Incident Incident
+}
+
+// IncidentTracker groups error events into incidents
+// Observe is called for every line; Sweep is called regularly to resolve
+// incidents whose errors stopped; both return the transitions to act on
+type IncidentTracker struct {
+	threshold    int
+	window       time.Duration
+	resolveAfter time.Duration
+
+	mutex     sync.Mutex
+	incidents map[string]*Incident
+	sequence  int
+}
+
+// NewIncidentTracker creates a tracker with the configured thresholds
+func NewIncidentTracker(cfg config.IncidentConfig) *IncidentTracker {
+	return &IncidentTracker{
+		threshold:    cfg.Threshold,
+		window:       time.Duration(cfg.Window) * time.Second,
+		resolveAfter: time.Duration(cfg.ResolveAfter) * time.Second,
+		incidents:    make(map[string]*Incident),
+	}
+}
+
+// Observe adds one error event to the incident of its server, instance,
+// agreement and consumer
+// Events must be observed in the order they were received
+// It returns the transition it caused, or nil
+func (t *IncidentTracker) Observe(event ErrorEvent) *Transition {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	key := event.Hostname + "\x00" + event.Instance + "\x00" + event.AgreementName + "\x00" + event.Consumer + "\x00" + strings.ToLower(event.BindDN)
+	incident := t.incidents[key]
+	if incident == nil {
+		t.sequence++
+		incident = &Incident{
+			ID:        fmt.Sprintf("%s-%d", event.Timestamp.UTC().Format("20060102T150405"), t.sequence),
+			Agreement: event.AgreementName,
+			Consumer:  event.Consumer,
+			BindDN:    event.BindDN,
+			Hostname:  event.Hostname,
+			Instance:  event.Instance,
+			State:     IncidentSuspected,
+			FirstSeen: event.Timestamp,
+		}
+		t.incidents[key] = incident
+	}
+
+	incident.Count++
+	if event.Timestamp.After(incident.LastSeen) {
+		incident.LastSeen = event.Timestamp
+	}
+	incident.lastReceived = event.Received
+	incident.recent = append(incident.recent, event.Received)
+	cutoff := event.Received.Add(-t.window)
+	for len(incident.recent) > 0 && incident.recent[0].Before(cutoff) {
+		incident.recent = incident.recent[1:]
+	}
+
+	if incident.State == IncidentSuspected && len(incident.recent) >= t.threshold {
+		incident.State = IncidentActive
+		incident.ActiveSince = event.Timestamp
+		return &Transition{From: IncidentSuspected, Incident: *incident}
+	}
+	return nil
+}
+
+// Sweep ends the incidents that received no line for the resolve period
+// Active incidents are resolved; suspected ones were blips
+// now is the monitor's clock, compared with the receive times of the lines
+func (t *IncidentTracker) Sweep(now time.Time) []Transition {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	var transitions []Transition
+	for key, incident := range t.incidents {
+		if now.Sub(incident.lastReceived) < t.resolveAfter {
+			continue
+		}
+		from := incident.State
+		if from == IncidentActive {
+			incident.State = IncidentResolved
+		} else {
+			incident.State = IncidentBlip
+		}
+		transitions = append(transitions, Transition{From: from, Incident: *incident})
+		delete(t.incidents, key)
+	}
+	sort.Slice(transitions, func(i, j int) bool {
+		return transitions[i].Incident.FirstSeen.Before(transitions[j].Incident.FirstSeen)
+	})
+	return transitions
+}
+
+// Incidents returns the incidents still open, oldest first
+func (t *IncidentTracker) Incidents() []Incident {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	incidents := make([]Incident, 0, len(t.incidents))
+	for _, incident := range t.incidents {
+		incidents = append(incidents, *incident)
+	}
+	sort.Slice(incidents, func(i, j int) bool {
+		return incidents[i].FirstSeen.Before(incidents[j].FirstSeen)
+	})
+	return incidents
+}
@@ -0,0 +1,150 @@
+package monitor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ldap-replication-manager/internal/config"
+)
+
+// line is one error 49 line: when it was received and the time written in
+// it, both as offsets from the start of the test
+type line struct {
+	received time.Duration
+	logged   time.Duration
+	host     string
+}
+
+func TestIncidentTracker(t *testing.T) {
+	start := time.Date(2026, 10, 18, 13, 0, 0, 0, time.UTC)
+	cfg := config.IncidentConfig{Threshold: 3, Window: 120, ResolveAfter: 600}
+
+	tests := []struct {
+		name  string
+		lines []line
+
+		// The line that makes the incident active, -1 for none
+		activeAt int
+
+		// Sweeps after the last line and the state they resolve to, "" for
+		// none
+		quiet time.Duration
+		ended string
+	}{
+		{
+			name:     "burst within the window",
+			lines:    []line{{0, 0, ""}, {5 * time.Second, 5 * time.Second, ""}, {10 * time.Second, 10 * time.Second, ""}},
+			activeAt: 2,
+			quiet:    10 * time.Minute,
+			ended:    IncidentResolved,
+		},
+		{
+			name:     "lines too far apart",
+			lines:    []line{{0, 0, ""}, {90 * time.Second, 90 * time.Second, ""}, {4 * time.Minute, 4 * time.Minute, ""}},
+			activeAt: -1,
+			quiet:    10 * time.Minute,
+			ended:    IncidentBlip,
+		},
+		{
+			name:     "not quiet long enough",
+			lines:    []line{{0, 0, ""}, {time.Second, time.Second, ""}, {2 * time.Second, 2 * time.Second, ""}},
+			activeAt: 2,
+			quiet:    9 * time.Minute,
+		},
+		{
+			// A journal backlog read at once: logged an hour ago, received now
+			name:     "replayed backlog",
+			lines:    []line{{0, -time.Hour, ""}, {time.Millisecond, -time.Hour + 30*time.Second, ""}, {2 * time.Millisecond, -time.Hour + time.Minute, ""}},
+			activeAt: 2,
+			quiet:    5 * time.Minute,
+		},
+		{
+			// Timestamps of a sender whose clock is a day behind are spread
+			// over the window as they arrive
+			name:     "skewed sender clock",
+			lines:    []line{{0, -24 * time.Hour, ""}, {30 * time.Second, -24*time.Hour + 30*time.Second, ""}, {time.Minute, -24*time.Hour + time.Minute, ""}},
+			activeAt: 2,
+			quiet:    time.Minute,
+		},
+		{
+			name:     "separate servers",
+			lines:    []line{{0, 0, "dc1-a"}, {time.Second, time.Second, "dc1-b"}, {2 * time.Second, 2 * time.Second, "dc1-a"}},
+			activeAt: -1,
+			quiet:    10 * time.Minute,
+			ended:    IncidentBlip,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tracker := NewIncidentTracker(cfg)
+			activeAt := -1
+			var last time.Time
+			for i, l := range test.lines {
+				last = start.Add(l.received)
+				event := ErrorEvent{
+					AgreementName: "to-c1",
+					Consumer:      "c1",
+					Hostname:      l.host,
+					Timestamp:     start.Add(l.logged),
+					Received:      last,
+				}
+				if transition := tracker.Observe(event); transition != nil {
+					if transition.From != IncidentSuspected || transition.Incident.State != IncidentActive {
+						t.Errorf("line %d: unexpected transition %s -> %s", i, transition.From, transition.Incident.State)
+					}
+					if activeAt >= 0 {
+						t.Errorf("line %d: incident became active again", i)
+					}
+					activeAt = i
+				}
+			}
+			if activeAt != test.activeAt {
+				t.Errorf("incident became active at line %d, want %d", activeAt, test.activeAt)
+			}
+
+			// Sweeping right after the last line never ends an incident
+			if transitions := tracker.Sweep(last); len(transitions) != 0 {
+				t.Errorf("sweep right after the last line: %+v", transitions)
+			}
+
+			transitions := tracker.Sweep(last.Add(test.quiet))
+			if test.ended == "" {
+				if len(transitions) != 0 {
+					t.Errorf("sweep after %v: %+v", test.quiet, transitions)
+				}
+				return
+			}
+			if len(transitions) == 0 {
+				t.Fatalf("sweep after %v ended nothing", test.quiet)
+			}
+			for _, transition := range transitions {
+				if transition.Incident.State != test.ended {
+					t.Errorf("incident ended as %s, want %s", transition.Incident.State, test.ended)
+				}
+			}
+			if open := tracker.Incidents(); len(open) != 0 {
+				t.Errorf("%d incidents still open after they ended", len(open))
+			}
+		})
+	}
+}
+
+func TestIncidentKeepsLogTimestamps(t *testing.T) {
+	tracker := NewIncidentTracker(config.IncidentConfig{Threshold: 2, Window: 60, ResolveAfter: 600})
+	received := time.Date(2026, 10, 18, 13, 0, 0, 0, time.UTC)
+	logged := received.Add(-3 * time.Hour)
+
+	tracker.Observe(ErrorEvent{AgreementName: "to-c1", Timestamp: logged, Received: received})
+	transition := tracker.Observe(ErrorEvent{AgreementName: "to-c1", Timestamp: logged.Add(10 * time.Second), Received: received.Add(time.Second)})
+	if transition == nil {
+		t.Fatal("incident did not become active")
+	}
+	incident := transition.Incident
+	if !incident.FirstSeen.Equal(logged) || !incident.LastSeen.Equal(logged.Add(10*time.Second)) || !incident.ActiveSince.Equal(incident.LastSeen) {
+		t.Errorf("first %v, last %v, active since %v: want the log timestamps", incident.FirstSeen, incident.LastSeen, incident.ActiveSince)
+	}
+	if incident.Count != 2 {
+		t.Errorf("count = %d, want 2", incident.Count)
+	}
+}
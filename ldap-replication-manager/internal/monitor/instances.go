@@ -0,0 +1,169 @@
+package monitor
+
+import (
+	"bufio"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Instance is a 389DS instance installed on this host
+// Its name is the part after "slapd-" in its configuration directory:
+// /etc/dirsrv/slapd-hub01 holds instance "hub01"
+type Instance struct {
+	Name string
+
+	// Logs named by nsslapd-accesslog and nsslapd-errorlog in cn=config
+	AccessLog string
+	ErrorLog  string
+}
+
+// LogFile is one file to watch and the instance it belongs to
+type LogFile struct {
+	Path     string
+	Instance string
+}
+
+// DiscoverInstances finds the instances configured under dir
+// Every slapd-<name> directory with a dse.ldif is an instance; its log
+// paths come from the cn=config entry, or are the 389DS defaults under
+// /var/log/dirsrv/slapd-<name> when dse.ldif does not set them
+// Instances whose dse.ldif cannot be read are skipped and returned as errors
+func DiscoverInstances(dir string) ([]Instance, []error) {
+	matches, _ := filepath.Glob(filepath.Join(dir, "slapd-*", "dse.ldif"))
+	sort.Strings(matches)
+
+	var instances []Instance
+	var errs []error
+	for _, dse := range matches {
+		name := strings.TrimPrefix(filepath.Base(filepath.Dir(dse)), "slapd-")
+		if strings.HasSuffix(name, ".removed") {
+			continue // left behind by dsctl remove
+		}
+		attributes, err := readConfigEntry(dse)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		instance := Instance{
+			Name:      name,
+			AccessLog: attributes["nsslapd-accesslog"],
+			ErrorLog:  attributes["nsslapd-errorlog"],
+		}
+		if instance.AccessLog == "" {
+			instance.AccessLog = filepath.Join("/var/log/dirsrv", "slapd-"+name, "access")
+		}
+		if instance.ErrorLog == "" {
+			instance.ErrorLog = filepath.Join("/var/log/dirsrv", "slapd-"+name, "errors")
+		}
+		instances = append(instances, instance)
+	}
+	return instances, errs
+}
+
+// LogFiles lists the files to watch for a set of instances
+func LogFiles(instances []Instance) []LogFile {
+	var files []LogFile
+	for _, instance := range instances {
+		files = append(files,
+			LogFile{Path: instance.ErrorLog, Instance: instance.Name},
+			LogFile{Path: instance.AccessLog, Instance: instance.Name})
+	}
+	return files
+}
+
+// instanceInPath recognises the instance of a configured log path such as
+// /var/log/dirsrv/slapd-hub01/errors
+var instanceInPath = regexp.MustCompile(`slapd-([^/]+)`)
+
+// ConfiguredLogFiles turns the grpc.log_paths setting into log files,
+// tagged with the instance their path names, if any
+func ConfiguredLogFiles(paths []string) []LogFile {
+	files := make([]LogFile, 0, len(paths))
+	for _, path := range paths {
+		file := LogFile{Path: path}
+		if match := instanceInPath.FindStringSubmatch(path); match != nil {
+			file.Instance = match[1]
+		}
+		files = append(files, file)
+	}
+	return files
+}
+
+// readConfigEntry returns the attributes of the cn=config entry of a
+// dse.ldif, with lower case names
+// Folded lines (continuations starting with a space) and base64 values
+// ("attr:: ...") are decoded; only the first value of an attribute is kept
+func readConfigEntry(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	// Unfold the LDIF into logical lines first
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.HasPrefix(line, " ") && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	attributes := make(map[string]string)
+	inConfig := false
+	for _, line := range lines {
+		if line == "" {
+			if inConfig {
+				break // end of the cn=config entry
+			}
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, value, ok := parseLDIFLine(line)
+		if !ok {
+			continue
+		}
+		if name == "dn" {
+			inConfig = strings.EqualFold(strings.ReplaceAll(value, " ", ""), "cn=config")
+			continue
+		}
+		if inConfig {
+			if _, seen := attributes[name]; !seen {
+				attributes[name] = value
+			}
+		}
+	}
+	return attributes, nil
+}
+
+// parseLDIFLine splits "name: value" or "name:: base64" into a lower case
+// name and the decoded value
+func parseLDIFLine(line string) (string, string, bool) {
+	colon := strings.Index(line, ":")
+	if colon < 0 {
+		return "", "", false
+	}
+	name := strings.ToLower(line[:colon])
+	value := line[colon+1:]
+	if strings.HasPrefix(value, ":") {
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value[1:]))
+		if err != nil {
+			return "", "", false
+		}
+		return name, string(decoded), true
+	}
+	return name, strings.TrimSpace(value), true
+}
@@ -0,0 +1,77 @@
+package monitor
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDiscoverInstances(t *testing.T) {
+	// testdata/dirsrv holds:
+	//   slapd-hub01          log paths folded and base64 encoded
+	//   slapd-hub02          CRLF line ends and no log paths
+	//   slapd-hub00.removed  left behind by dsctl remove
+	//   slapd-nodse          no dse.ldif
+	instances, errs := DiscoverInstances(filepath.Join("testdata", "dirsrv"))
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	want := []Instance{
+		{Name: "hub01", AccessLog: "/srv/logs/hub01/access", ErrorLog: "/srv/logs/répl/errors"},
+		{Name: "hub02", AccessLog: "/var/log/dirsrv/slapd-hub02/access", ErrorLog: "/var/log/dirsrv/slapd-hub02/errors"},
+	}
+	if !reflect.DeepEqual(instances, want) {
+		t.Errorf("instances\n%+v\nwant\n%+v", instances, want)
+	}
+
+	files := LogFiles(instances)
+	wantFiles := []LogFile{
+		{Path: "/srv/logs/répl/errors", Instance: "hub01"},
+		{Path: "/srv/logs/hub01/access", Instance: "hub01"},
+		{Path: "/var/log/dirsrv/slapd-hub02/errors", Instance: "hub02"},
+		{Path: "/var/log/dirsrv/slapd-hub02/access", Instance: "hub02"},
+	}
+	if !reflect.DeepEqual(files, wantFiles) {
+		t.Errorf("log files\n%+v\nwant\n%+v", files, wantFiles)
+	}
+}
+
+func TestDiscoverInstancesSkipsUnreadable(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"slapd-good", "slapd-bad"} {
+		if err := os.Mkdir(filepath.Join(dir, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "slapd-good", "dse.ldif"), []byte("dn: cn=config\ncn: config\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// A directory opens but cannot be read
+	if err := os.Mkdir(filepath.Join(dir, "slapd-bad", "dse.ldif"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	instances, errs := DiscoverInstances(dir)
+	if len(instances) != 1 || instances[0].Name != "good" {
+		t.Errorf("instances %+v, want only good", instances)
+	}
+	if len(errs) != 1 {
+		t.Errorf("errors %v, want one for slapd-bad", errs)
+	}
+
+	if instances, errs := DiscoverInstances(filepath.Join(dir, "missing")); len(instances) != 0 || len(errs) != 0 {
+		t.Errorf("missing directory: %+v, %v", instances, errs)
+	}
+}
+
+func TestConfiguredLogFiles(t *testing.T) {
+	files := ConfiguredLogFiles([]string{"/var/log/dirsrv/slapd-hub01/errors", "/srv/logs/replication.log"})
+	want := []LogFile{
+		{Path: "/var/log/dirsrv/slapd-hub01/errors", Instance: "hub01"},
+		{Path: "/srv/logs/replication.log"},
+	}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("log files %+v, want %+v", files, want)
+	}
+}
@@ -0,0 +1,239 @@
+package monitor
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// journaldSource reads the journal of one 389DS instance
+// Instances running in containers, or with their logs sent to standard
+// output, have no log files: their lines are in the journal under the unit
+// dirsrv@<instance>, which "journalctl -f -o json" follows
+//
+// The cursor of the last entry read is saved in cursorDir, so after a
+// restart the monitor resumes where it stopped instead of skipping or
+// repeating lines; without a saved cursor it starts at the end of the
+// journal, like a log file
+type journaldSource struct {
+	instance   string
+	journalctl string
+	cursorDir  string
+	interval   time.Duration
+}
+
+// Name is the unit whose journal is read
+func (s journaldSource) Name() string {
+	return "journald:" + s.unit()
+}
+
+// unit is the systemd unit of the instance
+func (s journaldSource) unit() string {
+	return "dirsrv@" + s.instance
+}
+
+// Run follows the journal until ctx ends
+// journalctl is restarted every interval after it exits; the cursor is
+// saved every interval and when the source stops
+func (s journaldSource) Run(ctx context.Context, logger *slog.Logger, handle func(line LogLine)) {
+	r := &journalReader{
+		source:     s,
+		cursorFile: filepath.Join(s.cursorDir, "journald-"+s.instance+".cursor"),
+		logger:     logger.With("instance", s.instance),
+	}
+	r.cursor = r.loadCursor()
+	r.saved = r.cursor
+	defer r.saveCursor()
+
+	go func() {
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				r.saveCursor()
+			}
+		}
+	}()
+
+	for {
+		err := r.follow(ctx, handle)
+		if ctx.Err() != nil {
+			return
+		}
+		r.logger.Warn("journalctl stopped, will restart", "unit", s.unit(), "error", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(s.interval):
+		}
+	}
+}
+
+// journalReader is the state of a running journald source
+type journalReader struct {
+	source     journaldSource
+	cursorFile string
+	logger     *slog.Logger
+
+	mutex  sync.Mutex
+	cursor string
+	saved  string
+}
+
+// follow runs journalctl once and passes its lines to handle until it
+// exits or ctx ends
+func (r *journalReader) follow(ctx context.Context, handle func(line LogLine)) error {
+	r.mutex.Lock()
+	cursor := r.cursor
+	r.mutex.Unlock()
+
+	args := []string{"--follow", "--output=json", "--unit=" + r.source.unit()}
+	if cursor != "" {
+		args = append(args, "--after-cursor="+cursor)
+	} else {
+		args = append(args, "--lines=0")
+	}
+	cmd := exec.CommandContext(ctx, r.source.journalctl, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start %s: %v", r.source.journalctl, err)
+	}
+	r.logger.Debug("Following journal", "unit", r.source.unit(), "cursor", cursor)
+
+	read := 0
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		entry, err := parseJournalEntry(scanner.Bytes())
+		if err != nil {
+			r.logger.Debug("Skipping unreadable journal entry", "unit", r.source.unit(), "error", err)
+			continue
+		}
+		read++
+		r.mutex.Lock()
+		r.cursor = entry.cursor
+		r.mutex.Unlock()
+		for _, line := range strings.Split(entry.message, "\n") {
+			if line != "" {
+				handle(LogLine{Text: line, Instance: r.source.instance})
+			}
+		}
+	}
+
+	err = cmd.Wait()
+	if ctx.Err() != nil {
+		return nil
+	}
+	if message := strings.TrimSpace(stderr.String()); message != "" {
+		err = fmt.Errorf("%v: %s", err, message)
+	}
+
+	// A cursor of entries removed by journal rotation cannot be found;
+	// start again from the end rather than failing for ever
+	if err != nil && cursor != "" && read == 0 {
+		r.logger.Warn("Saved journal cursor not usable, continuing from the end of the journal", "unit", r.source.unit())
+		r.mutex.Lock()
+		r.cursor = ""
+		r.mutex.Unlock()
+	}
+	return err
+}
+
+// journalEntry is the part of a journal entry the monitor uses
+type journalEntry struct {
+	cursor  string
+	message string
+}
+
+// parseJournalEntry decodes one line of "journalctl -o json"
+// MESSAGE is a string, or an array of bytes when it is not valid UTF-8
+func parseJournalEntry(data []byte) (journalEntry, error) {
+	var fields struct {
+		Cursor  string          `json:"__CURSOR"`
+		Message json.RawMessage `json:"MESSAGE"`
+	}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return journalEntry{}, err
+	}
+	entry := journalEntry{cursor: fields.Cursor}
+	if len(fields.Message) == 0 || string(fields.Message) == "null" {
+		return entry, nil
+	}
+	if err := json.Unmarshal(fields.Message, &entry.message); err == nil {
+		return entry, nil
+	}
+	var raw []byte
+	var numbers []int
+	if err := json.Unmarshal(fields.Message, &numbers); err != nil {
+		return journalEntry{}, fmt.Errorf("unexpected MESSAGE field: %v", err)
+	}
+	for _, n := range numbers {
+		raw = append(raw, byte(n))
+	}
+	entry.message = string(raw)
+	return entry, nil
+}
+
+// loadCursor reads the saved cursor; none was saved on a first start
+func (r *journalReader) loadCursor() string {
+	data, err := os.ReadFile(r.cursorFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			r.logger.Warn("Cannot read journal cursor, starting at the end of the journal", "file", r.cursorFile, "error", err)
+		}
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// saveCursor writes the cursor if it moved since it was last saved
+// The file is replaced atomically, so a crash never leaves half a cursor
+func (r *journalReader) saveCursor() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.cursor == "" || r.cursor == r.saved {
+		return
+	}
+	if err := writeCursor(r.cursorFile, r.cursor); err != nil {
+		r.logger.Warn("Cannot save journal cursor", "file", r.cursorFile, "error", err)
+		return
+	}
+	r.saved = r.cursor
+}
+
+// writeCursor replaces the cursor file through a temporary file
+func writeCursor(path, cursor string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(cursor + "\n"); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,157 @@
+package monitor
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseJournalEntry(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr bool
+		cursor  string
+		message string
+	}{
+		{
+			name:    "text message",
+			data:    `{"__CURSOR":"s=1;i=2","MESSAGE":"conn=5 op=0 RESULT err=49 tag=97","_SYSTEMD_UNIT":"dirsrv@hub01.service"}`,
+			cursor:  "s=1;i=2",
+			message: "conn=5 op=0 RESULT err=49 tag=97",
+		},
+		{
+			name:    "message as bytes",
+			data:    `{"__CURSOR":"s=1;i=3","MESSAGE":[101,114,114,61,52,57,255]}`,
+			cursor:  "s=1;i=3",
+			message: "err=49\xff",
+		},
+		{
+			name:   "no message",
+			data:   `{"__CURSOR":"s=1;i=4"}`,
+			cursor: "s=1;i=4",
+		},
+		{
+			name:   "null message",
+			data:   `{"__CURSOR":"s=1;i=5","MESSAGE":null}`,
+			cursor: "s=1;i=5",
+		},
+		{
+			name:    "message of another type",
+			data:    `{"__CURSOR":"s=1;i=6","MESSAGE":{"a":1}}`,
+			wantErr: true,
+		},
+		{
+			name:    "not JSON",
+			data:    `-- No entries --`,
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			entry, err := parseJournalEntry([]byte(test.data))
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("parsed %+v", entry)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if entry.cursor != test.cursor || entry.message != test.message {
+				t.Errorf("entry = %+v, want cursor %q and message %q", entry, test.cursor, test.message)
+			}
+		})
+	}
+}
+
+// fakeJournalctl writes a script that records its arguments in args and
+// prints output like "journalctl -o json"
+func fakeJournalctl(t *testing.T, dir, output string) string {
+	t.Helper()
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no shell to run a fake journalctl")
+	}
+	if err := os.WriteFile(filepath.Join(dir, "output"), []byte(output), 0600); err != nil {
+		t.Fatal(err)
+	}
+	script := filepath.Join(dir, "journalctl")
+	content := "#!/bin/sh\necho \"$@\" >> " + filepath.Join(dir, "args") + "\ncat " + filepath.Join(dir, "output") + "\n"
+	if err := os.WriteFile(script, []byte(content), 0700); err != nil {
+		t.Fatal(err)
+	}
+	return script
+}
+
+func TestJournaldSourceResumesAtCursor(t *testing.T) {
+	dir := t.TempDir()
+	journalctl := fakeJournalctl(t, dir, strings.Join([]string{
+		`{"__CURSOR":"c1","MESSAGE":"first line"}`,
+		`not an entry`,
+		`{"__CURSOR":"c2","MESSAGE":"second line\nthird line\n"}`,
+	}, "\n")+"\n")
+	source := journaldSource{instance: "hub01", journalctl: journalctl, cursorDir: dir, interval: time.Hour}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	run := func() []LogLine {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		var lines []LogLine
+		stopped := make(chan struct{})
+		go func() {
+			source.Run(ctx, logger, func(line LogLine) {
+				lines = append(lines, line)
+				if len(lines) == 3 {
+					cancel()
+				}
+			})
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-time.After(5 * time.Second):
+			t.Fatal("source did not stop")
+		}
+		return lines
+	}
+
+	lines := run()
+	want := []string{"first line", "second line", "third line"}
+	if len(lines) != len(want) {
+		t.Fatalf("lines = %+v", lines)
+	}
+	for i, line := range lines {
+		if line.Text != want[i] || line.Instance != "hub01" {
+			t.Errorf("line %d = %+v, want %q from hub01", i, line, want[i])
+		}
+	}
+
+	cursor, err := os.ReadFile(filepath.Join(dir, "journald-hub01.cursor"))
+	if err != nil || strings.TrimSpace(string(cursor)) != "c2" {
+		t.Fatalf("saved cursor %q, %v", cursor, err)
+	}
+
+	run()
+	args, err := os.ReadFile(filepath.Join(dir, "args"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := strings.Split(strings.TrimSpace(string(args)), "\n")
+	if len(calls) != 2 {
+		t.Fatalf("journalctl calls: %q", calls)
+	}
+	if !strings.Contains(calls[0], "--unit=dirsrv@hub01") || !strings.Contains(calls[0], "--lines=0") {
+		t.Errorf("first start %q should begin at the end of the journal", calls[0])
+	}
+	if !strings.Contains(calls[1], "--after-cursor=c2") {
+		t.Errorf("restart %q should resume after the saved cursor", calls[1])
+	}
+}
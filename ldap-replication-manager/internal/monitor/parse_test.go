@@ -0,0 +1,172 @@
+package monitor
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestParseLogLine(t *testing.T) {
+	tests := []struct {
+		name      string
+		line      string
+		match     bool
+		agreement string
+		consumer  string
+		bindDN    string
+		timestamp time.Time
+	}{
+		{
+			name:      "supplier error log",
+			line:      `[18/Oct/2026:13:54:42.318815241 -0500] - ERR - NSMMReplicationPlugin - bind_and_check_pwp - agmt="cn=to-consumer1" (consumer1:389) - Replication bind with SIMPLE auth failed: LDAP error 49 (Invalid credentials) ()`,
+			match:     true,
+			agreement: "to-consumer1",
+			consumer:  "consumer1",
+			timestamp: time.Date(2026, 10, 18, 18, 54, 42, 318815241, time.UTC),
+		},
+		{
+			name:      "supplier error log with seconds",
+			line:      `[18/Oct/2026:13:54:42 +0200] - ERR - NSMMReplicationPlugin - agmt="cn=to c2" (c2.example.com) - Replication bind with SIMPLE auth failed: LDAP error 49 (Invalid credentials)`,
+			match:     true,
+			agreement: "to c2",
+			consumer:  "c2.example.com",
+			timestamp: time.Date(2026, 10, 18, 11, 54, 42, 0, time.UTC),
+		},
+		{
+			name:      "legacy agreement line",
+			line:      `conn=7 op=1 RESULT err=49 tag=97 for replication agreement: to-consumer3`,
+			match:     true,
+			agreement: "to-consumer3",
+		},
+		{
+			name:      "consumer access log",
+			line:      `[18/Oct/2026:13:54:42.319102455 -0500] conn=1843 op=0 RESULT err=49 tag=97 nentries=0 wtime=0.000098 optime=0.000287 etime=0.000383 - Invalid credentials`,
+			match:     true,
+			timestamp: time.Date(2026, 10, 18, 18, 54, 42, 319102455, time.UTC),
+		},
+		{
+			name:   "consumer access log naming the DN",
+			line:   `[18/Oct/2026:13:54:42 -0500] conn=1843 op=0 RESULT err=49 tag=97 nentries=0 etime=0 dn="cn=replication manager,cn=config"`,
+			match:  true,
+			bindDN: "cn=replication manager,cn=config",
+		},
+		{
+			name: "successful bind",
+			line: `[18/Oct/2026:13:54:42 -0500] conn=1843 op=0 RESULT err=0 tag=97 nentries=0 etime=0 dn="cn=replication manager,cn=config"`,
+		},
+		{
+			name: "err=49 on a compare",
+			line: `[18/Oct/2026:13:54:42 -0500] conn=12 op=3 RESULT err=49 tag=111 nentries=0 etime=0`,
+		},
+		{
+			name: "other replication error",
+			line: `[18/Oct/2026:13:54:42 -0500] - ERR - NSMMReplicationPlugin - agmt="cn=to-c1" (c1:389) - Unable to acquire replica: error 1`,
+		},
+		{
+			name: "empty",
+			line: "",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			event, err := ParseLogLine(test.line)
+			if !test.match {
+				if err == nil {
+					t.Fatalf("matched %+v", event)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if event.AgreementName != test.agreement || event.Consumer != test.consumer || event.BindDN != test.bindDN {
+				t.Errorf("agreement %q, consumer %q, bind DN %q; want %q, %q, %q",
+					event.AgreementName, event.Consumer, event.BindDN, test.agreement, test.consumer, test.bindDN)
+			}
+			if event.Received.IsZero() {
+				t.Error("receive time not set")
+			}
+			if !test.timestamp.IsZero() && !event.Timestamp.Equal(test.timestamp) {
+				t.Errorf("timestamp %v, want %v", event.Timestamp, test.timestamp)
+			}
+		})
+	}
+}
+
+func TestBindTracker(t *testing.T) {
+	dc1 := func(text string) LogLine { return LogLine{Text: text, Host: "dc1", Instance: "hub01"} }
+	dc2 := func(text string) LogLine { return LogLine{Text: text, Host: "dc2", Instance: "hub01"} }
+
+	tests := []struct {
+		name  string
+		lines []LogLine
+		want  string
+	}{
+		{
+			name: "bind then failed result",
+			lines: []LogLine{
+				dc1(`[18/Oct/2026:13:54:42 -0500] conn=5 op=0 BIND dn="cn=replication manager,cn=config" method=128 version=3`),
+				dc1(`[18/Oct/2026:13:54:42 -0500] conn=5 op=0 RESULT err=49 tag=97 nentries=0 etime=0 - Invalid credentials`),
+			},
+			want: "cn=replication manager,cn=config",
+		},
+		{
+			name: "interleaved connections",
+			lines: []LogLine{
+				dc1(`conn=5 op=0 BIND dn="cn=rm a,cn=config" method=128 version=3`),
+				dc1(`conn=6 op=0 BIND dn="cn=rm b,cn=config" method=128 version=3`),
+				dc1(`conn=5 op=0 RESULT err=0 tag=97 nentries=0 etime=0`),
+				dc1(`conn=6 op=0 RESULT err=49 tag=97 nentries=0 etime=0`),
+			},
+			want: "cn=rm b,cn=config",
+		},
+		{
+			name: "same connection on another server",
+			lines: []LogLine{
+				dc2(`conn=5 op=0 BIND dn="cn=rm b,cn=config" method=128 version=3`),
+				dc1(`conn=5 op=0 RESULT err=49 tag=97 nentries=0 etime=0`),
+			},
+			want: "",
+		},
+		{
+			name: "result without its bind",
+			lines: []LogLine{
+				dc1(`conn=9 op=2 RESULT err=49 tag=97 nentries=0 etime=0`),
+			},
+			want: "",
+		},
+		{
+			name: "bind answered once",
+			lines: []LogLine{
+				dc1(`conn=5 op=0 BIND dn="cn=rm a,cn=config" method=128 version=3`),
+				dc1(`conn=5 op=0 RESULT err=49 tag=97 nentries=0 etime=0`),
+				dc1(`conn=5 op=0 RESULT err=49 tag=97 nentries=0 etime=0`),
+			},
+			want: "",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			binds := newBindTracker()
+			var got string
+			for _, line := range test.lines {
+				got = binds.track(line)
+			}
+			if got != test.want {
+				t.Errorf("bind DN %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestBindTrackerIsBounded(t *testing.T) {
+	binds := newBindTracker()
+	for i := 0; i < 3*bindTrackerSize; i++ {
+		binds.track(LogLine{Text: `conn=` + strconv.Itoa(i) + ` op=0 BIND dn="cn=x" method=128 version=3`})
+	}
+	if len(binds.binds) > bindTrackerSize {
+		t.Errorf("%d binds remembered, limit %d", len(binds.binds), bindTrackerSize)
+	}
+}
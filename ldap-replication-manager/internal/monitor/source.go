@@ -0,0 +1,48 @@
+package monitor
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// LogSource is where the monitor reads 389DS log lines from
+// Every source hands plain log lines to the same parser, so an error 49
+// looks the same whether it was read from a file, the journal or syslog
+// Sources are plain values describing what to read; the monitor compares
+// them to find out which sources were added or removed
+type LogSource interface {
+	// Name identifies the source in log messages and events, such as the
+	// path of a file
+	Name() string
+
+	// Run passes every new line to handle until ctx ends
+	Run(ctx context.Context, logger *slog.Logger, handle func(line LogLine))
+}
+
+// LogLine is one line read by a source and the instance that wrote it
+// Host is the server the line came from; sources reading this host's own
+// logs leave it empty
+type LogLine struct {
+	Text     string
+	Instance string
+	Host     string
+}
+
+// fileSource reads a log file, following it like "tail -F"
+type fileSource struct {
+	file     LogFile
+	interval time.Duration
+}
+
+// Name is the path of the file
+func (s fileSource) Name() string {
+	return s.file.Path
+}
+
+// Run tails the file, checking it every interval, until ctx ends
+func (s fileSource) Run(ctx context.Context, logger *slog.Logger, handle func(line LogLine)) {
+	tailFile(ctx, s.file.Path, s.interval, logger.With("instance", s.file.Instance), func(line string) {
+		handle(LogLine{Text: line, Instance: s.file.Instance})
+	})
+}
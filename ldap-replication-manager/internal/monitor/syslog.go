@@ -0,0 +1,292 @@
+package monitor
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// syslogMaxMessage limits a received message; longer ones are cut, which
+// never affects the short lines 389DS writes for error 49
+const syslogMaxMessage = 64 * 1024
+
+// syslogSource receives 389DS log lines forwarded by rsyslog or syslog-ng
+// This lets one monitor on a central log host cover a whole fleet: every
+// line is tagged with the hostname of the server that sent it
+//   - network "udp": one message per datagram
+//   - network "tcp": messages framed by octet counting (RFC 6587) or ended
+//     by a newline, as rsyslog sends them
+//
+// Both RFC 5424 and the older RFC 3164 (BSD) format are understood; the
+// message part is the 389DS access or error log line
+type syslogSource struct {
+	network  string
+	address  string
+	interval time.Duration
+}
+
+// Name is the protocol and address listened on
+func (s syslogSource) Name() string {
+	return "syslog:" + s.network + "/" + s.address
+}
+
+// Run receives messages until ctx ends
+// When the address cannot be listened on, listening is tried again every
+// interval
+func (s syslogSource) Run(ctx context.Context, logger *slog.Logger, handle func(line LogLine)) {
+	for {
+		var err error
+		if s.network == "udp" {
+			err = s.receiveUDP(ctx, handle)
+		} else {
+			err = s.receiveTCP(ctx, logger, handle)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		logger.Warn("Cannot receive syslog messages, will retry", "network", s.network, "address", s.address, "error", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(s.interval):
+		}
+	}
+}
+
+// receiveUDP handles datagrams until ctx ends or the socket fails
+func (s syslogSource) receiveUDP(ctx context.Context, handle func(line LogLine)) error {
+	conn, err := net.ListenPacket("udp", s.address)
+	if err != nil {
+		return err
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	defer conn.Close()
+
+	buf := make([]byte, syslogMaxMessage)
+	for {
+		n, sender, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		s.deliver(string(buf[:n]), sender, handle)
+	}
+}
+
+// receiveTCP accepts connections until ctx ends or the listener fails
+// Connections are closed when ctx ends
+func (s syslogSource) receiveTCP(ctx context.Context, logger *slog.Logger, handle func(line LogLine)) error {
+	listener, err := net.Listen("tcp", s.address)
+	if err != nil {
+		return err
+	}
+	stop := context.AfterFunc(ctx, func() { listener.Close() })
+	defer stop()
+	defer listener.Close()
+
+	var connections sync.WaitGroup
+	defer connections.Wait()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		logger.Debug("Syslog sender connected", "sender", conn.RemoteAddr().String())
+		connections.Add(1)
+		go func() {
+			defer connections.Done()
+			stopConn := context.AfterFunc(ctx, func() { conn.Close() })
+			defer stopConn()
+			defer conn.Close()
+			s.readStream(conn, handle)
+		}()
+	}
+}
+
+// readStream reads the framed messages of one TCP connection
+// A frame starting with a digit is octet counted ("<length> <message>");
+// anything else runs up to the next newline
+func (s syslogSource) readStream(conn net.Conn, handle func(line LogLine)) {
+	reader := bufio.NewReaderSize(conn, syslogMaxMessage)
+	for {
+		first, err := reader.Peek(1)
+		if err != nil {
+			return
+		}
+		var message string
+		if first[0] >= '0' && first[0] <= '9' {
+			prefix, err := reader.ReadString(' ')
+			if err != nil {
+				return
+			}
+			length, err := strconv.Atoi(strings.TrimSuffix(prefix, " "))
+			if err != nil || length < 1 || length > syslogMaxMessage {
+				return // not a syslog sender, or out of step
+			}
+			data := make([]byte, length)
+			if _, err := io.ReadFull(reader, data); err != nil {
+				return
+			}
+			message = string(data)
+		} else {
+			line, err := reader.ReadSlice('\n')
+			if err != nil && err != bufio.ErrBufferFull {
+				if len(line) > 0 {
+					s.deliver(string(line), conn.RemoteAddr(), handle)
+				}
+				return
+			}
+			message = string(line)
+			if err == bufio.ErrBufferFull {
+				reader.ReadString('\n') // drop the rest of an overlong line
+			}
+		}
+		s.deliver(message, conn.RemoteAddr(), handle)
+	}
+}
+
+// deliver parses one message and hands its 389DS line to handle
+func (s syslogSource) deliver(data string, sender net.Addr, handle func(line LogLine)) {
+	message, ok := ParseSyslogMessage(data)
+	if !ok || message.Text == "" {
+		return
+	}
+	if message.Hostname == "" {
+		message.Hostname = sender.String()
+		if host, _, err := net.SplitHostPort(message.Hostname); err == nil {
+			message.Hostname = host
+		}
+	}
+	line := LogLine{Text: message.Text, Host: message.Hostname}
+	if match := instanceInTag.FindStringSubmatch(message.AppName); match != nil {
+		line.Instance = match[1]
+	}
+	handle(line)
+}
+
+// instanceInTag recognises the instance in the tag of a message, for
+// example "dirsrv@hub01" from the journal or "slapd-hub01" set by imfile
+var instanceInTag = regexp.MustCompile(`(?:slapd-|dirsrv@)([A-Za-z0-9_.-]+)`)
+
+// SyslogMessage is the part of a syslog message the monitor uses
+type SyslogMessage struct {
+	// Host that sent the message; empty when the message does not say
+	Hostname string
+
+	// APP-NAME (RFC 5424) or TAG (RFC 3164)
+	AppName string
+
+	// The message itself: the 389DS log line
+	Text string
+}
+
+// ParseSyslogMessage parses an RFC 5424 or RFC 3164 message
+//
+//	<27>1 2026-10-18T13:54:42.318Z dc1-a ns-slapd 812 - - [18/Oct/2026:13:54:42 -0500] - ERR - ...
+//	<27>Oct 18 13:54:42 dc1-a ns-slapd[812]: [18/Oct/2026:13:54:42 -0500] - ERR - ...
+//
+// It returns false when data does not start with a priority
+func ParseSyslogMessage(data string) (SyslogMessage, bool) {
+	data = strings.TrimRight(data, "\r\n\x00")
+	end := strings.IndexByte(data, '>')
+	if !strings.HasPrefix(data, "<") || end < 2 || end > 4 {
+		return SyslogMessage{}, false
+	}
+	if _, err := strconv.Atoi(data[1:end]); err != nil {
+		return SyslogMessage{}, false
+	}
+	rest := data[end+1:]
+	if strings.HasPrefix(rest, "1 ") {
+		return parseRFC5424(rest[2:]), true
+	}
+	return parseRFC3164(rest), true
+}
+
+// parseRFC5424 parses what follows "<PRI>1 "
+// TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA [MSG]
+func parseRFC5424(rest string) SyslogMessage {
+	fields := strings.SplitN(rest, " ", 6)
+	for len(fields) < 6 {
+		fields = append(fields, "")
+	}
+	message := SyslogMessage{Hostname: nilValue(fields[1]), AppName: nilValue(fields[2])}
+
+	// Skip the structured data: "-" or one or more [id param="value"]
+	text := fields[5]
+	if strings.HasPrefix(text, "-") {
+		text = text[1:]
+	} else {
+		for strings.HasPrefix(text, "[") {
+			text = text[elementEnd(text):]
+		}
+	}
+	text = strings.TrimPrefix(text, " ")
+	message.Text = strings.TrimPrefix(text, "\ufeff")
+	return message
+}
+
+// elementEnd returns the length of the structured data element at the
+// start of text; '\' escapes the next character
+func elementEnd(text string) int {
+	for i := 1; i < len(text); i++ {
+		switch text[i] {
+		case '\\':
+			i++
+		case ']':
+			return i + 1
+		}
+	}
+	return len(text)
+}
+
+// nilValue turns the RFC 5424 nil value "-" into an empty string
+func nilValue(field string) string {
+	if field == "-" {
+		return ""
+	}
+	return field
+}
+
+// parseRFC3164 parses what follows "<PRI>"
+// TIMESTAMP HOSTNAME TAG[PID]: MSG, where the timestamp is "Mmm dd hh:mm:ss"
+// or, as rsyslog can be set to send, RFC 3339
+func parseRFC3164(rest string) SyslogMessage {
+	timestamped := false
+	if len(rest) > 15 && rest[15] == ' ' {
+		if _, err := time.Parse(time.Stamp, rest[:15]); err == nil {
+			rest, timestamped = rest[16:], true
+		}
+	}
+	if !timestamped {
+		if token, after, found := strings.Cut(rest, " "); found {
+			if _, err := time.Parse(time.RFC3339Nano, token); err == nil {
+				rest, timestamped = after, true
+			}
+		}
+	}
+
+	var message SyslogMessage
+	token, after, found := strings.Cut(rest, " ")
+	if timestamped && found && !strings.HasSuffix(token, ":") {
+		message.Hostname = token
+		rest = after
+		token, after, found = strings.Cut(rest, " ")
+	}
+	if found && strings.HasSuffix(token, ":") {
+		tag := strings.TrimSuffix(token, ":")
+		if open := strings.IndexByte(tag, '['); open >= 0 {
+			tag = tag[:open]
+		}
+		message.AppName = tag
+		rest = after
+	}
+	message.Text = rest
+	return message
+}
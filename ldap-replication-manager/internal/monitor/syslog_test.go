@@ -0,0 +1,379 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ldap-replication-manager/internal/config"
+)
+
+const errorLine = `[18/Oct/2026:13:54:42.318815241 -0500] - ERR - NSMMReplicationPlugin - bind_and_check_pwp - agmt="cn=to-c1" (c1:389) - Replication bind with SIMPLE auth failed: LDAP error 49 (Invalid credentials) ()`
+
+func TestParseSyslogMessage(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		ok   bool
+		want SyslogMessage
+	}{
+		{
+			name: "RFC 5424",
+			data: "<27>1 2026-10-18T13:54:42.318Z dc1-a ns-slapd 812 - - " + errorLine,
+			ok:   true,
+			want: SyslogMessage{Hostname: "dc1-a", AppName: "ns-slapd", Text: errorLine},
+		},
+		{
+			name: "RFC 5424 with structured data and BOM",
+			data: `<27>1 2026-10-18T13:54:42Z dc1-a dirsrv@hub01 - ID47 [origin ip="10.0.0.1"][meta note="a \] b"] ` + "\xef\xbb\xbf" + errorLine + "\n",
+			ok:   true,
+			want: SyslogMessage{Hostname: "dc1-a", AppName: "dirsrv@hub01", Text: errorLine},
+		},
+		{
+			name: "RFC 5424 with nil values",
+			data: "<27>1 - - - - - -",
+			ok:   true,
+			want: SyslogMessage{},
+		},
+		{
+			name: "RFC 3164",
+			data: "<27>Oct 18 13:54:42 dc1-a ns-slapd[812]: " + errorLine,
+			ok:   true,
+			want: SyslogMessage{Hostname: "dc1-a", AppName: "ns-slapd", Text: errorLine},
+		},
+		{
+			name: "RFC 3164 with a single digit day",
+			data: "<30>Oct  8 03:04:05 dc1-b slapd-hub01: " + errorLine,
+			ok:   true,
+			want: SyslogMessage{Hostname: "dc1-b", AppName: "slapd-hub01", Text: errorLine},
+		},
+		{
+			name: "RFC 3164 with an RFC 3339 timestamp",
+			data: "<27>2026-10-18T13:54:42.318+02:00 dc1-a ns-slapd: " + errorLine,
+			ok:   true,
+			want: SyslogMessage{Hostname: "dc1-a", AppName: "ns-slapd", Text: errorLine},
+		},
+		{
+			name: "RFC 3164 without a hostname",
+			data: "<27>Oct 18 13:54:42 ns-slapd[812]: " + errorLine,
+			ok:   true,
+			want: SyslogMessage{AppName: "ns-slapd", Text: errorLine},
+		},
+		{
+			name: "RFC 3164 with only a message",
+			data: "<13>" + errorLine,
+			ok:   true,
+			want: SyslogMessage{Text: errorLine},
+		},
+		{name: "no priority", data: errorLine},
+		{name: "priority not a number", data: "<ab>1 - - - - - - x"},
+		{name: "priority too long", data: "<12345>x"},
+		{name: "empty", data: ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, ok := ParseSyslogMessage(test.data)
+			if ok != test.ok {
+				t.Fatalf("ParseSyslogMessage() ok = %v, want %v", ok, test.ok)
+			}
+			if got != test.want {
+				t.Errorf("ParseSyslogMessage() = %+v\nwant %+v", got, test.want)
+			}
+		})
+	}
+}
+
+// frame returns a message framed by octet counting
+func frame(message string) string {
+	return fmt.Sprintf("%d %s", len(message), message)
+}
+
+func TestReadStream(t *testing.T) {
+	message := "<27>1 2026-10-18T13:54:42Z dc1-a slapd-hub01 - - - " + errorLine
+	multiline := "<27>1 2026-10-18T13:54:42Z dc1-a slapd-hub01 - - - first\nsecond"
+
+	tests := []struct {
+		name   string
+		stream string
+		want   []LogLine
+	}{
+		{
+			name:   "octet counted",
+			stream: frame(message) + frame(message),
+			want:   []LogLine{{Text: errorLine, Host: "dc1-a", Instance: "hub01"}, {Text: errorLine, Host: "dc1-a", Instance: "hub01"}},
+		},
+		{
+			name:   "octet counted message containing a newline",
+			stream: frame(multiline),
+			want:   []LogLine{{Text: "first\nsecond", Host: "dc1-a", Instance: "hub01"}},
+		},
+		{
+			name:   "newline framed",
+			stream: message + "\n" + message + "\r\n",
+			want:   []LogLine{{Text: errorLine, Host: "dc1-a", Instance: "hub01"}, {Text: errorLine, Host: "dc1-a", Instance: "hub01"}},
+		},
+		{
+			name:   "last line without a newline",
+			stream: message + "\n" + message,
+			want:   []LogLine{{Text: errorLine, Host: "dc1-a", Instance: "hub01"}, {Text: errorLine, Host: "dc1-a", Instance: "hub01"}},
+		},
+		{
+			name:   "mixed framing",
+			stream: frame(message) + message + "\n",
+			want:   []LogLine{{Text: errorLine, Host: "dc1-a", Instance: "hub01"}, {Text: errorLine, Host: "dc1-a", Instance: "hub01"}},
+		},
+		{
+			name:   "truncated frame",
+			stream: frame(message)[:40],
+		},
+		{
+			name:   "bad length stops the stream",
+			stream: "0 " + message + "\n" + frame(message),
+		},
+		{
+			name:   "no hostname uses the sender",
+			stream: "<27>Oct 18 13:54:42 ns-slapd: " + errorLine + "\n",
+			want:   []LogLine{{Text: errorLine, Host: "pipe"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server, client := net.Pipe()
+			go func() {
+				io.WriteString(client, test.stream)
+				client.Close()
+			}()
+
+			var got []LogLine
+			done := make(chan struct{})
+			go func() {
+				syslogSource{network: "tcp"}.readStream(server, func(line LogLine) { got = append(got, line) })
+				close(done)
+			}()
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("readStream did not return at the end of the stream")
+			}
+			server.Close()
+
+			if len(got) != len(test.want) {
+				t.Fatalf("got %d lines %+v, want %d", len(got), got, len(test.want))
+			}
+			for i := range got {
+				if got[i] != test.want[i] {
+					t.Errorf("line %d = %+v, want %+v", i, got[i], test.want[i])
+				}
+			}
+		})
+	}
+}
+
+// TestSyslogSourceReceives sends messages to a running source over UDP and
+// TCP on the loopback interface
+func TestSyslogSourceReceives(t *testing.T) {
+	for _, network := range []string{"udp", "tcp"} {
+		t.Run(network, func(t *testing.T) {
+			address := freeAddress(t, network)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			lines := make(chan LogLine, 10)
+			source := syslogSource{network: network, address: address, interval: 50 * time.Millisecond}
+			stopped := make(chan struct{})
+			go func() {
+				source.Run(ctx, slog.New(slog.NewTextHandler(io.Discard, nil)), func(line LogLine) { lines <- line })
+				close(stopped)
+			}()
+
+			message := "<27>Oct 18 13:54:42 dc1-a slapd-hub01: " + errorLine + "\n"
+			deadline := time.Now().Add(5 * time.Second)
+			var got LogLine
+		receive:
+			for {
+				// The listener may not be up yet; UDP loses those datagrams
+				// and TCP refuses the connection, so keep trying
+				if conn, err := net.Dial(network, address); err == nil {
+					io.WriteString(conn, message)
+					conn.Close()
+				}
+				select {
+				case got = <-lines:
+					break receive
+				case <-time.After(100 * time.Millisecond):
+				}
+				if time.Now().After(deadline) {
+					t.Fatal("no line received")
+				}
+			}
+			if want := (LogLine{Text: errorLine, Host: "dc1-a", Instance: "hub01"}); got != want {
+				t.Errorf("received %+v, want %+v", got, want)
+			}
+
+			cancel()
+			select {
+			case <-stopped:
+			case <-time.After(5 * time.Second):
+				t.Fatal("source did not stop when its context ended")
+			}
+		})
+	}
+}
+
+// freeAddress returns a loopback address with a port nothing listens on
+func freeAddress(t *testing.T, network string) string {
+	t.Helper()
+	if network == "udp" {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Skipf("no loopback networking: %v", err)
+		}
+		defer conn.Close()
+		return conn.LocalAddr().String()
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("no loopback networking: %v", err)
+	}
+	defer listener.Close()
+	return listener.Addr().String()
+}
+
+func TestWatchSourceTagsAccessLogEvents(t *testing.T) {
+	monitor := NewGRPCMonitor(testConfig())
+	monitor.SetLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	monitor.watchSource(context.Background(), staticSource{lines: []LogLine{
+		{Text: `[18/Oct/2026:13:54:42 -0500] conn=5 op=0 BIND dn="cn=replication manager,cn=config" method=128 version=3`, Host: "c1", Instance: "hub01"},
+		{Text: `[18/Oct/2026:13:54:42 -0500] conn=5 op=0 RESULT err=49 tag=97 nentries=0 etime=0 - Invalid credentials`, Host: "c1", Instance: "hub01"},
+		{Text: errorLine, Instance: "supplier1"},
+	}})
+
+	history := monitor.GetErrorHistory()
+	if len(history) != 2 {
+		t.Fatalf("%d events, want 2: %+v", len(history), history)
+	}
+	access, supplier := history[0], history[1]
+	if access.AgreementName != "" || access.Consumer != "c1" || access.Hostname != "c1" || access.BindDN != "cn=replication manager,cn=config" || access.Instance != "hub01" {
+		t.Errorf("access log event %+v", access)
+	}
+	if access.Source != "static" {
+		t.Errorf("source %q", access.Source)
+	}
+	if supplier.AgreementName != "to-c1" || supplier.Consumer != "c1" || supplier.Hostname != monitor.hostname || supplier.BindDN != "" {
+		t.Errorf("error log event %+v", supplier)
+	}
+
+	open := monitor.Incidents()
+	if len(open) != 2 {
+		t.Fatalf("%d incidents, want one per server and agreement or bind DN", len(open))
+	}
+	for _, incident := range open {
+		if incident.Agreement == "" && !strings.Contains(incident.Subject(), `"cn=replication manager,cn=config" to c1`) {
+			t.Errorf("access log incident subject %q", incident.Subject())
+		}
+	}
+}
+
+// TestBindTrackerConcurrentTCPSenders has two consumers send their access
+// logs over TCP at the same time; every failed bind must keep its own DN
+// Run with -race: each connection is read by its own goroutine, and both
+// share the tracker of the source
+func TestBindTrackerConcurrentTCPSenders(t *testing.T) {
+	const binds = 500
+	address := freeAddress(t, "tcp")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Only the last line of a sender signals, so nothing but the tracker
+	// orders the two connections
+	tracker := newBindTracker()
+	finished := make(chan string, 2)
+	wrong := make(chan string, 2*binds)
+	source := syslogSource{network: "tcp", address: address, interval: 50 * time.Millisecond}
+	stopped := make(chan struct{})
+	go func() {
+		source.Run(ctx, slog.New(slog.NewTextHandler(io.Discard, nil)), func(line LogLine) {
+			if line.Text == "done" {
+				finished <- line.Host
+				return
+			}
+			if dn := tracker.track(line); strings.Contains(line.Text, "RESULT") && dn != "cn=rm-"+line.Host+",cn=config" {
+				wrong <- line.Host + ": " + dn
+			}
+		})
+		close(stopped)
+	}()
+
+	// Both senders connect first, then write at the same time
+	var connections []net.Conn
+	for range 2 {
+		var conn net.Conn
+		var err error
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+			if conn, err = net.Dial("tcp", address); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		connections = append(connections, conn)
+	}
+	for i, host := range []string{"c1", "c2"} {
+		go func() {
+			for n := 0; n < binds; n++ {
+				io.WriteString(connections[i], frame(fmt.Sprintf(`<134>Oct 18 13:54:42 %s slapd-hub01: conn=%d op=0 BIND dn="cn=rm-%s,cn=config" method=128 version=3`, host, n, host))+
+					frame(fmt.Sprintf(`<134>Oct 18 13:54:42 %s slapd-hub01: conn=%d op=0 RESULT err=49 tag=97 nentries=0 etime=0`, host, n)))
+			}
+			io.WriteString(connections[i], frame("<134>Oct 18 13:54:42 "+host+" slapd-hub01: done"))
+		}()
+	}
+
+	for range 2 {
+		select {
+		case <-finished:
+		case <-time.After(5 * time.Second):
+			t.Fatal("the senders' lines were not all received")
+		}
+	}
+	close(wrong)
+	for result := range wrong {
+		t.Errorf("failed bind from %s has the wrong bind DN", result)
+	}
+
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("source did not stop when its context ended")
+	}
+}
+
+// testConfig is a configuration with the monitor defaults
+func testConfig() *config.Config {
+	cfg := &config.Config{}
+	cfg.GRPC.CheckInterval = 5
+	cfg.GRPC.Incidents = config.IncidentConfig{Threshold: 3, Window: 120, ResolveAfter: 600}
+	return cfg
+}
+
+// staticSource hands fixed lines to the monitor
+type staticSource struct {
+	lines []LogLine
+}
+
+func (s staticSource) Name() string { return "static" }
+
+func (s staticSource) Run(ctx context.Context, logger *slog.Logger, handle func(line LogLine)) {
+	for _, line := range s.lines {
+		handle(line)
+	}
+}
@@ -0,0 +1,131 @@
+package monitor
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// tailMaxLine limits a line kept while waiting for its end; longer lines
+// are cut, which never affects the short lines 389DS writes for error 49
+const tailMaxLine = 64 * 1024
+
+// fileTailer follows a log file like "tail -F"
+// It starts at the end of a file that already exists, so old errors are
+// not reported again, and reads from the start of files created later
+// When 389DS rotates the log (the path then names a new file) the rest of
+// the old file is read first; a truncated file is read again from the start
+type fileTailer struct {
+	path   string
+	logger *slog.Logger
+
+	file    *os.File
+	offset  int64
+	partial []byte
+	missing bool
+}
+
+// tailFile calls handle for every line appended to path until ctx ends
+// The file is checked every interval
+func tailFile(ctx context.Context, path string, interval time.Duration, logger *slog.Logger, handle func(line string)) {
+	t := &fileTailer{path: path, logger: logger}
+	defer t.close()
+
+	t.open(true)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			t.poll(handle)
+		}
+	}
+}
+
+// open opens the file, at its end when atEnd is set
+func (t *fileTailer) open(atEnd bool) {
+	file, err := os.Open(t.path)
+	if err != nil {
+		if !t.missing {
+			t.logger.Warn("Cannot open log file, will retry", "file", t.path, "error", err)
+			t.missing = true
+		}
+		return
+	}
+	t.missing = false
+	t.file, t.offset, t.partial = file, 0, nil
+	if atEnd {
+		if offset, err := file.Seek(0, io.SeekEnd); err == nil {
+			t.offset = offset
+		}
+	}
+}
+
+// close closes the current file
+func (t *fileTailer) close() {
+	if t.file != nil {
+		t.file.Close()
+		t.file = nil
+	}
+}
+
+// poll reads what was appended since the last poll and follows rotation
+func (t *fileTailer) poll(handle func(line string)) {
+	if t.file == nil {
+		t.open(false)
+		if t.file == nil {
+			return
+		}
+	}
+
+	current, err := t.file.Stat()
+	if err == nil && current.Size() < t.offset {
+		t.logger.Info("Log file was truncated, reading from the start", "file", t.path)
+		t.file.Seek(0, io.SeekStart)
+		t.offset, t.partial = 0, nil
+	}
+	t.read(handle)
+
+	// A different file under the path means the log was rotated
+	latest, err := os.Stat(t.path)
+	if err != nil || (current != nil && !os.SameFile(current, latest)) {
+		t.logger.Info("Log file was rotated, following the new file", "file", t.path)
+		t.close()
+		t.open(false)
+		if t.file != nil {
+			t.read(handle)
+		}
+	}
+}
+
+// read passes every complete line from the current offset to handle
+func (t *fileTailer) read(handle func(line string)) {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := t.file.Read(buf)
+		if n > 0 {
+			t.offset += int64(n)
+			data := append(t.partial, buf[:n]...)
+			for {
+				end := bytes.IndexByte(data, '\n')
+				if end < 0 {
+					break
+				}
+				handle(string(bytes.TrimRight(data[:end], "\r")))
+				data = data[end+1:]
+			}
+			if len(data) > tailMaxLine {
+				data = data[:tailMaxLine]
+			}
+			t.partial = append([]byte(nil), data...)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package monitor
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testTailer follows path, starting at its end like tailFile does
+// Tests call poll themselves instead of waiting for the ticker
+func testTailer(t *testing.T, path string) *fileTailer {
+	t.Helper()
+	tailer := &fileTailer{path: path, logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	tailer.open(true)
+	t.Cleanup(tailer.close)
+	return tailer
+}
+
+// appendLog appends text to a file, creating it if needed
+func appendLog(t *testing.T, path, text string) {
+	t.Helper()
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := file.WriteString(text); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// pollLines polls once and returns the lines handled
+func pollLines(tailer *fileTailer) []string {
+	var lines []string
+	tailer.poll(func(line string) { lines = append(lines, line) })
+	return lines
+}
+
+func TestTailStartsAtEnd(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "errors")
+	appendLog(t, path, "old 1\nold 2\n")
+	tailer := testTailer(t, path)
+
+	if lines := pollLines(tailer); len(lines) != 0 {
+		t.Errorf("existing lines reported: %q", lines)
+	}
+	appendLog(t, path, "new 1\r\nnew 2\nhalf")
+	if lines, want := pollLines(tailer), []string{"new 1", "new 2"}; !reflect.DeepEqual(lines, want) {
+		t.Errorf("lines %q, want %q", lines, want)
+	}
+	// The partial line is completed by the next write
+	appendLog(t, path, " a line\n")
+	if lines, want := pollLines(tailer), []string{"half a line"}; !reflect.DeepEqual(lines, want) {
+		t.Errorf("lines %q, want %q", lines, want)
+	}
+}
+
+func TestTailFollowsRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "errors")
+	appendLog(t, path, "before start\n")
+	tailer := testTailer(t, path)
+	appendLog(t, path, "first\n")
+	pollLines(tailer)
+
+	// 389DS writes the last lines to the old file after it was renamed,
+	// then starts the new one
+	if err := os.Rename(path, path+".20261018-135442"); err != nil {
+		t.Fatal(err)
+	}
+	appendLog(t, path+".20261018-135442", "last of the old file\n")
+	appendLog(t, path, "first of the new file\n")
+
+	want := []string{"last of the old file", "first of the new file"}
+	if lines := pollLines(tailer); !reflect.DeepEqual(lines, want) {
+		t.Errorf("lines %q, want %q", lines, want)
+	}
+	appendLog(t, path, "second of the new file\n")
+	if lines, want := pollLines(tailer), []string{"second of the new file"}; !reflect.DeepEqual(lines, want) {
+		t.Errorf("lines %q, want %q", lines, want)
+	}
+}
+
+func TestTailRotationBeforeNewFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "errors")
+	appendLog(t, path, "")
+	tailer := testTailer(t, path)
+
+	// Between the rename and the first write the path does not exist
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	appendLog(t, path+".1", "last of the old file\n")
+	if lines, want := pollLines(tailer), []string{"last of the old file"}; !reflect.DeepEqual(lines, want) {
+		t.Errorf("lines %q, want %q", lines, want)
+	}
+	if lines := pollLines(tailer); len(lines) != 0 {
+		t.Errorf("lines %q while the file is missing", lines)
+	}
+
+	// A file created later is read from its start
+	appendLog(t, path, "first of the new file\n")
+	if lines, want := pollLines(tailer), []string{"first of the new file"}; !reflect.DeepEqual(lines, want) {
+		t.Errorf("lines %q, want %q", lines, want)
+	}
+}
+
+func TestTailTruncation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "errors")
+	tailer := testTailer(t, path)
+	appendLog(t, path, "a long line before the truncation\nanother one\n")
+	pollLines(tailer)
+
+	// copytruncate empties the file in place; the next lines are shorter
+	// than what was read, which shows the file was truncated
+	if err := os.Truncate(path, 0); err != nil {
+		t.Fatal(err)
+	}
+	appendLog(t, path, "after\n")
+	if lines, want := pollLines(tailer), []string{"after"}; !reflect.DeepEqual(lines, want) {
+		t.Errorf("lines %q, want %q", lines, want)
+	}
+}
+
+// logWriter passes every log line to a channel
+type logWriter chan string
+
+func (w logWriter) Write(p []byte) (int, error) {
+	w <- string(p)
+	return len(p), nil
+}
+
+func TestTailFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "errors")
+	ctx, cancel := context.WithCancel(context.Background())
+	lines := make(chan string, 10)
+	warnings := make(logWriter, 10)
+	done := make(chan struct{})
+	go func() {
+		tailFile(ctx, path, 5*time.Millisecond, slog.New(slog.NewTextHandler(warnings, nil)), func(line string) { lines <- line })
+		close(done)
+	}()
+
+	// Create the file once tailFile has found it missing
+	<-warnings
+	appendLog(t, path, "created after the start\n")
+	select {
+	case line := <-lines:
+		if line != "created after the start" {
+			t.Errorf("line %q", line)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no line from tailFile")
+	}
+	cancel()
+	<-done
+}
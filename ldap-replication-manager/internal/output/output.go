@@ -0,0 +1,291 @@
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/ldap-replication-manager/internal/ldap"
+	"github.com/ldap-replication-manager/internal/password"
+	"github.com/ldap-replication-manager/internal/rotation"
+)
+
+// APIVersion identifies the schema of every machine readable document
+// Fields may be added within a version; renaming or removing a field, or
+// changing its meaning, requires a new version
+const APIVersion = "ldap-replication-manager/v1"
+
+// Output formats selected with --output
+// "table" is the human readable output; json and yaml write one document to
+// stdout while all progress messages go to stderr
+const (
+	FormatTable = "table"
+	FormatJSON  = "json"
+	FormatYAML  = "yaml"
+)
+
+// Validate checks an --output value
+func Validate(format string) error {
+	switch format {
+	case FormatTable, FormatJSON, FormatYAML:
+		return nil
+	}
+	return fmt.Errorf("unknown output format %q (available: table, json, yaml)", format)
+}
+
+// Document is the envelope of every machine readable output
+// Kind tells consumers which structure Data holds:
+// AgreementList, Plan, RotationReport, VerificationReport, LockStatus,
+// AuditVerification, VaultList or PasswordHistory
+type Document struct {
+	APIVersion  string      `json:"api_version" yaml:"api_version"`
+	Kind        string      `json:"kind" yaml:"kind"`
+	GeneratedAt time.Time   `json:"generated_at" yaml:"generated_at"`
+	Data        interface{} `json:"data" yaml:"data"`
+}
+
+// now returns the time documents are stamped with; tests replace it
+var now = time.Now
+
+// Write writes one document in the given machine format
+func Write(w io.Writer, format, kind string, data interface{}) error {
+	document := Document{APIVersion: APIVersion, Kind: kind, GeneratedAt: now().UTC(), Data: data}
+	switch format {
+	case FormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(document)
+	case FormatYAML:
+		encoded, err := yaml.Marshal(document)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(encoded)
+		return err
+	}
+	return fmt.Errorf("output format %q is not a machine format", format)
+}
+
+// Agreement describes one replication agreement
+type Agreement struct {
+	Name             string `json:"name" yaml:"name"`
+	DN               string `json:"dn" yaml:"dn"`
+	Supplier         string `json:"supplier" yaml:"supplier"`
+	Consumer         string `json:"consumer" yaml:"consumer"`
+	BindDN           string `json:"bind_dn" yaml:"bind_dn"`
+	Suffix           string `json:"suffix" yaml:"suffix"`
+	Enabled          bool   `json:"enabled" yaml:"enabled"`
+	Status           string `json:"status" yaml:"status"`
+	LastUpdateStatus string `json:"last_update_status" yaml:"last_update_status"`
+}
+
+// AgreementList is the result of discover (kind AgreementList)
+type AgreementList struct {
+	Selection  string      `json:"selection" yaml:"selection"`
+	Total      int         `json:"total" yaml:"total"`
+	Agreements []Agreement `json:"agreements" yaml:"agreements"`
+}
+
+// Plan is the result of plan (kind Plan)
+// Passwords are never included
+type Plan struct {
+	RunID   string      `json:"run_id" yaml:"run_id"`
+	SavedTo string      `json:"saved_to,omitempty" yaml:"saved_to,omitempty"`
+	Groups  []PlanGroup `json:"groups" yaml:"groups"`
+}
+
+// PlanGroup is a set of agreements rotated together
+type PlanGroup struct {
+	Consumer   string   `json:"consumer" yaml:"consumer"`
+	BindDN     string   `json:"bind_dn" yaml:"bind_dn"`
+	Strategy   string   `json:"strategy" yaml:"strategy"`
+	NewAccount string   `json:"new_account,omitempty" yaml:"new_account,omitempty"`
+	Agreements []string `json:"agreements" yaml:"agreements"`
+}
+
+// RotationReport is the result of apply (kind RotationReport)
+type RotationReport struct {
+	RunID     string             `json:"run_id" yaml:"run_id"`
+	DryRun    bool               `json:"dry_run" yaml:"dry_run"`
+	Total     int                `json:"total" yaml:"total"`
+	Succeeded int                `json:"succeeded" yaml:"succeeded"`
+	Failed    int                `json:"failed" yaml:"failed"`
+	Results   []AgreementOutcome `json:"results" yaml:"results"`
+
+	// Set when changes were made that the audit log does not hold
+	AuditError string `json:"audit_error,omitempty" yaml:"audit_error,omitempty"`
+}
+
+// AgreementOutcome is the rotation outcome of one agreement
+type AgreementOutcome struct {
+	Agreement       string  `json:"agreement" yaml:"agreement"`
+	Supplier        string  `json:"supplier" yaml:"supplier"`
+	Consumer        string  `json:"consumer" yaml:"consumer"`
+	Success         bool    `json:"success" yaml:"success"`
+	Error           string  `json:"error,omitempty" yaml:"error,omitempty"`
+	Note            string  `json:"note,omitempty" yaml:"note,omitempty"`
+	DurationSeconds float64 `json:"duration_seconds" yaml:"duration_seconds"`
+}
+
+// VerificationReport is the result of verify (kind VerificationReport)
+type VerificationReport struct {
+	Total      int         `json:"total" yaml:"total"`
+	Failed     int         `json:"failed" yaml:"failed"`
+	Agreements []Agreement `json:"agreements" yaml:"agreements"`
+}
+
+// LockStatus is the result of lock status (kind LockStatus)
+type LockStatus struct {
+	DN      string     `json:"dn" yaml:"dn"`
+	Held    bool       `json:"held" yaml:"held"`
+	Stale   bool       `json:"stale" yaml:"stale"`
+	Holder  string     `json:"holder,omitempty" yaml:"holder,omitempty"`
+	Expires *time.Time `json:"expires,omitempty" yaml:"expires,omitempty"`
+}
+
+// AuditVerification is the result of audit verify (kind AuditVerification)
+// Records counts the records that verified; Head is empty when Valid is false
+type AuditVerification struct {
+	File    string `json:"file" yaml:"file"`
+	Valid   bool   `json:"valid" yaml:"valid"`
+	Records int64  `json:"records" yaml:"records"`
+	Head    string `json:"head,omitempty" yaml:"head,omitempty"`
+	Error   string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// VaultList is the result of list (kind VaultList)
+type VaultList struct {
+	Agreements []VaultAgreement `json:"agreements" yaml:"agreements"`
+}
+
+// VaultAgreement is one agreement stored in the local vault
+type VaultAgreement struct {
+	Name        string    `json:"name" yaml:"name"`
+	LastRotated time.Time `json:"last_rotated" yaml:"last_rotated"`
+	RunID       string    `json:"run_id" yaml:"run_id"`
+	Consumer    string    `json:"consumer,omitempty" yaml:"consumer,omitempty"`
+	Previous    int       `json:"previous" yaml:"previous"`
+}
+
+// PasswordHistory is the result of history (kind PasswordHistory)
+// It says when and by which run each version was set, but not the password
+type PasswordHistory struct {
+	Agreement string            `json:"agreement" yaml:"agreement"`
+	Versions  []PasswordVersion `json:"versions" yaml:"versions"`
+}
+
+// PasswordVersion is one stored password version, newest first
+// Version is "current" or "previous N", as given to get --previous N
+type PasswordVersion struct {
+	Version  string    `json:"version" yaml:"version"`
+	SetAt    time.Time `json:"set_at" yaml:"set_at"`
+	RunID    string    `json:"run_id" yaml:"run_id"`
+	Supplier string    `json:"supplier,omitempty" yaml:"supplier,omitempty"`
+	Consumer string    `json:"consumer,omitempty" yaml:"consumer,omitempty"`
+}
+
+// NewAgreement converts a discovered agreement
+func NewAgreement(agreement ldap.ReplicationAgreement) Agreement {
+	return Agreement{
+		Name:             agreement.Name,
+		DN:               agreement.DN,
+		Supplier:         agreement.Supplier,
+		Consumer:         agreement.Consumer,
+		BindDN:           agreement.BindDN,
+		Suffix:           agreement.Suffix,
+		Enabled:          agreement.Enabled,
+		Status:           agreement.Status(),
+		LastUpdateStatus: agreement.LastUpdateStatus,
+	}
+}
+
+// NewAgreements converts a list of discovered agreements
+// The result is never nil so an empty list is written as [] rather than null
+func NewAgreements(agreements []ldap.ReplicationAgreement) []Agreement {
+	converted := make([]Agreement, 0, len(agreements))
+	for _, agreement := range agreements {
+		converted = append(converted, NewAgreement(agreement))
+	}
+	return converted
+}
+
+// NewPlan converts a rotation plan, leaving out the passwords
+func NewPlan(runID string, plan *rotation.Plan) Plan {
+	converted := Plan{RunID: runID, Groups: make([]PlanGroup, 0, len(plan.Groups))}
+	for _, group := range plan.Groups {
+		planGroup := PlanGroup{Consumer: group.Consumer, BindDN: group.BindDN, Strategy: group.Strategy, NewAccount: group.NewAccount}
+		for _, agreement := range group.Agreements {
+			planGroup.Agreements = append(planGroup.Agreements, agreement.Name)
+		}
+		converted.Groups = append(converted.Groups, planGroup)
+	}
+	return converted
+}
+
+// NewRotationReport converts the results of a rotation run
+func NewRotationReport(runID string, dryRun bool, results []rotation.Result) RotationReport {
+	report := RotationReport{RunID: runID, DryRun: dryRun, Total: len(results), Results: make([]AgreementOutcome, 0, len(results))}
+	for _, result := range results {
+		outcome := AgreementOutcome{
+			Agreement:       result.Agreement,
+			Supplier:        result.Supplier,
+			Consumer:        result.Consumer,
+			Success:         result.Success,
+			Note:            result.Note,
+			DurationSeconds: result.Duration.Seconds(),
+		}
+		if result.Err != nil {
+			outcome.Error = result.Err.Error()
+		}
+		if result.Success {
+			report.Succeeded++
+		} else {
+			report.Failed++
+		}
+		report.Results = append(report.Results, outcome)
+	}
+	return report
+}
+
+// NewVaultList converts the agreements of the local vault, sorted by name
+func NewVaultList(records map[string]*password.VaultRecord, names []string) VaultList {
+	list := VaultList{Agreements: make([]VaultAgreement, 0, len(names))}
+	for _, name := range names {
+		record := records[name]
+		list.Agreements = append(list.Agreements, VaultAgreement{
+			Name:        name,
+			LastRotated: record.Current.CreatedAt,
+			RunID:       record.Current.RunID,
+			Consumer:    record.Current.Consumer,
+			Previous:    len(record.Previous),
+		})
+	}
+	return list
+}
+
+// NewPasswordHistory converts the stored versions of one agreement,
+// leaving out the passwords
+func NewPasswordHistory(agreementName string, record *password.VaultRecord) PasswordHistory {
+	history := PasswordHistory{Agreement: agreementName}
+	for i, entry := range append([]password.VaultEntry{record.Current}, record.Previous...) {
+		history.Versions = append(history.Versions, PasswordVersion{
+			Version:  VersionName(i),
+			SetAt:    entry.CreatedAt,
+			RunID:    entry.RunID,
+			Supplier: entry.Supplier,
+			Consumer: entry.Consumer,
+		})
+	}
+	return history
+}
+
+// VersionName names the Nth stored password version, 0 being the current one
+func VersionName(n int) string {
+	if n == 0 {
+		return "current"
+	}
+	return fmt.Sprintf("previous %d", n)
+}
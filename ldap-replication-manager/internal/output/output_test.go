@@ -0,0 +1,133 @@
+package output
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ldap-replication-manager/internal/ldap"
+	"github.com/ldap-replication-manager/internal/password"
+	"github.com/ldap-replication-manager/internal/rotation"
+)
+
+// Run "go test ./internal/output -update" after an intended change of the
+// documents, and review the golden files like any other change of the schema
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+var (
+	generatedAt = time.Date(2026, 10, 18, 13, 54, 42, 0, time.UTC)
+	rotatedAt   = time.Date(2026, 9, 1, 2, 30, 0, 0, time.UTC)
+)
+
+var goldenAgreements = []ldap.ReplicationAgreement{
+	{
+		Name:             "to-dc1-a",
+		Supplier:         "ldap1.example.com",
+		Consumer:         "dc1-a.example.com",
+		BindDN:           "cn=replication manager,cn=config",
+		DN:               "cn=to-dc1-a,cn=replica,cn=dc\\=example\\,dc\\=com,cn=mapping tree,cn=config",
+		Suffix:           "dc=example,dc=com",
+		Enabled:          true,
+		LastUpdateStatus: "Error (0) Replica acquired successfully: Incremental update succeeded",
+	},
+	{
+		Name:             "to-dc2-a",
+		Supplier:         "ldap2.example.com",
+		Consumer:         "dc2-a.example.com",
+		BindDN:           "cn=replication manager,cn=config",
+		DN:               "cn=to-dc2-a,cn=replica,cn=dc\\=example\\,dc\\=com,cn=mapping tree,cn=config",
+		Suffix:           "dc=example,dc=com",
+		Enabled:          false,
+		LastUpdateStatus: "Error (49) Replication error acquiring replica: Invalid credentials",
+	},
+}
+
+func TestGoldenDocuments(t *testing.T) {
+	now = func() time.Time { return generatedAt.In(time.FixedZone("CEST", 2*60*60)) }
+	defer func() { now = time.Now }()
+
+	plan := &rotation.Plan{Groups: []rotation.Group{
+		{Consumer: "dc1-a.example.com", BindDN: "cn=replication manager,cn=config", Strategy: "in-place",
+			Password: "never written", Agreements: goldenAgreements[:1]},
+		{Consumer: "dc2-a.example.com", BindDN: "cn=replication manager,cn=config", Strategy: "bind-group",
+			NewAccount: "cn=replication manager 2,cn=config", Password: "never written", Agreements: goldenAgreements[1:]},
+	}}
+	results := []rotation.Result{
+		{Agreement: "to-dc1-a", Supplier: "ldap1.example.com", Consumer: "dc1-a.example.com", Success: true,
+			Duration: 2500 * time.Millisecond},
+		{Agreement: "to-dc2-a", Supplier: "ldap2.example.com", Consumer: "dc2-a.example.com", Success: false,
+			Err: errors.New("verification failed: replication session failed"), Note: rotation.NoteRolledBack, Duration: 31 * time.Second},
+	}
+	report := NewRotationReport("20261018T135442-3fa2c1", false, results)
+	report.AuditError = "1 changes were not recorded in the audit log, the first because: no space left on device"
+	expires := rotatedAt.Add(time.Hour)
+	record := &password.VaultRecord{
+		Current: password.VaultEntry{Password: "never written", CreatedAt: rotatedAt, RunID: "20260901T023000-a1b2c3",
+			Supplier: "ldap1.example.com", Consumer: "dc1-a.example.com"},
+		Previous: []password.VaultEntry{{Password: "never written either", CreatedAt: rotatedAt.AddDate(0, -3, 0), RunID: "20260601T023000-d4e5f6"}},
+	}
+
+	documents := []struct {
+		name string
+		kind string
+		data interface{}
+	}{
+		{"agreement-list", "AgreementList", AgreementList{Selection: "all agreements", Total: 2, Agreements: NewAgreements(goldenAgreements)}},
+		{"agreement-list-empty", "AgreementList", AgreementList{Selection: "consumer dc9-*", Agreements: NewAgreements(nil)}},
+		{"plan", "Plan", NewPlan("20261018T135442-3fa2c1", plan)},
+		{"rotation-report", "RotationReport", report},
+		{"rotation-report-dry-run", "RotationReport", NewRotationReport("20261018T135442-3fa2c1", true, results[:1])},
+		{"lock-status", "LockStatus", LockStatus{DN: "cn=rotation lock,cn=config", Held: true, Holder: "alice@admin1", Expires: &expires}},
+		{"lock-status-free", "LockStatus", LockStatus{DN: "cn=rotation lock,cn=config"}},
+		{"vault-list", "VaultList", NewVaultList(map[string]*password.VaultRecord{"to-dc1-a": record}, []string{"to-dc1-a"})},
+		{"password-history", "PasswordHistory", NewPasswordHistory("to-dc1-a", record)},
+	}
+
+	for _, document := range documents {
+		for _, format := range []string{FormatJSON, FormatYAML} {
+			t.Run(document.name+"."+format, func(t *testing.T) {
+				var buffer bytes.Buffer
+				if err := Write(&buffer, format, document.kind, document.data); err != nil {
+					t.Fatal(err)
+				}
+				if strings.Contains(buffer.String(), "never written") {
+					t.Fatal("a password was written")
+				}
+
+				golden := filepath.Join("testdata", document.name+"."+format)
+				if *update {
+					if err := os.WriteFile(golden, buffer.Bytes(), 0644); err != nil {
+						t.Fatal(err)
+					}
+					return
+				}
+				want, err := os.ReadFile(golden)
+				if err != nil {
+					t.Fatalf("%v (run with -update to create it)", err)
+				}
+				if buffer.String() != string(want) {
+					t.Errorf("%s differs from %s:\n%s", format, golden, buffer.String())
+				}
+			})
+		}
+	}
+}
+
+func TestWriteRejectsTable(t *testing.T) {
+	if err := Write(new(bytes.Buffer), FormatTable, "Plan", Plan{}); err == nil {
+		t.Error("Write() in table format must fail")
+	}
+	for _, format := range []string{FormatTable, FormatJSON, FormatYAML} {
+		if err := Validate(format); err != nil {
+			t.Errorf("Validate(%q) = %v", format, err)
+		}
+	}
+	if err := Validate("xml"); err == nil {
+		t.Error("Validate(\"xml\") must fail")
+	}
+}
@@ -0,0 +1,278 @@
+package password
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+
+	"github.com/ldap-replication-manager/internal/config"
+)
+
+// bloomMagic identifies a breach bloom filter file built by BuildBreachFilter
+var bloomMagic = []byte("LRMBLOOM")
+
+// bloomHeaderSize is the size of the bloom filter header:
+// magic (8) + bit count (8) + hash count (4) + item count (8)
+const bloomHeaderSize = 28
+
+// BreachChecker looks up passwords in a local corpus of breached passwords
+// The corpus is a Have I Been Pwned style file of SHA-1 hashes, either as the
+// sorted text file ("HASH:COUNT" per line) or as a compact bloom filter
+// Nothing is sent over the network; only the SHA-1 of the password is looked up
+// A bloom filter can report a false match at its configured rate, but never
+// misses a breached password
+type BreachChecker struct {
+	file   string
+	format string
+}
+
+// NewBreachChecker prepares the breach check selected in the configuration
+// It returns nil when no corpus file is configured
+// The file format is detected from its first bytes when format is "auto"
+func NewBreachChecker(cfg config.BreachCheckConfig) (*BreachChecker, error) {
+	if cfg.File == "" {
+		return nil, nil
+	}
+
+	file, err := os.Open(cfg.File)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open breached password corpus %s: %v", cfg.File, err)
+	}
+	defer file.Close()
+
+	format := cfg.Format
+	if format == "auto" {
+		header := make([]byte, len(bloomMagic))
+		io.ReadFull(file, header)
+		format = "sorted"
+		if bytes.Equal(header, bloomMagic) {
+			format = "bloom"
+		}
+	}
+
+	return &BreachChecker{file: cfg.File, format: format}, nil
+}
+
+// Contains reports whether the password appears in the breach corpus
+func (b *BreachChecker) Contains(password string) (bool, error) {
+	digest := sha1.Sum([]byte(password))
+	if b.format == "bloom" {
+		return bloomContains(b.file, digest[:])
+	}
+	return sortedFileContains(b.file, strings.ToUpper(hex.EncodeToString(digest[:])))
+}
+
+// sortedFileContains binary searches a hash file sorted by hash
+// Lines have different lengths, so the search works on byte offsets: it takes
+// the middle offset, moves to the start of the next line and compares that hash
+// Only a few dozen reads are needed even for a file with a billion lines
+func sortedFileContains(path, hash string) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, fmt.Errorf("failed to open breached password corpus %s: %v", path, err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return false, err
+	}
+	size := info.Size()
+
+	// lineStartFrom returns the first line start at or after offset
+	lineStartFrom := func(offset int64) (int64, error) {
+		if offset == 0 {
+			return 0, nil
+		}
+		reader := bufio.NewReader(io.NewSectionReader(file, offset-1, size-offset+1))
+		skipped, err := reader.ReadString('\n')
+		if err == io.EOF {
+			return size, nil
+		}
+		return offset - 1 + int64(len(skipped)), err
+	}
+
+	// readLine returns the hash on the line at start and where the next line begins
+	readLine := func(start int64) (string, int64, error) {
+		reader := bufio.NewReader(io.NewSectionReader(file, start, size-start))
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return "", 0, err
+		}
+		next := start + int64(len(line))
+		line = strings.TrimSpace(line)
+		if separator := strings.IndexByte(line, ':'); separator >= 0 {
+			line = line[:separator]
+		}
+		return strings.ToUpper(line), next, nil
+	}
+
+	// The searched hash, if present, starts a line within [low, high)
+	low, high := int64(0), size
+	for low < high {
+		middle := low + (high-low)/2
+		start, err := lineStartFrom(middle)
+		if err != nil {
+			return false, fmt.Errorf("failed to read breached password corpus %s: %v", path, err)
+		}
+		if start >= high {
+			high = middle
+			continue
+		}
+		found, next, err := readLine(start)
+		if err != nil {
+			return false, fmt.Errorf("failed to read breached password corpus %s: %v", path, err)
+		}
+		switch {
+		case found == hash:
+			return true, nil
+		case found < hash:
+			low = next
+		default:
+			high = middle
+		}
+	}
+	return false, nil
+}
+
+// bloomContains checks the bits for a SHA-1 digest in a bloom filter file
+// Only the k bytes holding the relevant bits are read from disk
+func bloomContains(path string, digest []byte) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, fmt.Errorf("failed to open breach filter %s: %v", path, err)
+	}
+	defer file.Close()
+
+	header := make([]byte, bloomHeaderSize)
+	if _, err := io.ReadFull(file, header); err != nil || !bytes.Equal(header[:8], bloomMagic) {
+		return false, fmt.Errorf("%s is not a breach filter file", path)
+	}
+	bits := binary.BigEndian.Uint64(header[8:16])
+	hashes := binary.BigEndian.Uint32(header[16:20])
+
+	one := make([]byte, 1)
+	for _, position := range bloomPositions(digest, bits, hashes) {
+		if _, err := file.ReadAt(one, bloomHeaderSize+int64(position/8)); err != nil {
+			return false, fmt.Errorf("failed to read breach filter %s: %v", path, err)
+		}
+		if one[0]&(1<<(position%8)) == 0 {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// bloomPositions derives the k bit positions for a digest (double hashing)
+// SHA-1 output is already uniformly random, so its bytes are used directly
+func bloomPositions(digest []byte, bits uint64, hashes uint32) []uint64 {
+	h1 := binary.BigEndian.Uint64(digest[0:8])
+	h2 := binary.BigEndian.Uint64(digest[8:16]) | 1
+	positions := make([]uint64, hashes)
+	for i := range positions {
+		positions[i] = (h1 + uint64(i)*h2) % bits
+	}
+	return positions
+}
+
+// BuildBreachFilter builds a bloom filter from a Have I Been Pwned hash dump
+// The input has one "SHA1HASH:COUNT" (or bare hash) per line, in any order
+// The filter is sized for the requested false positive rate; at 0.1% it
+// needs about 1.8 bytes per hash, far less than the text file, but for the
+// full corpus of close to a billion hashes that is still about 1.6 GB
+// At most maxMemory bytes of the filter are held in memory: a larger filter
+// is built in slices, reading the input once more for every slice
+// It returns the number of hashes added
+func BuildBreachFilter(inputPath, outputPath string, falsePositiveRate float64, maxMemory int64) (int, error) {
+	if falsePositiveRate <= 0 || falsePositiveRate >= 1 {
+		return 0, fmt.Errorf("false positive rate must be between 0 and 1")
+	}
+	if maxMemory < 1 {
+		return 0, fmt.Errorf("memory limit must be positive")
+	}
+
+	// First pass: count the hashes to size the filter
+	count := 0
+	err := eachBreachHash(inputPath, func([]byte) { count++ })
+	if err != nil {
+		return 0, err
+	}
+	if count == 0 {
+		return 0, fmt.Errorf("no hashes found in %s", inputPath)
+	}
+
+	bits := uint64(math.Ceil(-float64(count) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2)))
+	hashes := uint32(math.Max(1, math.Round(float64(bits)/float64(count)*math.Ln2)))
+	size := int64((bits + 7) / 8)
+
+	header := make([]byte, bloomHeaderSize)
+	copy(header, bloomMagic)
+	binary.BigEndian.PutUint64(header[8:16], bits)
+	binary.BigEndian.PutUint32(header[16:20], hashes)
+	binary.BigEndian.PutUint64(header[20:28], uint64(count))
+
+	// Every further pass sets the bits that fall into one slice of the filter
+	err = writeFileAtomicWith(outputPath, 0644, func(file *os.File) error {
+		if _, err := file.Write(header); err != nil {
+			return err
+		}
+		slice := make([]byte, min(size, maxMemory))
+		for offset := int64(0); offset < size; offset += int64(len(slice)) {
+			part := slice[:min(int64(len(slice)), size-offset)]
+			clear(part)
+			first, last := uint64(offset)*8, uint64(offset+int64(len(part)))*8
+			err := eachBreachHash(inputPath, func(digest []byte) {
+				for _, position := range bloomPositions(digest, bits, hashes) {
+					if position >= first && position < last {
+						part[(position-first)/8] |= 1 << (position % 8)
+					}
+				}
+			})
+			if err != nil {
+				return err
+			}
+			if _, err := file.Write(part); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to write breach filter %s: %v", outputPath, err)
+	}
+	return count, nil
+}
+
+// eachBreachHash calls fn with the decoded SHA-1 digest of every line
+// Lines that are not a 40 character hex hash are skipped
+func eachBreachHash(path string, fn func([]byte)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %v", path, err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if separator := strings.IndexByte(line, ':'); separator >= 0 {
+			line = line[:separator]
+		}
+		digest, err := hex.DecodeString(strings.TrimSpace(line))
+		if err != nil || len(digest) != sha1.Size {
+			continue
+		}
+		fn(digest)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read %s: %v", path, err)
+	}
+	return nil
+}
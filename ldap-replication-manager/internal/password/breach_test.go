@@ -0,0 +1,263 @@
+package password
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/ldap-replication-manager/internal/config"
+)
+
+// breachedPasswords is the fixture corpus; the HIBP file lists each hash
+// with how often it was seen, so counts of different widths are used
+var breachedPasswords = map[string]int{
+	"password":      9545824,
+	"123456":        37359195,
+	"letmein":       1,
+	"Summer2024!":   52,
+	"correct horse": 7,
+	"qwerty":        10000,
+	"dragon":        968625,
+	"monkey":        1000,
+	"zzzzzzzz":      3,
+	"":              12,
+}
+
+var cleanPasswords = []string{"Xk9#mQ2$vL7&pR4w", "not in the corpus", "Password", "password ", "letmein2"}
+
+func breachHash(password string) string {
+	digest := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(digest[:]))
+}
+
+// writeSortedCorpus writes the fixture corpus as a HIBP "ordered by hash" file
+func writeSortedCorpus(t *testing.T, lineEnding string) string {
+	t.Helper()
+	var lines []string
+	for password, count := range breachedPasswords {
+		lines = append(lines, fmt.Sprintf("%s:%d", breachHash(password), count))
+	}
+	sort.Strings(lines)
+	path := filepath.Join(t.TempDir(), "corpus.txt")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, lineEnding)+lineEnding), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestSortedFileContains(t *testing.T) {
+	for _, lineEnding := range []string{"\n", "\r\n"} {
+		path := writeSortedCorpus(t, lineEnding)
+		for password := range breachedPasswords {
+			found, err := sortedFileContains(path, breachHash(password))
+			if err != nil || !found {
+				t.Errorf("%q not found (line ending %q): %v", password, lineEnding, err)
+			}
+		}
+		for _, password := range cleanPasswords {
+			if found, err := sortedFileContains(path, breachHash(password)); err != nil || found {
+				t.Errorf("%q found (line ending %q): %v", password, lineEnding, err)
+			}
+		}
+	}
+
+	// Hashes before the first line and after the last one
+	path := writeSortedCorpus(t, "\n")
+	for _, hash := range []string{strings.Repeat("0", 40), strings.Repeat("F", 40)} {
+		if found, err := sortedFileContains(path, hash); err != nil || found {
+			t.Errorf("%s found: %v", hash, err)
+		}
+	}
+}
+
+func TestSortedFileContainsSmallFiles(t *testing.T) {
+	hash := breachHash("letmein")
+	tests := []struct {
+		name     string
+		contents string
+		want     bool
+	}{
+		{"empty", "", false},
+		{"single line", hash + ":1\n", true},
+		{"single line without newline", hash + ":1", true},
+		{"bare hash", hash + "\n", true},
+		{"lower case hash", strings.ToLower(hash) + ":1\n", true},
+		{"other hash", breachHash("dragon") + ":1\n", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "corpus.txt")
+			if err := os.WriteFile(path, []byte(test.contents), 0644); err != nil {
+				t.Fatal(err)
+			}
+			found, err := sortedFileContains(path, hash)
+			if err != nil || found != test.want {
+				t.Errorf("sortedFileContains() = %v, %v, want %v", found, err, test.want)
+			}
+		})
+	}
+}
+
+// writeUnsortedDump writes the fixture corpus in random order with a few
+// lines that are not hashes, which the filter build skips
+func writeUnsortedDump(t *testing.T) string {
+	t.Helper()
+	lines := []string{"# comment", "not a hash:3"}
+	for password, count := range breachedPasswords {
+		lines = append(lines, fmt.Sprintf("%s:%d", breachHash(password), count))
+	}
+	path := filepath.Join(t.TempDir(), "dump.txt")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestBreachFilter(t *testing.T) {
+	dump := writeUnsortedDump(t)
+	filter := filepath.Join(t.TempDir(), "breached.bloom")
+	count, err := BuildBreachFilter(dump, filter, 0.001, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != len(breachedPasswords) {
+		t.Errorf("%d hashes added, want %d", count, len(breachedPasswords))
+	}
+
+	// A bloom filter never misses a breached password
+	for password := range breachedPasswords {
+		digest := sha1.Sum([]byte(password))
+		if found, err := bloomContains(filter, digest[:]); err != nil || !found {
+			t.Errorf("%q not found: %v", password, err)
+		}
+	}
+
+	// False matches stay near the configured rate
+	falseMatches := 0
+	for i := 0; i < 10000; i++ {
+		digest := sha1.Sum([]byte(fmt.Sprintf("clean password %d", i)))
+		found, err := bloomContains(filter, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found {
+			falseMatches++
+		}
+	}
+	if falseMatches > 50 {
+		t.Errorf("%d false matches in 10000, want about 10", falseMatches)
+	}
+}
+
+func TestBreachFilterMemoryLimit(t *testing.T) {
+	// A large enough corpus for a filter of many bytes
+	var lines []string
+	for i := 0; i < 2000; i++ {
+		lines = append(lines, breachHash(fmt.Sprintf("password %d", i))+":1")
+	}
+	dump := filepath.Join(t.TempDir(), "dump.txt")
+	if err := os.WriteFile(dump, []byte(strings.Join(lines, "\n")), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	whole, sliced := filepath.Join(dir, "whole.bloom"), filepath.Join(dir, "sliced.bloom")
+	if _, err := BuildBreachFilter(dump, whole, 0.01, 1<<20); err != nil {
+		t.Fatal(err)
+	}
+	// 100 bytes does not divide the filter, so the last slice is shorter
+	if _, err := BuildBreachFilter(dump, sliced, 0.01, 100); err != nil {
+		t.Fatal(err)
+	}
+
+	wholeData, err := os.ReadFile(whole)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slicedData, err := os.ReadFile(sliced)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(wholeData)-bloomHeaderSize < 1000 || (len(wholeData)-bloomHeaderSize)%100 == 0 {
+		t.Fatalf("filter of %d bytes does not test slicing", len(wholeData)-bloomHeaderSize)
+	}
+	if !bytes.Equal(wholeData, slicedData) {
+		t.Error("a filter built in slices differs from one built at once")
+	}
+}
+
+func TestBuildBreachFilterErrors(t *testing.T) {
+	dump := writeUnsortedDump(t)
+	output := filepath.Join(t.TempDir(), "breached.bloom")
+	empty := filepath.Join(t.TempDir(), "empty.txt")
+	if err := os.WriteFile(empty, []byte("no hashes here\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		input     string
+		rate      float64
+		maxMemory int64
+		wantErr   string
+	}{
+		{"zero rate", dump, 0, 1 << 20, "between 0 and 1"},
+		{"rate of one", dump, 1, 1 << 20, "between 0 and 1"},
+		{"no memory", dump, 0.001, 0, "memory limit"},
+		{"no hashes", empty, 0.001, 1 << 20, "no hashes found"},
+		{"missing input", filepath.Join(t.TempDir(), "missing.txt"), 0.001, 1 << 20, "failed to open"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := BuildBreachFilter(test.input, output, test.rate, test.maxMemory)
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("BuildBreachFilter() = %v, want %q", err, test.wantErr)
+			}
+			if _, err := os.Stat(output); !os.IsNotExist(err) {
+				t.Error("a failed build must not leave a filter behind")
+			}
+		})
+	}
+}
+
+func TestBreachCheckerDetectsFormat(t *testing.T) {
+	sorted := writeSortedCorpus(t, "\n")
+	bloom := filepath.Join(t.TempDir(), "breached.bloom")
+	if _, err := BuildBreachFilter(writeUnsortedDump(t), bloom, 0.001, 1<<20); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, file := range []string{sorted, bloom} {
+		checker, err := NewBreachChecker(config.BreachCheckConfig{File: file, Format: "auto"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found, err := checker.Contains("Summer2024!"); err != nil || !found {
+			t.Errorf("%s: breached password not found: %v", filepath.Base(file), err)
+		}
+		if found, err := checker.Contains("Xk9#mQ2$vL7&pR4w"); err != nil || found {
+			t.Errorf("%s: clean password found: %v", filepath.Base(file), err)
+		}
+	}
+
+	// A text corpus given as a bloom filter is refused rather than misread
+	checker, err := NewBreachChecker(config.BreachCheckConfig{File: sorted, Format: "bloom"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := checker.Contains("letmein"); err == nil || !strings.Contains(err.Error(), "not a breach filter") {
+		t.Errorf("Contains() = %v, want a format error", err)
+	}
+
+	if checker, err := NewBreachChecker(config.BreachCheckConfig{}); checker != nil || err != nil {
+		t.Errorf("NewBreachChecker() without a file = %v, %v", checker, err)
+	}
+}
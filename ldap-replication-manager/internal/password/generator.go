@@ -1,10 +1,14 @@
 package password
 
 import (
-	"crypto/rand"
 	"fmt"
-	"math/big"
+	"io"
+	"log/slog"
+	"os"
+	"os/user"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/ldap-replication-manager/internal/config"
 	"github.com/ldap-replication-manager/internal/ldap"
@@ -17,6 +21,34 @@ import (
 // Non-programmers can adjust password requirements through the config file
 type Manager struct {
 	config *config.Config
+
+	// Optional external secret store (nil when not configured)
+	store SecretStore
+
+	// Optional local encrypted vault file (nil when not configured)
+	localVault *LocalVault
+
+	// Identifier of the current run, saved with every recorded password
+	runID string
+
+	// Salted hashes of recently applied passwords (nil when disabled)
+	history *History
+
+	// Password syntax rules enforced by each agreement's consumer
+	serverPolicies map[string]Policy
+
+	// Optional breached password corpus (nil when not configured)
+	breach *BreachChecker
+
+	// Where each agreement's password came from during GeneratePasswords
+	// Used to avoid writing a password back to the store it was read from
+	sources map[string]string
+
+	// Structured logger, see SetLogger; passwords are never logged
+	logger *slog.Logger
+
+	// Where GeneratePasswords reports its choices, see SetOutput
+	out io.Writer
 }
 
 // NewManager creates a new password manager instance
@@ -26,147 +58,443 @@ type Manager struct {
 // This separation allows easy testing and configuration changes
 func NewManager(cfg *config.Config) *Manager {
 	return &Manager{
-		config: cfg,
+		config:         cfg,
+		sources:        make(map[string]string),
+		history:        NewHistory(cfg.Password.Reuse),
+		serverPolicies: make(map[string]Policy),
+		logger:         slog.Default().With("component", "password"),
+		out:            os.Stdout,
 	}
 }
 
+// SetLogger replaces the logger of the manager
+// Pass a logger carrying the correlation fields of the run, such as run_id;
+// the manager adds the agreement to the lines it writes
+func (m *Manager) SetLogger(logger *slog.Logger) {
+	m.logger = logger.With("component", "password")
+}
+
+// SetOutput sets where the password choices are reported
+// Machine readable output sends them to stderr with the other messages
+func (m *Manager) SetOutput(out io.Writer) {
+	m.out = out
+}
+
+// SetSecretStore attaches an external secret store to the manager
+// Predefined passwords are then also looked up in the store,
+// and applied passwords are written back by RecordRotation
+func (m *Manager) SetSecretStore(store SecretStore) {
+	m.store = store
+}
+
+// SetLocalVault attaches the local encrypted vault file to the manager
+// RecordRotation then saves every applied password to it
+func (m *Manager) SetLocalVault(vault *LocalVault) {
+	m.localVault = vault
+}
+
+// SetBreachChecker attaches the breached password corpus to the manager
+// Passwords chosen by people are then rejected if they appear in it
+func (m *Manager) SetBreachChecker(checker *BreachChecker) {
+	m.breach = checker
+}
+
+// SetRunID sets the identifier recorded with every saved password
+// It lets operators match stored passwords to the run that applied them
+func (m *Manager) SetRunID(runID string) {
+	m.runID = runID
+}
+
 // GeneratePasswords creates or retrieves passwords for all replication agreements
 // This method first checks for predefined passwords in the configuration
 // If no predefined password exists, it can generate random passwords or use a default
+// Agreements that bind as the same consumer entry always get the same password,
+// because that entry has only one userPassword: the password chosen for any of
+// them is used for the whole group, and one password is generated per group
 // The returned map uses agreement names as keys for easy lookup
 // This approach gives administrators full control over password management
-func (m *Manager) GeneratePasswords(agreements []ldap.ReplicationAgreement) map[string]string {
+func (m *Manager) GeneratePasswords(agreements []ldap.ReplicationAgreement) (map[string]string, error) {
 	passwords := make(map[string]string)
 
+	// First pass: find the password chosen for each consumer entry
+	// Within a group the source with the highest precedence wins
+	chosen := make(map[string]*passwordChoice)
 	for _, agreement := range agreements {
-		// Only use predefined passwords, or default if not specified
-		password := ""
-		if predefinedPassword, exists := m.config.Password.PredefinedPasswords[agreement.Name]; exists && predefinedPassword != "" {
-			password = predefinedPassword
-			fmt.Printf("Password for agreement '%s': using predefined password\n", agreement.Name)
-		} else if m.config.Password.DefaultPassword != "" {
-			password = m.config.Password.DefaultPassword
-			fmt.Printf("Password for agreement '%s': using default password\n", agreement.Name)
-		} else {
-			fmt.Printf("Password for agreement '%s': ERROR - no predefined or default password found!\n", agreement.Name)
+		choice, err := m.choosePassword(agreement.Name)
+		if err != nil {
+			return nil, err
+		}
+		if choice == nil {
+			continue
+		}
+		entry := agreement.ConsumerEntry()
+		previous, exists := chosen[entry]
+		switch {
+		case !exists || sourceRank[choice.source] > sourceRank[previous.source]:
+			chosen[entry] = choice
+		case sourceRank[choice.source] == sourceRank[previous.source] && choice.password != previous.password:
+			return nil, fmt.Errorf("agreements %s and %s bind as the same consumer entry but have different %s passwords; they must share one password",
+				previous.agreement, agreement.Name, choice.source)
 		}
-		passwords[agreement.Name] = password
 	}
 
-	return passwords
+	// Second pass: generate missing passwords, check and report them
+	for _, agreement := range agreements {
+		entry := agreement.ConsumerEntry()
+		choice, exists := chosen[entry]
+		if !exists && m.config.Password.GenerateRandom {
+			generated, generatedBits, err := m.generatePassword(agreement.Name)
+			if err != nil {
+				return nil, fmt.Errorf("failed to generate password for agreement %s: %v", agreement.Name, err)
+			}
+			choice = &passwordChoice{password: generated, source: "generated", agreement: agreement.Name, bits: generatedBits, checked: true}
+			chosen[entry] = choice
+		}
+
+		if choice == nil {
+			m.logger.Error("No predefined or default password found", "agreement", agreement.Name)
+			fmt.Fprintf(m.out, "Password for agreement '%s': ERROR - no predefined or default password found!\n", agreement.Name)
+			passwords[agreement.Name] = ""
+			m.sources[agreement.Name] = ""
+			continue
+		}
+
+		// Passwords chosen by people are checked against the consumer
+		// policy, the entropy threshold and the breach corpus;
+		// generated ones already comply
+		if choice.source != "generated" {
+			policy := m.serverPolicy(agreement.Name)
+			if err := policy.Check(choice.password); err != nil {
+				return nil, fmt.Errorf("the %s password for agreement %s does not satisfy the consumer password policy (%s): %v",
+					choice.source, agreement.Name, policy.Describe(), err)
+			}
+			if !choice.checked {
+				strength, err := m.checkChosenPassword(fmt.Sprintf("the %s password for agreement %s", choice.source, choice.agreement), choice.password)
+				if err != nil {
+					return nil, err
+				}
+				choice.bits = strength.Bits
+				choice.checked = true
+			}
+		}
+
+		source := choice.source
+		shared := ""
+		if choice.agreement != agreement.Name {
+			source = choice.source + " (shared)"
+			shared = fmt.Sprintf(", shared with '%s' through the same consumer entry", choice.agreement)
+		}
+		fmt.Fprintf(m.out, "Password for agreement '%s': using %s password (%s, %.1f bits of entropy)%s\n",
+			agreement.Name, choice.source, StrengthLabel(choice.bits), choice.bits, shared)
+		if err := m.checkReuse(agreement.Name, choice.password, choice.source); err != nil {
+			return nil, err
+		}
+		passwords[agreement.Name] = choice.password
+		m.sources[agreement.Name] = source
+		m.logger.Debug("Password chosen", "agreement", agreement.Name, "source", source,
+			"entropy_bits", choice.bits, "consumer_entry", entry)
+	}
+
+	if err := m.checkSharedPasswords(agreements, passwords); err != nil {
+		return nil, err
+	}
+
+	return passwords, nil
 }
 
-// generateSecurePassword creates a cryptographically secure password
-// This function uses Go's crypto/rand package for true randomness
-// It respects all configuration settings for character types and length
-// The password generation follows security best practices
-// Understanding this helps administrators see how secure passwords are created
-func (m *Manager) generateSecurePassword() (string, error) {
-	// Build character set based on configuration
-	// This allows administrators to control password complexity
-	var charset string
+// passwordChoice is the password selected for one consumer entry
+// agreement names the agreement whose configuration provided it
+type passwordChoice struct {
+	password  string
+	source    string
+	agreement string
+	bits      float64
+	checked   bool
+}
 
-	// Add lowercase letters if enabled
-	if m.config.Password.IncludeLowercase {
-		charset += "abcdefghijklmnopqrstuvwxyz"
+// sourceRank orders the password sources by precedence
+// A predefined password for one agreement of a group beats a stored or
+// default password of another agreement in the same group
+var sourceRank = map[string]int{
+	"default":      1,
+	"secret store": 2,
+	"predefined":   3,
+}
+
+// choosePassword returns the password configured or stored for an agreement
+// Sources are checked in order of precedence: config file, secret store, default
+// It returns nil when the agreement has no such password
+func (m *Manager) choosePassword(agreementName string) (*passwordChoice, error) {
+	if predefinedPassword, exists := m.config.Password.PredefinedPasswords[agreementName]; exists && predefinedPassword != "" {
+		return &passwordChoice{password: predefinedPassword, source: "predefined", agreement: agreementName}, nil
 	}
+	stored, err := m.lookupStoredPassword(agreementName)
+	if err != nil {
+		return nil, err
+	}
+	if stored != "" {
+		return &passwordChoice{password: stored, source: "secret store", agreement: agreementName}, nil
+	}
+	if m.config.Password.DefaultPassword != "" {
+		return &passwordChoice{password: m.config.Password.DefaultPassword, source: "default", agreement: agreementName}, nil
+	}
+	return nil, nil
+}
 
-	// Add uppercase letters if enabled
-	if m.config.Password.IncludeUppercase {
-		charset += "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+// checkReuse refuses a password that was applied to the same agreement
+// within the last N rotations according to the password history
+// This catches predefined and default passwords that were never changed
+func (m *Manager) checkReuse(agreementName, password, source string) error {
+	if m.history == nil {
+		return nil
+	}
+	reused, err := m.history.Contains(agreementName, password)
+	if err != nil {
+		return err
 	}
+	if reused {
+		return fmt.Errorf("the %s password for agreement %s was already used within the last %d rotations; choose a new one",
+			source, agreementName, m.config.Password.Reuse.HistoryDepth)
+	}
+	return nil
+}
 
-	// Add numbers if enabled
-	if m.config.Password.IncludeNumbers {
-		charset += "0123456789"
+// checkSharedPasswords looks for agreements that would get the same password
+// Depending on the shared policy this prints a warning or stops the run
+// A shared password means one leaked credential opens several agreements
+// Agreements binding as the same consumer entry must share their password,
+// so only passwords used by more than one consumer entry are reported
+func (m *Manager) checkSharedPasswords(agreements []ldap.ReplicationAgreement, passwords map[string]string) error {
+	if m.config.Password.Reuse.SharedPolicy == "allow" {
+		return nil
 	}
 
-	// Add special characters if enabled
-	if m.config.Password.IncludeSpecial {
-		charset += "!@#$%^&*()_+-=[]{}|;:,.<>?"
+	byPassword := make(map[string][]string)
+	entries := make(map[string]map[string]bool)
+	for _, agreement := range agreements {
+		password := passwords[agreement.Name]
+		if password == "" {
+			continue
+		}
+		byPassword[password] = append(byPassword[password], agreement.Name)
+		if entries[password] == nil {
+			entries[password] = make(map[string]bool)
+		}
+		entries[password][agreement.ConsumerEntry()] = true
 	}
 
-	// Remove excluded characters to avoid confusion
-	// This helps prevent issues with characters that look similar
-	for _, char := range m.config.Password.ExcludeChars {
-		charset = strings.ReplaceAll(charset, string(char), "")
+	var problems []string
+	for password, names := range byPassword {
+		if len(entries[password]) > 1 {
+			sort.Strings(names)
+			problems = append(problems, strings.Join(names, ", "))
+		}
 	}
+	sort.Strings(problems)
 
-	// Ensure we have characters to work with
-	if len(charset) == 0 {
-		return "", fmt.Errorf("no characters available for password generation")
+	for _, problem := range problems {
+		if m.config.Password.Reuse.SharedPolicy == "strict" {
+			return fmt.Errorf("agreements %s would share the same password across different consumer entries (shared_policy is strict)", problem)
+		}
+		fmt.Fprintf(m.out, "WARNING: agreements %s would share the same password across different consumer entries\n", problem)
 	}
+	return nil
+}
 
-	// Generate password of specified length
-	password := make([]byte, m.config.Password.Length)
-	charsetLen := big.NewInt(int64(len(charset)))
+// lookupStoredPassword asks the secret store for an agreement password
+// Without a configured store there is never a stored password
+func (m *Manager) lookupStoredPassword(agreementName string) (string, error) {
+	if m.store == nil {
+		return "", nil
+	}
+	password, err := m.store.AgreementPassword(agreementName)
+	if err != nil {
+		return "", fmt.Errorf("failed to read password for agreement %s from secret store: %v", agreementName, err)
+	}
+	return password, nil
+}
 
-	// Use cryptographically secure random number generation
-	// This ensures passwords cannot be predicted or reproduced
-	for i := range password {
-		randomIndex, err := rand.Int(rand.Reader, charsetLen)
-		if err != nil {
-			return "", fmt.Errorf("failed to generate random number: %v", err)
+// RecordRotation is called after an agreement password was applied successfully
+// It saves the new password to the local vault file, the reuse history
+// and the secret store
+// Passwords that were read unchanged from the secret store are not written back
+// A failure here does not undo the rotation, so callers should report it loudly
+func (m *Manager) RecordRotation(agreement ldap.ReplicationAgreement, password string) error {
+	now := time.Now().UTC()
+
+	if m.localVault != nil {
+		entry := VaultEntry{
+			Password:  password,
+			CreatedAt: now,
+			RunID:     m.runID,
+			Supplier:  agreement.Supplier,
+			Consumer:  agreement.Consumer,
+		}
+		if err := m.localVault.Record(agreement.Name, entry); err != nil {
+			return err
+		}
+	}
+
+	if m.history != nil {
+		if err := m.history.Add(agreement.Name, password, m.runID); err != nil {
+			return err
 		}
-		password[i] = charset[randomIndex.Int64()]
 	}
 
-	// Validate that the generated password meets requirements
-	// This ensures we don't return passwords that don't meet policy
-	if err := m.validatePassword(string(password)); err != nil {
-		// If validation fails, try again (recursive call)
-		// This handles edge cases where random generation doesn't meet requirements
-		return m.generateSecurePassword()
+	if m.store == nil || m.sources[agreement.Name] == "secret store" {
+		return nil
 	}
 
-	return string(password), nil
+	operator := os.Getenv("USER")
+	if current, err := user.Current(); err == nil {
+		operator = current.Username
+	}
+	metadata := map[string]string{
+		"rotated_at": now.Format(time.RFC3339),
+		"rotated_by": operator,
+		"run_id":     m.runID,
+		"supplier":   agreement.Supplier,
+		"consumer":   agreement.Consumer,
+		"source":     m.sources[agreement.Name],
+	}
+
+	if err := m.store.StoreAgreementPassword(agreement.Name, password, metadata); err != nil {
+		return err
+	}
+	m.logger.Info("Password saved to the secret store", "agreement", agreement.Name)
+	return nil
 }
 
-// validatePassword ensures a password meets all requirements
-// This function checks that the password contains required character types
-// It prevents weak passwords from being generated
-// The validation logic matches the generation configuration
-// This double-check ensures password policy compliance
-func (m *Manager) validatePassword(password string) error {
-	// Check minimum length
-	if len(password) < m.config.Password.Length {
-		return fmt.Errorf("password too short")
+// generatePassword creates a new password in the configured mode
+// Random mode produces a character string, passphrase mode a list of words
+// The entropy in bits is returned so the strength can be reported
+func (m *Manager) generatePassword(agreementName string) (string, float64, error) {
+	if m.config.Password.Mode == "passphrase" {
+		// The character class settings describe random passwords, so a
+		// passphrase only has to meet the length and the consumer policy
+		policy := m.serverPolicy(agreementName)
+		policy.MinLength = maxInt(policy.MinLength, m.config.Password.Length)
+		return m.generatePassphrase(policy)
 	}
 
-	// Check for required character types
-	hasLower := false
-	hasUpper := false
-	hasNumber := false
-	hasSpecial := false
+	return m.generateSecurePassword(m.generationPolicy(agreementName))
+}
 
-	for _, char := range password {
-		switch {
-		case char >= 'a' && char <= 'z':
-			hasLower = true
-		case char >= 'A' && char <= 'Z':
-			hasUpper = true
-		case char >= '0' && char <= '9':
-			hasNumber = true
-		case strings.ContainsRune("!@#$%^&*()_+-=[]{}|;:,.<>?", char):
-			hasSpecial = true
+// generateSecurePassword creates a cryptographically secure password
+// This function uses Go's crypto/rand package for true randomness
+// It respects all configuration settings for character types and length
+// The password generation follows security best practices
+// Understanding this helps administrators see how secure passwords are created
+// It also returns the entropy in bits, computed from the charset and length
+func (m *Manager) generateSecurePassword(policy Policy) (string, float64, error) {
+	// Build character sets based on configuration
+	// This allows administrators to control password complexity
+	// A class is also used when the consumer policy requires it
+	exclude := func(chars string) string {
+		for _, char := range m.config.Password.ExcludeChars {
+			chars = strings.ReplaceAll(chars, string(char), "")
 		}
+		return chars
+	}
+	classes := []struct {
+		name    string
+		chars   string
+		enabled bool
+		minimum int
+	}{
+		{"lowercase", exclude(lowerChars), m.config.Password.IncludeLowercase, policy.MinLowers},
+		{"uppercase", exclude(upperChars), m.config.Password.IncludeUppercase, policy.MinUppers},
+		{"numbers", exclude(digitChars), m.config.Password.IncludeNumbers, policy.MinDigits},
+		{"special", exclude(specialChars), m.config.Password.IncludeSpecial, policy.MinSpecials},
+	}
+
+	if policy.Min8Bit > 0 {
+		return "", 0, fmt.Errorf("consumer policy requires 8-bit characters, which the generator does not produce")
 	}
 
-	// Validate required character types are present
-	if m.config.Password.IncludeLowercase && !hasLower {
-		return fmt.Errorf("password missing lowercase letters")
+	// Start with the characters each class requires, then add one character
+	// from further classes until enough categories are represented
+	// Every enabled class is already required, so a further class is one the
+	// configuration disables; the consumer would reject the password without
+	// it, so it is used anyway and the operator is warned
+	var charset string
+	var password []byte
+	categories := 0
+	for _, class := range classes {
+		if class.minimum > 0 && class.chars == "" {
+			return "", 0, fmt.Errorf("policy requires characters that are all excluded by exclude_chars")
+		}
+		if class.enabled || class.minimum > 0 {
+			charset += class.chars
+		}
+		if class.minimum > 0 {
+			if !class.enabled {
+				m.logger.Warn("Consumer policy requires a character class the configuration disables, using it anyway",
+					"class", class.name, "minimum", class.minimum, "policy", policy.Describe())
+			}
+			categories++
+			required, err := randomChars(class.chars, class.minimum)
+			if err != nil {
+				return "", 0, err
+			}
+			password = append(password, required...)
+		}
 	}
-	if m.config.Password.IncludeUppercase && !hasUpper {
-		return fmt.Errorf("password missing uppercase letters")
+	for _, class := range classes {
+		if categories >= policy.MinCategories {
+			break
+		}
+		if class.minimum == 0 && class.chars != "" {
+			m.logger.Warn("Consumer policy requires more character categories than the configuration enables, adding a disabled class",
+				"class", class.name, "min_categories", policy.MinCategories, "policy", policy.Describe())
+			extra, err := randomChars(class.chars, 1)
+			if err != nil {
+				return "", 0, err
+			}
+			password = append(password, extra...)
+			charset += class.chars
+			categories++
+		}
+	}
+	if letters := policy.MinAlphas - policy.MinLowers - policy.MinUppers; letters > 0 {
+		extra, err := randomChars(exclude(lowerChars+upperChars), letters)
+		if err != nil {
+			return "", 0, err
+		}
+		password = append(password, extra...)
+	}
+
+	// Ensure we have characters to work with
+	if len(charset) == 0 {
+		return "", 0, fmt.Errorf("no characters available for password generation")
 	}
-	if m.config.Password.IncludeNumbers && !hasNumber {
-		return fmt.Errorf("password missing numbers")
+	if categories < policy.MinCategories {
+		return "", 0, fmt.Errorf("policy requires %d character categories but only %d are available", policy.MinCategories, categories)
 	}
-	if m.config.Password.IncludeSpecial && !hasSpecial {
-		return fmt.Errorf("password missing special characters")
+
+	// Fill up to the required length and shuffle so the required
+	// characters do not always appear at the start
+	// Random passwords occasionally break the repeat or sequence rules,
+	// so a few attempts are made before giving up
+	length := maxInt(policy.MinLength, len(password))
+	for attempt := 0; attempt < 100; attempt++ {
+		fill, err := randomChars(charset, length-len(password))
+		if err != nil {
+			return "", 0, err
+		}
+		candidate := append(append([]byte{}, password...), fill...)
+		if err := shuffle(candidate); err != nil {
+			return "", 0, err
+		}
+
+		// Validate that the generated password meets requirements
+		// This ensures we don't return passwords that don't meet policy
+		if policy.Check(string(candidate)) == nil {
+			return string(candidate), GeneratedEntropy(len(charset), length), nil
+		}
 	}
 
-	return nil
+	return "", 0, fmt.Errorf("could not generate a password satisfying %s", policy.Describe())
 }
 
 // generateFallbackPassword creates a simple password when secure generation fails
@@ -196,51 +524,74 @@ func (m *Manager) generateFallbackPassword(agreementName string) string {
 	return password
 }
 
-// GetPasswordStrength evaluates the strength of a generated password
+// GetPasswordStrength evaluates the strength of a password
 // This method provides feedback on password quality
 // It helps administrators understand if their password policy is adequate
-// The strength assessment considers length, character diversity, and entropy
+// The rating is based on the estimated entropy from EstimateStrength, which
+// looks for dictionary words, keyboard walks, repeats, sequences and dates
 // This educational feature helps users understand password security
 func (m *Manager) GetPasswordStrength(password string) string {
-	score := 0
+	return EstimateStrength(password).Label
+}
 
-	// Length scoring
-	if len(password) >= 12 {
-		score += 2
-	} else if len(password) >= 8 {
-		score += 1
+// ValidateConfiguredPasswords checks the predefined and default passwords
+// from the configuration file against the minimum entropy threshold and
+// the breached password corpus
+// It is called at startup so weak passwords are rejected before any
+// LDAP server is contacted
+func (m *Manager) ValidateConfiguredPasswords() error {
+	names := make([]string, 0, len(m.config.Password.PredefinedPasswords))
+	for name := range m.config.Password.PredefinedPasswords {
+		names = append(names, name)
 	}
+	sort.Strings(names)
 
-	// Character type scoring
-	hasLower := strings.ContainsAny(password, "abcdefghijklmnopqrstuvwxyz")
-	hasUpper := strings.ContainsAny(password, "ABCDEFGHIJKLMNOPQRSTUVWXYZ")
-	hasNumber := strings.ContainsAny(password, "0123456789")
-	hasSpecial := strings.ContainsAny(password, "!@#$%^&*()_+-=[]{}|;:,.<>?")
-
-	if hasLower {
-		score++
+	for _, name := range names {
+		if _, err := m.checkChosenPassword(fmt.Sprintf("predefined password for agreement %s", name), m.config.Password.PredefinedPasswords[name]); err != nil {
+			return err
+		}
 	}
-	if hasUpper {
-		score++
+	if m.config.Password.DefaultPassword != "" {
+		if _, err := m.checkChosenPassword("default password", m.config.Password.DefaultPassword); err != nil {
+			return err
+		}
 	}
-	if hasNumber {
-		score++
+	return nil
+}
+
+// checkChosenPassword runs every check for a password chosen by a person:
+// the entropy threshold and, when configured, the breached password corpus
+func (m *Manager) checkChosenPassword(description, password string) (StrengthResult, error) {
+	strength, err := m.checkEntropy(description, password)
+	if err != nil {
+		return strength, err
 	}
-	if hasSpecial {
-		score++
+	if m.breach != nil {
+		breached, err := m.breach.Contains(password)
+		if err != nil {
+			return strength, err
+		}
+		if breached {
+			return strength, fmt.Errorf("%s appears in the breached password corpus; choose a new one", description)
+		}
 	}
+	return strength, nil
+}
 
-	// Return strength assessment
-	switch {
-	case score >= 7:
-		return "Very Strong"
-	case score >= 5:
-		return "Strong"
-	case score >= 3:
-		return "Medium"
-	default:
-		return "Weak"
+// checkEntropy estimates the strength of a user-supplied password and
+// rejects it when it falls below password.min_entropy_bits
+// The error names the weak patterns so the operator knows what to change
+func (m *Manager) checkEntropy(description, password string) (StrengthResult, error) {
+	strength := EstimateStrength(password)
+	if strength.Bits < m.config.Password.MinEntropyBits {
+		reason := ""
+		if len(strength.Patterns) > 0 {
+			reason = " (found " + strings.Join(strength.Patterns, ", ") + ")"
+		}
+		return strength, fmt.Errorf("%s is too weak: %.1f bits of entropy, at least %.0f required%s",
+			description, strength.Bits, m.config.Password.MinEntropyBits, reason)
 	}
+	return strength, nil
 }
 
 // GeneratePasswordPolicy creates a human-readable description of password requirements
@@ -252,6 +603,21 @@ func (m *Manager) GeneratePasswordPolicy() string {
 	var policy strings.Builder
 
 	policy.WriteString(fmt.Sprintf("Password Policy:\n"))
+	if m.config.Password.Mode == "passphrase" {
+		settings := m.config.Password.Passphrase
+		policy.WriteString(fmt.Sprintf("- Passphrase of %d words from the EFF large wordlist, separated by %q\n", settings.Words, settings.Separator))
+		policy.WriteString(fmt.Sprintf("- At least %d characters\n", m.config.Password.Length))
+		if settings.Capitalize {
+			policy.WriteString("- Every word capitalized\n")
+		}
+		if settings.IncludeDigit {
+			policy.WriteString("- Includes a digit\n")
+		}
+		if settings.IncludeSymbol {
+			policy.WriteString("- Includes a symbol\n")
+		}
+		return policy.String()
+	}
 	policy.WriteString(fmt.Sprintf("- Length: %d characters\n", m.config.Password.Length))
 
 	if m.config.Password.IncludeLowercase {
@@ -0,0 +1,120 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/ldap-replication-manager/internal/config"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// historyIterations is the PBKDF2 work factor for new history entries
+// It makes brute-forcing the stored hashes expensive while keeping
+// a check of a few dozen agreements well under a second per password
+const historyIterations = 100000
+
+// History remembers salted hashes of previously applied passwords
+// It is used to refuse a password that was applied to the same agreement
+// within the last N rotations, for example a predefined password that was
+// never changed in the config file
+// Only hashes are stored, so the file does not reveal any password
+type History struct {
+	config config.ReuseConfig
+}
+
+// historyEntry is one remembered password hash
+type historyEntry struct {
+	Salt       []byte    `json:"salt"`
+	Hash       []byte    `json:"hash"`
+	Iterations int       `json:"iterations"`
+	AppliedAt  time.Time `json:"applied_at"`
+	RunID      string    `json:"run_id,omitempty"`
+}
+
+// NewHistory prepares access to the password history file
+// It returns nil when no history file is configured
+func NewHistory(cfg config.ReuseConfig) *History {
+	if cfg.HistoryFile == "" {
+		return nil
+	}
+	return &History{config: cfg}
+}
+
+// Contains reports whether the password was applied to the agreement
+// within the configured number of previous rotations
+func (h *History) Contains(agreementName, password string) (bool, error) {
+	entries, err := h.load()
+	if err != nil {
+		return false, err
+	}
+
+	recent := entries[agreementName]
+	if len(recent) > h.config.HistoryDepth {
+		recent = recent[:h.config.HistoryDepth]
+	}
+	for _, entry := range recent {
+		hash := pbkdf2.Key([]byte(password), entry.Salt, entry.Iterations, sha256.Size, sha256.New)
+		if subtle.ConstantTimeCompare(hash, entry.Hash) == 1 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Add remembers a newly applied password for the agreement
+// Entries older than the configured depth are dropped
+func (h *History) Add(agreementName, password, runID string) error {
+	entries, err := h.load()
+	if err != nil {
+		return err
+	}
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	entry := historyEntry{
+		Salt:       salt,
+		Hash:       pbkdf2.Key([]byte(password), salt, historyIterations, sha256.Size, sha256.New),
+		Iterations: historyIterations,
+		AppliedAt:  time.Now().UTC(),
+		RunID:      runID,
+	}
+
+	list := append([]historyEntry{entry}, entries[agreementName]...)
+	if len(list) > h.config.HistoryDepth {
+		list = list[:h.config.HistoryDepth]
+	}
+	entries[agreementName] = list
+
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := WriteFileAtomic(h.config.HistoryFile, data, 0600); err != nil {
+		return fmt.Errorf("failed to write password history %s: %v", h.config.HistoryFile, err)
+	}
+	return nil
+}
+
+// load reads the history file; a missing file means an empty history
+func (h *History) load() (map[string][]historyEntry, error) {
+	entries := make(map[string][]historyEntry)
+
+	data, err := os.ReadFile(h.config.HistoryFile)
+	if os.IsNotExist(err) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read password history %s: %v", h.config.HistoryFile, err)
+	}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("password history %s is corrupt: %v", h.config.HistoryFile, err)
+	}
+	return entries, nil
+}
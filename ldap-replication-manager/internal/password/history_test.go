@@ -0,0 +1,99 @@
+package password
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ldap-replication-manager/internal/config"
+)
+
+func TestHistory(t *testing.T) {
+	tests := []struct {
+		name      string
+		depth     int
+		applied   []string // applied to to-c1, oldest first
+		agreement string
+		password  string
+		want      bool
+	}{
+		{"empty history", 3, nil, "to-c1", "first", false},
+		{"last password", 3, []string{"first", "second"}, "to-c1", "second", true},
+		{"older password within depth", 3, []string{"first", "second", "third"}, "to-c1", "first", true},
+		{"password beyond depth", 2, []string{"first", "second", "third"}, "to-c1", "first", false},
+		{"other agreement", 3, []string{"first"}, "to-c2", "first", false},
+		{"different password", 3, []string{"first"}, "to-c1", "First", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			history := NewHistory(config.ReuseConfig{
+				HistoryFile:  filepath.Join(t.TempDir(), "history.json"),
+				HistoryDepth: test.depth,
+			})
+			for _, password := range test.applied {
+				if err := history.Add("to-c1", password, "run"); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got, err := history.Contains(test.agreement, test.password)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("Contains(%q, %q) = %v, want %v", test.agreement, test.password, got, test.want)
+			}
+		})
+	}
+}
+
+func TestHistoryStoresOnlySaltedHashes(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "history.json")
+	history := NewHistory(config.ReuseConfig{HistoryFile: file, HistoryDepth: 5})
+	for i := 0; i < 2; i++ {
+		if err := history.Add("to-c1", "Correct-Horse-Battery", "run"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := history.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	list := entries["to-c1"]
+	if len(list) != 2 {
+		t.Fatalf("%d entries, want 2", len(list))
+	}
+	if string(list[0].Salt) == string(list[1].Salt) || string(list[0].Hash) == string(list[1].Hash) {
+		t.Error("the same password must get a fresh salt and a different hash")
+	}
+	if list[0].Iterations != historyIterations || len(list[0].Hash) != 32 {
+		t.Errorf("entry uses %d iterations and a %d byte hash", list[0].Iterations, len(list[0].Hash))
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "Correct-Horse-Battery") {
+		t.Error("history file contains the password")
+	}
+	if info, err := os.Stat(file); err == nil && info.Mode().Perm() != 0600 {
+		t.Errorf("history file mode %v, want 0600", info.Mode().Perm())
+	}
+}
+
+func TestHistoryRejectsCorruptFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "history.json")
+	if err := os.WriteFile(file, []byte("not json"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	history := NewHistory(config.ReuseConfig{HistoryFile: file, HistoryDepth: 5})
+	if _, err := history.Contains("to-c1", "x"); err == nil {
+		t.Error("a corrupt history must be reported, not treated as empty")
+	}
+	if NewHistory(config.ReuseConfig{}) != nil {
+		t.Error("no history file means no history")
+	}
+}
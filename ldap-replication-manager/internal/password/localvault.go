@@ -0,0 +1,296 @@
+package password
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ldap-replication-manager/internal/config"
+	"golang.org/x/crypto/scrypt"
+)
+
+// LocalVault is an encrypted file that keeps every applied agreement password
+// Without it, generated passwords are only printed once and then lost
+// The file stores the current and previous passwords for each agreement
+// together with the time they were applied and the run that applied them
+// Operators use the get, list and history commands to recover a credential
+type LocalVault struct {
+	config config.LocalVaultConfig
+	secret []byte
+}
+
+// VaultEntry is one password version stored in the local vault
+type VaultEntry struct {
+	Password  string    `json:"password"`
+	CreatedAt time.Time `json:"created_at"`
+	RunID     string    `json:"run_id"`
+	Supplier  string    `json:"supplier,omitempty"`
+	Consumer  string    `json:"consumer,omitempty"`
+}
+
+// VaultRecord holds the password versions of a single agreement
+// Previous is ordered newest first and limited by the history setting
+type VaultRecord struct {
+	Current  VaultEntry   `json:"current"`
+	Previous []VaultEntry `json:"previous,omitempty"`
+}
+
+// vaultContents is the decrypted content of the vault file
+type vaultContents struct {
+	Agreements map[string]*VaultRecord `json:"agreements"`
+}
+
+// sealedFile is the on-disk format of an encrypted file
+// Only the ciphertext is secret; the salt and nonce are stored in the clear
+type sealedFile struct {
+	Version    int    `json:"version"`
+	KDF        string `json:"kdf"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// NewLocalVault prepares access to the local vault file
+// It returns nil when no vault file is configured
+// The passphrase or key file is required whenever a file is configured,
+// so misconfiguration is reported before any password is changed
+func NewLocalVault(cfg config.LocalVaultConfig) (*LocalVault, error) {
+	if cfg.File == "" {
+		return nil, nil
+	}
+
+	secret, err := LoadSecret(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("local vault %s needs a passphrase in $%s or a key_file", cfg.File, cfg.PassphraseEnv)
+	}
+
+	return &LocalVault{config: cfg, secret: secret}, nil
+}
+
+// LoadSecret returns the passphrase or key that encrypts local files
+// The key file wins over the passphrase environment variable
+// An empty result means neither is set
+// Other encrypted files, such as the rotation state, use the same secret
+// so operators only have one passphrase to manage
+func LoadSecret(cfg config.LocalVaultConfig) ([]byte, error) {
+	if cfg.KeyFile != "" {
+		data, err := os.ReadFile(cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read local vault key file %s: %v", cfg.KeyFile, err)
+		}
+		return data, nil
+	}
+	return []byte(os.Getenv(cfg.PassphraseEnv)), nil
+}
+
+// Record saves a newly applied password for an agreement
+// The former current password moves to the front of the history
+// The whole file is rewritten atomically so a crash never leaves it half written
+func (v *LocalVault) Record(agreementName string, entry VaultEntry) error {
+	contents, err := v.load()
+	if err != nil {
+		return err
+	}
+
+	record, exists := contents.Agreements[agreementName]
+	if !exists {
+		record = &VaultRecord{}
+		contents.Agreements[agreementName] = record
+	} else {
+		record.Previous = append([]VaultEntry{record.Current}, record.Previous...)
+		if len(record.Previous) > v.config.History {
+			record.Previous = record.Previous[:v.config.History]
+		}
+	}
+	record.Current = entry
+
+	return v.save(contents)
+}
+
+// Get returns the stored password versions of one agreement
+func (v *LocalVault) Get(agreementName string) (*VaultRecord, error) {
+	contents, err := v.load()
+	if err != nil {
+		return nil, err
+	}
+	record, exists := contents.Agreements[agreementName]
+	if !exists {
+		return nil, fmt.Errorf("no password stored for agreement %s", agreementName)
+	}
+	return record, nil
+}
+
+// List returns all agreements in the vault sorted by name
+func (v *LocalVault) List() (map[string]*VaultRecord, []string, error) {
+	contents, err := v.load()
+	if err != nil {
+		return nil, nil, err
+	}
+	names := make([]string, 0, len(contents.Agreements))
+	for name := range contents.Agreements {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return contents.Agreements, names, nil
+}
+
+// load decrypts the vault file
+// A vault file that does not exist yet is treated as empty
+func (v *LocalVault) load() (*vaultContents, error) {
+	contents := &vaultContents{Agreements: make(map[string]*VaultRecord)}
+
+	data, err := os.ReadFile(v.config.File)
+	if os.IsNotExist(err) {
+		return contents, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read local vault %s: %v", v.config.File, err)
+	}
+
+	plaintext, err := OpenSealed(v.secret, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt local vault %s: %v", v.config.File, err)
+	}
+	if err := json.Unmarshal(plaintext, contents); err != nil {
+		return nil, fmt.Errorf("local vault %s is corrupt: %v", v.config.File, err)
+	}
+	if contents.Agreements == nil {
+		contents.Agreements = make(map[string]*VaultRecord)
+	}
+	return contents, nil
+}
+
+// save encrypts and writes the vault file with owner-only permissions
+func (v *LocalVault) save(contents *vaultContents) error {
+	plaintext, err := json.Marshal(contents)
+	if err != nil {
+		return err
+	}
+	sealed, err := Seal(v.secret, plaintext)
+	if err != nil {
+		return err
+	}
+	if err := WriteFileAtomic(v.config.File, sealed, 0600); err != nil {
+		return fmt.Errorf("failed to write local vault %s: %v", v.config.File, err)
+	}
+	return nil
+}
+
+// Seal encrypts data with AES-256-GCM
+// The key is derived from the secret with scrypt and a fresh random salt,
+// so the same passphrase never produces the same key twice
+func Seal(secret, plaintext []byte) ([]byte, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(secret, salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return json.MarshalIndent(sealedFile{
+		Version:    1,
+		KDF:        "scrypt",
+		Salt:       salt,
+		Nonce:      nonce,
+		Ciphertext: gcm.Seal(nil, nonce, plaintext, nil),
+	}, "", "  ")
+}
+
+// OpenSealed decrypts data produced by Seal
+// A wrong passphrase and a tampered file both fail GCM authentication
+func OpenSealed(secret, data []byte) ([]byte, error) {
+	var sealed sealedFile
+	if err := json.Unmarshal(data, &sealed); err != nil {
+		return nil, fmt.Errorf("unrecognized file format: %v", err)
+	}
+	if sealed.Version != 1 || sealed.KDF != "scrypt" {
+		return nil, fmt.Errorf("unsupported format version %d (%s)", sealed.Version, sealed.KDF)
+	}
+	gcm, err := newGCM(secret, sealed.Salt)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := gcm.Open(nil, sealed.Nonce, sealed.Ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("wrong passphrase or damaged file")
+	}
+	return plaintext, nil
+}
+
+// newGCM derives a 256-bit key from the secret and returns an AES-GCM cipher
+func newGCM(secret, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key(secret, salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, fmt.Errorf("key derivation failed: %v", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// WriteFileAtomic writes a file through a temporary file and a rename
+// Readers never see a partially written file, even if the process is killed
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	return writeFileAtomicWith(path, perm, func(file *os.File) error {
+		_, err := file.Write(data)
+		return err
+	})
+}
+
+// writeFileAtomicWith is WriteFileAtomic for contents written piece by piece
+// by write, for files too large to hold in memory at once
+func writeFileAtomicWith(path string, perm os.FileMode, write func(*os.File) error) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// MaskPassword hides most of a password for display
+// Only the first and last character are shown so entries can be told apart
+func MaskPassword(password string) string {
+	if len(password) <= 4 {
+		return strings.Repeat("*", len(password))
+	}
+	return password[:1] + strings.Repeat("*", len(password)-2) + password[len(password)-1:]
+}
@@ -0,0 +1,204 @@
+package password
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ldap-replication-manager/internal/config"
+)
+
+func TestSealRoundTrip(t *testing.T) {
+	secret := []byte("vault passphrase")
+	plaintext := []byte(`{"agreements":{}}`)
+
+	first, err := Seal(secret, plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := Seal(secret, plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(first) == string(second) {
+		t.Error("sealing twice must use a fresh salt and nonce")
+	}
+	if strings.Contains(string(first), "agreements") {
+		t.Error("sealed data contains the plaintext")
+	}
+
+	opened, err := OpenSealed(secret, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(opened) != string(plaintext) {
+		t.Errorf("OpenSealed() = %q, want %q", opened, plaintext)
+	}
+}
+
+func TestOpenSealedFails(t *testing.T) {
+	secret := []byte("vault passphrase")
+	sealed, err := Seal(secret, []byte("secret data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// modify decodes the sealed file, changes it and encodes it again
+	modify := func(change func(file *sealedFile)) []byte {
+		var file sealedFile
+		if err := json.Unmarshal(sealed, &file); err != nil {
+			t.Fatal(err)
+		}
+		change(&file)
+		data, err := json.Marshal(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	tests := []struct {
+		name   string
+		secret []byte
+		data   []byte
+	}{
+		{"wrong passphrase", []byte("other passphrase"), sealed},
+		{"tampered ciphertext", secret, modify(func(file *sealedFile) { file.Ciphertext[0] ^= 1 })},
+		{"tampered salt", secret, modify(func(file *sealedFile) { file.Salt[0] ^= 1 })},
+		{"tampered nonce", secret, modify(func(file *sealedFile) { file.Nonce[0] ^= 1 })},
+		{"unknown version", secret, modify(func(file *sealedFile) { file.Version = 2 })},
+		{"unknown KDF", secret, modify(func(file *sealedFile) { file.KDF = "pbkdf2" })},
+		{"not a sealed file", secret, []byte("plain text")},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if opened, err := OpenSealed(test.secret, test.data); err == nil {
+				t.Errorf("OpenSealed() = %q, want an error", opened)
+			}
+		})
+	}
+}
+
+func TestLocalVault(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "vault", "passwords.vault")
+	t.Setenv("LRM_TEST_VAULT_PASSPHRASE", "vault passphrase")
+	cfg := config.LocalVaultConfig{File: file, PassphraseEnv: "LRM_TEST_VAULT_PASSPHRASE", History: 2}
+	vault, err := NewLocalVault(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	applied := time.Date(2026, 10, 18, 13, 54, 42, 0, time.UTC)
+	for i, password := range []string{"first", "second", "third", "fourth"} {
+		entry := VaultEntry{Password: password, CreatedAt: applied.Add(time.Duration(i) * time.Hour), RunID: "run"}
+		if err := vault.Record("to-c1", entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := vault.Record("to-a1", VaultEntry{Password: "other"}); err != nil {
+		t.Fatal(err)
+	}
+
+	record, err := vault.Get("to-c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Current.Password != "fourth" || !record.Current.CreatedAt.Equal(applied.Add(3*time.Hour)) {
+		t.Errorf("current entry %+v", record.Current)
+	}
+	if len(record.Previous) != 2 || record.Previous[0].Password != "third" || record.Previous[1].Password != "second" {
+		t.Errorf("previous entries %+v, want third and second, newest first", record.Previous)
+	}
+
+	_, names, err := vault.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(names, ",") != "to-a1,to-c1" {
+		t.Errorf("List() names %v", names)
+	}
+	if _, err := vault.Get("to-x1"); err == nil {
+		t.Error("an unknown agreement must be reported")
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "fourth") {
+		t.Error("vault file contains a password")
+	}
+	if info, err := os.Stat(file); err == nil && info.Mode().Perm() != 0600 {
+		t.Errorf("vault file mode %v, want 0600", info.Mode().Perm())
+	}
+
+	// The same file opened with another passphrase must not be readable
+	t.Setenv("LRM_TEST_VAULT_PASSPHRASE", "other passphrase")
+	other, err := NewLocalVault(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.Get("to-c1"); err == nil {
+		t.Error("a wrong passphrase must not open the vault")
+	}
+}
+
+func TestLocalVaultSecret(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(keyFile, []byte("key file secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("LRM_TEST_VAULT_PASSPHRASE", "passphrase")
+
+	tests := []struct {
+		name    string
+		cfg     config.LocalVaultConfig
+		secret  string
+		wantErr bool
+	}{
+		{"key file wins", config.LocalVaultConfig{File: "v", PassphraseEnv: "LRM_TEST_VAULT_PASSPHRASE", KeyFile: keyFile}, "key file secret", false},
+		{"passphrase", config.LocalVaultConfig{File: "v", PassphraseEnv: "LRM_TEST_VAULT_PASSPHRASE"}, "passphrase", false},
+		{"no secret", config.LocalVaultConfig{File: "v", PassphraseEnv: "LRM_TEST_VAULT_UNSET"}, "", true},
+		{"missing key file", config.LocalVaultConfig{File: "v", KeyFile: keyFile + ".missing"}, "", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			vault, err := NewLocalVault(test.cfg)
+			if test.wantErr {
+				if err == nil {
+					t.Error("want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(vault.secret) != test.secret {
+				t.Errorf("secret %q, want %q", vault.secret, test.secret)
+			}
+		})
+	}
+
+	if vault, err := NewLocalVault(config.LocalVaultConfig{}); vault != nil || err != nil {
+		t.Error("no vault file means no local vault")
+	}
+}
+
+func TestMaskPassword(t *testing.T) {
+	tests := map[string]string{
+		"":          "",
+		"abcd":      "****",
+		"abcde":     "a***e",
+		"s3cret-pw": "s*******w",
+	}
+	for password, want := range tests {
+		if got := MaskPassword(password); got != want {
+			t.Errorf("MaskPassword(%q) = %q, want %q", password, got, want)
+		}
+	}
+}
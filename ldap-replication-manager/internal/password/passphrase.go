@@ -0,0 +1,192 @@
+package password
+
+import (
+	_ "embed"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+)
+
+// effWordlistData is the EFF large wordlist for diceware passphrases
+// Each line holds the five dice rolls and the word, separated by a tab
+// Source: https://www.eff.org/files/2016/07/18/eff_large_wordlist.txt (CC BY 3.0 US)
+//
+//go:embed eff_large_wordlist.txt
+var effWordlistData string
+
+// passphraseSymbols are the symbols inserted into passphrases when required
+// They are easy to find on any keyboard layout
+const passphraseSymbols = "!@#$%&*?"
+
+var (
+	wordlistOnce sync.Once
+	wordlist     []string
+)
+
+// effWordlist returns the words of the embedded EFF list
+// The list is parsed once on first use
+func effWordlist() []string {
+	wordlistOnce.Do(func() {
+		for _, line := range strings.Split(effWordlistData, "\n") {
+			fields := strings.Fields(line)
+			if len(fields) == 2 {
+				wordlist = append(wordlist, fields[1])
+			}
+		}
+	})
+	return wordlist
+}
+
+// generatePassphrase creates a diceware-style passphrase
+// Random words are much easier to read out and type by hand than random
+// characters, while still being very hard to guess
+// Capital letters, digits and symbols are added only when the settings or the
+// consumer password policy require them, and the passphrase grows by one word
+// at a time until it is long enough
+// It returns the passphrase and its entropy in bits
+func (m *Manager) generatePassphrase(policy Policy) (string, float64, error) {
+	settings := m.config.Password.Passphrase
+	words := effWordlist()
+	if len(words) == 0 {
+		return "", 0, fmt.Errorf("embedded wordlist is empty")
+	}
+
+	// Count the special characters the separator contributes per boundary
+	separatorSpecials := 0
+	for _, char := range settings.Separator {
+		if !strings.ContainsRune(lowerChars+upperChars+digitChars, char) {
+			separatorSpecials++
+		}
+	}
+
+	wordCount := settings.Words
+	for attempt := 0; attempt < 100; attempt++ {
+		// Work out what has to be injected to satisfy the policy
+		digits := policy.MinDigits
+		if settings.IncludeDigit && digits == 0 {
+			digits = 1
+		}
+		symbols := policy.MinSpecials - separatorSpecials*(wordCount-1)
+		if settings.IncludeSymbol && symbols < 1 {
+			symbols = 1
+		}
+		if symbols < 0 {
+			symbols = 0
+		}
+		capitals := policy.MinUppers
+		if settings.Capitalize {
+			capitals = wordCount
+		}
+
+		// Add further categories until the policy minimum is reached
+		// A separator such as "-" or " " already provides the special characters
+		specials := separatorSpecials > 0 && wordCount > 1 || symbols > 0
+		categories := 1 // lowercase letters
+		for _, present := range []bool{specials, digits > 0, capitals > 0} {
+			if present {
+				categories++
+			}
+		}
+		for categories < policy.MinCategories {
+			switch {
+			case digits == 0:
+				digits = 1
+			case !specials:
+				symbols = 1
+				specials = true
+			case capitals == 0:
+				capitals = 1
+			default:
+				return "", 0, fmt.Errorf("passphrases cannot satisfy %d character categories", policy.MinCategories)
+			}
+			categories++
+		}
+		if capitals > wordCount {
+			wordCount = capitals
+			continue
+		}
+
+		passphrase, bits, err := buildPassphrase(words, wordCount, capitals, digits, symbols, settings.Capitalize, settings.Separator, m.config.Password.ExcludeChars)
+		if err != nil {
+			return "", 0, err
+		}
+
+		// Too short for the length rule: try again with one more word
+		if len(passphrase) < policy.MinLength {
+			wordCount++
+			continue
+		}
+		if policy.Check(passphrase) == nil {
+			return passphrase, bits, nil
+		}
+	}
+
+	return "", 0, fmt.Errorf("could not generate a passphrase satisfying %s", policy.Describe())
+}
+
+// buildPassphrase picks the words and injects the requested characters
+// Injected digits and symbols are appended to randomly chosen words
+// The entropy counts the words plus the choice and position of each injection
+func buildPassphrase(words []string, wordCount, capitals, digits, symbols int, capitalizeAll bool, separator, exclude string) (string, float64, error) {
+	picked := make([]string, wordCount)
+	for i := range picked {
+		index, err := randomIndex(len(words))
+		if err != nil {
+			return "", 0, err
+		}
+		picked[i] = words[index]
+	}
+	bits := float64(wordCount) * math.Log2(float64(len(words)))
+
+	// Capitalize every word, or only as many randomly chosen words as required
+	if capitalizeAll {
+		for i := range picked {
+			picked[i] = strings.ToUpper(picked[i][:1]) + picked[i][1:]
+		}
+	} else {
+		for n := 0; n < capitals; n++ {
+			index, err := randomIndex(wordCount)
+			if err != nil {
+				return "", 0, err
+			}
+			if picked[index][:1] == strings.ToUpper(picked[index][:1]) {
+				n-- // already capitalized, pick another word
+				continue
+			}
+			picked[index] = strings.ToUpper(picked[index][:1]) + picked[index][1:]
+			bits += math.Log2(float64(wordCount))
+		}
+	}
+
+	// Inject digits and symbols, avoiding excluded characters
+	inject := func(charset string, count int) error {
+		for _, char := range exclude {
+			charset = strings.ReplaceAll(charset, string(char), "")
+		}
+		if count > 0 && charset == "" {
+			return fmt.Errorf("all injectable characters are excluded by exclude_chars")
+		}
+		for n := 0; n < count; n++ {
+			chars, err := randomChars(charset, 1)
+			if err != nil {
+				return err
+			}
+			index, err := randomIndex(wordCount)
+			if err != nil {
+				return err
+			}
+			picked[index] += string(chars)
+			bits += math.Log2(float64(len(charset))) + math.Log2(float64(wordCount))
+		}
+		return nil
+	}
+	if err := inject(digitChars, digits); err != nil {
+		return "", 0, err
+	}
+	if err := inject(passphraseSymbols, symbols); err != nil {
+		return "", 0, err
+	}
+
+	return strings.Join(picked, separator), bits, nil
+}
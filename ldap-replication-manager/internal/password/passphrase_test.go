@@ -0,0 +1,202 @@
+package password
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"unicode"
+
+	"github.com/ldap-replication-manager/internal/config"
+)
+
+// wordBits is the entropy of one word of the EFF large wordlist
+var wordBits = math.Log2(7776)
+
+func newPassphraseManager(settings config.PassphraseConfig) *Manager {
+	cfg := &config.Config{}
+	cfg.Password.Mode = "passphrase"
+	cfg.Password.Passphrase = settings
+	return NewManager(cfg)
+}
+
+func TestEFFWordlist(t *testing.T) {
+	words := effWordlist()
+	if len(words) != 7776 {
+		t.Fatalf("%d words, want 7776 (6^5 dice rolls)", len(words))
+	}
+	seen := make(map[string]bool)
+	for _, word := range words {
+		if seen[word] {
+			t.Errorf("%q listed twice", word)
+		}
+		seen[word] = true
+		if word != strings.ToLower(word) {
+			t.Errorf("%q is not lowercase", word)
+		}
+	}
+}
+
+func TestGeneratePassphrase(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings config.PassphraseConfig
+		policy   Policy
+		words    int     // words expected when split on the separator, 0 to skip
+		bits     float64 // exact entropy
+		check    func(t *testing.T, passphrase string)
+	}{
+		{
+			name:     "plain words",
+			settings: config.PassphraseConfig{Words: 6, Separator: " "},
+			words:    6,
+			bits:     6 * wordBits,
+		},
+		{
+			name:     "dot separator",
+			settings: config.PassphraseConfig{Words: 4, Separator: "."},
+			words:    4,
+			bits:     4 * wordBits,
+		},
+		{
+			name:     "no separator",
+			settings: config.PassphraseConfig{Words: 5, Separator: ""},
+			bits:     5 * wordBits,
+			check: func(t *testing.T, passphrase string) {
+				if strings.ContainsAny(passphrase, " .") {
+					t.Errorf("%q has a separator", passphrase)
+				}
+			},
+		},
+		{
+			name:     "capitalize every word",
+			settings: config.PassphraseConfig{Words: 5, Separator: " ", Capitalize: true},
+			words:    5,
+			bits:     5 * wordBits,
+			check: func(t *testing.T, passphrase string) {
+				for _, word := range strings.Fields(passphrase) {
+					if !unicode.IsUpper([]rune(word)[0]) {
+						t.Errorf("word %q not capitalized", word)
+					}
+				}
+			},
+		},
+		{
+			// A digit costs its value and the word it is appended to
+			name:     "include digit",
+			settings: config.PassphraseConfig{Words: 6, Separator: " ", IncludeDigit: true},
+			words:    6,
+			bits:     6*wordBits + math.Log2(10) + math.Log2(6),
+			check:    countChars(digitChars, 1),
+		},
+		{
+			name:     "include symbol",
+			settings: config.PassphraseConfig{Words: 6, Separator: " ", IncludeSymbol: true},
+			words:    6,
+			bits:     6*wordBits + math.Log2(float64(len(passphraseSymbols))) + math.Log2(6),
+			check:    countChars(passphraseSymbols, 1),
+		},
+		{
+			// The five dots already provide the special characters
+			name:     "separator counts as special characters",
+			settings: config.PassphraseConfig{Words: 6, Separator: "."},
+			policy:   Policy{MinSpecials: 5},
+			words:    6,
+			bits:     6 * wordBits,
+			check:    countChars(passphraseSymbols, 0),
+		},
+		{
+			name:     "too few separators for the specials",
+			settings: config.PassphraseConfig{Words: 3, Separator: "."},
+			policy:   Policy{MinSpecials: 4},
+			words:    3,
+			bits:     3*wordBits + 2*(math.Log2(float64(len(passphraseSymbols)))+math.Log2(3)),
+			check:    countChars(passphraseSymbols, 2),
+		},
+		{
+			// Without a separator every category needs an injection:
+			// a digit, a symbol and a capital
+			name:     "categories",
+			settings: config.PassphraseConfig{Words: 4, Separator: ""},
+			policy:   Policy{MinCategories: 4},
+			bits:     4*wordBits + math.Log2(10) + math.Log2(4) + math.Log2(float64(len(passphraseSymbols))) + math.Log2(4) + math.Log2(4),
+			check:    countChars(passphraseSymbols, 1),
+		},
+		{
+			// The spaces are the special characters, so no symbol is added
+			name:     "categories with a separator",
+			settings: config.PassphraseConfig{Words: 4, Separator: " "},
+			policy:   Policy{MinCategories: 4},
+			words:    4,
+			bits:     4*wordBits + math.Log2(10) + math.Log2(4) + math.Log2(4),
+			check:    countChars(passphraseSymbols, 0),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			manager := newPassphraseManager(test.settings)
+			passphrase, bits, err := manager.generatePassphrase(test.policy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if math.Abs(bits-test.bits) > 1e-9 {
+				t.Errorf("entropy %.4f, want %.4f", bits, test.bits)
+			}
+			if test.words > 0 {
+				if got := len(strings.Split(passphrase, test.settings.Separator)); got != test.words {
+					t.Errorf("%q has %d words, want %d", passphrase, got, test.words)
+				}
+			}
+			if err := test.policy.Check(passphrase); err != nil {
+				t.Errorf("%q breaks the policy: %v", passphrase, err)
+			}
+			if test.check != nil {
+				test.check(t, passphrase)
+			}
+		})
+	}
+}
+
+// countChars checks that a passphrase holds exactly count characters of charset
+func countChars(charset string, count int) func(t *testing.T, passphrase string) {
+	return func(t *testing.T, passphrase string) {
+		got := 0
+		for _, char := range passphrase {
+			if strings.ContainsRune(charset, char) {
+				got++
+			}
+		}
+		if got != count {
+			t.Errorf("%q has %d of %q, want %d", passphrase, got, charset, count)
+		}
+	}
+}
+
+func TestGeneratePassphraseGrowsToMinLength(t *testing.T) {
+	manager := newPassphraseManager(config.PassphraseConfig{Words: 3, Separator: " "})
+	passphrase, bits, err := manager.generatePassphrase(Policy{MinLength: 60})
+	if err != nil {
+		t.Fatal(err)
+	}
+	words := len(strings.Fields(passphrase))
+	if len(passphrase) < 60 || words <= 3 {
+		t.Errorf("%q: %d characters in %d words, want at least 60 in more than 3", passphrase, len(passphrase), words)
+	}
+	// Every added word adds its entropy
+	if math.Abs(bits-float64(words)*wordBits) > 1e-9 {
+		t.Errorf("entropy %.4f for %d words, want %.4f", bits, words, float64(words)*wordBits)
+	}
+}
+
+func TestGeneratePassphraseErrors(t *testing.T) {
+	manager := newPassphraseManager(config.PassphraseConfig{Words: 4, Separator: " ", IncludeDigit: true})
+	manager.config.Password.ExcludeChars = digitChars
+	if _, _, err := manager.generatePassphrase(Policy{}); err == nil || !strings.Contains(err.Error(), "excluded") {
+		t.Errorf("generatePassphrase() with every digit excluded = %v", err)
+	}
+
+	manager = newPassphraseManager(config.PassphraseConfig{Words: 4, Separator: " "})
+	if _, _, err := manager.generatePassphrase(Policy{MinCategories: 5}); err == nil || !strings.Contains(err.Error(), "categories") {
+		t.Errorf("generatePassphrase() with 5 categories = %v", err)
+	}
+}
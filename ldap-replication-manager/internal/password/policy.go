@@ -0,0 +1,254 @@
+package password
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ldap-replication-manager/internal/ldap"
+)
+
+// Character classes used by the generator and the policy checks
+// 389DS counts any non-alphanumeric 7-bit character as a special character
+const (
+	lowerChars   = "abcdefghijklmnopqrstuvwxyz"
+	upperChars   = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	digitChars   = "0123456789"
+	specialChars = "!@#$%^&*()_+-=[]{}|;:,.<>?"
+)
+
+// Policy is the effective set of rules a password must satisfy
+// It combines the generator settings from the configuration file with the
+// password syntax policy the consumer enforces on the replication manager
+// Using one structure for both keeps generation and validation consistent
+// A zero value for a rule means that rule is not enforced
+type Policy struct {
+	MinLength     int
+	MinDigits     int
+	MinAlphas     int
+	MinUppers     int
+	MinLowers     int
+	MinSpecials   int
+	Min8Bit       int
+	MinCategories int
+	MaxRepeats    int
+	MaxSequence   int
+	Palindrome    bool
+
+	// Human readable origin of the rules, for error messages
+	Sources []string
+}
+
+// SetServerPolicy records the consumer password policy for an agreement
+// The policy is read from the consumer by ldap.Manager.ReadPasswordPolicy
+// Rules are only recorded when the consumer actually checks password syntax
+func (m *Manager) SetServerPolicy(agreementName string, serverPolicy *ldap.PasswordPolicy) {
+	if serverPolicy == nil || !serverPolicy.CheckSyntax {
+		return
+	}
+	m.serverPolicies[agreementName] = Policy{
+		MinLength:     serverPolicy.MinLength,
+		MinDigits:     serverPolicy.MinDigits,
+		MinAlphas:     serverPolicy.MinAlphas,
+		MinUppers:     serverPolicy.MinUppers,
+		MinLowers:     serverPolicy.MinLowers,
+		MinSpecials:   serverPolicy.MinSpecials,
+		Min8Bit:       serverPolicy.Min8Bit,
+		MinCategories: serverPolicy.MinCategories,
+		MaxRepeats:    serverPolicy.MaxRepeats,
+		MaxSequence:   serverPolicy.MaxSequence,
+		Palindrome:    serverPolicy.Palindrome,
+		Sources:       serverPolicy.Sources,
+	}
+}
+
+// serverPolicy returns the consumer rules for an agreement
+// User supplied passwords (predefined, default or stored) are checked against
+// these rules only, because the local settings describe the generator
+func (m *Manager) serverPolicy(agreementName string) Policy {
+	return m.serverPolicies[agreementName]
+}
+
+// generationPolicy merges the local generator settings with the consumer rules
+// For every rule the stricter of the two values wins
+func (m *Manager) generationPolicy(agreementName string) Policy {
+	policy := m.serverPolicies[agreementName]
+
+	policy.MinLength = maxInt(policy.MinLength, m.config.Password.Length)
+	if m.config.Password.IncludeLowercase {
+		policy.MinLowers = maxInt(policy.MinLowers, 1)
+	}
+	if m.config.Password.IncludeUppercase {
+		policy.MinUppers = maxInt(policy.MinUppers, 1)
+	}
+	if m.config.Password.IncludeNumbers {
+		policy.MinDigits = maxInt(policy.MinDigits, 1)
+	}
+	if m.config.Password.IncludeSpecial {
+		policy.MinSpecials = maxInt(policy.MinSpecials, 1)
+	}
+	policy.Sources = append([]string{"local configuration"}, policy.Sources...)
+
+	return policy
+}
+
+// Check verifies that a password satisfies every rule of the policy
+// The checks mirror the ones 389DS performs when passwordCheckSyntax is on
+// The first violated rule is returned as an error
+func (p Policy) Check(password string) error {
+	var digits, uppers, lowers, specials, eightBit int
+	for _, char := range password {
+		switch {
+		case char > 127:
+			eightBit++
+		case char >= '0' && char <= '9':
+			digits++
+		case char >= 'A' && char <= 'Z':
+			uppers++
+		case char >= 'a' && char <= 'z':
+			lowers++
+		default:
+			specials++
+		}
+	}
+
+	categories := 0
+	for _, count := range []int{digits, uppers, lowers, specials, eightBit} {
+		if count > 0 {
+			categories++
+		}
+	}
+
+	switch {
+	case len([]rune(password)) < p.MinLength:
+		return fmt.Errorf("password shorter than %d characters", p.MinLength)
+	case digits < p.MinDigits:
+		return fmt.Errorf("password needs at least %d digits", p.MinDigits)
+	case uppers+lowers < p.MinAlphas:
+		return fmt.Errorf("password needs at least %d letters", p.MinAlphas)
+	case uppers < p.MinUppers:
+		return fmt.Errorf("password needs at least %d uppercase letters", p.MinUppers)
+	case lowers < p.MinLowers:
+		return fmt.Errorf("password needs at least %d lowercase letters", p.MinLowers)
+	case specials < p.MinSpecials:
+		return fmt.Errorf("password needs at least %d special characters", p.MinSpecials)
+	case eightBit < p.Min8Bit:
+		return fmt.Errorf("password needs at least %d 8-bit characters", p.Min8Bit)
+	case categories < p.MinCategories:
+		return fmt.Errorf("password needs characters from at least %d categories", p.MinCategories)
+	}
+
+	if p.MaxRepeats > 0 && longestRepeat(password) > p.MaxRepeats {
+		return fmt.Errorf("password repeats a character more than %d times in a row", p.MaxRepeats)
+	}
+	if p.MaxSequence > 0 && longestSequence(password) > p.MaxSequence {
+		return fmt.Errorf("password contains a sequence longer than %d characters", p.MaxSequence)
+	}
+	if p.Palindrome && isPalindrome(password) {
+		return fmt.Errorf("password is a palindrome")
+	}
+	return nil
+}
+
+// Describe returns a one-line summary of the policy origin for messages
+func (p Policy) Describe() string {
+	if len(p.Sources) == 0 {
+		return "no policy"
+	}
+	return strings.Join(p.Sources, " + ")
+}
+
+// longestRepeat returns the longest run of one character
+func longestRepeat(password string) int {
+	longest, current := 0, 0
+	var previous rune = -1
+	for _, char := range password {
+		if char == previous {
+			current++
+		} else {
+			current = 1
+		}
+		previous = char
+		longest = maxInt(longest, current)
+	}
+	return longest
+}
+
+// longestSequence returns the longest ascending or descending run such as
+// "abc", "cba" or "123"
+func longestSequence(password string) int {
+	runes := []rune(password)
+	if len(runes) == 0 {
+		return 0
+	}
+	longest, up, down := 1, 1, 1
+	for i := 1; i < len(runes); i++ {
+		if runes[i] == runes[i-1]+1 {
+			up++
+		} else {
+			up = 1
+		}
+		if runes[i] == runes[i-1]-1 {
+			down++
+		} else {
+			down = 1
+		}
+		longest = maxInt(longest, maxInt(up, down))
+	}
+	return longest
+}
+
+// isPalindrome reports whether the password reads the same backwards
+func isPalindrome(password string) bool {
+	runes := []rune(password)
+	for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+		if runes[i] != runes[j] {
+			return false
+		}
+	}
+	return len(runes) > 1
+}
+
+// randomChars picks count characters from charset using crypto/rand
+func randomChars(charset string, count int) ([]byte, error) {
+	result := make([]byte, count)
+	charsetLen := big.NewInt(int64(len(charset)))
+	for i := range result {
+		randomIndex, err := rand.Int(rand.Reader, charsetLen)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate random number: %v", err)
+		}
+		result[i] = charset[randomIndex.Int64()]
+	}
+	return result, nil
+}
+
+// randomIndex returns a uniformly random number in [0, n) using crypto/rand
+func randomIndex(n int) (int, error) {
+	value, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0, fmt.Errorf("failed to generate random number: %v", err)
+	}
+	return int(value.Int64()), nil
+}
+
+// shuffle randomly reorders the characters with a Fisher-Yates shuffle
+func shuffle(chars []byte) error {
+	for i := len(chars) - 1; i > 0; i-- {
+		j, err := rand.Int(rand.Reader, big.NewInt(int64(i+1)))
+		if err != nil {
+			return fmt.Errorf("failed to generate random number: %v", err)
+		}
+		chars[i], chars[j.Int64()] = chars[j.Int64()], chars[i]
+	}
+	return nil
+}
+
+// maxInt returns the larger of two integers
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,104 @@
+package password
+
+import (
+	"bytes"
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ldap-replication-manager/internal/config"
+	"github.com/ldap-replication-manager/internal/ldap"
+)
+
+// newPolicyManager returns a manager generating 16 character passwords
+// from the enabled classes, logging to the returned buffer
+func newPolicyManager(lower, upper, numbers, special bool) (*Manager, *bytes.Buffer) {
+	cfg := &config.Config{}
+	cfg.Password.Length = 16
+	cfg.Password.IncludeLowercase = lower
+	cfg.Password.IncludeUppercase = upper
+	cfg.Password.IncludeNumbers = numbers
+	cfg.Password.IncludeSpecial = special
+	manager := NewManager(cfg)
+	var logs bytes.Buffer
+	manager.SetLogger(slog.New(slog.NewTextHandler(&logs, nil)))
+	return manager, &logs
+}
+
+func TestGenerationPolicyMerge(t *testing.T) {
+	manager, _ := newPolicyManager(true, true, false, false)
+	manager.SetServerPolicy("to-c1", &ldap.PasswordPolicy{
+		Sources:     []string{"cn=config", "cn=replication policy,cn=config"},
+		CheckSyntax: true,
+		MinLength:   20,
+		MinUppers:   3,
+		MinDigits:   2,
+		MaxRepeats:  2,
+	})
+	manager.SetServerPolicy("to-c2", &ldap.PasswordPolicy{CheckSyntax: false, MinLength: 30, MinDigits: 5})
+
+	policy := manager.generationPolicy("to-c1")
+	want := Policy{MinLength: 20, MinLowers: 1, MinUppers: 3, MinDigits: 2, MaxRepeats: 2}
+	policy.Sources = nil
+	if !reflect.DeepEqual(policy, want) {
+		t.Errorf("merged policy %+v, want %+v", policy, want)
+	}
+	if got := manager.generationPolicy("to-c1").Describe(); got != "local configuration + cn=config + cn=replication policy,cn=config" {
+		t.Errorf("policy sources %q", got)
+	}
+
+	// A consumer that does not check syntax adds nothing to the local settings
+	policy = manager.generationPolicy("to-c2")
+	want = Policy{MinLength: 16, MinLowers: 1, MinUppers: 1}
+	policy.Sources = nil
+	if !reflect.DeepEqual(policy, want) {
+		t.Errorf("policy without syntax checking %+v, want %+v", policy, want)
+	}
+}
+
+func TestGenerateWarnsAboutDisabledClasses(t *testing.T) {
+	tests := []struct {
+		name    string
+		server  ldap.PasswordPolicy
+		contain string // characters of which the password must have one
+		warning string // "" when no warning is expected
+	}{
+		{"enabled classes suffice", ldap.PasswordPolicy{CheckSyntax: true, MinCategories: 2}, "", ""},
+		{"categories need a disabled class", ldap.PasswordPolicy{CheckSyntax: true, MinCategories: 3}, digitChars,
+			"more character categories than the configuration enables"},
+		{"consumer requires a disabled class", ldap.PasswordPolicy{CheckSyntax: true, MinSpecials: 2}, specialChars,
+			"requires a character class the configuration disables"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			manager, logs := newPolicyManager(true, true, false, false)
+			manager.SetServerPolicy("to-c1", &test.server)
+
+			password, _, err := manager.generatePassword("to-c1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := manager.generationPolicy("to-c1").Check(password); err != nil {
+				t.Errorf("generated password breaks the policy: %v", err)
+			}
+			if test.contain != "" && !strings.ContainsAny(password, test.contain) {
+				t.Errorf("password has none of %q", test.contain)
+			}
+
+			if test.warning == "" {
+				if strings.Contains(logs.String(), "level=WARN") {
+					t.Errorf("unexpected warning: %s", logs.String())
+				}
+				return
+			}
+			if !strings.Contains(logs.String(), "level=WARN") || !strings.Contains(logs.String(), test.warning) {
+				t.Errorf("no warning %q in logs: %s", test.warning, logs.String())
+			}
+			if strings.Contains(logs.String(), password) {
+				t.Error("the password was logged")
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package password
+
+import (
+	"fmt"
+
+	"github.com/ldap-replication-manager/internal/config"
+)
+
+// SecretStore is the interface implemented by external credential backends
+// It lets the password manager read the Directory Manager password and
+// predefined agreement passwords from somewhere other than the config file
+// After a successful rotation the new agreement passwords are written back
+// Keeping this an interface makes it easy to add other backends later
+type SecretStore interface {
+	// BindPassword returns the password for the LDAP bind DN
+	BindPassword() (string, error)
+
+	// AgreementPassword returns the stored password for an agreement
+	// An empty string means the store has no password for that agreement
+	AgreementPassword(agreementName string) (string, error)
+
+	// StoreAgreementPassword saves a newly applied agreement password
+	// The metadata describes the rotation (time, operator, hosts)
+	// It must not be returned by AgreementPassword later, or the next run
+	// would apply the same password again instead of rotating
+	StoreAgreementPassword(agreementName, password string, metadata map[string]string) error
+}
+
+// NewSecretStore creates the secret store selected in the configuration
+// It returns nil when no backend is configured, which means that all
+// credentials come from the configuration file as before
+// Unknown backends are rejected by config validation, so this function
+// only needs to handle the supported ones
+func NewSecretStore(cfg *config.Config) (SecretStore, error) {
+	switch cfg.Secrets.Backend {
+	case "":
+		return nil, nil
+	case "vault":
+		return NewVaultStore(cfg.Secrets.Vault)
+	default:
+		return nil, fmt.Errorf("unknown secrets backend %q", cfg.Secrets.Backend)
+	}
+}
@@ -0,0 +1,472 @@
+package password
+
+import (
+	_ "embed"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// commonPasswordsData lists frequently used and leaked passwords, most common first
+// Together with the EFF wordlist it forms the dictionary for strength estimation
+//
+//go:embed common_passwords.txt
+var commonPasswordsData string
+
+// StrengthResult describes the estimated strength of a password
+// Bits is the estimated entropy: roughly log2 of the number of guesses an
+// attacker who knows common patterns would need
+// Patterns lists the weaknesses that were found, for display to operators
+type StrengthResult struct {
+	Bits     float64
+	Label    string
+	Patterns []string
+}
+
+// strengthMatch is a weak pattern found in part of a password
+type strengthMatch struct {
+	start, end  int // rune positions, end is exclusive
+	bits        float64
+	description string
+}
+
+var (
+	dictionaryOnce sync.Once
+	dictionary     map[string]int // word -> rank (1 is most common)
+
+	yearPattern = regexp.MustCompile(`(19|20)\d\d`)
+	datePattern = regexp.MustCompile(`\d{1,4}[-/.]\d{1,2}[-/.]\d{1,4}|\d{6}|\d{8}`)
+)
+
+// leetSubstitutions maps common "l33t" replacements back to letters
+var leetSubstitutions = map[rune]rune{
+	'4': 'a', '@': 'a', '8': 'b', '(': 'c', '3': 'e', '6': 'g', '1': 'i',
+	'!': 'i', '|': 'l', '0': 'o', '$': 's', '5': 's', '7': 't', '+': 't', '2': 'z',
+}
+
+// keyboardRows is the US QWERTY layout used to detect keyboard walks
+// The second string of each pair holds the shifted characters
+var keyboardRows = [][2]string{
+	{"`1234567890-=", "~!@#$%^&*()_+"},
+	{"qwertyuiop[]\\", "QWERTYUIOP{}|"},
+	{"asdfghjkl;'", "ASDFGHJKL:\""},
+	{"zxcvbnm,./", "ZXCVBNM<>?"},
+}
+
+// GeneratedEntropy returns the entropy of a randomly generated password
+// Every character is picked uniformly from the charset, so the entropy is
+// exactly length * log2(charset size); no pattern analysis is needed
+func GeneratedEntropy(charsetSize, length int) float64 {
+	if charsetSize < 2 || length <= 0 {
+		return 0
+	}
+	return float64(length) * math.Log2(float64(charsetSize))
+}
+
+// StrengthLabel turns an entropy value into a simple rating
+// The thresholds follow common guidance for offline attacks on hashed passwords
+func StrengthLabel(bits float64) string {
+	switch {
+	case bits >= 80:
+		return "Very Strong"
+	case bits >= 60:
+		return "Strong"
+	case bits >= 40:
+		return "Medium"
+	default:
+		return "Weak"
+	}
+}
+
+// EstimateStrength estimates the entropy of a password chosen by a human
+// It works like the zxcvbn estimator: it finds dictionary words (also reversed
+// or written in l33t speak), keyboard walks, repeated characters, sequences
+// and dates, then picks the cheapest way to cover the whole password with
+// those patterns and brute-forced characters
+// "Aaaaaaaaaaa1!" is therefore rated by its real weakness, not by its length
+func EstimateStrength(password string) StrengthResult {
+	runes := []rune(password)
+	if len(runes) == 0 {
+		return StrengthResult{Label: StrengthLabel(0)}
+	}
+
+	matches := findDictionaryMatches(runes)
+	matches = append(matches, findRepeatMatches(runes)...)
+	matches = append(matches, findSequenceMatches(runes)...)
+	matches = append(matches, findKeyboardMatches(runes)...)
+	matches = append(matches, findDateMatches(password)...)
+
+	// Any character not covered by a pattern costs a brute-force guess
+	// over the character classes used in the whole password
+	perChar := math.Log2(float64(bruteForceCardinality(runes)))
+
+	// best[i] is the lowest entropy needed to cover the first i characters
+	best := make([]float64, len(runes)+1)
+	via := make([]*strengthMatch, len(runes)+1)
+	for i := 1; i <= len(runes); i++ {
+		best[i] = best[i-1] + perChar
+		via[i] = nil
+		for index := range matches {
+			match := &matches[index]
+			if match.end == i && best[match.start]+match.bits < best[i] {
+				best[i] = best[match.start] + match.bits
+				via[i] = match
+			}
+		}
+	}
+
+	// Walk back through the chosen patterns to describe them
+	var patterns []string
+	for i := len(runes); i > 0; {
+		if via[i] == nil {
+			i--
+			continue
+		}
+		patterns = append([]string{via[i].description}, patterns...)
+		i = via[i].start
+	}
+
+	return StrengthResult{
+		Bits:     best[len(runes)],
+		Label:    StrengthLabel(best[len(runes)]),
+		Patterns: patterns,
+	}
+}
+
+// loadDictionary builds the ranked word list on first use
+// Common passwords get the lowest ranks, EFF words follow
+func loadDictionary() map[string]int {
+	dictionaryOnce.Do(func() {
+		dictionary = make(map[string]int)
+		rank := 1
+		for _, word := range strings.Split(commonPasswordsData, "\n") {
+			word = strings.TrimSpace(word)
+			if word != "" {
+				if _, exists := dictionary[word]; !exists {
+					dictionary[word] = rank
+					rank++
+				}
+			}
+		}
+		for _, word := range effWordlist() {
+			if _, exists := dictionary[word]; !exists && len(word) >= 3 {
+				dictionary[word] = rank
+				rank++
+			}
+		}
+	})
+	return dictionary
+}
+
+// findDictionaryMatches looks for known words anywhere in the password
+// Each word costs log2 of its rank, plus extra bits for capital letters,
+// l33t substitutions and reversal
+func findDictionaryMatches(runes []rune) []strengthMatch {
+	words := loadDictionary()
+	var matches []strengthMatch
+
+	for start := 0; start < len(runes); start++ {
+		for end := start + 3; end <= len(runes) && end-start <= 20; end++ {
+			token := runes[start:end]
+			lower := strings.ToLower(string(token))
+			extra := uppercaseBits(token)
+
+			candidates := []struct {
+				word  string
+				bits  float64
+				label string
+			}{
+				{lower, 0, "dictionary word"},
+				{reverseString(lower), 1, "reversed dictionary word"},
+			}
+			if unleet, substitutions := unleetString(lower); substitutions > 0 {
+				candidates = append(candidates, struct {
+					word  string
+					bits  float64
+					label string
+				}{unleet, float64(substitutions), "l33t dictionary word"})
+			}
+
+			for _, candidate := range candidates {
+				if rank, found := words[candidate.word]; found {
+					matches = append(matches, strengthMatch{
+						start:       start,
+						end:         end,
+						bits:        math.Log2(float64(rank)) + extra + candidate.bits + 1,
+						description: fmt.Sprintf("%s %q", candidate.label, string(token)),
+					})
+				}
+			}
+		}
+	}
+	return matches
+}
+
+// findRepeatMatches finds runs of the same character such as "aaaa"
+// A run costs the choice of character plus its length
+func findRepeatMatches(runes []rune) []strengthMatch {
+	var matches []strengthMatch
+	for start := 0; start < len(runes); {
+		end := start + 1
+		for end < len(runes) && runes[end] == runes[start] {
+			end++
+		}
+		if end-start >= 3 {
+			matches = append(matches, strengthMatch{
+				start:       start,
+				end:         end,
+				bits:        math.Log2(float64(charClassSize(runes[start]) * (end - start))),
+				description: fmt.Sprintf("repeated character %q", string(runes[start:end])),
+			})
+		}
+		start = end
+	}
+	return matches
+}
+
+// findSequenceMatches finds alphabetic or numeric runs such as "abcd" or "4321"
+// Obvious starting points like "a" or "1" make the sequence even cheaper
+func findSequenceMatches(runes []rune) []strengthMatch {
+	var matches []strengthMatch
+	for start := 0; start < len(runes)-2; {
+		delta := runes[start+1] - runes[start]
+		end := start + 1
+		if delta == 1 || delta == -1 {
+			for end < len(runes) && runes[end]-runes[end-1] == delta {
+				end++
+			}
+		}
+		if end-start >= 3 {
+			first := unicode.ToLower(runes[start])
+			base := math.Log2(float64(charClassSize(runes[start])))
+			if first == 'a' || first == 'z' || first == '0' || first == '1' || first == '9' {
+				base = 1
+			}
+			if delta < 0 {
+				base++
+			}
+			matches = append(matches, strengthMatch{
+				start:       start,
+				end:         end,
+				bits:        base + math.Log2(float64(end-start)),
+				description: fmt.Sprintf("sequence %q", string(runes[start:end])),
+			})
+			start = end - 1
+			continue
+		}
+		start++
+	}
+	return matches
+}
+
+// findKeyboardMatches finds walks across neighbouring keys such as "qwerty" or "zxcvb"
+// Walks cost the starting key, the length and every change of direction
+func findKeyboardMatches(runes []rune) []strengthMatch {
+	var matches []strengthMatch
+	for start := 0; start < len(runes)-2; {
+		end := start + 1
+		turns := 0
+		shifted := 0
+		lastDirection := [2]int{}
+		for end < len(runes) {
+			direction, adjacent := keyboardStep(runes[end-1], runes[end])
+			if !adjacent {
+				break
+			}
+			if end > start+1 && direction != lastDirection {
+				turns++
+			}
+			lastDirection = direction
+			end++
+		}
+		if end-start >= 3 {
+			for _, char := range runes[start:end] {
+				if unicode.IsUpper(char) || strings.ContainsRune("~!@#$%^&*()_+{}|:\"<>?", char) {
+					shifted++
+				}
+			}
+			bits := math.Log2(47*float64(end-start)) + float64(turns)*math.Log2(4.6)
+			if shifted > 0 && shifted < end-start {
+				bits++
+			}
+			matches = append(matches, strengthMatch{
+				start:       start,
+				end:         end,
+				bits:        bits,
+				description: fmt.Sprintf("keyboard walk %q", string(runes[start:end])),
+			})
+			start = end - 1
+			continue
+		}
+		start++
+	}
+	return matches
+}
+
+// findDateMatches finds years and dates, which are popular password parts
+func findDateMatches(password string) []strengthMatch {
+	var matches []strengthMatch
+	// Positions from the regexp are byte offsets; convert them to rune positions
+	toRune := func(offset int) int { return len([]rune(password[:offset])) }
+
+	for _, location := range yearPattern.FindAllStringIndex(password, -1) {
+		matches = append(matches, strengthMatch{
+			start:       toRune(location[0]),
+			end:         toRune(location[1]),
+			bits:        math.Log2(200),
+			description: fmt.Sprintf("year %q", password[location[0]:location[1]]),
+		})
+	}
+	for _, location := range datePattern.FindAllStringIndex(password, -1) {
+		text := password[location[0]:location[1]]
+		bits := math.Log2(31 * 12 * 200)
+		if strings.ContainsAny(text, "-/.") {
+			bits += 2
+		}
+		matches = append(matches, strengthMatch{
+			start:       toRune(location[0]),
+			end:         toRune(location[1]),
+			bits:        bits,
+			description: fmt.Sprintf("date %q", text),
+		})
+	}
+	return matches
+}
+
+// keyboardStep reports whether two characters are neighbouring keys
+// and in which direction the walk moves
+func keyboardStep(from, to rune) ([2]int, bool) {
+	fromRow, fromCol, ok1 := keyPosition(from)
+	toRow, toCol, ok2 := keyPosition(to)
+	if !ok1 || !ok2 || (fromRow == toRow && fromCol == toCol) {
+		return [2]int{}, false
+	}
+	rowDelta := toRow - fromRow
+	colDelta := toCol - fromCol
+	// Rows are staggered, so the key diagonally down-left is in the same column
+	if rowDelta < -1 || rowDelta > 1 || colDelta < -1 || colDelta > 1 {
+		return [2]int{}, false
+	}
+	if rowDelta != 0 && colDelta == rowDelta {
+		return [2]int{}, false
+	}
+	return [2]int{rowDelta, colDelta}, true
+}
+
+// keyPosition returns the row and column of a key on the keyboard
+// The number row starts half a key further left than the letter rows,
+// so its columns are shifted to line up with the keys below
+func keyPosition(char rune) (int, int, bool) {
+	for row, keys := range keyboardRows {
+		offset := 0
+		if row == 0 {
+			offset = -1
+		}
+		if col := strings.IndexRune(keys[0], char); col >= 0 {
+			return row, col + offset, true
+		}
+		if col := strings.IndexRune(keys[1], char); col >= 0 {
+			return row, col + offset, true
+		}
+	}
+	return 0, 0, false
+}
+
+// uppercaseBits estimates the extra guesses needed for capital letters in a word
+// All lowercase adds nothing; a capital first letter or all capitals add one bit
+func uppercaseBits(token []rune) float64 {
+	upper, lower := 0, 0
+	for _, char := range token {
+		if unicode.IsUpper(char) {
+			upper++
+		} else if unicode.IsLower(char) {
+			lower++
+		}
+	}
+	switch {
+	case upper == 0:
+		return 0
+	case lower == 0 || (upper == 1 && unicode.IsUpper(token[0])):
+		return 1
+	}
+	variations := 0.0
+	for i := 1; i <= upper && i <= lower; i++ {
+		variations += binomial(upper+lower, i)
+	}
+	return math.Log2(variations)
+}
+
+// unleetString undoes l33t substitutions and counts how many were made
+func unleetString(word string) (string, int) {
+	substitutions := 0
+	runes := []rune(word)
+	for i, char := range runes {
+		if letter, found := leetSubstitutions[char]; found {
+			runes[i] = letter
+			substitutions++
+		}
+	}
+	return string(runes), substitutions
+}
+
+// reverseString returns the characters of a string in reverse order
+func reverseString(text string) string {
+	runes := []rune(text)
+	for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+		runes[i], runes[j] = runes[j], runes[i]
+	}
+	return string(runes)
+}
+
+// bruteForceCardinality returns the size of the character space a password uses
+func bruteForceCardinality(runes []rune) int {
+	var lower, upper, digit, symbol, other bool
+	for _, char := range runes {
+		switch {
+		case char >= 'a' && char <= 'z':
+			lower = true
+		case char >= 'A' && char <= 'Z':
+			upper = true
+		case char >= '0' && char <= '9':
+			digit = true
+		case char < 128:
+			symbol = true
+		default:
+			other = true
+		}
+	}
+	size := 0
+	for _, class := range []struct {
+		present bool
+		size    int
+	}{{lower, 26}, {upper, 26}, {digit, 10}, {symbol, 33}, {other, 100}} {
+		if class.present {
+			size += class.size
+		}
+	}
+	return size
+}
+
+// charClassSize returns the size of the character class a character belongs to
+func charClassSize(char rune) int {
+	switch {
+	case unicode.IsLower(char), unicode.IsUpper(char):
+		return 26
+	case unicode.IsDigit(char):
+		return 10
+	default:
+		return 33
+	}
+}
+
+// binomial returns n choose k as a float
+func binomial(n, k int) float64 {
+	result := 1.0
+	for i := 1; i <= k; i++ {
+		result = result * float64(n-k+i) / float64(i)
+	}
+	return result
+}
@@ -0,0 +1,120 @@
+package password
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestStrengthLabel(t *testing.T) {
+	tests := []struct {
+		bits float64
+		want string
+	}{
+		{0, "Weak"},
+		{39.99, "Weak"},
+		{40, "Medium"},
+		{59.99, "Medium"},
+		{60, "Strong"},
+		{79.99, "Strong"},
+		{80, "Very Strong"},
+		{256, "Very Strong"},
+	}
+
+	for _, test := range tests {
+		if got := StrengthLabel(test.bits); got != test.want {
+			t.Errorf("StrengthLabel(%v) = %q, want %q", test.bits, got, test.want)
+		}
+	}
+}
+
+func TestGeneratedEntropy(t *testing.T) {
+	tests := []struct {
+		charsetSize, length int
+		want                float64
+	}{
+		{2, 1, 1},
+		{64, 10, 60},
+		{94, 20, 20 * math.Log2(94)},
+		{1, 32, 0},
+		{94, 0, 0},
+	}
+
+	for _, test := range tests {
+		if got := GeneratedEntropy(test.charsetSize, test.length); math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("GeneratedEntropy(%d, %d) = %v, want %v", test.charsetSize, test.length, got, test.want)
+		}
+	}
+}
+
+func TestEstimateStrength(t *testing.T) {
+	printable := math.Log2(95) // lower, upper, digit and symbol classes
+
+	tests := []struct {
+		name     string
+		password string
+		bits     float64 // exact entropy, or 0 to check only maxBits
+		maxBits  float64
+		label    string
+		pattern  string // prefix of the only pattern found, "" for none
+	}{
+		{"empty", "", 0, 0, "Weak", ""},
+		{"random characters", "kX9#mQ2$vL7&", 12 * printable, 0, "Strong", ""},
+		{"short random", "xkq", 3 * math.Log2(26), 0, "Weak", ""},
+
+		// Repeats cost the character and the run length, not the length in characters
+		{"long repeat looks long but is weak", "Aaaaaaaaaaa1!", 3*printable + math.Log2(26*10), 0, "Weak", "repeated character"},
+		{"repeat", "aaaaaaaaaaaa", math.Log2(26 * 12), 0, "Weak", "repeated character"},
+		{"longer repeat", strings.Repeat("z", 30), math.Log2(26 * 30), 0, "Weak", "repeated character"},
+
+		// Sequences cost their first character (one bit for "a", "1" and the like)
+		// and their length; a descending one costs a bit more
+		{"sequence", "abcdefghij", 1 + math.Log2(10), 0, "Weak", "sequence"},
+		{"descending sequence", "jihgfedcba", math.Log2(26) + 1 + math.Log2(10), 0, "Weak", "sequence"},
+		{"keyboard walk", "sdfghj", math.Log2(47 * 6), 0, "Weak", "keyboard walk"},
+		{"date", "1987-05-21", math.Log2(31*12*200) + 2, 0, "Weak", "date"},
+
+		// Dictionary costs depend on the rank in the word lists
+		{"common password", "password", 0, 10, "Weak", "dictionary word"},
+		{"reversed", "drowssap", 0, 10, "Weak", "reversed dictionary word"},
+		{"l33t", "p@ssw0rd", 0, 10, "Weak", "l33t dictionary word"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := EstimateStrength(test.password)
+			if test.maxBits > 0 {
+				if result.Bits > test.maxBits {
+					t.Errorf("bits = %.2f, want at most %v", result.Bits, test.maxBits)
+				}
+			} else if math.Abs(result.Bits-test.bits) > 1e-9 {
+				t.Errorf("bits = %.4f, want %.4f", result.Bits, test.bits)
+			}
+			if result.Label != test.label {
+				t.Errorf("label = %q, want %q", result.Label, test.label)
+			}
+			if test.pattern == "" {
+				if len(result.Patterns) != 0 {
+					t.Errorf("patterns = %q, want none", result.Patterns)
+				}
+			} else if len(result.Patterns) != 1 || !strings.HasPrefix(result.Patterns[0], test.pattern) {
+				t.Errorf("patterns = %q, want one %s", result.Patterns, test.pattern)
+			}
+		})
+	}
+}
+
+func TestEstimateStrengthPenalisesPatterns(t *testing.T) {
+	// A repeat of the same length as a random password is far weaker
+	repeated := EstimateStrength("Aaaaaaaaaaa1!")
+	random := EstimateStrength("Ak9#mQ2$vL7&p")
+	if repeated.Bits >= random.Bits/2 {
+		t.Errorf("repeat %.2f bits, random %.2f bits: the repeat is not penalised", repeated.Bits, random.Bits)
+	}
+
+	// Making a repeat longer adds almost nothing
+	short, long := EstimateStrength(strings.Repeat("a", 12)), EstimateStrength(strings.Repeat("a", 48))
+	if long.Bits-short.Bits > 2 {
+		t.Errorf("48 repeated characters rated %.2f bits above 12", long.Bits-short.Bits)
+	}
+}
@@ -0,0 +1,226 @@
+package password
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ldap-replication-manager/internal/config"
+)
+
+// VaultStore reads and writes passwords in a HashiCorp Vault KV version 2 engine
+// It talks to the Vault HTTP API directly so no extra dependencies are needed
+// Each agreement has its own secret under the configured agreement path,
+// holding the password an operator chose for it
+// Applied passwords are written under a separate rotated path, so they are
+// never read back as an operator's choice on the next run
+// Writing a secret creates a new KV version, so Vault keeps the history
+// Custom metadata on the secret records when and by whom it was rotated
+type VaultStore struct {
+	config config.VaultConfig
+	token  string
+	client *http.Client
+}
+
+// vaultResponse is the part of a KV version 2 API response that we use
+// Reads return the secret in data.data and its version in data.metadata
+// Writes return the new version directly in data
+type vaultResponse struct {
+	Data struct {
+		Data     map[string]interface{} `json:"data"`
+		Metadata struct {
+			Version int `json:"version"`
+		} `json:"metadata"`
+		Version int `json:"version"`
+	} `json:"data"`
+	Errors []string `json:"errors"`
+}
+
+// NewVaultStore creates a Vault client from the configuration
+// The token is taken from the config, then the token file, then VAULT_TOKEN
+// A custom CA certificate can be supplied for internal Vault deployments
+// No request is made here; connection problems show up on first use
+func NewVaultStore(cfg config.VaultConfig) (*VaultStore, error) {
+	token := cfg.Token
+	if token == "" && cfg.TokenFile != "" {
+		data, err := os.ReadFile(cfg.TokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read vault token file %s: %v", cfg.TokenFile, err)
+		}
+		token = strings.TrimSpace(string(data))
+	}
+	if token == "" {
+		token = os.Getenv("VAULT_TOKEN")
+	}
+	if token == "" {
+		return nil, fmt.Errorf("no vault token configured (set token, token_file or VAULT_TOKEN)")
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: cfg.SkipTLSVerify}
+	if cfg.CACert != "" {
+		pem, err := os.ReadFile(cfg.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read vault CA certificate %s: %v", cfg.CACert, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.CACert)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return &VaultStore{
+		config: cfg,
+		token:  token,
+		client: &http.Client{
+			Timeout:   time.Duration(cfg.Timeout) * time.Second,
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+		},
+	}, nil
+}
+
+// BindPassword reads the Directory Manager password from Vault
+// A missing secret is an error because the tool cannot bind without it
+func (v *VaultStore) BindPassword() (string, error) {
+	data, _, err := v.readSecret(v.config.BindPasswordPath)
+	if err != nil {
+		return "", err
+	}
+	password, _ := data[v.config.PasswordKey].(string)
+	if password == "" {
+		return "", fmt.Errorf("vault secret %s has no %q value", v.config.BindPasswordPath, v.config.PasswordKey)
+	}
+	return password, nil
+}
+
+// AgreementPassword reads the predefined password for one agreement
+// Agreements without a secret simply return an empty string
+func (v *VaultStore) AgreementPassword(agreementName string) (string, error) {
+	data, _, err := v.readSecret(v.agreementPath(agreementName))
+	if err != nil {
+		return "", err
+	}
+	password, _ := data[v.config.PasswordKey].(string)
+	return password, nil
+}
+
+// StoreAgreementPassword writes a new password version for an agreement
+// The KV engine keeps older versions, which allows recovery if needed
+// The rotation metadata and resulting version are stored as custom metadata
+func (v *VaultStore) StoreAgreementPassword(agreementName, password string, metadata map[string]string) error {
+	path := v.rotatedPath(agreementName)
+
+	body := map[string]interface{}{
+		"data": map[string]string{v.config.PasswordKey: password},
+	}
+	var resp vaultResponse
+	if err := v.do(http.MethodPost, "data/"+path, body, &resp); err != nil {
+		return fmt.Errorf("failed to write vault secret %s: %v", path, err)
+	}
+
+	custom := make(map[string]string, len(metadata)+1)
+	for key, value := range metadata {
+		custom[key] = value
+	}
+	custom["rotated_version"] = fmt.Sprintf("%d", resp.Data.Version)
+	if err := v.do(http.MethodPost, "metadata/"+path, map[string]interface{}{"custom_metadata": custom}, nil); err != nil {
+		return fmt.Errorf("failed to write vault metadata for %s: %v", path, err)
+	}
+
+	slog.Debug("Stored password in vault", "component", "password", "agreement", agreementName, "path", path, "version", resp.Data.Version)
+	return nil
+}
+
+// readSecret returns the latest version of a KV secret
+// A secret that does not exist (HTTP 404) returns nil data without an error
+func (v *VaultStore) readSecret(path string) (map[string]interface{}, int, error) {
+	var resp vaultResponse
+	if err := v.do(http.MethodGet, "data/"+path, nil, &resp); err != nil {
+		if err == errVaultNotFound {
+			return nil, 0, nil
+		}
+		return nil, 0, fmt.Errorf("failed to read vault secret %s: %v", path, err)
+	}
+	return resp.Data.Data, resp.Data.Metadata.Version, nil
+}
+
+// agreementPath builds the secret path for an agreement
+// Agreement names are escaped so that unusual characters cannot change the path
+func (v *VaultStore) agreementPath(agreementName string) string {
+	return strings.TrimSuffix(v.config.AgreementPath, "/") + "/" + url.PathEscape(agreementName)
+}
+
+// rotatedPath builds the secret path where an applied password is saved
+func (v *VaultStore) rotatedPath(agreementName string) string {
+	return strings.TrimSuffix(v.config.RotatedPath, "/") + "/" + url.PathEscape(agreementName)
+}
+
+// errVaultNotFound is returned by do when Vault answers with HTTP 404
+var errVaultNotFound = fmt.Errorf("secret not found")
+
+// do sends one request to the KV engine and decodes the JSON response
+// The path is relative to the mount, for example "data/ldap/agreements/x"
+// Vault error messages are included in the returned error for troubleshooting
+func (v *VaultStore) do(method, path string, body interface{}, out interface{}) error {
+	endpoint := strings.TrimSuffix(v.config.Address, "/") + "/v1/" + strings.Trim(v.config.Mount, "/") + "/" + path
+
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(encoded)
+	}
+
+	req, err := http.NewRequest(method, endpoint, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Vault-Token", v.token)
+	req.Header.Set("X-Vault-Request", "true")
+	if v.config.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.config.Namespace)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return errVaultNotFound
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var failure vaultResponse
+		if json.Unmarshal(data, &failure) == nil && len(failure.Errors) > 0 {
+			return fmt.Errorf("vault returned %s: %s", resp.Status, strings.Join(failure.Errors, "; "))
+		}
+		return fmt.Errorf("vault returned %s", resp.Status)
+	}
+
+	if out != nil && len(data) > 0 {
+		if err := json.Unmarshal(data, out); err != nil {
+			return fmt.Errorf("invalid vault response: %v", err)
+		}
+	}
+	return nil
+}
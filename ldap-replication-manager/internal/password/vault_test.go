@@ -0,0 +1,246 @@
+package password
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ldap-replication-manager/internal/config"
+	"github.com/ldap-replication-manager/internal/ldap"
+)
+
+// kvStandIn implements the part of the Vault KV version 2 HTTP API the
+// store uses: reading and writing secret data and writing custom metadata
+type kvStandIn struct {
+	t     *testing.T
+	token string
+
+	mutex    sync.Mutex
+	versions map[string][]map[string]interface{}
+	metadata map[string]map[string]string
+}
+
+func newKVStandIn(t *testing.T) (*kvStandIn, *httptest.Server) {
+	kv := &kvStandIn{
+		t:        t,
+		token:    "test-token",
+		versions: make(map[string][]map[string]interface{}),
+		metadata: make(map[string]map[string]string),
+	}
+	server := httptest.NewServer(kv)
+	t.Cleanup(server.Close)
+	return kv, server
+}
+
+// put stores a secret as an operator would with "vault kv put"
+func (kv *kvStandIn) put(path string, data map[string]interface{}) {
+	kv.mutex.Lock()
+	defer kv.mutex.Unlock()
+	kv.versions[path] = append(kv.versions[path], data)
+}
+
+func (kv *kvStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-Vault-Token") != kv.token {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string][]string{"errors": {"permission denied"}})
+		return
+	}
+	kind, path, ok := strings.Cut(strings.TrimPrefix(r.URL.EscapedPath(), "/v1/secret/"), "/")
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	kv.mutex.Lock()
+	defer kv.mutex.Unlock()
+	switch {
+	case kind == "data" && r.Method == http.MethodGet:
+		versions := kv.versions[path]
+		if len(versions) == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string][]string{"errors": {}})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{
+			"data":     versions[len(versions)-1],
+			"metadata": map[string]int{"version": len(versions)},
+		}})
+	case kind == "data" && r.Method == http.MethodPost:
+		var body struct {
+			Data map[string]interface{} `json:"data"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		kv.versions[path] = append(kv.versions[path], body.Data)
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]int{"version": len(kv.versions[path])}})
+	case kind == "metadata" && r.Method == http.MethodPost:
+		var body struct {
+			CustomMetadata map[string]string `json:"custom_metadata"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		kv.metadata[path] = body.CustomMetadata
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func vaultConfig(address, token string) config.VaultConfig {
+	return config.VaultConfig{
+		Address:          address,
+		Token:            token,
+		Mount:            "secret",
+		BindPasswordPath: "lrm/directory-manager",
+		AgreementPath:    "lrm/agreements",
+		RotatedPath:      "lrm/rotated",
+		PasswordKey:      "password",
+		Timeout:          5,
+	}
+}
+
+func TestVaultStoreReads(t *testing.T) {
+	kv, server := newKVStandIn(t)
+	kv.put("lrm/directory-manager", map[string]interface{}{"password": "dm-secret"})
+	kv.put("lrm/agreements/to-c1", map[string]interface{}{"password": "chosen-by-operator"})
+	kv.put("lrm/agreements/to%20c2", map[string]interface{}{"password": "escaped-name"})
+	kv.put("lrm/agreements/no-key", map[string]interface{}{"other": "x"})
+
+	store, err := NewVaultStore(vaultConfig(server.URL, kv.token))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bind, err := store.BindPassword()
+	if err != nil || bind != "dm-secret" {
+		t.Fatalf("BindPassword() = %q, %v", bind, err)
+	}
+
+	tests := []struct {
+		agreement string
+		want      string
+	}{
+		{"to-c1", "chosen-by-operator"},
+		{"to c2", "escaped-name"},
+		{"missing", ""},
+		{"no-key", ""},
+	}
+	for _, test := range tests {
+		got, err := store.AgreementPassword(test.agreement)
+		if err != nil {
+			t.Errorf("AgreementPassword(%q) error: %v", test.agreement, err)
+		}
+		if got != test.want {
+			t.Errorf("AgreementPassword(%q) = %q, want %q", test.agreement, got, test.want)
+		}
+	}
+}
+
+func TestVaultStoreErrors(t *testing.T) {
+	kv, server := newKVStandIn(t)
+
+	store, err := NewVaultStore(vaultConfig(server.URL, "wrong-token"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.AgreementPassword("to-c1"); err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Errorf("expected the vault error message, got %v", err)
+	}
+
+	store, err = NewVaultStore(vaultConfig(server.URL, kv.token))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.BindPassword(); err == nil {
+		t.Error("a missing bind password secret must be an error")
+	}
+
+	t.Setenv("VAULT_TOKEN", "")
+	if _, err := NewVaultStore(vaultConfig(server.URL, "")); err == nil {
+		t.Error("a store without a token must be refused")
+	}
+}
+
+func TestVaultStoreWritesRotatedPath(t *testing.T) {
+	kv, server := newKVStandIn(t)
+	kv.put("lrm/agreements/to-c1", map[string]interface{}{"password": "chosen-by-operator"})
+
+	store, err := NewVaultStore(vaultConfig(server.URL, kv.token))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, password := range []string{"first-rotation", "second-rotation"} {
+		if err := store.StoreAgreementPassword("to-c1", password, map[string]string{"run_id": "run-1"}); err != nil {
+			t.Fatal(err)
+		}
+		versions := kv.versions["lrm/rotated/to-c1"]
+		if len(versions) != i+1 || versions[i]["password"] != password {
+			t.Fatalf("rotated secret versions = %v", versions)
+		}
+		metadata := kv.metadata["lrm/rotated/to-c1"]
+		if metadata["run_id"] != "run-1" || metadata["rotated_version"] != []string{"1", "2"}[i] {
+			t.Errorf("custom metadata = %v", metadata)
+		}
+	}
+
+	// The operator's secret is left alone and still read as before
+	if len(kv.versions["lrm/agreements/to-c1"]) != 1 {
+		t.Errorf("the agreement secret was written: %v", kv.versions["lrm/agreements/to-c1"])
+	}
+	got, err := store.AgreementPassword("to-c1")
+	if err != nil || got != "chosen-by-operator" {
+		t.Errorf("AgreementPassword() = %q, %v", got, err)
+	}
+}
+
+// A rotated password written back to the store must not be chosen again on
+// the next run, or nothing would rotate and the reuse history would refuse it
+func TestGeneratedPasswordsRotateAcrossRuns(t *testing.T) {
+	kv, server := newKVStandIn(t)
+	dir := t.TempDir()
+	cfg := &config.Config{}
+	cfg.Password = config.PasswordConfig{
+		Length:           24,
+		IncludeUppercase: true,
+		IncludeLowercase: true,
+		IncludeNumbers:   true,
+		GenerateRandom:   true,
+		Reuse:            config.ReuseConfig{HistoryFile: filepath.Join(dir, "history.json"), HistoryDepth: 5, SharedPolicy: "warn"},
+	}
+
+	agreement := ldap.ReplicationAgreement{Name: "to-c1", Supplier: "s1", Consumer: "c1"}
+	var previous string
+	for run := 1; run <= 3; run++ {
+		store, err := NewVaultStore(vaultConfig(server.URL, kv.token))
+		if err != nil {
+			t.Fatal(err)
+		}
+		manager := NewManager(cfg)
+		manager.SetSecretStore(store)
+		manager.SetOutput(io.Discard)
+
+		passwords, err := manager.GeneratePasswords([]ldap.ReplicationAgreement{agreement})
+		if err != nil {
+			t.Fatalf("run %d: %v", run, err)
+		}
+		password := passwords["to-c1"]
+		if password == "" || password == previous {
+			t.Fatalf("run %d applied %q again", run, password)
+		}
+		if err := manager.RecordRotation(agreement, password); err != nil {
+			t.Fatalf("run %d: %v", run, err)
+		}
+		previous = password
+	}
+	if got := len(kv.versions["lrm/rotated/to-c1"]); got != 3 {
+		t.Errorf("rotated versions = %d, want 3", got)
+	}
+}
@@ -0,0 +1,291 @@
+package rotation
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ldap-replication-manager/internal/config"
+)
+
+// Approval is what an approval token asserts: an operator reviewed a saved
+// plan and allowed it to be applied until the expiry time
+type Approval struct {
+	RunID    string    `json:"run_id"`
+	Digest   string    `json:"digest"`
+	Approver string    `json:"approver"`
+	Expires  time.Time `json:"expires"`
+}
+
+// Digest fingerprints the changes a saved run will make
+// It covers the operator who planned the run, the groups, agreements,
+// strategies and the encrypted passwords, but not the progress, so an approved run can still be resumed after an
+// interruption; any other change to the state file invalidates the approval
+func (r *RunState) Digest() string {
+	type digestAgreement struct {
+		Name     string `json:"name"`
+		DN       string `json:"dn"`
+		Supplier string `json:"supplier"`
+		Consumer string `json:"consumer"`
+	}
+	type digestGroup struct {
+		Consumer   string            `json:"consumer"`
+		BindDN     string            `json:"bind_dn"`
+		Strategy   string            `json:"strategy"`
+		NewAccount string            `json:"new_account"`
+		Agreements []digestAgreement `json:"agreements"`
+	}
+	content := struct {
+		RunID           string          `json:"run_id"`
+		CreatedBy       string          `json:"created_by"`
+		Groups          []digestGroup   `json:"groups"`
+		SealedPasswords json.RawMessage `json:"sealed_passwords"`
+	}{RunID: r.RunID, CreatedBy: r.CreatedBy, SealedPasswords: r.SealedPasswords}
+
+	for _, group := range r.Groups {
+		entry := digestGroup{Consumer: group.Consumer, BindDN: group.BindDN, Strategy: group.Strategy, NewAccount: group.NewAccount}
+		for _, agreement := range group.Agreements {
+			entry.Agreements = append(entry.Agreements, digestAgreement{
+				Name:     agreement.Agreement.Name,
+				DN:       agreement.Agreement.DN,
+				Supplier: agreement.Agreement.Supplier,
+				Consumer: agreement.Agreement.Consumer,
+			})
+		}
+		content.Groups = append(content.Groups, entry)
+	}
+
+	data, _ := json.Marshal(content)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// ApprovalKeys holds the keys that sign and check approval tokens
+// With a shared key_file every token is an HMAC made with the same key:
+// anyone who can read the file can issue a token under any approver name,
+// so the approver recorded in such a token is only what the issuer claims
+// With approvers_dir every approver has an Ed25519 key pair; the private key
+// stays with the approver and the directory holds only the public keys, so
+// a token proves which approver issued it
+type ApprovalKeys struct {
+	shared []byte
+	public map[string]ed25519.PublicKey
+}
+
+// ApprovalSigner issues tokens in the name of one approver
+type ApprovalSigner struct {
+	keys     *ApprovalKeys
+	approver string
+	private  ed25519.PrivateKey
+}
+
+// LoadApprovalKeys reads the shared key or the public keys of the approvers
+// Public keys are PEM files named after the approver's user name,
+// for example alice.pem, as written by "openssl pkey -pubout"
+func LoadApprovalKeys(cfg config.ApprovalConfig) (*ApprovalKeys, error) {
+	if cfg.ApproversDir == "" {
+		key, err := loadSharedKey(cfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		return &ApprovalKeys{shared: key}, nil
+	}
+
+	files, err := filepath.Glob(filepath.Join(cfg.ApproversDir, "*.pem"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read approver keys: %v", err)
+	}
+	keys := &ApprovalKeys{public: make(map[string]ed25519.PublicKey)}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read approver key: %v", err)
+		}
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("approver key %s is not a PEM file", file)
+		}
+		parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("approver key %s is not a public key: %v", file, err)
+		}
+		public, ok := parsed.(ed25519.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("approver key %s is not an Ed25519 key", file)
+		}
+		keys.public[strings.TrimSuffix(filepath.Base(file), ".pem")] = public
+	}
+	if len(keys.public) == 0 {
+		return nil, fmt.Errorf("no approver keys (*.pem) in %s", cfg.ApproversDir)
+	}
+	return keys, nil
+}
+
+// loadSharedKey reads the shared signing key from its file
+func loadSharedKey(path string) ([]byte, error) {
+	if path == "" {
+		return nil, fmt.Errorf("no approval key configured (set rotation.approval.key_file or approvers_dir)")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read approval key: %v", err)
+	}
+	key := []byte(strings.TrimSpace(string(data)))
+	if len(key) < 16 {
+		return nil, fmt.Errorf("approval key in %s is shorter than 16 bytes", path)
+	}
+	return key, nil
+}
+
+// Signer prepares to issue tokens
+// With a shared key the approver is the operator running the command
+// With approver keys the approver is whoever owns the private key in
+// privateKeyFile (a PKCS #8 PEM file, as written by
+// "openssl genpkey -algorithm ed25519"); its public key must be in
+// approvers_dir, and the approver is named after that public key file
+func (k *ApprovalKeys) Signer(operator, privateKeyFile string) (*ApprovalSigner, error) {
+	if k.public == nil {
+		if privateKeyFile != "" {
+			return nil, fmt.Errorf("a private approval key is only used with rotation.approval.approvers_dir")
+		}
+		return &ApprovalSigner{keys: k, approver: operator}, nil
+	}
+
+	if privateKeyFile == "" {
+		return nil, fmt.Errorf("approvers sign with their own key: pass --key <private key file>")
+	}
+	data, err := os.ReadFile(privateKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private approval key: %v", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("private approval key %s is not a PEM file", privateKeyFile)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("private approval key %s is not valid: %v", privateKeyFile, err)
+	}
+	private, ok := parsed.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("private approval key %s is not an Ed25519 key", privateKeyFile)
+	}
+	for name, public := range k.public {
+		if public.Equal(private.Public()) {
+			return &ApprovalSigner{keys: k, approver: name, private: private}, nil
+		}
+	}
+	return nil, fmt.Errorf("the public key of %s is not in the approvers directory", privateKeyFile)
+}
+
+// Approver returns the name the signer's tokens are issued under
+func (s *ApprovalSigner) Approver() string {
+	return s.approver
+}
+
+// Approve issues a token for a saved run
+// The approver must be another operator than the one who saved the plan;
+// operators are compared by user name, so the same person on another host
+// still counts as one person
+// Token layout: base64url(approval JSON) "." base64url(signature)
+func Approve(signer *ApprovalSigner, state *RunState, ttl time.Duration) (string, error) {
+	if state.CreatedBy != "" && operatorName(state.CreatedBy) == operatorName(signer.approver) {
+		return "", fmt.Errorf("run %s was planned by %s and must be approved by another operator", state.RunID, state.CreatedBy)
+	}
+
+	approval := Approval{
+		RunID:    state.RunID,
+		Digest:   state.Digest(),
+		Approver: signer.approver,
+		Expires:  time.Now().Add(ttl).UTC().Truncate(time.Second),
+	}
+	data, err := json.Marshal(approval)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	if signer.private != nil {
+		return payload + "." + base64.RawURLEncoding.EncodeToString(ed25519.Sign(signer.private, []byte(payload))), nil
+	}
+	return payload + "." + sign(signer.keys.shared, payload), nil
+}
+
+// VerifyApproval checks a token against the run it is meant to apply
+// It fails when the signature is wrong, the token is for another run or an
+// older version of the plan, or it has expired
+// It also fails when the approver planned the run or is the operator
+// applying it, so one person cannot both approve and plan or apply a run
+func VerifyApproval(keys *ApprovalKeys, state *RunState, token, applier string) (*Approval, error) {
+	payload, signature, ok := strings.Cut(strings.TrimSpace(token), ".")
+	if !ok {
+		return nil, fmt.Errorf("approval token is not valid")
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, fmt.Errorf("approval token is not valid")
+	}
+	var approval Approval
+	if err := json.Unmarshal(data, &approval); err != nil {
+		return nil, fmt.Errorf("approval token is not valid")
+	}
+	if !keys.valid(approval.Approver, payload, signature) {
+		return nil, fmt.Errorf("approval token is not valid")
+	}
+
+	if approval.RunID != state.RunID {
+		return nil, fmt.Errorf("approval token is for run %s, not %s", approval.RunID, state.RunID)
+	}
+	if approval.Digest != state.Digest() {
+		return nil, fmt.Errorf("the plan of run %s changed after it was approved", state.RunID)
+	}
+	if time.Now().After(approval.Expires) {
+		return nil, fmt.Errorf("approval token expired %s", approval.Expires.Local().Format(time.RFC1123))
+	}
+	if state.CreatedBy != "" && operatorName(state.CreatedBy) == operatorName(approval.Approver) {
+		return nil, fmt.Errorf("run %s was approved by the operator who planned it", state.RunID)
+	}
+	if operatorName(applier) == operatorName(approval.Approver) {
+		return nil, fmt.Errorf("run %s was approved by %s, who cannot also apply it", state.RunID, approval.Approver)
+	}
+	return &approval, nil
+}
+
+// valid checks the signature of a token payload
+// With approver keys only the key of the named approver is accepted
+func (k *ApprovalKeys) valid(approver, payload, signature string) bool {
+	if k.public == nil {
+		return hmac.Equal([]byte(signature), []byte(sign(k.shared, payload)))
+	}
+	public, exists := k.public[approver]
+	if !exists {
+		return false
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	return ed25519.Verify(public, []byte(payload), decoded)
+}
+
+// sign returns the base64url HMAC-SHA256 of a token payload
+func sign(key []byte, payload string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// operatorName returns the user part of "user@host"
+func operatorName(identity string) string {
+	name, _, _ := strings.Cut(identity, "@")
+	return name
+}
@@ -0,0 +1,228 @@
+package rotation
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ldap-replication-manager/internal/config"
+)
+
+func testRunState() *RunState {
+	return &RunState{
+		RunID:           "20261018T135442-3fa2c1",
+		CreatedBy:       "alice@admin1",
+		Groups:          []GroupState{{Consumer: "c1", BindDN: "cn=replication manager,cn=config", Strategy: "in-place"}},
+		SealedPasswords: []byte(`{"sealed":true}`),
+	}
+}
+
+// writeApproverKey creates a key pair for an approver, stores the public
+// key in dir and returns the path of the private key
+func writeApproverKey(t *testing.T, dir, name string) string {
+	t.Helper()
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateDER, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name+".pem"), pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	privateFile := filepath.Join(t.TempDir(), name+".key")
+	if err := os.WriteFile(privateFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return privateFile
+}
+
+func sharedKeys(t *testing.T) *ApprovalKeys {
+	t.Helper()
+	keyFile := filepath.Join(t.TempDir(), "approval.key")
+	if err := os.WriteFile(keyFile, []byte("0123456789abcdef0123456789abcdef\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := LoadApprovalKeys(config.ApprovalConfig{KeyFile: keyFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+func TestApprovalSharedKey(t *testing.T) {
+	keys := sharedKeys(t)
+	state := testRunState()
+
+	planner, err := keys.Signer("alice@admin2", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Approve(planner, state, time.Hour); err == nil {
+		t.Error("the planner must not approve their own run, even from another host")
+	}
+
+	signer, err := keys.Signer("bob@admin2", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := Approve(signer, state, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := Approve(signer, state, -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changed := testRunState()
+	changed.Groups[0].Strategy = "bind-group"
+	replanned := testRunState()
+	replanned.CreatedBy = "carol@admin1"
+
+	tests := []struct {
+		name    string
+		state   *RunState
+		token   string
+		applier string
+		wantErr string
+	}{
+		{"planner applies", state, token, "alice@admin1", ""},
+		{"approver applies", state, token, "bob@admin1", "cannot also apply"},
+		{"plan changed", changed, token, "alice@admin1", "changed after it was approved"},
+		{"planner changed", replanned, token, "alice@admin1", "changed after it was approved"},
+		{"expired", state, expired, "alice@admin1", "expired"},
+		{"tampered", state, "x" + token, "alice@admin1", "not valid"},
+		{"no signature", state, strings.Split(token, ".")[0], "alice@admin1", "not valid"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			approval, err := VerifyApproval(keys, test.state, test.token, test.applier)
+			if test.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if approval.Approver != "bob@admin2" {
+					t.Errorf("approver %q", approval.Approver)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("VerifyApproval() error = %v, want %q", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestApprovalApproverKeys(t *testing.T) {
+	dir := t.TempDir()
+	bobKey := writeApproverKey(t, dir, "bob")
+	aliceKey := writeApproverKey(t, dir, "alice")
+	keys, err := LoadApprovalKeys(config.ApprovalConfig{ApproversDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := testRunState()
+
+	// The approver is the owner of the key, whoever runs the command
+	signer, err := keys.Signer("alice@admin1", bobKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signer.Approver() != "bob" {
+		t.Errorf("approver %q, want bob", signer.Approver())
+	}
+	token, err := Approve(signer, state, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VerifyApproval(keys, state, token, "alice@admin1"); err != nil {
+		t.Errorf("token signed by bob: %v", err)
+	}
+	if _, err := VerifyApproval(keys, state, token, "bob@admin1"); err == nil {
+		t.Error("bob approved the run and must not apply it")
+	}
+
+	alice, err := keys.Signer("alice@admin1", aliceKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Approve(alice, state, time.Hour); err == nil {
+		t.Error("alice planned the run and must not approve it with their own key")
+	}
+
+	// A token that names another approver than the key that signed it
+	forged := ApprovalSigner{keys: keys, approver: "carol", private: alice.private}
+	state.CreatedBy = "dave@admin1"
+	token, err = Approve(&forged, state, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VerifyApproval(keys, state, token, "dave@admin1"); err == nil {
+		t.Error("a token for an approver without a key must be refused")
+	}
+	forged.approver = "bob"
+	token, err = Approve(&forged, state, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VerifyApproval(keys, state, token, "dave@admin1"); err == nil {
+		t.Error("a token in bob's name signed with alice's key must be refused")
+	}
+
+	// Tokens made with the shared key are not accepted either
+	shared, err := sharedKeys(t).Signer("bob@admin2", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err = Approve(shared, state, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VerifyApproval(keys, state, token, "dave@admin1"); err == nil {
+		t.Error("an HMAC token must not pass as an approver signature")
+	}
+}
+
+func TestApprovalKeySettings(t *testing.T) {
+	dir := t.TempDir()
+	writeApproverKey(t, dir, "bob")
+	unknownKey := writeApproverKey(t, t.TempDir(), "mallory")
+	keys, err := LoadApprovalKeys(config.ApprovalConfig{ApproversDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := keys.Signer("bob@admin1", ""); err == nil {
+		t.Error("approver keys need --key")
+	}
+	if _, err := keys.Signer("mallory@admin1", unknownKey); err == nil {
+		t.Error("a private key whose public key is not in the directory must be refused")
+	}
+	if _, err := sharedKeys(t).Signer("bob@admin1", unknownKey); err == nil {
+		t.Error("--key is only used with approver keys")
+	}
+
+	if _, err := LoadApprovalKeys(config.ApprovalConfig{ApproversDir: t.TempDir()}); err == nil {
+		t.Error("an empty approvers directory must be refused")
+	}
+	short := filepath.Join(t.TempDir(), "short.key")
+	if err := os.WriteFile(short, []byte("too short"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadApprovalKeys(config.ApprovalConfig{KeyFile: short}); err == nil {
+		t.Error("a shared key shorter than 16 bytes must be refused")
+	}
+}
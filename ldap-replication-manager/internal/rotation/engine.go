@@ -0,0 +1,507 @@
+package rotation
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ldap-replication-manager/internal/config"
+	"github.com/ldap-replication-manager/internal/ldap"
+	"github.com/ldap-replication-manager/internal/password"
+)
+
+// Result is the outcome of rotating one replication agreement
+// Results are collected for the summary table printed at the end of a run
+type Result struct {
+	Agreement string
+	Supplier  string
+	Consumer  string
+
+	// Whether both sides of the agreement were updated
+	Success bool
+
+	// The first error met, nil on success
+	Err error
+
+	// How long the agreement took, including waiting for a connection
+	Duration time.Duration
+
+	// Extra information for the summary, such as a rollback
+	Note string
+}
+
+// NoteRolledBack is the note of an agreement that failed verification and
+// was put back on its previous account, so it still replicates
+const NoteRolledBack = "rolled back"
+
+// Engine applies a rotation plan with several groups rotated concurrently
+// Up to rotation.parallelism groups are rotated at the same time; the LDAP
+// manager's connection pool additionally limits connections per server
+// Output of each group is buffered and printed in plan order once the
+// group is done, so lines from different groups never interleave
+type Engine struct {
+	ldap        *ldap.Manager
+	passwords   *password.Manager
+	parallelism int
+	record      bool
+	out         io.Writer
+
+	// How long to wait for a replication session after updating a supplier
+	verifyTimeout time.Duration
+
+	// Progress of the run on disk, nil when not recorded
+	state *StateFile
+
+	// The vault and history files are not safe for concurrent writers
+	recordMutex sync.Mutex
+}
+
+// NewEngine creates a rotation engine
+// When record is true every applied password is saved with RecordRotation;
+// main only enables this in production mode
+func NewEngine(cfg *config.Config, ldapManager *ldap.Manager, passwordManager *password.Manager, record bool, out io.Writer) *Engine {
+	parallelism := cfg.Rotation.Parallelism
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	return &Engine{
+		ldap:        ldapManager,
+		passwords:   passwordManager,
+		parallelism: parallelism,
+		record:      record,
+		out:         out,
+
+		verifyTimeout: time.Duration(cfg.Rotation.VerifyTimeout) * time.Second,
+	}
+}
+
+// Run rotates every group of the plan
+// It blocks until all groups are done and returns one result per
+// agreement, in plan order
+func (e *Engine) Run(plan *Plan) []Result {
+	groups := plan.Groups
+
+	outputs := make([]bytes.Buffer, len(groups))
+	groupResults := make([][]Result, len(groups))
+	done := make([]chan struct{}, len(groups))
+	for i := range done {
+		done[i] = make(chan struct{})
+	}
+
+	// Workers take groups from the queue until it is empty
+	queue := make(chan int)
+	for worker := 0; worker < e.parallelism && worker < len(groups); worker++ {
+		go func() {
+			for i := range queue {
+				groupResults[i] = e.rotateGroup(groups[i], &outputs[i])
+				close(done[i])
+			}
+		}()
+	}
+	go func() {
+		for i := range groups {
+			queue <- i
+		}
+		close(queue)
+	}()
+
+	// Print each group's output in order as soon as it is complete
+	var results []Result
+	for i := range groups {
+		<-done[i]
+		e.out.Write(outputs[i].Bytes())
+		results = append(results, groupResults[i]...)
+	}
+	return results
+}
+
+// rotateGroup updates the consumer entry once and then every supplier agreement,
+// waiting for a replication session after each one
+// If the consumer cannot be changed no supplier is touched, so replication
+// keeps working with the old password
+// A failed supplier does not stop the other suppliers of the group
+// In a resumed run, steps the interrupted run completed are skipped
+func (e *Engine) rotateGroup(group Group, out io.Writer) []Result {
+	// Once a change could not be audited no further group is started;
+	// groups already started finish, so none is left half rotated
+	if err := e.ldap.AuditError(); err != nil {
+		return e.skipGroup(group, fmt.Errorf("not started: %v", err), out)
+	}
+	if group.Strategy == "bind-group" {
+		return e.rotateBindGroup(group, out)
+	}
+
+	start := time.Now()
+	results := make([]Result, 0, len(group.Agreements))
+	finish := func(agreement ldap.ReplicationAgreement, err error, note string) {
+		if err != nil {
+			e.markError(agreement, err, out)
+		}
+		results = append(results, Result{
+			Agreement: agreement.Name,
+			Supplier:  agreement.Supplier,
+			Consumer:  agreement.Consumer,
+			Success:   err == nil,
+			Err:       err,
+			Duration:  time.Since(start),
+			Note:      note,
+		})
+	}
+
+	fmt.Fprintf(out, "Updating %s on %s (%d agreements)\n", group.BindDN, group.Consumer, len(group.Agreements))
+
+	if e.groupDone(group, StepConsumerUpdated) {
+		fmt.Fprintf(out, "  ✓ Consumer password already updated by the interrupted run\n")
+	} else {
+		err := fmt.Errorf("no password available")
+		if group.ConsumerValue != "" {
+			err = e.ldap.UpdateReplicationPassword(group.Agreements[0], group.ConsumerValue, "consumer")
+		}
+		if err != nil {
+			fmt.Fprintf(out, "  ✗ Failed to update consumer password: %v\n", err)
+			fmt.Fprintf(out, "  Supplier agreements left unchanged\n")
+			for _, agreement := range group.Agreements {
+				finish(agreement, fmt.Errorf("consumer update failed: %v", err), "")
+			}
+			return results
+		}
+		fmt.Fprintf(out, "  ✓ Consumer password updated\n")
+		for _, agreement := range group.Agreements {
+			e.markStep(agreement, StepConsumerUpdated, out)
+		}
+	}
+
+	for _, agreement := range group.Agreements {
+		if e.done(agreement, StepVerified) {
+			fmt.Fprintf(out, "  ✓ %s already updated and verified by the interrupted run\n", agreement.Name)
+			finish(agreement, nil, "resumed")
+			continue
+		}
+
+		// An agreement updated by the interrupted run only needs verifying
+		since := time.Now()
+		if e.done(agreement, StepSupplierUpdated) {
+			_, since = e.state.Step(agreement)
+			fmt.Fprintf(out, "  %s was updated by the interrupted run, verifying\n", agreement.Name)
+		} else {
+			err := e.ldap.UpdateReplicationPassword(agreement, group.Password, "supplier")
+			if err != nil {
+				fmt.Fprintf(out, "  ✗ Failed to update supplier password for %s: %v\n", agreement.Name, err)
+				finish(agreement, err, "")
+				continue
+			}
+
+			if e.ldap.DryRun {
+				fmt.Fprintf(out, "  ✓ Dry-run simulation completed for %s\n", agreement.Name)
+				finish(agreement, nil, "")
+				continue
+			}
+			e.recordRotation(agreement, group.Password, out)
+			e.markStep(agreement, StepSupplierUpdated, out)
+		}
+
+		// The consumer no longer accepts the old password, so a failed
+		// session cannot be rolled back here; it is reported instead
+		err := e.ldap.VerifyReplication(agreement, since, e.verifyTimeout)
+		switch {
+		case errors.Is(err, ldap.ErrNotVerified):
+			fmt.Fprintf(out, "  ! Updated passwords for %s, but %v\n", agreement.Name, err)
+			finish(agreement, nil, "not verified")
+		case err != nil:
+			fmt.Fprintf(out, "  ✗ %s failed verification: %v\n", agreement.Name, err)
+			finish(agreement, fmt.Errorf("verification failed: %v", err), "")
+		default:
+			fmt.Fprintf(out, "  ✓ Successfully updated and verified passwords for %s\n", agreement.Name)
+			e.markStep(agreement, StepVerified, out)
+			finish(agreement, nil, "")
+		}
+	}
+	return results
+}
+
+// rotateBindGroup rotates a group without interrupting replication
+// The consumer accepts every member of its nsds5ReplicaBindDNGroup, so a
+// second account is staged with the new password next to the one in use:
+//  1. create or refresh the new account and add it to the bind DN group
+//  2. move each supplier agreement to it and wait for a successful session;
+//     an agreement whose session fails is moved back to the old account
+//  3. once every agreement is verified, retire the old account
+//
+// The old account keeps working until the last step, so a failure at any
+// point leaves replication running
+func (e *Engine) rotateBindGroup(group Group, out io.Writer) []Result {
+	start := time.Now()
+	results := make([]Result, 0, len(group.Agreements))
+	finish := func(agreement ldap.ReplicationAgreement, err error, note string) {
+		if err != nil {
+			e.markError(agreement, err, out)
+		}
+		results = append(results, Result{
+			Agreement: agreement.Name,
+			Supplier:  agreement.Supplier,
+			Consumer:  agreement.Consumer,
+			Success:   err == nil,
+			Err:       err,
+			Duration:  time.Since(start),
+			Note:      note,
+		})
+	}
+	failAll := func(err error) []Result {
+		fmt.Fprintf(out, "  ✗ %v\n", err)
+		fmt.Fprintf(out, "  Supplier agreements left unchanged\n")
+		for _, agreement := range group.Agreements {
+			finish(agreement, err, "")
+		}
+		return results
+	}
+
+	fmt.Fprintf(out, "Updating %s on %s (%d agreements, moving to %s)\n", group.BindDN, group.Consumer, len(group.Agreements), group.NewAccount)
+	if group.Password == "" || group.ConsumerValue == "" {
+		return failAll(fmt.Errorf("no password available"))
+	}
+
+	// Step 1: stage the new account next to the one in use
+	if e.groupDone(group, StepConsumerUpdated) {
+		fmt.Fprintf(out, "  ✓ %s already staged by the interrupted run\n", group.NewAccount)
+	} else {
+		if err := e.ldap.StageAccount(group.Consumer, group.NewAccount, group.ConsumerValue); err != nil {
+			return failAll(err)
+		}
+		for _, groupDN := range group.BindDNGroups {
+			if err := e.ldap.AddGroupMember(group.Consumer, groupDN, group.NewAccount); err != nil {
+				return failAll(err)
+			}
+		}
+		if group.GroupCheckInterval > 0 && !e.ldap.DryRun {
+			fmt.Fprintf(out, "  Waiting %ds for the consumer to re-read its bind DN group\n", group.GroupCheckInterval)
+			time.Sleep(time.Duration(group.GroupCheckInterval+1) * time.Second)
+		}
+		if err := e.ldap.VerifyBind(group.Consumer, group.NewAccount, group.Password); err != nil {
+			return failAll(err)
+		}
+		fmt.Fprintf(out, "  ✓ Staged %s in %s\n", group.NewAccount, strings.Join(group.BindDNGroups, ", "))
+		for _, agreement := range group.Agreements {
+			e.markStep(agreement, StepConsumerUpdated, out)
+		}
+	}
+
+	// Step 2: move the suppliers one by one
+	allVerified := true
+	for _, agreement := range group.Agreements {
+		if e.done(agreement, StepVerified) {
+			fmt.Fprintf(out, "  ✓ %s already moved and verified by the interrupted run\n", agreement.Name)
+			finish(agreement, nil, "resumed")
+			continue
+		}
+
+		// An agreement moved by the interrupted run only needs verifying;
+		// its previous settings are unknown, so it cannot be rolled back
+		var previous *ldap.AgreementCredentials
+		since := time.Now()
+		if e.done(agreement, StepSupplierUpdated) {
+			_, since = e.state.Step(agreement)
+			fmt.Fprintf(out, "  %s was moved by the interrupted run, verifying\n", agreement.Name)
+		} else {
+			var err error
+			previous, err = e.ldap.RepointAgreement(agreement, group.NewAccount, group.Password)
+			if err != nil {
+				fmt.Fprintf(out, "  ✗ %v\n", err)
+				finish(agreement, err, "")
+				allVerified = false
+				continue
+			}
+			e.markStep(agreement, StepSupplierUpdated, out)
+		}
+
+		err := e.ldap.VerifyReplication(agreement, since, e.verifyTimeout)
+		switch {
+		case errors.Is(err, ldap.ErrNotVerified):
+			fmt.Fprintf(out, "  ! %s moved to %s, but %v\n", agreement.Name, group.NewAccount, err)
+			allVerified = false
+			e.recordMoved(agreement, group, out)
+			finish(agreement, nil, "not verified")
+		case err != nil && previous == nil:
+			allVerified = false
+			fmt.Fprintf(out, "  ✗ %s failed verification: %v\n", agreement.Name, err)
+			finish(agreement, fmt.Errorf("verification failed: %v", err), "")
+		case err != nil:
+			allVerified = false
+			if restoreErr := e.ldap.RestoreAgreement(agreement, previous); restoreErr != nil {
+				fmt.Fprintf(out, "  ✗ %s failed verification (%v) and could not be rolled back: %v\n", agreement.Name, err, restoreErr)
+				finish(agreement, fmt.Errorf("verification failed: %v; rollback failed: %v", err, restoreErr), "")
+				continue
+			}
+			fmt.Fprintf(out, "  ↺ %s failed verification and was rolled back to %s: %v\n", agreement.Name, previous.BindDN, err)
+			e.markStep(agreement, StepConsumerUpdated, out)
+			finish(agreement, fmt.Errorf("verification failed: %v", err), NoteRolledBack)
+		default:
+			fmt.Fprintf(out, "  ✓ %s moved to %s and verified\n", agreement.Name, group.NewAccount)
+			e.recordMoved(agreement, group, out)
+			e.markStep(agreement, StepVerified, out)
+			finish(agreement, nil, "")
+		}
+	}
+
+	// Step 3: the old account is only retired when nobody can still need it
+	if !allVerified {
+		fmt.Fprintf(out, "  Old account %s kept because not every agreement was verified\n", group.BindDN)
+		return results
+	}
+	if reason := e.oldAccountInUse(group); reason != "" {
+		fmt.Fprintf(out, "  Old account %s kept because %s\n", group.BindDN, reason)
+		return results
+	}
+	if err := e.ldap.RetireAccount(group.Consumer, group.BindDN, group.BindDNGroups); err != nil {
+		fmt.Fprintf(out, "  WARNING: could not retire %s: %v\n", group.BindDN, err)
+		return results
+	}
+	fmt.Fprintf(out, "  ✓ Retired %s\n", group.BindDN)
+	return results
+}
+
+// oldAccountInUse checks every supplier for agreements that still bind as
+// the old account of a bind-group rotation
+// The plan only holds the agreements found when the run started; one added
+// since, or one on a supplier that was not discovered, would fail with
+// error 49 once the account is retired
+// It returns why the account must be kept, or "" when it can be retired
+func (e *Engine) oldAccountInUse(group Group) string {
+	if err := checkGroupSuppliers(group, e.ldap); err != nil {
+		return err.Error()
+	}
+	agreements, err := e.ldap.DiscoverReplicationAgreements()
+	if err != nil {
+		return fmt.Sprintf("the agreements could not be checked: %v", err)
+	}
+
+	// A dry run moved nothing, so the group's own agreements still bind as it
+	moved := make(map[string]bool)
+	if e.ldap.DryRun {
+		for _, agreement := range group.Agreements {
+			moved[strings.ToLower(agreement.Supplier)+"|"+strings.ToLower(agreement.DN)] = true
+		}
+	}
+	old := ldap.ReplicationAgreement{Consumer: group.Consumer, BindDN: group.BindDN}.ConsumerEntry()
+	for _, agreement := range agreements {
+		if agreement.ConsumerEntry() == old && !moved[strings.ToLower(agreement.Supplier)+"|"+strings.ToLower(agreement.DN)] {
+			return fmt.Sprintf("agreement %s on %s still binds as it", agreement.Name, agreement.Supplier)
+		}
+	}
+	return ""
+}
+
+// skipGroup reports every agreement of a group as failed without changing it
+func (e *Engine) skipGroup(group Group, err error, out io.Writer) []Result {
+	fmt.Fprintf(out, "Skipping %s on %s (%d agreements): %v\n", group.BindDN, group.Consumer, len(group.Agreements), err)
+	results := make([]Result, 0, len(group.Agreements))
+	for _, agreement := range group.Agreements {
+		e.markError(agreement, err, out)
+		results = append(results, Result{
+			Agreement: agreement.Name,
+			Supplier:  agreement.Supplier,
+			Consumer:  agreement.Consumer,
+			Err:       err,
+		})
+	}
+	return results
+}
+
+// recordMoved saves the password of an agreement moved to the group's new account
+// Rolled back agreements are not recorded, they still use the old password
+func (e *Engine) recordMoved(agreement ldap.ReplicationAgreement, group Group, out io.Writer) {
+	agreement.BindDN = group.NewAccount
+	e.recordRotation(agreement, group.Password, out)
+}
+
+// SetState attaches the state file that records the progress of the run
+// With a state loaded from an interrupted run, completed steps are skipped
+func (e *Engine) SetState(state *StateFile) {
+	e.state = state
+}
+
+// done reports whether the run state shows a step completed for an agreement
+func (e *Engine) done(agreement ldap.ReplicationAgreement, step string) bool {
+	return e.state != nil && e.state.Done(agreement, step)
+}
+
+// groupDone reports whether every agreement of a group completed a step
+func (e *Engine) groupDone(group Group, step string) bool {
+	for _, agreement := range group.Agreements {
+		if !e.done(agreement, step) {
+			return false
+		}
+	}
+	return true
+}
+
+// markStep saves the progress of an agreement to the run state
+// The change itself already happened, so a failure to save is only reported
+func (e *Engine) markStep(agreement ldap.ReplicationAgreement, step string, out io.Writer) {
+	if e.state == nil || e.ldap.DryRun {
+		return
+	}
+	if err := e.state.SetStep(agreement, step); err != nil {
+		fmt.Fprintf(out, "  WARNING: %v\n", err)
+	}
+}
+
+// markError saves why an agreement failed to the run state
+func (e *Engine) markError(agreement ldap.ReplicationAgreement, err error, out io.Writer) {
+	if e.state == nil || e.ldap.DryRun {
+		return
+	}
+	if saveErr := e.state.SetError(agreement, err); saveErr != nil {
+		fmt.Fprintf(out, "  WARNING: %v\n", saveErr)
+	}
+}
+
+// recordRotation saves an applied password when recording is enabled
+// The rotation already happened, so a failure to save is only reported
+func (e *Engine) recordRotation(agreement ldap.ReplicationAgreement, newPassword string, out io.Writer) {
+	if !e.record {
+		return
+	}
+	e.recordMutex.Lock()
+	err := e.passwords.RecordRotation(agreement, newPassword)
+	e.recordMutex.Unlock()
+	if err != nil {
+		fmt.Fprintf(out, "  WARNING: password for %s was applied but not saved: %v\n", agreement.Name, err)
+	}
+}
+
+// AllSucceeded reports whether every agreement was rotated
+func AllSucceeded(results []Result) bool {
+	for _, result := range results {
+		if !result.Success {
+			return false
+		}
+	}
+	return true
+}
+
+// PrintSummary writes a table with the outcome of every agreement
+// followed by a one-line total
+func PrintSummary(out io.Writer, results []Result) {
+	table := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "AGREEMENT\tSUPPLIER\tCONSUMER\tRESULT\tTIME\tDETAILS")
+	failed := 0
+	for _, result := range results {
+		status, message := "ok", result.Note
+		if !result.Success {
+			status, message = "FAILED", fmt.Sprint(result.Err)
+			if result.Note != "" {
+				status = "FAILED (" + result.Note + ")"
+			}
+			failed++
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\t%s\n", result.Agreement, result.Supplier, result.Consumer,
+			status, result.Duration.Round(time.Millisecond), message)
+	}
+	table.Flush()
+	fmt.Fprintf(out, "%d of %d agreements rotated, %d failed\n", len(results)-failed, len(results), failed)
+}
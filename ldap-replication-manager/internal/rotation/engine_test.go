@@ -0,0 +1,406 @@
+package rotation
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	goldap "github.com/go-ldap/ldap/v3"
+
+	"github.com/ldap-replication-manager/internal/audit"
+	"github.com/ldap-replication-manager/internal/config"
+	"github.com/ldap-replication-manager/internal/ldap"
+	"github.com/ldap-replication-manager/internal/ldap/ldaptest"
+	"github.com/ldap-replication-manager/internal/password"
+)
+
+const (
+	oldAccount = "cn=replication manager,cn=config"
+	newAccount = "cn=replication manager 2,cn=config"
+	bindGroup  = "cn=replication managers,cn=config"
+)
+
+// addTestAgreement stores an agreement whose last replication session
+// succeeded in the future, so verification passes at once
+func addTestAgreement(directory *ldaptest.Directory, agreement ldap.ReplicationAgreement) {
+	directory.AddEntry(agreement.Supplier, agreement.DN, map[string][]string{
+		"objectClass":                  {"top", "nsds5ReplicationAgreement"},
+		"cn":                           {agreement.Name},
+		"nsds5replicahost":             {agreement.Consumer},
+		"nsds5replicabinddn":           {agreement.ReplicationManagerDN()},
+		"nsds5replicacredentials":      {"old"},
+		"nsds5replicaroot":             {agreement.Suffix},
+		"nsds5replicaenabled":          {"on"},
+		"nsds5replicaLastUpdateStatus": {"Error (0) Replica acquired successfully: Incremental update succeeded"},
+		"nsds5replicaLastUpdateEnd":    {"20991231000000Z"},
+	})
+}
+
+// addConsumerAccount stores a replication manager account on a consumer
+func addConsumerAccount(directory *ldaptest.Directory, consumer, dn, userPassword string) {
+	directory.AddEntry(consumer, dn, map[string][]string{
+		"objectClass":  {"top", "person"},
+		"userPassword": {userPassword},
+	})
+}
+
+// bindGroupPlan is a bind-group rotation of the agreements to c1 from oldAccount to newAccount
+func bindGroupPlan(agreements ...ldap.ReplicationAgreement) *Plan {
+	return &Plan{Groups: []Group{{
+		Consumer:      "c1",
+		BindDN:        oldAccount,
+		Password:      "new password",
+		ConsumerValue: "new password",
+		Agreements:    agreements,
+		Strategy:      "bind-group",
+		NewAccount:    newAccount,
+		BindDNGroups:  []string{bindGroup},
+	}}}
+}
+
+// runEngine runs a plan and returns the results and the printed output
+func runEngine(cfg *config.Config, manager *ldap.Manager, plan *Plan) ([]Result, string) {
+	var out bytes.Buffer
+	engine := NewEngine(cfg, manager, password.NewManager(cfg), false, &out)
+	return engine.Run(plan), out.String()
+}
+
+func TestBindGroupRetiresOldAccount(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.LDAP.Suppliers = []string{"s2"}
+	manager, directory := newTestDirectory(t, cfg, "s1", "s2", "c1")
+	setRUV(directory, "c1", "s1", "s2")
+	addConsumerAccount(directory, "c1", oldAccount, "old")
+	directory.AddEntry("c1", bindGroup, map[string][]string{"objectClass": {"groupOfNames"}, "member": {oldAccount}})
+
+	first, second := testAgreement("s1-to-c1", "s1", "c1"), testAgreement("s2-to-c1", "s2", "c1")
+	addTestAgreement(directory, first)
+	addTestAgreement(directory, second)
+
+	results, output := runEngine(cfg, manager, bindGroupPlan(first, second))
+	if !AllSucceeded(results) {
+		t.Fatalf("results %+v\n%s", results, output)
+	}
+	if !strings.Contains(output, "Retired "+oldAccount) {
+		t.Errorf("old account not retired:\n%s", output)
+	}
+	if got := directory.Values("c1", oldAccount, "userPassword"); got != nil {
+		t.Errorf("retired account still has a password %v", got)
+	}
+	if got := strings.Join(directory.Values("c1", bindGroup, "member"), ";"); got != newAccount {
+		t.Errorf("bind group members %s, want only %s", got, newAccount)
+	}
+	for _, agreement := range []ldap.ReplicationAgreement{first, second} {
+		if got := directory.Values(agreement.Supplier, agreement.DN, "nsds5replicabinddn"); len(got) != 1 || got[0] != newAccount {
+			t.Errorf("%s binds as %v", agreement.Name, got)
+		}
+	}
+}
+
+func TestBindGroupKeepsOldAccountInUse(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.LDAP.Suppliers = []string{"s2"}
+	manager, directory := newTestDirectory(t, cfg, "s1", "s2", "c1")
+	setRUV(directory, "c1", "s1", "s2")
+	addConsumerAccount(directory, "c1", oldAccount, "old")
+	directory.AddEntry("c1", bindGroup, map[string][]string{"objectClass": {"groupOfNames"}, "member": {oldAccount}})
+
+	// The agreement on s2 was created after the plan was made
+	first := testAgreement("s1-to-c1", "s1", "c1")
+	addTestAgreement(directory, first)
+	addTestAgreement(directory, testAgreement("s2-to-c1", "s2", "c1"))
+
+	results, output := runEngine(cfg, manager, bindGroupPlan(first))
+	if !AllSucceeded(results) {
+		t.Fatalf("results %+v\n%s", results, output)
+	}
+	if !strings.Contains(output, "kept because agreement s2-to-c1 on s2 still binds as it") {
+		t.Errorf("old account not kept:\n%s", output)
+	}
+	if got := directory.Values("c1", oldAccount, "userPassword"); len(got) != 1 {
+		t.Errorf("old account lost its password")
+	}
+
+	// A supplier in the consumer's RUV that was never discovered may use it as well
+	cfg.LDAP.Suppliers = nil
+	directory.DeleteEntry("s2", testAgreement("s2-to-c1", "s2", "c1").DN)
+	_, output = runEngine(cfg, manager, bindGroupPlan(first))
+	if !strings.Contains(output, "kept because s2 replicates") {
+		t.Errorf("old account not kept for an undiscovered supplier:\n%s", output)
+	}
+}
+
+// inPlacePlan is an in-place rotation of one agreement per consumer
+func inPlacePlan(agreements ...ldap.ReplicationAgreement) *Plan {
+	plan := &Plan{}
+	for _, agreement := range agreements {
+		plan.Groups = append(plan.Groups, Group{
+			Consumer:      agreement.Consumer,
+			BindDN:        oldAccount,
+			Password:      "new password",
+			ConsumerValue: "new password",
+			Agreements:    []ldap.ReplicationAgreement{agreement},
+			Strategy:      "in-place",
+		})
+	}
+	return plan
+}
+
+func TestAuditFailureStopsNewGroups(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Rotation.Parallelism = 1
+	manager, directory := newTestDirectory(t, cfg, "s1", "c1", "c2")
+	first, second := testAgreement("s1-to-c1", "s1", "c1"), testAgreement("s1-to-c2", "s1", "c2")
+	for _, agreement := range []ldap.ReplicationAgreement{first, second} {
+		addConsumerAccount(directory, agreement.Consumer, oldAccount, "old")
+		addTestAgreement(directory, agreement)
+	}
+
+	auditLog, err := audit.Open(filepath.Join(t.TempDir(), "audit.log"), "alice@admin1", "run-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager.SetAuditLog(auditLog)
+	auditLog.Close()
+
+	results, output := runEngine(cfg, manager, inPlacePlan(first, second))
+	if len(results) != 2 {
+		t.Fatalf("%d results, want 2", len(results))
+	}
+
+	// The group already started is finished, so its supplier follows its consumer
+	if !results[0].Success {
+		t.Errorf("started group failed: %+v\n%s", results[0], output)
+	}
+	if got := directory.Values("s1", first.DN, "nsds5replicacredentials"); len(got) != 1 || got[0] != "new password" {
+		t.Errorf("supplier of the started group has %v", got)
+	}
+
+	// The next one is not touched
+	if results[1].Success || !strings.Contains(output, "Skipping "+oldAccount+" on c2") {
+		t.Errorf("second group not skipped: %+v\n%s", results[1], output)
+	}
+	if got := directory.Values("c2", oldAccount, "userPassword"); len(got) != 1 || got[0] != "old" {
+		t.Errorf("consumer of the skipped group has %v", got)
+	}
+}
+
+// consumerAgreements stores an agreement from supplier to each consumer,
+// with the consumer's replication manager account, and returns them
+func consumerAgreements(directory *ldaptest.Directory, supplier string, consumers ...string) []ldap.ReplicationAgreement {
+	var agreements []ldap.ReplicationAgreement
+	for _, consumer := range consumers {
+		agreement := testAgreement(supplier+"-to-"+consumer, supplier, consumer)
+		addConsumerAccount(directory, consumer, oldAccount, "old")
+		addTestAgreement(directory, agreement)
+		agreements = append(agreements, agreement)
+	}
+	return agreements
+}
+
+// barrier holds operations until count of them are waiting at once, and
+// records the most that were ever in progress together
+type barrier struct {
+	mutex    sync.Mutex
+	count    int
+	inFlight int
+	most     int
+	released bool
+	release  chan struct{}
+}
+
+func newBarrier(count int) *barrier {
+	return &barrier{count: count, release: make(chan struct{})}
+}
+
+// wait blocks until count operations arrived, or fails after a timeout
+// Operations arriving after the release pass at once
+func (b *barrier) wait() error {
+	b.mutex.Lock()
+	b.inFlight++
+	if b.inFlight > b.most {
+		b.most = b.inFlight
+	}
+	if b.inFlight == b.count && !b.released {
+		b.released = true
+		close(b.release)
+	}
+	b.mutex.Unlock()
+	defer func() {
+		b.mutex.Lock()
+		b.inFlight--
+		b.mutex.Unlock()
+	}()
+
+	select {
+	case <-b.release:
+		// Give operations that should be held back a chance to start
+		time.Sleep(10 * time.Millisecond)
+		return nil
+	case <-time.After(5 * time.Second):
+		return fmt.Errorf("only %d operations ran at once, want %d", b.inFlight, b.count)
+	}
+}
+
+func (b *barrier) maxInFlight() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.most
+}
+
+func TestEngineRotatesGroupsConcurrently(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Rotation.Parallelism = 3
+	cfg.LDAP.MaxConnectionsPerHost = 6
+	consumers := []string{"c1", "c2", "c3", "c4", "c5", "c6"}
+	manager, directory := newTestDirectory(t, cfg, append([]string{"s1"}, consumers...)...)
+	agreements := consumerAgreements(directory, "s1", consumers...)
+
+	// Each group holds its consumer update until parallelism groups do
+	consumerUpdates := newBarrier(cfg.Rotation.Parallelism)
+	directory.Hook = func(host, operation, dn string) error {
+		if operation == "modify" && host != "s1" {
+			return consumerUpdates.wait()
+		}
+		return nil
+	}
+
+	results, output := runEngine(cfg, manager, inPlacePlan(agreements...))
+	if !AllSucceeded(results) {
+		t.Fatalf("results %+v\n%s", results, output)
+	}
+	if got := consumerUpdates.maxInFlight(); got != cfg.Rotation.Parallelism {
+		t.Errorf("%d groups rotated at once, want %d", got, cfg.Rotation.Parallelism)
+	}
+
+	// Results and output follow the plan, whichever group finished first
+	last := -1
+	for i, result := range results {
+		if result.Agreement != agreements[i].Name {
+			t.Errorf("result %d is %s, want %s", i, result.Agreement, agreements[i].Name)
+		}
+		index := strings.Index(output, "Updating "+oldAccount+" on "+consumers[i]+" ")
+		if index < last {
+			t.Errorf("output of %s printed out of order:\n%s", consumers[i], output)
+		}
+		last = index
+	}
+	for _, consumer := range consumers {
+		if got := directory.Values(consumer, oldAccount, "userPassword"); len(got) != 1 || got[0] != "new password" {
+			t.Errorf("%s has password %v", consumer, got)
+		}
+	}
+}
+
+func TestEngineLimitsConnectionsPerHost(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Rotation.Parallelism = 6
+	cfg.LDAP.MaxConnectionsPerHost = 2
+	consumers := []string{"c1", "c2", "c3", "c4", "c5", "c6"}
+	manager, directory := newTestDirectory(t, cfg, append([]string{"s1"}, consumers...)...)
+	agreements := consumerAgreements(directory, "s1", consumers...)
+
+	// Every group updates the same supplier; only two may do so at once
+	supplierUpdates := newBarrier(cfg.LDAP.MaxConnectionsPerHost)
+	directory.Hook = func(host, operation, dn string) error {
+		if operation == "modify" && host == "s1" {
+			return supplierUpdates.wait()
+		}
+		return nil
+	}
+
+	results, output := runEngine(cfg, manager, inPlacePlan(agreements...))
+	if !AllSucceeded(results) {
+		t.Fatalf("results %+v\n%s", results, output)
+	}
+	if got := supplierUpdates.maxInFlight(); got != cfg.LDAP.MaxConnectionsPerHost {
+		t.Errorf("%d supplier updates at once, want %d", got, cfg.LDAP.MaxConnectionsPerHost)
+	}
+	if got := directory.MaxOpen("s1"); got != cfg.LDAP.MaxConnectionsPerHost {
+		t.Errorf("%d connections open to s1 at once, want %d", got, cfg.LDAP.MaxConnectionsPerHost)
+	}
+	for _, consumer := range consumers {
+		if got := directory.MaxOpen(consumer); got != 1 {
+			t.Errorf("%d connections open to %s at once, want 1", got, consumer)
+		}
+	}
+}
+
+func TestEngineReportsPartialFailure(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Rotation.Parallelism = 3
+	cfg.LDAP.MaxConnectionsPerHost = 2
+	cfg.LDAP.Suppliers = []string{"s2"}
+	manager, directory := newTestDirectory(t, cfg, "s1", "s2", "c1", "c2", "c3")
+	toC1, toC2 := consumerAgreements(directory, "s1", "c1", "c2")[0], testAgreement("s1-to-c2", "s1", "c2")
+	toC3 := consumerAgreements(directory, "s1", "c3")[0]
+	fromS2 := testAgreement("s2-to-c3", "s2", "c3")
+	addTestAgreement(directory, fromS2)
+
+	// c2 refuses its new password; s2 cannot store the new credentials
+	directory.Hook = func(host, operation, dn string) error {
+		if operation != "modify" {
+			return nil
+		}
+		if host == "c2" || host == "s2" {
+			return goldap.NewError(goldap.LDAPResultUnwillingToPerform, fmt.Errorf("refused by %s", host))
+		}
+		return nil
+	}
+
+	plan := inPlacePlan(toC1, toC2)
+	plan.Groups = append(plan.Groups, Group{
+		Consumer:      "c3",
+		BindDN:        oldAccount,
+		Password:      "new password",
+		ConsumerValue: "new password",
+		Agreements:    []ldap.ReplicationAgreement{toC3, fromS2},
+		Strategy:      "in-place",
+	})
+	results, output := runEngine(cfg, manager, plan)
+
+	if AllSucceeded(results) {
+		t.Fatalf("a run with failures reported success:\n%s", output)
+	}
+	want := []struct {
+		agreement string
+		success   bool
+		err       string
+	}{
+		{"s1-to-c1", true, ""},
+		{"s1-to-c2", false, "consumer update failed"},
+		{"s1-to-c3", true, ""},
+		{"s2-to-c3", false, "refused by s2"},
+	}
+	if len(results) != len(want) {
+		t.Fatalf("%d results, want %d:\n%s", len(results), len(want), output)
+	}
+	for i, w := range want {
+		result := results[i]
+		if result.Agreement != w.agreement || result.Success != w.success {
+			t.Errorf("result %d = %s success %v, want %s success %v", i, result.Agreement, result.Success, w.agreement, w.success)
+		}
+		if w.err != "" && (result.Err == nil || !strings.Contains(result.Err.Error(), w.err)) {
+			t.Errorf("%s error %v, want %q", w.agreement, result.Err, w.err)
+		}
+	}
+
+	// A consumer that was not updated keeps its supplier on the old password
+	if got := directory.Values("s1", toC2.DN, "nsds5replicacredentials"); len(got) != 1 || got[0] != "old" {
+		t.Errorf("supplier of the failed consumer has %v", got)
+	}
+	// A failed supplier does not stop the other supplier of its group
+	if got := directory.Values("s1", toC3.DN, "nsds5replicacredentials"); len(got) != 1 || got[0] != "new password" {
+		t.Errorf("working supplier of the partly failed group has %v", got)
+	}
+
+	var summary bytes.Buffer
+	PrintSummary(&summary, results)
+	if !strings.Contains(summary.String(), "2 of 4 agreements rotated, 2 failed") {
+		t.Errorf("summary:\n%s", summary.String())
+	}
+}
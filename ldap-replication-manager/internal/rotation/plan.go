@@ -0,0 +1,280 @@
+package rotation
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/ldap-replication-manager/internal/config"
+	"github.com/ldap-replication-manager/internal/ldap"
+)
+
+// Group is a set of agreements whose suppliers bind as the same consumer entry
+// In most topologies every supplier binds to a consumer as
+// cn=replication manager,cn=config, so changing that entry's password breaks
+// every supplier pointing at it until their agreements are updated as well
+// A group is therefore rotated as one unit: the consumer entry first, then
+// every supplier agreement that references it
+type Group struct {
+	// Consumer host and the DN of the entry the suppliers bind as
+	Consumer string
+	BindDN   string
+
+	// The one password shared by the whole group
+	Password string
+
+	// The userPassword value written on the consumer: the password hashed
+	// with the consumer password scheme, or the clear text without one
+	// Set by HashConsumerPasswords; it is not saved in the run state
+	ConsumerValue string
+
+	// Agreements in discovery order
+	Agreements []ldap.ReplicationAgreement
+
+	// How the group is rotated, "in-place" or "bind-group"
+	Strategy string
+
+	// Bind-group strategy only: the account the suppliers move to, the
+	// consumer groups that must contain it and how long the consumer takes
+	// to notice a membership change
+	NewAccount         string
+	BindDNGroups       []string
+	GroupCheckInterval int
+}
+
+// Plan is the ordered list of groups a run will rotate
+type Plan struct {
+	Groups []Group
+}
+
+// NewPlan groups agreements by consumer host and bind DN
+// Groups and the agreements inside them keep the discovery order,
+// so the output of a run is stable from one run to the next
+// Every agreement of a group must have been given the same password
+func NewPlan(agreements []ldap.ReplicationAgreement, newPasswords map[string]string) (*Plan, error) {
+	plan := &Plan{}
+	index := make(map[string]int)
+	for _, agreement := range agreements {
+		key := agreement.ConsumerEntry()
+		i, ok := index[key]
+		if !ok {
+			i = len(plan.Groups)
+			index[key] = i
+			plan.Groups = append(plan.Groups, Group{
+				Consumer: agreement.Consumer,
+				BindDN:   agreement.ReplicationManagerDN(),
+				Password: newPasswords[agreement.Name],
+				Strategy: "in-place",
+			})
+		}
+		group := &plan.Groups[i]
+		if newPasswords[agreement.Name] != group.Password {
+			return nil, fmt.Errorf("agreements %s and %s bind as %s on %s but were given different passwords",
+				group.Agreements[0].Name, agreement.Name, group.BindDN, group.Consumer)
+		}
+		group.Agreements = append(group.Agreements, agreement)
+	}
+	return plan, nil
+}
+
+// Agreements returns every agreement of the plan, group by group
+func (p *Plan) Agreements() []ldap.ReplicationAgreement {
+	var agreements []ldap.ReplicationAgreement
+	for _, group := range p.Groups {
+		agreements = append(agreements, group.Agreements...)
+	}
+	return agreements
+}
+
+// CheckCompleteGroups makes sure a selection does not split a consumer entry
+// Rotating the consumer entry of a selected agreement changes the password
+// every other agreement binding as that entry relies on, so leaving one of
+// them out would break its replication with error 49
+func CheckCompleteGroups(all, selected []ldap.ReplicationAgreement) error {
+	selectedNames := make(map[string]bool)
+	selectedEntries := make(map[string]string)
+	for _, agreement := range selected {
+		selectedNames[agreement.Name] = true
+		selectedEntries[agreement.ConsumerEntry()] = agreement.Name
+	}
+	for _, agreement := range all {
+		if selectedNames[agreement.Name] {
+			continue
+		}
+		if name, ok := selectedEntries[agreement.ConsumerEntry()]; ok {
+			return fmt.Errorf("agreement %s binds as %s on %s like the selected agreement %s; select both or neither",
+				agreement.Name, agreement.ReplicationManagerDN(), agreement.Consumer, name)
+		}
+	}
+	return nil
+}
+
+// CheckSuppliers makes sure every supplier of a group's consumer was discovered
+// Any supplier in the consumer's RUV may bind as the group's entry; if its
+// agreements were not discovered they would keep the old password and fail
+// with error 49 after the rotation, so such a group stops the run
+// The consumer itself is in its own RUV when it is a supplier too
+func (p *Plan) CheckSuppliers(ldapManager *ldap.Manager) error {
+	for _, group := range p.Groups {
+		if err := checkGroupSuppliers(group, ldapManager); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkGroupSuppliers reads the RUV of every suffix of a group on its consumer
+// and returns an error for the first supplier that was not discovered
+func checkGroupSuppliers(group Group, ldapManager *ldap.Manager) error {
+	discovered := make(map[string]bool)
+	for _, supplier := range ldapManager.Suppliers() {
+		discovered[strings.ToLower(supplier)] = true
+	}
+	seenSuffixes := make(map[string]bool)
+	for _, agreement := range group.Agreements {
+		if agreement.Suffix == "" {
+			return fmt.Errorf("agreement %s has no nsds5replicaroot, so the suppliers of %s cannot be checked", agreement.Name, group.Consumer)
+		}
+		if seenSuffixes[strings.ToLower(agreement.Suffix)] {
+			continue
+		}
+		seenSuffixes[strings.ToLower(agreement.Suffix)] = true
+
+		suppliers, err := ldapManager.ReadReplicaSuppliers(group.Consumer, agreement.Suffix)
+		if err != nil {
+			return err
+		}
+		for _, supplier := range suppliers {
+			if discovered[strings.ToLower(supplier)] || strings.EqualFold(supplier, group.Consumer) {
+				continue
+			}
+			return fmt.Errorf("%s replicates %s to %s, which may bind as %s, but its agreements were not discovered; add it to ldap.suppliers",
+				supplier, agreement.Suffix, group.Consumer, group.BindDN)
+		}
+	}
+	return nil
+}
+
+// ChooseStrategies decides how each group is rotated
+// The bind-group strategy needs every replica the group replicates to on the
+// consumer to accept a bind DN group that is re-read at runtime
+// (nsds5ReplicaBindDNGroupCheckInterval of 0 or more); other groups fall back
+// to in-place rotation and the reason is printed
+func (p *Plan) ChooseStrategies(cfg *config.Config, ldapManager *ldap.Manager, out io.Writer) {
+	if cfg.Rotation.Strategy != "bind-group" {
+		return
+	}
+	for i := range p.Groups {
+		group := &p.Groups[i]
+		groupDNs, interval, err := readBindDNGroups(group, ldapManager)
+		if err != nil {
+			fmt.Fprintf(out, "  %s on %s: using in-place rotation: %v\n", group.BindDN, group.Consumer, err)
+			continue
+		}
+		group.Strategy = "bind-group"
+		group.NewAccount = alternateAccount(cfg.Rotation.Accounts, group.BindDN)
+		group.BindDNGroups = groupDNs
+		group.GroupCheckInterval = interval
+		fmt.Fprintf(out, "  %s on %s: bind-group rotation to %s\n", group.BindDN, group.Consumer, group.NewAccount)
+	}
+}
+
+// HashConsumerPasswords computes the userPassword value of every group once
+// Hashing again would pick a new salt, so the printed ldapmodify commands
+// and the applied changes both use the value computed here
+func (p *Plan) HashConsumerPasswords(ldapManager *ldap.Manager) error {
+	for i := range p.Groups {
+		group := &p.Groups[i]
+		if group.Password == "" {
+			continue
+		}
+		value, err := ldapManager.ConsumerPasswordValue(group.Password)
+		if err != nil {
+			return fmt.Errorf("%s on %s: %v", group.BindDN, group.Consumer, err)
+		}
+		group.ConsumerValue = value
+	}
+	return nil
+}
+
+// readBindDNGroups reads the consumer replica of every suffix in a group
+// It returns the distinct bind DN groups and the longest check interval
+func readBindDNGroups(group *Group, ldapManager *ldap.Manager) ([]string, int, error) {
+	var groupDNs []string
+	seenSuffixes := make(map[string]bool)
+	seenGroups := make(map[string]bool)
+	interval := 0
+	for _, agreement := range group.Agreements {
+		if agreement.Suffix == "" {
+			return nil, 0, fmt.Errorf("agreement %s has no nsds5replicaroot", agreement.Name)
+		}
+		if seenSuffixes[strings.ToLower(agreement.Suffix)] {
+			continue
+		}
+		seenSuffixes[strings.ToLower(agreement.Suffix)] = true
+
+		replica, err := ldapManager.ReadReplicaConfig(group.Consumer, agreement.Suffix)
+		if err != nil {
+			return nil, 0, err
+		}
+		if replica.BindDNGroup == "" {
+			return nil, 0, fmt.Errorf("replica %s has no nsds5ReplicaBindDNGroup", replica.DN)
+		}
+		if replica.GroupCheckInterval < 0 {
+			return nil, 0, fmt.Errorf("replica %s only reads its bind DN group at startup (nsds5ReplicaBindDNGroupCheckInterval is -1)", replica.DN)
+		}
+		if !seenGroups[strings.ToLower(replica.BindDNGroup)] {
+			seenGroups[strings.ToLower(replica.BindDNGroup)] = true
+			groupDNs = append(groupDNs, replica.BindDNGroup)
+		}
+		if replica.GroupCheckInterval > interval {
+			interval = replica.GroupCheckInterval
+		}
+	}
+	return groupDNs, interval, nil
+}
+
+// alternateAccount returns the configured account that is not currently in use
+func alternateAccount(accounts []string, current string) string {
+	for _, account := range accounts {
+		if !ldap.SameDN(account, current) {
+			return account
+		}
+	}
+	return accounts[0]
+}
+
+// Print shows the planned changes group by group
+// The consumer change is listed before the supplier changes that depend on it,
+// together with the ldapmodify commands for applying the plan by hand
+func (p *Plan) Print(out io.Writer, ldapManager *ldap.Manager) {
+	count := 0
+	for _, group := range p.Groups {
+		count += len(group.Agreements)
+	}
+	fmt.Fprintf(out, "%d agreements in %d consumer entry groups\n", count, len(p.Groups))
+
+	for i, group := range p.Groups {
+		first := group.Agreements[0]
+		fmt.Fprintf(out, "\nGroup %d: %s on %s (%d agreements)\n", i+1, group.BindDN, group.Consumer, len(group.Agreements))
+		fmt.Fprintf(out, "  New Password: %s\n", group.Password)
+		if group.Strategy == "bind-group" {
+			fmt.Fprintf(out, "  Strategy: bind-group (replication keeps working during the rotation)\n")
+			fmt.Fprintf(out, "  1. Create or refresh %s on %s with the new password\n", group.NewAccount, group.Consumer)
+			fmt.Fprintf(out, "     and add it to %s\n", strings.Join(group.BindDNGroups, ", "))
+			fmt.Fprintf(out, "  2. Move every supplier agreement to it and verify replication:\n")
+			for _, agreement := range group.Agreements {
+				fmt.Fprintf(out, "     Agreement: %s (supplier %s -> consumer %s)\n", agreement.Name, agreement.Supplier, agreement.Consumer)
+			}
+			fmt.Fprintf(out, "  3. Retire %s once every agreement is verified\n", group.BindDN)
+			continue
+		}
+		fmt.Fprintf(out, "  1. Update the consumer entry once:\n")
+		fmt.Fprintf(out, "     %s\n", ldapManager.GeneratePasswordUpdateCommand(first, group.ConsumerValue, "consumer"))
+		fmt.Fprintf(out, "  2. Then update every supplier agreement that binds as it:\n")
+		for _, agreement := range group.Agreements {
+			fmt.Fprintf(out, "     Agreement: %s (supplier %s -> consumer %s)\n", agreement.Name, agreement.Supplier, agreement.Consumer)
+			fmt.Fprintf(out, "     %s\n", ldapManager.GeneratePasswordUpdateCommand(agreement, group.Password, "supplier"))
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package rotation
+
+import (
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/ldap-replication-manager/internal/config"
+	"github.com/ldap-replication-manager/internal/ldap"
+	"github.com/ldap-replication-manager/internal/ldap/ldaptest"
+)
+
+const (
+	testBindDN   = "cn=Directory Manager"
+	testPassword = "admin secret"
+	testSuffix   = "dc=example,dc=com"
+	testRUVDN    = "nsuniqueid=ffffffff-ffffffff-ffffffff-ffffffff,dc=example,dc=com"
+)
+
+// newTestDirectory returns an in-memory directory with the given servers
+// and a manager whose ldap.host is the first of them
+func newTestDirectory(t *testing.T, cfg *config.Config, hosts ...string) (*ldap.Manager, *ldaptest.Directory) {
+	t.Helper()
+	directory := ldaptest.NewDirectory()
+	for _, host := range hosts {
+		directory.AddServer(host, testBindDN, testPassword)
+		directory.AddEntry(host, "cn=config", map[string][]string{"objectClass": {"top"}})
+	}
+	cfg.LDAP.Host = hosts[0]
+	cfg.LDAP.BindDN = testBindDN
+	cfg.LDAP.Password = testPassword
+	cfg.LDAP.BaseDN = "cn=config"
+	manager, err := ldap.NewManagerWithDialer(cfg, directory.Dial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager.SetLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	t.Cleanup(manager.Close)
+	return manager, directory
+}
+
+// setRUV stores the RUV of testSuffix on a consumer naming the given suppliers
+func setRUV(directory *ldaptest.Directory, consumer string, suppliers ...string) {
+	directory.AddEntry(consumer, testSuffix, map[string][]string{"objectClass": {"top", "domain"}})
+	ruv := []string{"{replicageneration} 6531b7e2000000010000"}
+	for i, supplier := range suppliers {
+		ruv = append(ruv, "{replica "+strconv.Itoa(i+1)+" ldap://"+supplier+":389} 6531b7e3000000010000 6531c0a1000000010000")
+	}
+	directory.AddEntry(consumer, testRUVDN, map[string][]string{
+		"objectClass": {"top", "nsTombstone"},
+		"nsuniqueid":  {"ffffffff-ffffffff-ffffffff-ffffffff"},
+		"nsds50ruv":   ruv,
+	})
+}
+
+func testAgreement(name, supplier, consumer string) ldap.ReplicationAgreement {
+	return ldap.ReplicationAgreement{
+		Name:     name,
+		Supplier: supplier,
+		Consumer: consumer,
+		BindDN:   "cn=replication manager,cn=config",
+		DN:       "cn=" + name + ",cn=replica,cn=dc\\=example\\,dc\\=com,cn=mapping tree,cn=config",
+		Suffix:   testSuffix,
+		Enabled:  true,
+	}
+}
+
+func TestCheckSuppliers(t *testing.T) {
+	tests := []struct {
+		name      string
+		suppliers []string // ldap.suppliers
+		ruv       []string // suppliers in the consumer's RUV
+		wantErr   string
+	}{
+		{"only ldap.host", nil, []string{"s1"}, ""},
+		{"second supplier listed", []string{"s2"}, []string{"s1", "S2"}, ""},
+		{"consumer is a supplier too", nil, []string{"s1", "c1"}, ""},
+		{"second supplier not listed", nil, []string{"s1", "s2"}, "s2 replicates dc=example,dc=com to c1"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := &config.Config{}
+			cfg.LDAP.Suppliers = test.suppliers
+			manager, directory := newTestDirectory(t, cfg, "s1", "s2", "c1")
+			setRUV(directory, "c1", test.ruv...)
+
+			plan, err := NewPlan([]ldap.ReplicationAgreement{testAgreement("s1-to-c1", "s1", "c1")}, map[string]string{"s1-to-c1": "new"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = plan.CheckSuppliers(manager)
+			if test.wantErr == "" {
+				if err != nil {
+					t.Errorf("CheckSuppliers() = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("CheckSuppliers() = %v, want %q", err, test.wantErr)
+			}
+		})
+	}
+
+	// Without an RUV the suppliers of the consumer are unknown
+	manager, _ := newTestDirectory(t, &config.Config{}, "s1", "c1")
+	plan, _ := NewPlan([]ldap.ReplicationAgreement{testAgreement("s1-to-c1", "s1", "c1")}, map[string]string{"s1-to-c1": "new"})
+	if err := plan.CheckSuppliers(manager); err == nil {
+		t.Error("a consumer whose RUV cannot be read must stop the run")
+	}
+}
@@ -0,0 +1,280 @@
+package rotation
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ldap-replication-manager/internal/ldap"
+	"github.com/ldap-replication-manager/internal/password"
+)
+
+// Steps an agreement goes through during a rotation, in order
+// A resumed run skips every step an agreement has already completed
+const (
+	StepPlanned         = "planned"
+	StepConsumerUpdated = "consumer-updated"
+	StepSupplierUpdated = "supplier-updated"
+	StepVerified        = "verified"
+)
+
+// stepOrder ranks the steps so progress can be compared
+var stepOrder = map[string]int{
+	StepPlanned:         0,
+	StepConsumerUpdated: 1,
+	StepSupplierUpdated: 2,
+	StepVerified:        3,
+}
+
+// RunState is the content of a state file
+// It is rewritten after every step, so a killed process loses at most the
+// step that was in progress
+// The new passwords are only stored encrypted, in SealedPasswords
+type RunState struct {
+	RunID     string    `json:"run_id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Completed bool      `json:"completed"`
+
+	// Operator who created the run, as user@host
+	CreatedBy string `json:"created_by,omitempty"`
+
+	Groups []GroupState `json:"groups"`
+
+	// Password of every group, sealed with the local vault passphrase
+	SealedPasswords json.RawMessage `json:"sealed_passwords"`
+}
+
+// GroupState is the saved form of a plan group
+type GroupState struct {
+	Consumer           string           `json:"consumer"`
+	BindDN             string           `json:"bind_dn"`
+	Strategy           string           `json:"strategy"`
+	NewAccount         string           `json:"new_account,omitempty"`
+	BindDNGroups       []string         `json:"bind_dn_groups,omitempty"`
+	GroupCheckInterval int              `json:"group_check_interval,omitempty"`
+	Agreements         []AgreementState `json:"agreements"`
+}
+
+// AgreementState is the progress of one agreement
+type AgreementState struct {
+	Agreement ldap.ReplicationAgreement `json:"agreement"`
+	Step      string                    `json:"step"`
+	UpdatedAt time.Time                 `json:"updated_at"`
+	Error     string                    `json:"error,omitempty"`
+}
+
+// StateFile keeps the progress of a run on disk
+// It is safe for concurrent use by the rotation workers
+type StateFile struct {
+	path  string
+	mutex sync.Mutex
+	state RunState
+}
+
+// statePath returns the state file of a run
+func statePath(dir, runID string) string {
+	return filepath.Join(dir, runID+".json")
+}
+
+// NewStateFile writes the initial state of a run: every agreement planned
+// The secret encrypts the group passwords; without it nothing could be
+// resumed, so an empty secret is an error
+// createdBy identifies the operator, which the four-eyes check relies on
+func NewStateFile(dir, runID, createdBy string, secret []byte, plan *Plan) (*StateFile, error) {
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("no passphrase to encrypt the run state")
+	}
+
+	passwords := make([]string, len(plan.Groups))
+	for i, group := range plan.Groups {
+		passwords[i] = group.Password
+	}
+	plaintext, err := json.Marshal(passwords)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := password.Seal(secret, plaintext)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt run state: %v", err)
+	}
+
+	now := time.Now().UTC()
+	state := RunState{RunID: runID, CreatedAt: now, UpdatedAt: now, CreatedBy: createdBy, SealedPasswords: sealed}
+	for _, group := range plan.Groups {
+		groupState := GroupState{
+			Consumer:           group.Consumer,
+			BindDN:             group.BindDN,
+			Strategy:           group.Strategy,
+			NewAccount:         group.NewAccount,
+			BindDNGroups:       group.BindDNGroups,
+			GroupCheckInterval: group.GroupCheckInterval,
+		}
+		for _, agreement := range group.Agreements {
+			groupState.Agreements = append(groupState.Agreements, AgreementState{Agreement: agreement, Step: StepPlanned, UpdatedAt: now})
+		}
+		state.Groups = append(state.Groups, groupState)
+	}
+
+	file := &StateFile{path: statePath(dir, runID), state: state}
+	if err := file.save(); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// LoadStateFile reads the state of an interrupted run
+// It returns the state and the plan rebuilt from it, passwords included
+// The groups keep the strategy and accounts chosen by the original run
+func LoadStateFile(dir, runID string, secret []byte) (*StateFile, *Plan, error) {
+	state, err := ReadRunState(dir, runID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	plaintext, err := password.OpenSealed(secret, state.SealedPasswords)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decrypt state of run %s: %v", runID, err)
+	}
+	var passwords []string
+	if err := json.Unmarshal(plaintext, &passwords); err != nil || len(passwords) != len(state.Groups) {
+		return nil, nil, fmt.Errorf("state of run %s is damaged", runID)
+	}
+
+	plan := &Plan{}
+	for i, groupState := range state.Groups {
+		group := Group{
+			Consumer:           groupState.Consumer,
+			BindDN:             groupState.BindDN,
+			Password:           passwords[i],
+			Strategy:           groupState.Strategy,
+			NewAccount:         groupState.NewAccount,
+			BindDNGroups:       groupState.BindDNGroups,
+			GroupCheckInterval: groupState.GroupCheckInterval,
+		}
+		for _, agreementState := range groupState.Agreements {
+			group.Agreements = append(group.Agreements, agreementState.Agreement)
+		}
+		plan.Groups = append(plan.Groups, group)
+	}
+
+	return &StateFile{path: statePath(dir, runID), state: *state}, plan, nil
+}
+
+// ReadRunState reads the state of a run that has not completed
+// The passwords stay encrypted, so no passphrase is needed; this is what an
+// approver reviews
+func ReadRunState(dir, runID string) (*RunState, error) {
+	path := statePath(dir, runID)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state of run %s: %v", runID, err)
+	}
+	var state RunState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	if state.Completed {
+		return nil, fmt.Errorf("run %s already completed", runID)
+	}
+	return &state, nil
+}
+
+// State returns a copy of the saved state
+func (s *StateFile) State() RunState {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.state
+}
+
+// Path returns where the state file is stored
+func (s *StateFile) Path() string {
+	return s.path
+}
+
+// Step returns the last completed step of an agreement and when it completed
+// Agreements are identified by supplier and DN: with several suppliers two
+// agreements may share a cn, and the progress of one must not skip the other
+func (s *StateFile) Step(agreement ldap.ReplicationAgreement) (string, time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if saved := s.find(agreement); saved != nil {
+		return saved.Step, saved.UpdatedAt
+	}
+	return StepPlanned, time.Time{}
+}
+
+// Done reports whether an agreement has completed at least the given step
+func (s *StateFile) Done(agreement ldap.ReplicationAgreement, step string) bool {
+	current, _ := s.Step(agreement)
+	return stepOrder[current] >= stepOrder[step]
+}
+
+// SetStep records that an agreement completed a step and saves the file
+func (s *StateFile) SetStep(agreement ldap.ReplicationAgreement, step string) error {
+	return s.update(agreement, func(saved *AgreementState) {
+		saved.Step = step
+		saved.Error = ""
+	})
+}
+
+// SetError records why an agreement could not complete its next step
+// The completed step is kept, so a resumed run retries from there
+func (s *StateFile) SetError(agreement ldap.ReplicationAgreement, err error) error {
+	return s.update(agreement, func(saved *AgreementState) {
+		saved.Error = err.Error()
+	})
+}
+
+// Finish marks the run as completed; a completed run cannot be resumed
+func (s *StateFile) Finish() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.state.Completed = true
+	s.state.UpdatedAt = time.Now().UTC()
+	return s.save()
+}
+
+// update changes one agreement and saves the file
+func (s *StateFile) update(agreement ldap.ReplicationAgreement, change func(*AgreementState)) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	saved := s.find(agreement)
+	if saved == nil {
+		return fmt.Errorf("agreement %s on %s is not part of run %s", agreement.DN, agreement.Supplier, s.state.RunID)
+	}
+	change(saved)
+	saved.UpdatedAt = time.Now().UTC()
+	s.state.UpdatedAt = saved.UpdatedAt
+	return s.save()
+}
+
+// find returns the state of an agreement; the caller holds the mutex
+// Host names and DNs are compared without case, as LDAP does
+func (s *StateFile) find(agreement ldap.ReplicationAgreement) *AgreementState {
+	for i := range s.state.Groups {
+		for j := range s.state.Groups[i].Agreements {
+			saved := &s.state.Groups[i].Agreements[j]
+			if strings.EqualFold(saved.Agreement.Supplier, agreement.Supplier) && strings.EqualFold(saved.Agreement.DN, agreement.DN) {
+				return saved
+			}
+		}
+	}
+	return nil
+}
+
+// save writes the state atomically; the caller holds the mutex
+func (s *StateFile) save() error {
+	data, err := json.MarshalIndent(s.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := password.WriteFileAtomic(s.path, data, 0600); err != nil {
+		return fmt.Errorf("failed to save run state %s: %v", s.path, err)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package rotation
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+var testSecret = []byte("state passphrase")
+
+func TestStateKeyedBySupplierAndDN(t *testing.T) {
+	// Both suppliers name their agreement to the consumer the same way
+	first := testAgreement("to-consumer", "supplier1.example.com", "consumer.example.com")
+	second := testAgreement("to-consumer", "supplier2.example.com", "consumer.example.com")
+	plan := inPlacePlan(first, second)
+
+	dir := t.TempDir()
+	state, err := NewStateFile(dir, "r1", "alice@admin1", testSecret, plan)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := state.SetStep(first, StepSupplierUpdated); err != nil {
+		t.Fatal(err)
+	}
+	if !state.Done(first, StepConsumerUpdated) || !state.Done(first, StepSupplierUpdated) || state.Done(first, StepVerified) {
+		step, _ := state.Step(first)
+		t.Errorf("first agreement at %s, want %s", step, StepSupplierUpdated)
+	}
+	if step, _ := state.Step(second); step != StepPlanned {
+		t.Errorf("the agreement with the same cn on another supplier is at %s, want %s", step, StepPlanned)
+	}
+
+	// Host names and DNs do not depend on case
+	upper := first
+	upper.Supplier = strings.ToUpper(first.Supplier)
+	upper.DN = strings.ToUpper(first.DN)
+	if step, _ := state.Step(upper); step != StepSupplierUpdated {
+		t.Errorf("agreement with upper case supplier and DN at %s, want %s", step, StepSupplierUpdated)
+	}
+
+	if err := state.SetError(second, errors.New("consumer down")); err != nil {
+		t.Fatal(err)
+	}
+
+	// The saved progress survives a resume
+	resumed, resumedPlan, err := LoadStateFile(dir, "r1", testSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resumedPlan.Groups) != 2 || resumedPlan.Groups[1].Password != "new password" {
+		t.Fatalf("resumed plan %+v", resumedPlan.Groups)
+	}
+	if !resumed.Done(first, StepSupplierUpdated) || resumed.Done(second, StepConsumerUpdated) {
+		t.Error("resumed run lost the progress of its agreements")
+	}
+	saved := resumed.State().Groups[1].Agreements[0]
+	if saved.Agreement.Supplier != second.Supplier || saved.Error != "consumer down" {
+		t.Errorf("second agreement saved as %+v", saved)
+	}
+
+	unknown := testAgreement("to-consumer", "supplier3.example.com", "consumer.example.com")
+	if err := state.SetStep(unknown, StepVerified); err == nil || !strings.Contains(err.Error(), "supplier3.example.com") {
+		t.Errorf("SetStep() of an agreement outside the run = %v", err)
+	}
+}
+
+func TestStateRequiresSecret(t *testing.T) {
+	plan := inPlacePlan(testAgreement("to-consumer", "supplier1.example.com", "consumer.example.com"))
+	if _, err := NewStateFile(t.TempDir(), "r1", "alice@admin1", nil, plan); err == nil {
+		t.Error("a state file must not be written without a passphrase")
+	}
+}
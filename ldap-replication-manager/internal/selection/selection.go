@@ -0,0 +1,230 @@
+package selection
+
+import (
+	"flag"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/ldap-replication-manager/internal/ldap"
+)
+
+// Selector chooses which replication agreements an operation works on
+// Every command that handles agreements (discover, plan, apply, verify,
+// monitor) takes the same selection flags, so an operator can check a
+// selection with discover before rotating it
+// Criteria of different kinds must all match; values given several times
+// for the same criterion match if any of them does
+// An empty selector selects every agreement
+type Selector struct {
+	// Agreement names (cn), exact or with shell wildcards such as "to-dc2-*"
+	Names []string
+
+	// Regular expression the agreement name must match
+	Pattern *regexp.Regexp
+
+	// Consumer hosts, exact or with shell wildcards
+	Consumers []string
+
+	// Supplier hosts holding the agreement, exact or with shell wildcards
+	Suppliers []string
+
+	// Replicated suffixes, compared as DNs
+	Suffixes []string
+
+	// "yes" or "no" to select only enabled or disabled agreements
+	Enabled string
+
+	// Class of the last replication session: ok, error, auth-error or unknown
+	// "error" includes auth-error
+	Status string
+
+	// Agreement names (exact or wildcards) removed from the selection
+	Exclude []string
+}
+
+// listFlag is a flag that may be given several times
+type listFlag struct {
+	values *[]string
+}
+
+func (l listFlag) String() string {
+	if l.values == nil {
+		return ""
+	}
+	return strings.Join(*l.values, ",")
+}
+
+func (l listFlag) Set(value string) error {
+	*l.values = append(*l.values, value)
+	return nil
+}
+
+// regexpFlag compiles the regular expression when the flag is parsed
+type regexpFlag struct {
+	pattern **regexp.Regexp
+}
+
+func (r regexpFlag) String() string {
+	if r.pattern == nil || *r.pattern == nil {
+		return ""
+	}
+	return (*r.pattern).String()
+}
+
+func (r regexpFlag) Set(value string) error {
+	pattern, err := regexp.Compile(value)
+	if err != nil {
+		return err
+	}
+	*r.pattern = pattern
+	return nil
+}
+
+// AddFlags registers the selection flags on a command's flag set
+// Call Validate after parsing
+func (s *Selector) AddFlags(flags *flag.FlagSet) {
+	flags.Var(listFlag{&s.Names}, "agreement", "Select agreements by name or shell wildcard (repeatable)")
+	flags.Var(regexpFlag{&s.Pattern}, "match", "Select agreements whose name matches this regular expression")
+	flags.Var(listFlag{&s.Consumers}, "consumer", "Select agreements by consumer host or shell wildcard (repeatable)")
+	flags.Var(listFlag{&s.Suppliers}, "supplier", "Select agreements by supplier host or shell wildcard (repeatable)")
+	flags.Var(listFlag{&s.Suffixes}, "suffix", "Select agreements replicating this suffix (repeatable)")
+	flags.StringVar(&s.Enabled, "enabled", "", "Select only enabled (yes) or disabled (no) agreements")
+	flags.StringVar(&s.Status, "status", "", "Select by last replication status: ok, error, auth-error or unknown")
+	flags.Var(listFlag{&s.Exclude}, "exclude", "Leave out agreements by name or shell wildcard (repeatable)")
+}
+
+// Validate checks the flag values that cannot be checked while parsing
+func (s *Selector) Validate() error {
+	switch s.Enabled {
+	case "", "yes", "no":
+	default:
+		return fmt.Errorf("--enabled must be yes or no")
+	}
+	switch s.Status {
+	case "", ldap.StatusOK, ldap.StatusError, ldap.StatusAuthError, ldap.StatusUnknown:
+	default:
+		return fmt.Errorf("--status must be ok, error, auth-error or unknown")
+	}
+	// Host names are not case sensitive
+	for i := range s.Consumers {
+		s.Consumers[i] = strings.ToLower(s.Consumers[i])
+	}
+	for i := range s.Suppliers {
+		s.Suppliers[i] = strings.ToLower(s.Suppliers[i])
+	}
+	patterns := append(append([]string{}, s.Names...), s.Consumers...)
+	patterns = append(append(patterns, s.Suppliers...), s.Exclude...)
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid wildcard %q: %v", pattern, err)
+		}
+	}
+	return nil
+}
+
+// Empty reports whether the selector selects every agreement
+func (s *Selector) Empty() bool {
+	return len(s.Names) == 0 && s.Pattern == nil && len(s.Consumers) == 0 && len(s.Suppliers) == 0 && len(s.Suffixes) == 0 &&
+		s.Enabled == "" && s.Status == "" && len(s.Exclude) == 0
+}
+
+// Match reports whether an agreement is selected
+func (s *Selector) Match(agreement ldap.ReplicationAgreement) bool {
+	if len(s.Names) > 0 && !matchAny(s.Names, agreement.Name) {
+		return false
+	}
+	if s.Pattern != nil && !s.Pattern.MatchString(agreement.Name) {
+		return false
+	}
+	if len(s.Consumers) > 0 && !matchAny(s.Consumers, strings.ToLower(agreement.Consumer)) {
+		return false
+	}
+	if len(s.Suppliers) > 0 && !matchAny(s.Suppliers, strings.ToLower(agreement.Supplier)) {
+		return false
+	}
+	if len(s.Suffixes) > 0 {
+		found := false
+		for _, suffix := range s.Suffixes {
+			if ldap.SameDN(suffix, agreement.Suffix) {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if s.Enabled != "" && agreement.Enabled != (s.Enabled == "yes") {
+		return false
+	}
+	if s.Status != "" {
+		status := agreement.Status()
+		if status != s.Status && !(s.Status == ldap.StatusError && status == ldap.StatusAuthError) {
+			return false
+		}
+	}
+	return !matchAny(s.Exclude, agreement.Name)
+}
+
+// Filter returns the selected agreements in their original order
+func (s *Selector) Filter(agreements []ldap.ReplicationAgreement) []ldap.ReplicationAgreement {
+	var selected []ldap.ReplicationAgreement
+	for _, agreement := range agreements {
+		if s.Match(agreement) {
+			selected = append(selected, agreement)
+		}
+	}
+	return selected
+}
+
+// String describes the selection for the output, "all agreements" when empty
+func (s *Selector) String() string {
+	var parts []string
+	if len(s.Names) > 0 {
+		parts = append(parts, "agreement "+strings.Join(s.Names, " or "))
+	}
+	if s.Pattern != nil {
+		parts = append(parts, "name matching /"+s.Pattern.String()+"/")
+	}
+	if len(s.Consumers) > 0 {
+		parts = append(parts, "consumer "+strings.Join(s.Consumers, " or "))
+	}
+	if len(s.Suppliers) > 0 {
+		parts = append(parts, "supplier "+strings.Join(s.Suppliers, " or "))
+	}
+	if len(s.Suffixes) > 0 {
+		parts = append(parts, "suffix "+strings.Join(s.Suffixes, " or "))
+	}
+	switch s.Enabled {
+	case "yes":
+		parts = append(parts, "enabled")
+	case "no":
+		parts = append(parts, "disabled")
+	}
+	if s.Status != "" {
+		parts = append(parts, "status "+s.Status)
+	}
+	if len(s.Exclude) > 0 {
+		parts = append(parts, "excluding "+strings.Join(s.Exclude, ", "))
+	}
+	if len(parts) == 0 {
+		return "all agreements"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// matchAny reports whether a value equals or matches one of the wildcards
+// Names are compared exactly first so names containing wildcard characters
+// can still be selected
+func matchAny(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if pattern == value {
+			return true
+		}
+		if matched, _ := path.Match(pattern, value); matched {
+			return true
+		}
+	}
+	return false
+}
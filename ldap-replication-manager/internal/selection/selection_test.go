@@ -0,0 +1,135 @@
+package selection
+
+import (
+	"flag"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ldap-replication-manager/internal/ldap"
+)
+
+// testAgreements covers two suppliers, two data centres and every status
+var testAgreements = []ldap.ReplicationAgreement{
+	{Name: "to-dc1-a", Supplier: "ldap1.example.com", Consumer: "dc1-a.example.com", Suffix: "dc=example,dc=com", Enabled: true,
+		LastUpdateStatus: "Error (0) Replica acquired successfully: Incremental update succeeded"},
+	{Name: "to-dc1-b", Supplier: "ldap1.example.com", Consumer: "DC1-B.example.com", Suffix: "dc=example,dc=com", Enabled: true,
+		LastUpdateStatus: "Error (49) Replication error acquiring replica: Invalid credentials"},
+	{Name: "to-dc2-a", Supplier: "ldap2.example.com", Consumer: "dc2-a.example.com", Suffix: "o=ipaca", Enabled: false,
+		LastUpdateStatus: "Error (-1) Problem connecting to replica - LDAP error: Can't contact LDAP server"},
+	{Name: "to-dc2-legacy", Supplier: "LDAP2.example.com", Consumer: "dc2-legacy.example.com", Suffix: "DC=Example,DC=Com", Enabled: true},
+	{Name: "to-dc2-[old]", Supplier: "ldap2.example.com", Consumer: "dc2-old.example.com", Suffix: "dc=example,dc=com", Enabled: true,
+		LastUpdateStatus: "Error (0) Replica acquired successfully: Incremental update succeeded"},
+}
+
+// selectorFromFlags parses selection flags the way the commands do
+func selectorFromFlags(t *testing.T, args ...string) (*Selector, error) {
+	t.Helper()
+	selector := &Selector{}
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.SetOutput(new(strings.Builder))
+	selector.AddFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+	return selector, selector.Validate()
+}
+
+func TestSelectorFilter(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{"empty selects everything", nil, []string{"to-dc1-a", "to-dc1-b", "to-dc2-a", "to-dc2-legacy", "to-dc2-[old]"}},
+
+		{"exact name", []string{"--agreement", "to-dc1-a"}, []string{"to-dc1-a"}},
+		{"name glob", []string{"--agreement", "to-dc1-*"}, []string{"to-dc1-a", "to-dc1-b"}},
+		{"single character glob", []string{"--agreement", "to-dc?-a"}, []string{"to-dc1-a", "to-dc2-a"}},
+		{"repeated names match any", []string{"--agreement", "to-dc1-a", "--agreement", "to-dc2-a"}, []string{"to-dc1-a", "to-dc2-a"}},
+		{"name with wildcard characters", []string{"--agreement", "to-dc2-[old]"}, []string{"to-dc2-[old]"}},
+		{"names are case sensitive", []string{"--agreement", "TO-DC1-A"}, nil},
+		{"regular expression", []string{"--match", "^to-dc2-(a|legacy)$"}, []string{"to-dc2-a", "to-dc2-legacy"}},
+
+		{"consumer", []string{"--consumer", "dc1-a.example.com"}, []string{"to-dc1-a"}},
+		{"consumer glob ignores case", []string{"--consumer", "DC1-*"}, []string{"to-dc1-a", "to-dc1-b"}},
+		{"repeated consumers", []string{"--consumer", "dc1-b.*", "--consumer", "dc2-a.*"}, []string{"to-dc1-b", "to-dc2-a"}},
+
+		{"supplier", []string{"--supplier", "ldap1.example.com"}, []string{"to-dc1-a", "to-dc1-b"}},
+		{"supplier ignores case", []string{"--supplier", "LDAP2.EXAMPLE.COM"}, []string{"to-dc2-a", "to-dc2-legacy", "to-dc2-[old]"}},
+		{"supplier glob", []string{"--supplier", "ldap*"}, []string{"to-dc1-a", "to-dc1-b", "to-dc2-a", "to-dc2-legacy", "to-dc2-[old]"}},
+		{"unknown supplier", []string{"--supplier", "ldap3.example.com"}, nil},
+
+		{"suffix compared as DN", []string{"--suffix", "dc=example, dc=com"}, []string{"to-dc1-a", "to-dc1-b", "to-dc2-legacy", "to-dc2-[old]"}},
+		{"enabled", []string{"--enabled", "no"}, []string{"to-dc2-a"}},
+		{"status ok", []string{"--status", "ok"}, []string{"to-dc1-a", "to-dc2-[old]"}},
+		{"status error includes auth-error", []string{"--status", "error"}, []string{"to-dc1-b", "to-dc2-a"}},
+		{"status auth-error", []string{"--status", "auth-error"}, []string{"to-dc1-b"}},
+		{"status unknown", []string{"--status", "unknown"}, []string{"to-dc2-legacy"}},
+
+		{"exclude", []string{"--exclude", "to-dc2-legacy"}, []string{"to-dc1-a", "to-dc1-b", "to-dc2-a", "to-dc2-[old]"}},
+		{"exclude glob", []string{"--exclude", "to-dc2-*"}, []string{"to-dc1-a", "to-dc1-b"}},
+		{"exclude name with wildcard characters", []string{"--exclude", "to-dc2-[old]"}, []string{"to-dc1-a", "to-dc1-b", "to-dc2-a", "to-dc2-legacy"}},
+		{"exclude wins over a selected name", []string{"--agreement", "to-dc1-a", "--exclude", "to-dc1-*"}, nil},
+
+		{"criteria combine", []string{"--supplier", "ldap2.example.com", "--consumer", "dc2-*", "--exclude", "to-dc2-legacy", "--enabled", "yes"}, []string{"to-dc2-[old]"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			selector, err := selectorFromFlags(t, test.args...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, agreement := range selector.Filter(testAgreements) {
+				got = append(got, agreement.Name)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("selected %q, want %q", got, test.want)
+			}
+			if selector.Empty() != (len(test.args) == 0) {
+				t.Errorf("Empty() = %v with %q", selector.Empty(), test.args)
+			}
+		})
+	}
+}
+
+func TestSelectorValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr string
+	}{
+		{"enabled value", []string{"--enabled", "maybe"}, "--enabled must be yes or no"},
+		{"status value", []string{"--status", "failed"}, "--status must be ok"},
+		{"agreement glob", []string{"--agreement", "to-[dc"}, "invalid wildcard"},
+		{"consumer glob", []string{"--consumer", "dc[1"}, "invalid wildcard"},
+		{"supplier glob", []string{"--supplier", "ldap[1"}, "invalid wildcard"},
+		{"exclude glob", []string{"--exclude", "to-["}, "invalid wildcard"},
+		{"regular expression", []string{"--match", "to-(dc"}, "missing closing )"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := selectorFromFlags(t, test.args...)
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("error %v, want %q", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestSelectorString(t *testing.T) {
+	selector, err := selectorFromFlags(t, "--consumer", "DC2-*", "--supplier", "ldap2.example.com", "--status", "error", "--exclude", "to-dc2-legacy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "consumer dc2-*, supplier ldap2.example.com, status error, excluding to-dc2-legacy"
+	if got := selector.String(); got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+	if got := (&Selector{}).String(); got != "all agreements" {
+		t.Errorf("empty String() = %q", got)
+	}
+}
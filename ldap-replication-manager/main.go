@@ -1,15 +1,27 @@
 package main
 
 import (
+	"crypto/rand"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"log/slog"
 	"os"
+	"os/user"
+	"strings"
+	"time"
 
+	"github.com/ldap-replication-manager/internal/audit"
 	"github.com/ldap-replication-manager/internal/config"
+	"github.com/ldap-replication-manager/internal/events"
 	"github.com/ldap-replication-manager/internal/ldap"
+	"github.com/ldap-replication-manager/internal/logging"
 	"github.com/ldap-replication-manager/internal/monitor"
+	"github.com/ldap-replication-manager/internal/output"
 	"github.com/ldap-replication-manager/internal/password"
+	"github.com/ldap-replication-manager/internal/rotation"
+	"github.com/ldap-replication-manager/internal/selection"
 )
 
 // main is the entry point of the 389DS LDAP Replication Password Manager
@@ -27,8 +39,12 @@ func main() {
 		enableMonitor = flag.Bool("monitor", false, "Start GRPC monitoring for error 49 detection")
 		eduMode       = flag.Bool("edu", false, "Educational mode - uses simulated LDAP operations for learning")
 		prodMode      = flag.Bool("prod", false, "Production mode - performs real LDAP operations (requires real LDAP server)")
+		outputFormat  = flag.String("output", "table", "Result format: table, json or yaml (json and yaml go to stdout, messages to stderr)")
 	)
 	flag.Parse()
+	if err := output.Validate(*outputFormat); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
 
 	// Validate mode flags - only one mode can be active at a time
 	// This ensures clear operation mode and prevents confusion
@@ -64,158 +80,737 @@ func main() {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
 
-	// Set up logging based on verbose flag
-	if *verbose {
-		log.SetFlags(log.LstdFlags | log.Lshortfile)
-		log.Println("Verbose logging enabled")
+	// Set up structured logging from the logging section; --verbose means debug
+	logger, err := logging.New(cfg.Logging, *verbose)
+	if err != nil {
+		log.Fatalf("Failed to set up logging: %v", err)
 	}
 
-	fmt.Println("389DS LDAP Replication Password Manager")
-	fmt.Println("======================================")
-	fmt.Printf("Operation Mode: %s\n", operationMode)
+	// "apply" is the rotation workflow, which also runs when no command is given
+	// Maintenance commands such as "list" or "history" run instead of
+	// the normal rotation workflow and never change any password
+	// "plan" runs the same workflow up to the planned changes and stops there
+	var options applyOptions
+	if flag.NArg() > 0 && (flag.Arg(0) == "apply" || flag.Arg(0) == "plan") {
+		options = parseApplyOptions(flag.Arg(0), flag.Args()[1:])
+	} else if flag.NArg() > 0 {
+		if err := runCommand(cfg, *outputFormat, flag.Args()); err != nil {
+			log.Fatalf("%v", err)
+		}
+		return
+	}
 
-	// Display mode-specific information
-	if *eduMode {
-		fmt.Println("📚 EDUCATIONAL MODE: Using simulated LDAP operations for learning")
-		fmt.Println("   - No real LDAP connections will be made")
-		fmt.Println("   - Safe for learning and testing concepts")
-		fmt.Println("   - Use --prod flag for real operations")
-	} else if *prodMode {
-		fmt.Println("🔧 PRODUCTION MODE: Performing real LDAP operations")
-		fmt.Println("   - Will connect to actual LDAP servers")
-		fmt.Println("   - Will make real password changes")
-		fmt.Println("   - Ensure your configuration is correct!")
-	} else if *dryRun {
-		fmt.Println("🔍 DRY-RUN MODE: Showing planned changes without execution")
-		fmt.Println("   - Will connect to LDAP servers for discovery")
-		fmt.Println("   - Will NOT make any password changes")
-		fmt.Println("   - Safe for testing configuration")
+	// Checked on every path into the rotation, including a run without
+	// a command, so four-eyes approval cannot be skipped by leaving out "apply"
+	if err := checkApplyOptions(cfg, options, *dryRun); err != nil {
+		log.Fatalf("Error: %v", err)
 	}
 
-	// If monitor flag is set, start GRPC monitoring for real-time error detection
-	if *enableMonitor {
-		fmt.Println("Starting GRPC monitoring for error 49 detection...")
-		go monitor.StartGRPCMonitor(cfg)
+	// With json or yaml output only the result document goes to stdout
+	results := newResultOutput(*outputFormat, os.Stdout, os.Stderr)
+
+	// The rotation returns instead of exiting, so the lock entry is released
+	// and the audit log closed before the program ends
+	modes := runModes{
+		edu:        *eduMode,
+		prod:       *prodMode,
+		dryRun:     *dryRun,
+		monitor:    *enableMonitor,
+		name:       operationMode,
+		configFile: *configFile,
+	}
+	if err := rotate(cfg, logger, modes, options, results); err != nil {
+		if holder, ok := err.(*lockHeldError); ok {
+			holder.explain(results.messages)
+			os.Exit(1)
+		}
+		log.Fatalf("%v", err)
 	}
+}
 
-	// Create LDAP manager to handle all LDAP operations
-	// This encapsulates LDAP complexity and provides simple methods
-	// Pass the operation mode to determine if we use real or simulated LDAP operations
-	ldapManager, err := ldap.NewManager(cfg, *eduMode, *prodMode)
+// checkApplyOptions refuses option combinations the rotation cannot honour
+// It also enforces rotation.approval.required: anything that may change a
+// password, with or without the apply command, needs an approval token
+func checkApplyOptions(cfg *config.Config, options applyOptions, dryRun bool) error {
+	if options.resume != "" && dryRun {
+		return fmt.Errorf("--resume cannot be combined with --dry-run")
+	}
+	if options.resume != "" && !options.selection.Empty() {
+		return fmt.Errorf("selection flags cannot be combined with --resume; a resumed run keeps its agreements")
+	}
+	if options.approval != "" && options.resume == "" {
+		return fmt.Errorf("--approval applies to a saved plan and needs --resume <run-id>")
+	}
+	if cfg.Rotation.Approval.Required && !options.plan && !dryRun && options.approval == "" {
+		return fmt.Errorf("this configuration requires four-eyes approval: save the plan with \"plan --save\", " +
+			"have another operator run \"approve <run-id>\", then run \"apply --resume <run-id> --approval <token>\"")
+	}
+	return nil
+}
+
+// runModes are the mode flags of a rotation run
+type runModes struct {
+	edu     bool
+	prod    bool
+	dryRun  bool
+	monitor bool
+
+	// Name of the mode for messages, for example "production"
+	name string
+
+	// Configuration file, for the resume hint
+	configFile string
+}
+
+// rotate runs the rotation workflow: plan, confirm and apply
+// Every failure is returned rather than ending the program, so the deferred
+// release of the lock and closing of the audit log always happen
+func rotate(cfg *config.Config, logger *slog.Logger, modes runModes, options applyOptions, results resultOutput) error {
+	out := results.messages
+
+	// Connect to the external secret store if one is configured
+	// The Directory Manager password can then be kept out of the config file
+	secretStore, err := openSecretStore(cfg)
 	if err != nil {
-		log.Fatalf("Failed to create LDAP manager: %v", err)
+		return err
+	}
+
+	fmt.Fprintln(out, "389DS LDAP Replication Password Manager")
+	fmt.Fprintln(out, "======================================")
+	fmt.Fprintf(out, "Operation Mode: %s\n", modes.name)
+
+	// Every run gets an identifier that is saved with the passwords it applies
+	// A resumed run keeps the identifier of the run it continues
+	runID := newRunID()
+	if options.resume != "" {
+		runID = options.resume
+	}
+	fmt.Fprintf(out, "Run ID: %s\n", runID)
+
+	// Every log line of the run carries its run ID
+	logger = logger.With("run_id", runID)
+	slog.SetDefault(logger)
+	logger.Debug("Verbose logging enabled")
+
+	// Rotation and monitor events go to syslog or journald when configured
+	eventBus, err := events.Open(cfg.Events, logger)
+	if err != nil {
+		return fmt.Errorf("failed to set up event sinks: %v", err)
+	}
+	eventBus.SetRunID(runID)
+	defer eventBus.Close()
+
+	// Display mode-specific information
+	if modes.edu {
+		fmt.Fprintln(out, "📚 EDUCATIONAL MODE: Walking through a rotation for learning")
+		fmt.Fprintln(out, "   - Will connect to LDAP servers for discovery")
+		fmt.Fprintln(out, "   - Will NOT make any LDAP changes, each one is only shown")
+		fmt.Fprintln(out, "   - Safe for learning and testing concepts")
+		fmt.Fprintln(out, "   - Use --prod flag for real operations")
+	} else if modes.prod {
+		fmt.Fprintln(out, "🔧 PRODUCTION MODE: Performing real LDAP operations")
+		fmt.Fprintln(out, "   - Will connect to actual LDAP servers")
+		fmt.Fprintln(out, "   - Will make real password changes")
+		fmt.Fprintln(out, "   - Ensure your configuration is correct!")
+	} else if modes.dryRun {
+		fmt.Fprintln(out, "🔍 DRY-RUN MODE: Showing planned changes without execution")
+		fmt.Fprintln(out, "   - Will connect to LDAP servers for discovery")
+		fmt.Fprintln(out, "   - Will NOT make any password changes")
+		fmt.Fprintln(out, "   - Safe for testing configuration")
 	}
-	ldapManager.DryRun = *dryRun // Ensure dry-run mode is set
-	defer ldapManager.Close()
 
 	// Create password manager to handle password generation and updates
 	// This component ensures secure password generation and proper updates
 	passwordManager := password.NewManager(cfg)
+	breachChecker, err := password.NewBreachChecker(cfg.Password.BreachCheck)
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %v", err)
+	}
+	passwordManager.SetBreachChecker(breachChecker)
+	if err := passwordManager.ValidateConfiguredPasswords(); err != nil {
+		return fmt.Errorf("failed to load configuration: configuration validation failed: %v", err)
+	}
+	passwordManager.SetSecretStore(secretStore)
+	passwordManager.SetRunID(runID)
+	passwordManager.SetLogger(logger)
+	passwordManager.SetOutput(out)
 
-	// Main workflow: discover agreements, generate passwords, and update
-	fmt.Println("\nStep 1: Discovering replication agreements...")
-	agreements, err := ldapManager.DiscoverReplicationAgreements()
+	// Open the local password vault so applied passwords can be recovered later
+	localVault, err := password.NewLocalVault(cfg.Secrets.LocalVault)
 	if err != nil {
-		log.Fatalf("Failed to discover replication agreements: %v", err)
+		return fmt.Errorf("failed to open local password vault: %v", err)
 	}
+	passwordManager.SetLocalVault(localVault)
 
-	if len(agreements) == 0 {
-		fmt.Println("No replication agreements found.")
-		os.Exit(0)
+	// Create LDAP manager to handle all LDAP operations
+	// This encapsulates LDAP complexity and provides simple methods
+	// Pass the operation mode to determine if we use real or simulated LDAP operations
+	ldapManager, err := ldap.NewManager(cfg, modes.edu, modes.prod)
+	if err != nil {
+		return fmt.Errorf("failed to create LDAP manager: %v", err)
 	}
+	// Educational mode previews every change like a dry run: it reads the
+	// real topology but writes nothing, not even the lock entry, the audit
+	// log or the state file
+	preview := modes.dryRun || modes.edu
+	ldapManager.DryRun = preview
+	ldapManager.SetLogger(logger)
+	defer ldapManager.Close()
 
-	fmt.Printf("Found %d replication agreements\n", len(agreements))
+	// Every change made from here on is recorded in the audit log, including
+	// the lock entry itself; plans and previews change nothing
+	if !preview && !options.plan {
+		auditLog, err := openAuditLog(cfg, runID)
+		if err != nil {
+			return err
+		}
+		ldapManager.SetAuditLog(auditLog)
+		defer closeAuditLog(auditLog, out)
+	}
 
-	// Generate new passwords for all agreements
-	fmt.Println("\nStep 2: Generating new passwords...")
-	newPasswords := passwordManager.GeneratePasswords(agreements)
+	// Only one run may change the topology at a time
+	// The lock is taken before planning so the plan cannot be outdated by
+	// another run; a preview changes nothing and does not need it
+	if !preview && !options.plan {
+		lock, err := acquireRotationLock(cfg, ldapManager, runID, out)
+		if err != nil {
+			return err
+		}
+		defer releaseRotationLock(lock)
 
-	// Display what will be changed (always show this for transparency)
-	fmt.Println("\nStep 3: Planned changes:")
-	fmt.Println("=======================")
+		// Creating the lock is the first audited change; stop before any
+		// password is touched if it could not be recorded
+		if err := ldapManager.AuditError(); err != nil {
+			return fmt.Errorf("the audit log cannot be written, no password was changed: %v", err)
+		}
+	}
 
-	for _, agreement := range agreements {
-		newPassword := newPasswords[agreement.Name]
-		fmt.Printf("\nAgreement: %s\n", agreement.Name)
-		fmt.Printf("  Supplier: %s\n", agreement.Supplier)
-		fmt.Printf("  Consumer: %s\n", agreement.Consumer)
-		fmt.Printf("  New Password: %s\n", newPassword)
+	// Main workflow: discover agreements, generate passwords, and update
+	// A resumed run takes its plan and passwords from the state file instead
+	var plan *rotation.Plan
+	var state *rotation.StateFile
+	approved := false
+	if options.resume != "" {
+		state, plan, err = loadRunState(cfg, options.resume, out)
+		if err != nil {
+			return err
+		}
+		if options.approval != "" {
+			if err := checkApproval(cfg, state, options.approval, out); err != nil {
+				return err
+			}
+			approved = true
+		}
+	} else {
+		plan, err = buildPlan(cfg, ldapManager, passwordManager, &options.selection, out)
+		if err != nil {
+			return err
+		}
+		if plan == nil {
+			return nil // nothing to rotate
+		}
+	}
 
-		// Generate LDAP commands for manual execution
-		supplierCmd := ldapManager.GeneratePasswordUpdateCommand(agreement.Supplier, agreement.Name, newPassword, "supplier")
-		consumerCmd := ldapManager.GeneratePasswordUpdateCommand(agreement.Consumer, agreement.Name, newPassword, "consumer")
+	// The consumer hash is computed once so the plan shows what is applied
+	if err := plan.HashConsumerPasswords(ldapManager); err != nil {
+		return fmt.Errorf("failed to prepare consumer passwords: %v", err)
+	}
 
-		fmt.Printf("  Manual LDAP Commands:\n")
-		fmt.Printf("    Supplier: %s\n", supplierCmd)
-		fmt.Printf("    Consumer: %s\n", consumerCmd)
+	// Display what will be changed (always show this for transparency)
+	fmt.Fprintln(out, "\nStep 3: Planned changes:")
+	fmt.Fprintln(out, "=======================")
+	plan.Print(out, ldapManager)
+
+	// The plan command stops here, optionally saving the plan for approval
+	if options.plan {
+		document := output.NewPlan(runID, plan)
+		if options.save {
+			document.SavedTo, err = savePlan(cfg, runID, plan, out)
+			if err != nil {
+				return err
+			}
+		}
+		return results.write("Plan", document)
+	}
+
+	// If monitor flag is set, start GRPC monitoring for real-time error detection
+	// It watches the agreements of the plan, so a selection applies to it too
+	if modes.monitor {
+		fmt.Fprintln(out, "Starting GRPC monitoring for error 49 detection...")
+		go monitor.StartGRPCMonitor(cfg, logger, eventBus, selectedEvents(plan.Agreements()))
 	}
 
 	// Handle different operation modes
-	if *dryRun {
+	if modes.dryRun {
 		// Dry-run mode: show what would be changed by calling the update methods
 		// The LDAP manager will handle dry-run mode by showing changes without executing
-		fmt.Println("\nStep 4: Dry-run simulation - showing what would be changed...")
-		for _, agreement := range agreements {
-			newPassword := newPasswords[agreement.Name]
+		fmt.Fprintln(out, "\nStep 4: Dry-run simulation - showing what would be changed...")
+		engine := rotation.NewEngine(cfg, ldapManager, passwordManager, false, out)
+		outcomes := engine.Run(plan)
+
+		fmt.Fprintln(out, "\nDry-run completed: No actual changes were made.")
+		fmt.Fprintln(out, "Use the LDAP commands shown above to make changes manually,")
+		fmt.Fprintln(out, "or run with --prod flag to apply changes automatically.")
+		return results.write("RotationReport", output.NewRotationReport(runID, true, outcomes))
+	}
 
-			fmt.Printf("Processing agreement: %s\n", agreement.Name)
+	// Production and educational modes: confirm before proceeding
+	// Without a terminal the run stops unless --yes or an approval token
+	// already confirmed it, instead of waiting for input that never comes
+	confirmed, err := confirmApply(options, plan, approved, out)
+	if err != nil {
+		return fmt.Errorf("Error: %v", err)
+	}
+	if !confirmed {
+		fmt.Fprintln(out, "Operation cancelled.")
+		return nil
+	}
 
-			// Call update methods - they will show what would be changed in dry-run mode
-			if err := ldapManager.UpdateReplicationPassword(agreement.Supplier, agreement.Name, newPassword, "supplier"); err != nil {
-				log.Printf("Error in dry-run simulation for supplier %s: %v", agreement.Name, err)
-				continue
-			}
+	// Apply password changes (production mode will execute, educational mode will simulate)
+	fmt.Fprintln(out, "\nStep 4: Applying password changes...")
+	fmt.Fprintf(out, "Rotating up to %d agreement groups at a time\n", cfg.Rotation.Parallelism)
+
+	// Progress is saved after every step so an interrupted run can be resumed
+	if state == nil && !modes.edu {
+		state, err = createRunState(cfg, runID, plan, options.noState, out)
+		if err != nil {
+			return err
+		}
+	}
 
-			if err := ldapManager.UpdateReplicationPassword(agreement.Consumer, agreement.Name, newPassword, "consumer"); err != nil {
-				log.Printf("Error in dry-run simulation for consumer %s: %v", agreement.Name, err)
-				continue
+	// Applied passwords are saved to the vault and secret store in production only
+	engine := rotation.NewEngine(cfg, ldapManager, passwordManager, modes.prod, out)
+	engine.SetState(state)
+	eventBus.Publish(events.Event{
+		Type:     events.TypeRotationStarted,
+		Severity: events.SeverityInfo,
+		Message:  fmt.Sprintf("Rotation started by %s: %d agreements in %d groups", operatorIdentity(), len(plan.Agreements()), len(plan.Groups)),
+		Fields:   map[string]string{"operator": operatorIdentity(), "mode": modes.name},
+	})
+	outcomes := engine.Run(plan)
+	publishOutcomes(eventBus, outcomes)
+
+	fmt.Fprintln(out, "\nSummary:")
+	rotation.PrintSummary(out, outcomes)
+
+	if state != nil && !modes.edu {
+		if rotation.AllSucceeded(outcomes) {
+			if err := state.Finish(); err != nil {
+				slog.Warn("Could not mark the run as completed", "error", err)
 			}
-
-			fmt.Printf("  ✓ Dry-run simulation completed for %s\n", agreement.Name)
+		} else {
+			fmt.Fprintf(out, "\nSome agreements were not rotated. After fixing the cause, continue with:\n")
+			fmt.Fprintf(out, "  ldap-replication-manager --config %s apply --resume %s\n", modes.configFile, runID)
 		}
+	}
 
-		fmt.Println("\nDry-run completed: No actual changes were made.")
-		fmt.Println("Use the LDAP commands shown above to make changes manually,")
-		fmt.Println("or run with --prod flag to apply changes automatically.")
-		return
+	// The changes were made, but an auditor cannot account for all of them
+	auditErr := ldapManager.AuditError()
+	if auditErr != nil {
+		fmt.Fprintf(out, "\nRun degraded: %v\n", auditErr)
+		fmt.Fprintln(out, "Groups not started yet were skipped. Free the audit log's disk, then resume the run.")
 	}
 
-	// Production and educational modes: ask for confirmation before proceeding
-	fmt.Print("\nDo you want to apply these changes? (y/N): ")
-	var response string
-	fmt.Scanln(&response)
+	fmt.Fprintln(out, "\nPassword update completed!")
+	if modes.prod {
+		printErrorLogs(cfg, out)
+	} else {
+		fmt.Fprintln(out, "Educational mode completed - no real changes were made.")
+	}
+	report := output.NewRotationReport(runID, false, outcomes)
+	if auditErr != nil {
+		report.AuditError = auditErr.Error()
+	}
+	if err := results.write("RotationReport", report); err != nil {
+		return err
+	}
+	if auditErr != nil {
+		return fmt.Errorf("run degraded: %v", auditErr)
+	}
+	return nil
+}
 
-	if response != "y" && response != "Y" {
-		fmt.Println("Operation cancelled.")
+// printErrorLogs tells the operator where to look for remaining error 49
+// messages: the configured monitor log paths, or the error logs of the
+// 389DS instances installed on this host
+func printErrorLogs(cfg *config.Config, out io.Writer) {
+	paths := cfg.GRPC.LogPaths
+	if len(paths) == 0 {
+		instances, _ := monitor.DiscoverInstances(cfg.GRPC.InstanceDir)
+		for _, instance := range instances {
+			paths = append(paths, instance.ErrorLog)
+		}
+	}
+	if len(paths) == 0 {
+		fmt.Fprintln(out, "Monitor the error logs of the suppliers for any remaining error 49 messages.")
 		return
 	}
+	fmt.Fprintln(out, "Monitor these logs for any remaining error 49 messages:")
+	for _, path := range paths {
+		fmt.Fprintf(out, "  %s\n", path)
+	}
+}
 
-	// Apply password changes (production mode will execute, educational mode will simulate)
-	fmt.Println("\nStep 4: Applying password changes...")
-	for _, agreement := range agreements {
-		newPassword := newPasswords[agreement.Name]
+// publishOutcomes sends an event for every agreement of a rotation and one
+// for the run as a whole
+func publishOutcomes(eventBus *events.Bus, outcomes []rotation.Result) {
+	failed := 0
+	for _, outcome := range outcomes {
+		event := events.Event{
+			Type:      events.TypeRotationSucceeded,
+			Severity:  events.SeverityInfo,
+			Message:   fmt.Sprintf("Agreement %s rotated", outcome.Agreement),
+			Agreement: outcome.Agreement,
+			Host:      outcome.Consumer,
+			Fields:    map[string]string{"supplier": outcome.Supplier, "duration": outcome.Duration.Round(time.Millisecond).String()},
+		}
+		if outcome.Note != "" {
+			event.Fields["note"] = outcome.Note
+		}
+		if !outcome.Success {
+			failed++
+			event.Type = events.TypeRotationFailed
+			event.Severity = events.SeverityError
+			event.Message = fmt.Sprintf("Agreement %s was not rotated: %v", outcome.Agreement, outcome.Err)
+			event.Fields["error"] = fmt.Sprint(outcome.Err)
+		}
+		if outcome.Note == rotation.NoteRolledBack {
+			// The agreement still replicates with its previous account
+			event.Type = events.TypeRotationRollback
+			event.Severity = events.SeverityWarning
+			event.Message = fmt.Sprintf("Agreement %s was rolled back to its previous account: %v", outcome.Agreement, outcome.Err)
+		}
+		eventBus.Publish(event)
+	}
 
-		fmt.Printf("Updating agreement: %s\n", agreement.Name)
+	summary := events.Event{
+		Type:     events.TypeRotationFinished,
+		Severity: events.SeverityInfo,
+		Message:  fmt.Sprintf("Rotation finished: %d of %d agreements rotated", len(outcomes)-failed, len(outcomes)),
+		Fields:   map[string]string{"total": fmt.Sprint(len(outcomes)), "failed": fmt.Sprint(failed)},
+	}
+	if failed > 0 {
+		summary.Severity = events.SeverityWarning
+	}
+	eventBus.Publish(summary)
+}
 
-		// Update supplier password
-		if err := ldapManager.UpdateReplicationPassword(agreement.Supplier, agreement.Name, newPassword, "supplier"); err != nil {
-			log.Printf("Failed to update supplier password for %s: %v", agreement.Name, err)
-			continue
+// openSecretStore connects to the external secret store if one is configured
+// When ldap.password is empty the bind password is read from the store
+// It returns nil without error when no secret store is configured
+func openSecretStore(cfg *config.Config) (password.SecretStore, error) {
+	secretStore, err := password.NewSecretStore(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize secret store: %v", err)
+	}
+	if secretStore != nil && cfg.LDAP.Password == "" {
+		bindPassword, err := secretStore.BindPassword()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read LDAP bind password from secret store: %v", err)
 		}
+		cfg.LDAP.Password = bindPassword
+	}
+	return secretStore, nil
+}
 
-		// Update consumer password
-		if err := ldapManager.UpdateReplicationPassword(agreement.Consumer, agreement.Name, newPassword, "consumer"); err != nil {
-			log.Printf("Failed to update consumer password for %s: %v", agreement.Name, err)
-			continue
+// acquireRotationLock takes the rotation lock entry on ldap.host
+// When another run holds the lock a lockHeldError says who holds it
+// A lock left behind by a killed run is taken over once it expires
+func acquireRotationLock(cfg *config.Config, ldapManager *ldap.Manager, runID string, out io.Writer) (*ldap.Lock, error) {
+	ttl := time.Duration(cfg.Rotation.LockTTL) * time.Second
+	lock, holder, err := ldapManager.AcquireLock(cfg.Rotation.LockDN, lockHolder(runID), ttl)
+	if holder != nil {
+		return nil, &lockHeldError{holder: holder}
+	}
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(out, "Rotation lock acquired: %s\n", cfg.Rotation.LockDN)
+	return lock, nil
+}
+
+// releaseRotationLock removes the lock entry at the end of a run
+// A lock that cannot be removed expires on its own, so this only warns
+func releaseRotationLock(lock *ldap.Lock) {
+	if err := lock.Release(); err != nil {
+		slog.Warn("Could not release the rotation lock; it expires on its own", "error", err)
+	}
+}
+
+// lockHeldError reports that another run holds the rotation lock
+type lockHeldError struct {
+	holder *ldap.LockInfo
+}
+
+func (e *lockHeldError) Error() string {
+	return fmt.Sprintf("another rotation is in progress: %s", e.holder.Holder)
+}
+
+// explain tells the operator who holds the lock and what to do about it
+func (e *lockHeldError) explain(out io.Writer) {
+	fmt.Fprintf(out, "\nAnother rotation is in progress: %s\n", e.holder.Holder)
+	fmt.Fprintf(out, "Its lock %s expires %s.\n", e.holder.DN, e.holder.Expires.Local().Format(time.RFC1123))
+	fmt.Fprintln(out, "Use the lock status and lock break commands if that run is no longer active.")
+}
+
+// openAuditLog opens the audit log for the changes of a run
+// A log whose chain no longer verifies stops the run: new records would
+// be appended to a history that cannot be trusted
+func openAuditLog(cfg *config.Config, runID string) (*audit.Log, error) {
+	auditLog, err := audit.Open(cfg.Audit.File, operatorIdentity(), runID)
+	if err != nil {
+		return nil, fmt.Errorf("%v; run the audit verify command for details", err)
+	}
+	return auditLog, nil
+}
+
+// closeAuditLog closes the audit log and reports its head
+// Keeping the head hash outside this machine, for example in the change
+// ticket, later proves that the log was not rewritten
+func closeAuditLog(auditLog *audit.Log, out io.Writer) {
+	seq, hash := auditLog.Head()
+	if err := auditLog.Close(); err != nil {
+		slog.Warn("Could not close the audit log", "error", err)
+	}
+	slog.Info("Audit log closed", "records", seq, "head", hash)
+	fmt.Fprintf(out, "Audit log head: record %d, hash %s\n", seq, hash)
+}
+
+// lockHolder describes this process for the lock entry
+// Example: root@admin1.example.com pid 4242 run 20250901T135442-3fa2c1
+func lockHolder(runID string) string {
+	return fmt.Sprintf("%s pid %d run %s", operatorIdentity(), os.Getpid(), runID)
+}
+
+// operatorIdentity names the person running the tool as user@host
+// Example: root@admin1.example.com
+func operatorIdentity() string {
+	name := "unknown"
+	if current, err := user.Current(); err == nil {
+		name = current.Username
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return name + "@" + hostname
+}
+
+// buildPlan discovers the agreements, prepares their new passwords and
+// groups them into a rotation plan
+// Nothing is changed on any server while the plan is built
+// Only the agreements chosen by the selection flags are planned
+// It returns a nil plan when there is nothing to rotate
+func buildPlan(cfg *config.Config, ldapManager *ldap.Manager, passwordManager *password.Manager, selector *selection.Selector, out io.Writer) (*rotation.Plan, error) {
+	fmt.Fprintln(out, "\nStep 1: Discovering replication agreements...")
+	agreements, err := ldapManager.DiscoverReplicationAgreements()
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover replication agreements: %v", err)
+	}
+
+	if len(agreements) == 0 {
+		fmt.Fprintln(out, "No replication agreements found.")
+		return nil, nil
+	}
+
+	fmt.Fprintf(out, "Found %d replication agreements on %s\n", len(agreements), strings.Join(ldapManager.Suppliers(), ", "))
+	if !selector.Empty() {
+		selected := selector.Filter(agreements)
+		fmt.Fprintf(out, "Selected %d of them (%s)\n", len(selected), selector.String())
+		if len(selected) == 0 {
+			fmt.Fprintln(out, "No replication agreements match the selection.")
+			return nil, nil
+		}
+		if err := rotation.CheckCompleteGroups(agreements, selected); err != nil {
+			return nil, fmt.Errorf("failed to plan the rotation: %v", err)
 		}
+		agreements = selected
+	}
 
-		fmt.Printf("  ✓ Successfully updated passwords for %s\n", agreement.Name)
+	// Read the password policy each consumer enforces for its replication
+	// manager so that passwords are checked before anything is changed
+	if err := loadConsumerPolicies(cfg, ldapManager, passwordManager, agreements, out); err != nil {
+		return nil, err
+	}
+	if err := checkHashedPasswordSupport(cfg, ldapManager, agreements, out); err != nil {
+		return nil, err
 	}
 
-	fmt.Println("\nPassword update completed!")
-	if *prodMode {
-		fmt.Println("Monitor your /var/log/dirsrv/slapd-ldap/errors logs for any remaining error 49 messages.")
+	// Generate new passwords for all agreements
+	fmt.Fprintln(out, "\nStep 2: Generating new passwords...")
+	newPasswords, err := passwordManager.GeneratePasswords(agreements)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare passwords: %v", err)
+	}
+
+	// Agreements binding as the same consumer entry are rotated as one group:
+	// the consumer entry first, then every supplier agreement that uses it
+	plan, err := rotation.NewPlan(agreements, newPasswords)
+	if err != nil {
+		return nil, fmt.Errorf("failed to plan the rotation: %v", err)
+	}
+	if err := plan.CheckSuppliers(ldapManager); err != nil {
+		return nil, fmt.Errorf("failed to plan the rotation: %v", err)
+	}
+	if cfg.Rotation.Strategy == "bind-group" {
+		fmt.Fprintln(out, "Checking consumers for bind DN groups...")
+		plan.ChooseStrategies(cfg, ldapManager, out)
+	}
+	return plan, nil
+}
+
+// loadRunState loads the plan of an interrupted run for apply --resume
+// The new passwords were saved encrypted with the local vault passphrase
+func loadRunState(cfg *config.Config, runID string, out io.Writer) (*rotation.StateFile, *rotation.Plan, error) {
+	secret, err := password.LoadSecret(cfg.Secrets.LocalVault)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to resume run %s: %v", runID, err)
+	}
+	state, plan, err := rotation.LoadStateFile(cfg.Rotation.StateDir, runID, secret)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to resume run %s: %v", runID, err)
+	}
+	fmt.Fprintf(out, "\nResuming run %s: %d consumer entry groups loaded from %s\n", runID, len(plan.Groups), state.Path())
+	return state, plan, nil
+}
+
+// checkApproval verifies the approval token given to apply --resume
+// An invalid token stops the run; a valid one replaces the y/N prompt
+// The approver may not be the operator running apply
+func checkApproval(cfg *config.Config, state *rotation.StateFile, token string, out io.Writer) error {
+	keys, err := rotation.LoadApprovalKeys(cfg.Rotation.Approval)
+	if err != nil {
+		return fmt.Errorf("failed to check approval: %v", err)
+	}
+	runState := state.State()
+	approval, err := rotation.VerifyApproval(keys, &runState, token, operatorIdentity())
+	if err != nil {
+		return fmt.Errorf("failed to check approval: %v", err)
+	}
+	fmt.Fprintf(out, "Approved by %s (valid until %s)\n", approval.Approver, approval.Expires.Local().Format(time.RFC1123))
+	return nil
+}
+
+// savePlan stores a plan as a run that has not started, for plan --save
+// Another operator approves it with the approve command, and it is applied
+// with apply --resume, so the passphrase is required here
+// It returns the path of the saved plan
+func savePlan(cfg *config.Config, runID string, plan *rotation.Plan, out io.Writer) (string, error) {
+	secret, err := loadStateSecret(cfg)
+	if err != nil {
+		return "", fmt.Errorf("failed to save the plan: %v", err)
+	}
+	state, err := rotation.NewStateFile(cfg.Rotation.StateDir, runID, operatorIdentity(), secret, plan)
+	if err != nil {
+		return "", fmt.Errorf("failed to save the plan: %v", err)
+	}
+	fmt.Fprintf(out, "\nPlan saved to %s\n", state.Path())
+	fmt.Fprintf(out, "To approve it, another operator runs:\n")
+	if cfg.Rotation.Approval.ApproversDir != "" {
+		fmt.Fprintf(out, "  ldap-replication-manager approve --key <their private key> %s\n", runID)
 	} else {
-		fmt.Println("Educational mode completed - no real changes were made.")
+		fmt.Fprintf(out, "  ldap-replication-manager approve %s\n", runID)
+	}
+	fmt.Fprintf(out, "Then apply it with the token they give you:\n")
+	fmt.Fprintf(out, "  ldap-replication-manager --prod apply --resume %s --approval <token>\n", runID)
+	return state.Path(), nil
+}
+
+// loadStateSecret returns the passphrase that encrypts run state files
+func loadStateSecret(cfg *config.Config) ([]byte, error) {
+	secret, err := password.LoadSecret(cfg.Secrets.LocalVault)
+	if err == nil && len(secret) == 0 {
+		err = fmt.Errorf("no passphrase in $%s or secrets.local_vault.key_file", cfg.Secrets.LocalVault.PassphraseEnv)
 	}
+	return secret, err
+}
+
+// createRunState writes the state file of a new run before any change is made
+// Without a passphrase to encrypt the passwords nothing is applied, because
+// an interrupted run could neither be resumed nor tell which consumers already
+// have the new password; --no-state accepts that risk explicitly
+func createRunState(cfg *config.Config, runID string, plan *rotation.Plan, noState bool, out io.Writer) (*rotation.StateFile, error) {
+	secret, err := loadStateSecret(cfg)
+	var state *rotation.StateFile
+	if err == nil {
+		state, err = rotation.NewStateFile(cfg.Rotation.StateDir, runID, operatorIdentity(), secret, plan)
+	}
+	if err != nil {
+		if !noState {
+			return nil, fmt.Errorf("cannot save the progress of this run: %v; set a passphrase so an interrupted run "+
+				"can be resumed, or use \"apply --no-state\" to apply without saved progress", err)
+		}
+		slog.Warn("Progress is not saved, this run cannot be resumed", "error", err)
+		return nil, nil
+	}
+	fmt.Fprintf(out, "Progress is saved to %s\n", state.Path())
+	return state, nil
+}
+
+// loadConsumerPolicies reads the 389DS password policy from every consumer
+// A consumer may reject a password that does not match its passwordMin*
+// settings, which would fail the rotation halfway through
+// Policies are cached per consumer and bind DN because many agreements share them
+// With consumer_policy "require" an unreadable policy stops the run
+func loadConsumerPolicies(cfg *config.Config, ldapManager *ldap.Manager, passwordManager *password.Manager, agreements []ldap.ReplicationAgreement, out io.Writer) error {
+	if cfg.Password.ConsumerPolicy == "off" {
+		return nil
+	}
+
+	fmt.Fprintln(out, "\nReading consumer password policies...")
+	policies := make(map[string]*ldap.PasswordPolicy)
+	for _, agreement := range agreements {
+		key := agreement.Consumer + "|" + agreement.BindDN
+		policy, seen := policies[key]
+		if !seen {
+			var err error
+			policy, err = ldapManager.ReadPasswordPolicy(agreement.Consumer, agreement.BindDN)
+			if err != nil {
+				if cfg.Password.ConsumerPolicy == "require" {
+					return fmt.Errorf("failed to read password policy from %s: %v", agreement.Consumer, err)
+				}
+				slog.Warn("Could not read password policy, using local settings only", "host", agreement.Consumer, "agreement", agreement.Name, "error", err)
+			} else if policy.CheckSyntax {
+				fmt.Fprintf(out, "  %s: syntax checking on (%s)\n", agreement.Consumer, strings.Join(policy.Sources, " + "))
+			} else {
+				fmt.Fprintf(out, "  %s: syntax checking off\n", agreement.Consumer)
+			}
+			policies[key] = policy
+		}
+		passwordManager.SetServerPolicy(agreement.Name, policy)
+	}
+	return nil
+}
+
+// checkHashedPasswordSupport makes sure every consumer accepts pre-hashed passwords
+// It only runs when ldap.consumer_password_scheme is set
+// A consumer that refuses hashed values would fail the rotation after the
+// supplier side was already changed, so the run stops before any change
+func checkHashedPasswordSupport(cfg *config.Config, ldapManager *ldap.Manager, agreements []ldap.ReplicationAgreement, out io.Writer) error {
+	if cfg.LDAP.ConsumerPasswordScheme == "" {
+		return nil
+	}
+
+	fmt.Fprintf(out, "\nChecking that consumers accept {%s} hashed passwords...\n", strings.Trim(cfg.LDAP.ConsumerPasswordScheme, "{}"))
+	checked := make(map[string]bool)
+	for _, agreement := range agreements {
+		if checked[agreement.Consumer] {
+			continue
+		}
+		checked[agreement.Consumer] = true
+		if err := ldapManager.CheckHashedPasswordsAllowed(agreement.Consumer); err != nil {
+			return fmt.Errorf("consumer %s cannot receive a hashed password: %v", agreement.Consumer, err)
+		}
+		fmt.Fprintf(out, "  %s: ok\n", agreement.Consumer)
+	}
+	return nil
+}
+
+// newRunID creates a unique identifier for one run of the tool
+// The timestamp keeps IDs sortable and the random suffix keeps them unique
+// Example: 20250901T135442-3fa2c1
+func newRunID() string {
+	suffix := make([]byte, 3)
+	rand.Read(suffix)
+	return fmt.Sprintf("%s-%x", time.Now().Format("20060102T150405"), suffix)
 }
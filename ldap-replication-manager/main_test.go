@@ -0,0 +1,107 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ldap-replication-manager/internal/config"
+	"github.com/ldap-replication-manager/internal/rotation"
+)
+
+func TestCheckApplyOptionsRequiresApproval(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Rotation.Approval.Required = true
+
+	tests := []struct {
+		name    string
+		options applyOptions
+		dryRun  bool
+		wantErr string
+	}{
+		{"no command", applyOptions{}, false, "requires four-eyes approval"},
+		{"apply", parseApplyOptions("apply", nil), false, "requires four-eyes approval"},
+		{"apply --yes", parseApplyOptions("apply", []string{"--yes"}), false, "requires four-eyes approval"},
+		{"apply --resume without a token", parseApplyOptions("apply", []string{"--resume", "r1"}), false, "requires four-eyes approval"},
+		{"apply --resume with a token", parseApplyOptions("apply", []string{"--resume", "r1", "--approval", "token"}), false, ""},
+		{"plan", parseApplyOptions("plan", nil), false, ""},
+		{"dry run without a command", applyOptions{}, true, ""},
+		{"token without --resume", parseApplyOptions("apply", []string{"--approval", "token"}), false, "needs --resume"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := checkApplyOptions(cfg, test.options, test.dryRun)
+			if test.wantErr == "" {
+				if err != nil {
+					t.Errorf("checkApplyOptions() = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("checkApplyOptions() = %v, want %q", err, test.wantErr)
+			}
+		})
+	}
+
+	cfg.Rotation.Approval.Required = false
+	if err := checkApplyOptions(cfg, applyOptions{}, false); err != nil {
+		t.Errorf("without required approval a run with no command is allowed: %v", err)
+	}
+}
+
+func TestCreateRunStateRequiresPassphrase(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Rotation.StateDir = t.TempDir()
+	cfg.Secrets.LocalVault.PassphraseEnv = "LRM_TEST_VAULT_PASSPHRASE"
+	t.Setenv("LRM_TEST_VAULT_PASSPHRASE", "")
+	plan := &rotation.Plan{}
+
+	if state, err := createRunState(cfg, "r1", plan, false, io.Discard); err == nil || state != nil {
+		t.Fatalf("createRunState() without a passphrase = %v, %v, want a refusal", state, err)
+	} else if !strings.Contains(err.Error(), "--no-state") {
+		t.Errorf("refusal %q does not mention --no-state", err)
+	}
+
+	state, err := createRunState(cfg, "r1", plan, true, io.Discard)
+	if err != nil || state != nil {
+		t.Errorf("createRunState() with --no-state = %v, %v, want no state and no error", state, err)
+	}
+
+	t.Setenv("LRM_TEST_VAULT_PASSPHRASE", "state passphrase")
+	state, err = createRunState(cfg, "r2", plan, false, io.Discard)
+	if err != nil || state == nil {
+		t.Fatalf("createRunState() with a passphrase = %v, %v", state, err)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.Rotation.StateDir, "r2.json")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPrintErrorLogs(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.GRPC.InstanceDir = filepath.Join("internal", "monitor", "testdata", "dirsrv")
+	var out strings.Builder
+	printErrorLogs(cfg, &out)
+	want := "Monitor these logs for any remaining error 49 messages:\n  /srv/logs/répl/errors\n  /var/log/dirsrv/slapd-hub02/errors\n"
+	if out.String() != want {
+		t.Errorf("discovered instances: %q, want %q", out.String(), want)
+	}
+
+	cfg.GRPC.LogPaths = []string{"/srv/logs/replication.log"}
+	out.Reset()
+	printErrorLogs(cfg, &out)
+	if !strings.HasSuffix(out.String(), "\n  /srv/logs/replication.log\n") {
+		t.Errorf("configured log paths: %q", out.String())
+	}
+
+	cfg.GRPC.LogPaths = nil
+	cfg.GRPC.InstanceDir = t.TempDir()
+	out.Reset()
+	printErrorLogs(cfg, &out)
+	if strings.Contains(out.String(), "/") {
+		t.Errorf("no instances: %q names a path", out.String())
+	}
+}